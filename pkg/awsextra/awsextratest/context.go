@@ -0,0 +1,684 @@
+package awsextratest
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The WithContext calls awsextra makes.  Each fails the way the SDK does
+// once ctx is done, and otherwise makes the plain call.
+
+// canceled returns the SDK's error for a request whose context is done.
+func canceled(ctx aws.Context) error {
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	return nil
+}
+
+func (f *EC2) CreateTagsWithContext(ctx aws.Context, in *ec2.CreateTagsInput, _ ...request.Option) (*ec2.CreateTagsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateTags(in)
+}
+
+func (f *EC2) CreateVpcWithContext(ctx aws.Context, in *ec2.CreateVpcInput, _ ...request.Option) (*ec2.CreateVpcOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateVpc(in)
+}
+
+func (f *EC2) DescribeVpcsWithContext(ctx aws.Context, in *ec2.DescribeVpcsInput, _ ...request.Option) (*ec2.DescribeVpcsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeVpcs(in)
+}
+
+func (f *EC2) DescribeVpcAttributeWithContext(ctx aws.Context, in *ec2.DescribeVpcAttributeInput, _ ...request.Option) (*ec2.DescribeVpcAttributeOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeVpcAttribute(in)
+}
+
+func (f *EC2) ModifyVpcAttributeWithContext(ctx aws.Context, in *ec2.ModifyVpcAttributeInput, _ ...request.Option) (*ec2.ModifyVpcAttributeOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.ModifyVpcAttribute(in)
+}
+
+func (f *EC2) DeleteVpcWithContext(ctx aws.Context, in *ec2.DeleteVpcInput, _ ...request.Option) (*ec2.DeleteVpcOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteVpc(in)
+}
+
+func (f *EC2) CreateDhcpOptionsWithContext(ctx aws.Context, in *ec2.CreateDhcpOptionsInput, _ ...request.Option) (*ec2.CreateDhcpOptionsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateDhcpOptions(in)
+}
+
+func (f *EC2) AssociateDhcpOptionsWithContext(ctx aws.Context, in *ec2.AssociateDhcpOptionsInput, _ ...request.Option) (*ec2.AssociateDhcpOptionsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.AssociateDhcpOptions(in)
+}
+
+func (f *EC2) DescribeDhcpOptionsWithContext(ctx aws.Context, in *ec2.DescribeDhcpOptionsInput, _ ...request.Option) (*ec2.DescribeDhcpOptionsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeDhcpOptions(in)
+}
+
+func (f *EC2) DeleteDhcpOptionsWithContext(ctx aws.Context, in *ec2.DeleteDhcpOptionsInput, _ ...request.Option) (*ec2.DeleteDhcpOptionsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteDhcpOptions(in)
+}
+
+func (f *EC2) DescribeAvailabilityZonesWithContext(ctx aws.Context, in *ec2.DescribeAvailabilityZonesInput, _ ...request.Option) (*ec2.DescribeAvailabilityZonesOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeAvailabilityZones(in)
+}
+
+func (f *EC2) CreateSubnetWithContext(ctx aws.Context, in *ec2.CreateSubnetInput, _ ...request.Option) (*ec2.CreateSubnetOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateSubnet(in)
+}
+
+func (f *EC2) ModifySubnetAttributeWithContext(ctx aws.Context, in *ec2.ModifySubnetAttributeInput, _ ...request.Option) (*ec2.ModifySubnetAttributeOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.ModifySubnetAttribute(in)
+}
+
+func (f *EC2) DescribeSubnetsWithContext(ctx aws.Context, in *ec2.DescribeSubnetsInput, _ ...request.Option) (*ec2.DescribeSubnetsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeSubnets(in)
+}
+
+func (f *EC2) DeleteSubnetWithContext(ctx aws.Context, in *ec2.DeleteSubnetInput, _ ...request.Option) (*ec2.DeleteSubnetOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteSubnet(in)
+}
+
+func (f *EC2) CreateInternetGatewayWithContext(ctx aws.Context, in *ec2.CreateInternetGatewayInput, _ ...request.Option) (*ec2.CreateInternetGatewayOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateInternetGateway(in)
+}
+
+func (f *EC2) AttachInternetGatewayWithContext(ctx aws.Context, in *ec2.AttachInternetGatewayInput, _ ...request.Option) (*ec2.AttachInternetGatewayOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.AttachInternetGateway(in)
+}
+
+func (f *EC2) DescribeInternetGatewaysWithContext(ctx aws.Context, in *ec2.DescribeInternetGatewaysInput, _ ...request.Option) (*ec2.DescribeInternetGatewaysOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeInternetGateways(in)
+}
+
+func (f *EC2) DetachInternetGatewayWithContext(ctx aws.Context, in *ec2.DetachInternetGatewayInput, _ ...request.Option) (*ec2.DetachInternetGatewayOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DetachInternetGateway(in)
+}
+
+func (f *EC2) DeleteInternetGatewayWithContext(ctx aws.Context, in *ec2.DeleteInternetGatewayInput, _ ...request.Option) (*ec2.DeleteInternetGatewayOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteInternetGateway(in)
+}
+
+func (f *EC2) DescribeRouteTablesWithContext(ctx aws.Context, in *ec2.DescribeRouteTablesInput, _ ...request.Option) (*ec2.DescribeRouteTablesOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeRouteTables(in)
+}
+
+func (f *EC2) CreateRouteWithContext(ctx aws.Context, in *ec2.CreateRouteInput, _ ...request.Option) (*ec2.CreateRouteOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateRoute(in)
+}
+
+func (f *EC2) ReplaceRouteWithContext(ctx aws.Context, in *ec2.ReplaceRouteInput, _ ...request.Option) (*ec2.ReplaceRouteOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.ReplaceRoute(in)
+}
+
+func (f *EC2) DeleteRouteWithContext(ctx aws.Context, in *ec2.DeleteRouteInput, _ ...request.Option) (*ec2.DeleteRouteOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteRoute(in)
+}
+
+func (f *EC2) DisassociateRouteTableWithContext(ctx aws.Context, in *ec2.DisassociateRouteTableInput, _ ...request.Option) (*ec2.DisassociateRouteTableOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DisassociateRouteTable(in)
+}
+
+func (f *EC2) DeleteRouteTableWithContext(ctx aws.Context, in *ec2.DeleteRouteTableInput, _ ...request.Option) (*ec2.DeleteRouteTableOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteRouteTable(in)
+}
+
+func (f *EC2) CreateNetworkAclWithContext(ctx aws.Context, in *ec2.CreateNetworkAclInput, _ ...request.Option) (*ec2.CreateNetworkAclOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateNetworkAcl(in)
+}
+
+func (f *EC2) DescribeNetworkAclsWithContext(ctx aws.Context, in *ec2.DescribeNetworkAclsInput, _ ...request.Option) (*ec2.DescribeNetworkAclsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeNetworkAcls(in)
+}
+
+func (f *EC2) CreateNetworkAclEntryWithContext(ctx aws.Context, in *ec2.CreateNetworkAclEntryInput, _ ...request.Option) (*ec2.CreateNetworkAclEntryOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateNetworkAclEntry(in)
+}
+
+func (f *EC2) ReplaceNetworkAclEntryWithContext(ctx aws.Context, in *ec2.ReplaceNetworkAclEntryInput, _ ...request.Option) (*ec2.ReplaceNetworkAclEntryOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.ReplaceNetworkAclEntry(in)
+}
+
+func (f *EC2) DeleteNetworkAclEntryWithContext(ctx aws.Context, in *ec2.DeleteNetworkAclEntryInput, _ ...request.Option) (*ec2.DeleteNetworkAclEntryOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteNetworkAclEntry(in)
+}
+
+func (f *EC2) ReplaceNetworkAclAssociationWithContext(ctx aws.Context, in *ec2.ReplaceNetworkAclAssociationInput, _ ...request.Option) (*ec2.ReplaceNetworkAclAssociationOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.ReplaceNetworkAclAssociation(in)
+}
+
+func (f *EC2) DeleteNetworkAclWithContext(ctx aws.Context, in *ec2.DeleteNetworkAclInput, _ ...request.Option) (*ec2.DeleteNetworkAclOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteNetworkAcl(in)
+}
+
+func (f *EC2) CreateSecurityGroupWithContext(ctx aws.Context, in *ec2.CreateSecurityGroupInput, _ ...request.Option) (*ec2.CreateSecurityGroupOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateSecurityGroup(in)
+}
+
+func (f *EC2) DescribeSecurityGroupsWithContext(ctx aws.Context, in *ec2.DescribeSecurityGroupsInput, _ ...request.Option) (*ec2.DescribeSecurityGroupsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeSecurityGroups(in)
+}
+
+func (f *EC2) AuthorizeSecurityGroupIngressWithContext(ctx aws.Context, in *ec2.AuthorizeSecurityGroupIngressInput, _ ...request.Option) (*ec2.AuthorizeSecurityGroupIngressOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.AuthorizeSecurityGroupIngress(in)
+}
+
+func (f *EC2) RevokeSecurityGroupIngressWithContext(ctx aws.Context, in *ec2.RevokeSecurityGroupIngressInput, _ ...request.Option) (*ec2.RevokeSecurityGroupIngressOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.RevokeSecurityGroupIngress(in)
+}
+
+func (f *EC2) AuthorizeSecurityGroupEgressWithContext(ctx aws.Context, in *ec2.AuthorizeSecurityGroupEgressInput, _ ...request.Option) (*ec2.AuthorizeSecurityGroupEgressOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.AuthorizeSecurityGroupEgress(in)
+}
+
+func (f *EC2) RevokeSecurityGroupEgressWithContext(ctx aws.Context, in *ec2.RevokeSecurityGroupEgressInput, _ ...request.Option) (*ec2.RevokeSecurityGroupEgressOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.RevokeSecurityGroupEgress(in)
+}
+
+func (f *EC2) DeleteSecurityGroupWithContext(ctx aws.Context, in *ec2.DeleteSecurityGroupInput, _ ...request.Option) (*ec2.DeleteSecurityGroupOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteSecurityGroup(in)
+}
+
+func (f *EC2) DescribeInstancesWithContext(ctx aws.Context, in *ec2.DescribeInstancesInput, _ ...request.Option) (*ec2.DescribeInstancesOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeInstances(in)
+}
+
+func (f *EC2) TerminateInstancesWithContext(ctx aws.Context, in *ec2.TerminateInstancesInput, _ ...request.Option) (*ec2.TerminateInstancesOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.TerminateInstances(in)
+}
+
+func (f *EC2) DescribeNetworkInterfacesWithContext(ctx aws.Context, in *ec2.DescribeNetworkInterfacesInput, _ ...request.Option) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeNetworkInterfaces(in)
+}
+
+func (f *EC2) DetachNetworkInterfaceWithContext(ctx aws.Context, in *ec2.DetachNetworkInterfaceInput, _ ...request.Option) (*ec2.DetachNetworkInterfaceOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DetachNetworkInterface(in)
+}
+
+func (f *EC2) DeleteNetworkInterfaceWithContext(ctx aws.Context, in *ec2.DeleteNetworkInterfaceInput, _ ...request.Option) (*ec2.DeleteNetworkInterfaceOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteNetworkInterface(in)
+}
+
+func (f *EC2) AllocateAddressWithContext(ctx aws.Context, in *ec2.AllocateAddressInput, _ ...request.Option) (*ec2.AllocateAddressOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.AllocateAddress(in)
+}
+
+func (f *EC2) DescribeAddressesWithContext(ctx aws.Context, in *ec2.DescribeAddressesInput, _ ...request.Option) (*ec2.DescribeAddressesOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeAddresses(in)
+}
+
+func (f *EC2) ReleaseAddressWithContext(ctx aws.Context, in *ec2.ReleaseAddressInput, _ ...request.Option) (*ec2.ReleaseAddressOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.ReleaseAddress(in)
+}
+
+func (f *EC2) CreateNatGatewayWithContext(ctx aws.Context, in *ec2.CreateNatGatewayInput, _ ...request.Option) (*ec2.CreateNatGatewayOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateNatGateway(in)
+}
+
+func (f *EC2) DescribeNatGatewaysWithContext(ctx aws.Context, in *ec2.DescribeNatGatewaysInput, _ ...request.Option) (*ec2.DescribeNatGatewaysOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeNatGateways(in)
+}
+
+func (f *EC2) DeleteNatGatewayWithContext(ctx aws.Context, in *ec2.DeleteNatGatewayInput, _ ...request.Option) (*ec2.DeleteNatGatewayOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteNatGateway(in)
+}
+
+func (f *EC2) CreateRouteTableWithContext(ctx aws.Context, in *ec2.CreateRouteTableInput, _ ...request.Option) (*ec2.CreateRouteTableOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateRouteTable(in)
+}
+
+func (f *EC2) AssociateRouteTableWithContext(ctx aws.Context, in *ec2.AssociateRouteTableInput, _ ...request.Option) (*ec2.AssociateRouteTableOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.AssociateRouteTable(in)
+}
+
+func (f *EC2) AssociateVpcCidrBlockWithContext(ctx aws.Context, in *ec2.AssociateVpcCidrBlockInput, _ ...request.Option) (*ec2.AssociateVpcCidrBlockOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.AssociateVpcCidrBlock(in)
+}
+
+func (f *EC2) AssociateSubnetCidrBlockWithContext(ctx aws.Context, in *ec2.AssociateSubnetCidrBlockInput, _ ...request.Option) (*ec2.AssociateSubnetCidrBlockOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.AssociateSubnetCidrBlock(in)
+}
+
+func (f *EC2) CreateEgressOnlyInternetGatewayWithContext(ctx aws.Context, in *ec2.CreateEgressOnlyInternetGatewayInput, _ ...request.Option) (*ec2.CreateEgressOnlyInternetGatewayOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateEgressOnlyInternetGateway(in)
+}
+
+func (f *EC2) DescribeEgressOnlyInternetGatewaysWithContext(ctx aws.Context, in *ec2.DescribeEgressOnlyInternetGatewaysInput, _ ...request.Option) (*ec2.DescribeEgressOnlyInternetGatewaysOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeEgressOnlyInternetGateways(in)
+}
+
+func (f *EC2) DeleteEgressOnlyInternetGatewayWithContext(ctx aws.Context, in *ec2.DeleteEgressOnlyInternetGatewayInput, _ ...request.Option) (*ec2.DeleteEgressOnlyInternetGatewayOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteEgressOnlyInternetGateway(in)
+}
+
+func (f *EC2) CreateVpcEndpointWithContext(ctx aws.Context, in *ec2.CreateVpcEndpointInput, _ ...request.Option) (*ec2.CreateVpcEndpointOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateVpcEndpoint(in)
+}
+
+func (f *EC2) DescribeVpcEndpointsWithContext(ctx aws.Context, in *ec2.DescribeVpcEndpointsInput, _ ...request.Option) (*ec2.DescribeVpcEndpointsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeVpcEndpoints(in)
+}
+
+func (f *EC2) ModifyVpcEndpointWithContext(ctx aws.Context, in *ec2.ModifyVpcEndpointInput, _ ...request.Option) (*ec2.ModifyVpcEndpointOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.ModifyVpcEndpoint(in)
+}
+
+func (f *EC2) DeleteVpcEndpointsWithContext(ctx aws.Context, in *ec2.DeleteVpcEndpointsInput, _ ...request.Option) (*ec2.DeleteVpcEndpointsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteVpcEndpoints(in)
+}
+
+func (f *EC2) CreateFlowLogsWithContext(ctx aws.Context, in *ec2.CreateFlowLogsInput, _ ...request.Option) (*ec2.CreateFlowLogsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateFlowLogs(in)
+}
+
+func (f *EC2) DescribeFlowLogsWithContext(ctx aws.Context, in *ec2.DescribeFlowLogsInput, _ ...request.Option) (*ec2.DescribeFlowLogsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeFlowLogs(in)
+}
+
+func (f *EC2) DeleteFlowLogsWithContext(ctx aws.Context, in *ec2.DeleteFlowLogsInput, _ ...request.Option) (*ec2.DeleteFlowLogsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteFlowLogs(in)
+}
+
+func (f *EC2) CreateVpcPeeringConnectionWithContext(ctx aws.Context, in *ec2.CreateVpcPeeringConnectionInput, _ ...request.Option) (*ec2.CreateVpcPeeringConnectionOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateVpcPeeringConnection(in)
+}
+
+func (f *EC2) AcceptVpcPeeringConnectionWithContext(ctx aws.Context, in *ec2.AcceptVpcPeeringConnectionInput, _ ...request.Option) (*ec2.AcceptVpcPeeringConnectionOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.AcceptVpcPeeringConnection(in)
+}
+
+func (f *EC2) DescribeVpcPeeringConnectionsWithContext(ctx aws.Context, in *ec2.DescribeVpcPeeringConnectionsInput, _ ...request.Option) (*ec2.DescribeVpcPeeringConnectionsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeVpcPeeringConnections(in)
+}
+
+func (f *EC2) ModifyVpcPeeringConnectionOptionsWithContext(ctx aws.Context, in *ec2.ModifyVpcPeeringConnectionOptionsInput, _ ...request.Option) (*ec2.ModifyVpcPeeringConnectionOptionsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.ModifyVpcPeeringConnectionOptions(in)
+}
+
+func (f *EC2) DeleteVpcPeeringConnectionWithContext(ctx aws.Context, in *ec2.DeleteVpcPeeringConnectionInput, _ ...request.Option) (*ec2.DeleteVpcPeeringConnectionOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteVpcPeeringConnection(in)
+}
+
+func (f *EC2) CreateTransitGatewayWithContext(ctx aws.Context, in *ec2.CreateTransitGatewayInput, _ ...request.Option) (*ec2.CreateTransitGatewayOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateTransitGateway(in)
+}
+
+func (f *EC2) DescribeTransitGatewaysWithContext(ctx aws.Context, in *ec2.DescribeTransitGatewaysInput, _ ...request.Option) (*ec2.DescribeTransitGatewaysOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeTransitGateways(in)
+}
+
+func (f *EC2) DeleteTransitGatewayWithContext(ctx aws.Context, in *ec2.DeleteTransitGatewayInput, _ ...request.Option) (*ec2.DeleteTransitGatewayOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteTransitGateway(in)
+}
+
+func (f *EC2) CreateTransitGatewayVpcAttachmentWithContext(ctx aws.Context, in *ec2.CreateTransitGatewayVpcAttachmentInput, _ ...request.Option) (*ec2.CreateTransitGatewayVpcAttachmentOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateTransitGatewayVpcAttachment(in)
+}
+
+func (f *EC2) DescribeTransitGatewayVpcAttachmentsWithContext(ctx aws.Context, in *ec2.DescribeTransitGatewayVpcAttachmentsInput, _ ...request.Option) (*ec2.DescribeTransitGatewayVpcAttachmentsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeTransitGatewayVpcAttachments(in)
+}
+
+func (f *EC2) ModifyTransitGatewayVpcAttachmentWithContext(ctx aws.Context, in *ec2.ModifyTransitGatewayVpcAttachmentInput, _ ...request.Option) (*ec2.ModifyTransitGatewayVpcAttachmentOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.ModifyTransitGatewayVpcAttachment(in)
+}
+
+func (f *EC2) DeleteTransitGatewayVpcAttachmentWithContext(ctx aws.Context, in *ec2.DeleteTransitGatewayVpcAttachmentInput, _ ...request.Option) (*ec2.DeleteTransitGatewayVpcAttachmentOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteTransitGatewayVpcAttachment(in)
+}
+
+func (f *EC2) DescribeTransitGatewayAttachmentsWithContext(ctx aws.Context, in *ec2.DescribeTransitGatewayAttachmentsInput, _ ...request.Option) (*ec2.DescribeTransitGatewayAttachmentsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeTransitGatewayAttachments(in)
+}
+
+func (f *EC2) AssociateTransitGatewayRouteTableWithContext(ctx aws.Context, in *ec2.AssociateTransitGatewayRouteTableInput, _ ...request.Option) (*ec2.AssociateTransitGatewayRouteTableOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.AssociateTransitGatewayRouteTable(in)
+}
+
+func (f *EC2) DisassociateTransitGatewayRouteTableWithContext(ctx aws.Context, in *ec2.DisassociateTransitGatewayRouteTableInput, _ ...request.Option) (*ec2.DisassociateTransitGatewayRouteTableOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DisassociateTransitGatewayRouteTable(in)
+}
+
+func (f *EC2) GetTransitGatewayAttachmentPropagationsWithContext(ctx aws.Context, in *ec2.GetTransitGatewayAttachmentPropagationsInput, _ ...request.Option) (*ec2.GetTransitGatewayAttachmentPropagationsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.GetTransitGatewayAttachmentPropagations(in)
+}
+
+func (f *EC2) EnableTransitGatewayRouteTablePropagationWithContext(ctx aws.Context, in *ec2.EnableTransitGatewayRouteTablePropagationInput, _ ...request.Option) (*ec2.EnableTransitGatewayRouteTablePropagationOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.EnableTransitGatewayRouteTablePropagation(in)
+}
+
+func (f *EC2) CreateVpnGatewayWithContext(ctx aws.Context, in *ec2.CreateVpnGatewayInput, _ ...request.Option) (*ec2.CreateVpnGatewayOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateVpnGateway(in)
+}
+
+func (f *EC2) DescribeVpnGatewaysWithContext(ctx aws.Context, in *ec2.DescribeVpnGatewaysInput, _ ...request.Option) (*ec2.DescribeVpnGatewaysOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeVpnGateways(in)
+}
+
+func (f *EC2) AttachVpnGatewayWithContext(ctx aws.Context, in *ec2.AttachVpnGatewayInput, _ ...request.Option) (*ec2.AttachVpnGatewayOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.AttachVpnGateway(in)
+}
+
+func (f *EC2) DetachVpnGatewayWithContext(ctx aws.Context, in *ec2.DetachVpnGatewayInput, _ ...request.Option) (*ec2.DetachVpnGatewayOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DetachVpnGateway(in)
+}
+
+func (f *EC2) DeleteVpnGatewayWithContext(ctx aws.Context, in *ec2.DeleteVpnGatewayInput, _ ...request.Option) (*ec2.DeleteVpnGatewayOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteVpnGateway(in)
+}
+
+func (f *EC2) EnableVgwRoutePropagationWithContext(ctx aws.Context, in *ec2.EnableVgwRoutePropagationInput, _ ...request.Option) (*ec2.EnableVgwRoutePropagationOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.EnableVgwRoutePropagation(in)
+}
+
+func (f *EC2) CreateCustomerGatewayWithContext(ctx aws.Context, in *ec2.CreateCustomerGatewayInput, _ ...request.Option) (*ec2.CreateCustomerGatewayOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateCustomerGateway(in)
+}
+
+func (f *EC2) DescribeCustomerGatewaysWithContext(ctx aws.Context, in *ec2.DescribeCustomerGatewaysInput, _ ...request.Option) (*ec2.DescribeCustomerGatewaysOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeCustomerGateways(in)
+}
+
+func (f *EC2) DeleteCustomerGatewayWithContext(ctx aws.Context, in *ec2.DeleteCustomerGatewayInput, _ ...request.Option) (*ec2.DeleteCustomerGatewayOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteCustomerGateway(in)
+}
+
+func (f *EC2) CreateVpnConnectionWithContext(ctx aws.Context, in *ec2.CreateVpnConnectionInput, _ ...request.Option) (*ec2.CreateVpnConnectionOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateVpnConnection(in)
+}
+
+func (f *EC2) DescribeVpnConnectionsWithContext(ctx aws.Context, in *ec2.DescribeVpnConnectionsInput, _ ...request.Option) (*ec2.DescribeVpnConnectionsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeVpnConnections(in)
+}
+
+func (f *EC2) CreateVpnConnectionRouteWithContext(ctx aws.Context, in *ec2.CreateVpnConnectionRouteInput, _ ...request.Option) (*ec2.CreateVpnConnectionRouteOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateVpnConnectionRoute(in)
+}
+
+func (f *EC2) DeleteVpnConnectionWithContext(ctx aws.Context, in *ec2.DeleteVpnConnectionInput, _ ...request.Option) (*ec2.DeleteVpnConnectionOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteVpnConnection(in)
+}
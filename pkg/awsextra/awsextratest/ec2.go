@@ -0,0 +1,362 @@
+// Package awsextratest provides in-memory EC2, IAM and CloudWatch Logs
+// backends for testing code that uses awsextra without an AWS account.
+package awsextratest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/jeremyd/structureag/pkg/awsextra"
+)
+
+// EC2 is an in-memory simulation of the parts of EC2 awsextra uses.  It
+// models VPCs with their IPv6 blocks, subnets, internet and egress-only
+// internet gateways, route tables, network ACLs, DHCP options sets, elastic IPs, NAT
+// gateways, VPC endpoints, flow logs, VPC peering connections, transit
+// gateways with their route tables and VPC attachments, virtual private
+// gateways, customer gateways and VPN connections, security groups,
+// instances, network interfaces and tags, and returns the same error
+// codes EC2 does, including DependencyViolation when a resource that is still
+// in use is deleted.  It is safe for concurrent use.
+//
+// Calls that are not modelled fall through to the embedded nil EC2API and
+// panic, so a test notices when awsextra starts using something new.
+type EC2 struct {
+	awsextra.EC2API
+
+	mu       sync.Mutex
+	region   string
+	account  string
+	network  *network // VPCs and peering connections seen by linked fakes
+	zones    []*ec2.AvailabilityZone
+	nextID   int
+	nextIPv6 int
+	order    []string // IDs in creation order, so describes are stable
+	calls    []string
+	faults   map[string]error
+
+	vpcs              map[string]*ec2.Vpc
+	vpcAttributes     map[string]map[string]bool
+	dhcpOptions       map[string]*ec2.DhcpOptions
+	subnets           map[string]*ec2.Subnet
+	igws              map[string]*ec2.InternetGateway
+	eigws             map[string]*ec2.EgressOnlyInternetGateway
+	routeTables       map[string]*ec2.RouteTable
+	networkACLs       map[string]*ec2.NetworkAcl
+	addresses         map[string]*ec2.Address
+	natGateways       map[string]*ec2.NatGateway
+	endpoints         map[string]*ec2.VpcEndpoint
+	flowLogs          map[string]*ec2.FlowLog
+	transitGateways   map[string]*ec2.TransitGateway
+	tgwRouteTables    map[string]*ec2.TransitGatewayRouteTable
+	tgwAttachments    map[string]*transitGatewayAttachment
+	vpnGateways       map[string]*ec2.VpnGateway
+	customerGateways  map[string]*ec2.CustomerGateway
+	vpnConnections    map[string]*ec2.VpnConnection
+	securityGroups    map[string]*securityGroup
+	instances         map[string]*ec2.Instance
+	networkInterfaces map[string]*ec2.NetworkInterface
+	tags              map[string]map[string]string
+}
+
+// NewEC2 returns an empty EC2 for region with three available zones.
+func NewEC2(region string) *EC2 {
+	f := &EC2{
+		region:            region,
+		account:           defaultAccount,
+		network:           newNetwork(),
+		faults:            map[string]error{},
+		vpcs:              map[string]*ec2.Vpc{},
+		vpcAttributes:     map[string]map[string]bool{},
+		dhcpOptions:       map[string]*ec2.DhcpOptions{},
+		subnets:           map[string]*ec2.Subnet{},
+		igws:              map[string]*ec2.InternetGateway{},
+		eigws:             map[string]*ec2.EgressOnlyInternetGateway{},
+		routeTables:       map[string]*ec2.RouteTable{},
+		networkACLs:       map[string]*ec2.NetworkAcl{},
+		addresses:         map[string]*ec2.Address{},
+		natGateways:       map[string]*ec2.NatGateway{},
+		endpoints:         map[string]*ec2.VpcEndpoint{},
+		flowLogs:          map[string]*ec2.FlowLog{},
+		transitGateways:   map[string]*ec2.TransitGateway{},
+		tgwRouteTables:    map[string]*ec2.TransitGatewayRouteTable{},
+		tgwAttachments:    map[string]*transitGatewayAttachment{},
+		vpnGateways:       map[string]*ec2.VpnGateway{},
+		customerGateways:  map[string]*ec2.CustomerGateway{},
+		vpnConnections:    map[string]*ec2.VpnConnection{},
+		securityGroups:    map[string]*securityGroup{},
+		instances:         map[string]*ec2.Instance{},
+		networkInterfaces: map[string]*ec2.NetworkInterface{},
+		tags:              map[string]map[string]string{},
+	}
+	for i, letter := range []string{"a", "b", "c"} {
+		f.zones = append(f.zones, &ec2.AvailabilityZone{
+			RegionName: aws.String(region),
+			ZoneName:   aws.String(region + letter),
+			ZoneId:     aws.String(fmt.Sprintf("%s-az%d", zoneIDPrefix(region), i+1)),
+			ZoneType:   aws.String("availability-zone"),
+			State:      aws.String("available"),
+		})
+	}
+	return f
+}
+
+// zoneIDPrefix abbreviates region the way AWS zone IDs do, eg. "us-west-2"
+// becomes "usw2" and "ap-southeast-1" becomes "apse1".
+func zoneIDPrefix(region string) string {
+	parts := strings.Split(region, "-")
+	if len(parts) != 3 {
+		return region
+	}
+	direction := strings.NewReplacer("north", "n", "south", "s", "east", "e", "west", "w", "central", "c")
+	return parts[0] + direction.Replace(parts[1]) + parts[2]
+}
+
+// SetAvailabilityZones replaces the zones returned by
+// DescribeAvailabilityZones.
+func (f *EC2) SetAvailabilityZones(zones ...*ec2.AvailabilityZone) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.zones = zones
+}
+
+// SetAccount sets the AWS account ID that owns the fake's resources, by
+// default 123456789012.  Set it before creating any VPCs.
+func (f *EC2) SetAccount(account string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.account = account
+}
+
+// InjectError makes the next call to operation (eg. "CreateSubnet") fail
+// with err instead of running.
+func (f *EC2) InjectError(operation string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults[operation] = err
+}
+
+// Calls returns the names of the operations called so far, in order.
+func (f *EC2) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// Tags returns the tags currently set on resource ID.
+func (f *EC2) Tags(ID string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tags := map[string]string{}
+	for k, v := range f.tags[ID] {
+		tags[k] = v
+	}
+	return tags
+}
+
+// VpcAttribute reports whether a boolean VPC attribute such as
+// "enableDnsSupport" or "enableDnsHostnames" is set.
+func (f *EC2) VpcAttribute(vpcID string, attribute string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.vpcAttributes[vpcID][attribute]
+}
+
+// ResourceCount returns how many resources of all modelled kinds exist,
+// not counting the main route table, default network ACL and default security
+// group each VPC comes with, a transit gateway's route tables, or terminated instances,
+// deleted NAT gateways, transit gateways and attachments, VPN gateways and
+// connections and customer gateways, and peering connections that are no
+// longer live.
+func (f *EC2) ResourceCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := len(f.vpcs) + len(f.dhcpOptions) + len(f.subnets) + len(f.igws) + len(f.networkInterfaces) + len(f.addresses) + len(f.eigws) + len(f.endpoints) + len(f.flowLogs)
+	for _, nat := range f.natGateways {
+		if live(nat) {
+			n++
+		}
+	}
+	for _, peering := range f.network.peeringsOf(f) {
+		if livePeering(peering) {
+			n++
+		}
+	}
+	for _, tgw := range f.transitGateways {
+		if *tgw.State != "deleted" {
+			n++
+		}
+	}
+	for _, a := range f.tgwAttachments {
+		if *a.State != "deleted" {
+			n++
+		}
+	}
+	for _, vgw := range f.vpnGateways {
+		if *vgw.State != "deleted" {
+			n++
+		}
+	}
+	for _, cgw := range f.customerGateways {
+		if *cgw.State != "deleted" {
+			n++
+		}
+	}
+	for _, conn := range f.vpnConnections {
+		if *conn.State != "deleted" {
+			n++
+		}
+	}
+	for _, instance := range f.instances {
+		if running(instance) {
+			n++
+		}
+	}
+	for _, rt := range f.routeTables {
+		if !isMain(rt) {
+			n++
+		}
+	}
+	for _, acl := range f.networkACLs {
+		if !*acl.IsDefault {
+			n++
+		}
+	}
+	for _, sg := range f.securityGroups {
+		if aws.StringValue(sg.GroupName) != "default" {
+			n++
+		}
+	}
+	return n
+}
+
+// begin records a call and returns any injected fault for it.  The caller
+// must hold f.mu.
+func (f *EC2) begin(operation string) error {
+	f.calls = append(f.calls, operation)
+	if err, ok := f.faults[operation]; ok {
+		delete(f.faults, operation)
+		return err
+	}
+	return nil
+}
+
+func (f *EC2) newID(prefix string) *string {
+	f.nextID++
+	ID := fmt.Sprintf("%s-%017x", prefix, f.nextID)
+	f.order = append(f.order, ID)
+	return aws.String(ID)
+}
+
+func apiError(code string, format string, args ...interface{}) error {
+	return awserr.New(code, fmt.Sprintf(format, args...), nil)
+}
+
+// clone deep copies an SDK value so callers can't alias the fake's state.
+func clone(v interface{}) interface{} {
+	return awsutil.CopyOf(v)
+}
+
+//
+// Tags
+//
+
+func (f *EC2) CreateTags(in *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateTags"); err != nil {
+		return nil, err
+	}
+	for _, ID := range in.Resources {
+		if !f.exists(aws.StringValue(ID)) {
+			return nil, apiError("InvalidID", "The ID '%s' is not valid", aws.StringValue(ID))
+		}
+	}
+	for _, ID := range in.Resources {
+		if f.tags[*ID] == nil {
+			f.tags[*ID] = map[string]string{}
+		}
+		for _, tag := range in.Tags {
+			f.tags[*ID][aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (f *EC2) exists(ID string) bool {
+	switch {
+	case f.vpcs[ID] != nil, f.dhcpOptions[ID] != nil, f.subnets[ID] != nil,
+		f.igws[ID] != nil, f.routeTables[ID] != nil, f.networkACLs[ID] != nil, f.securityGroups[ID] != nil,
+		f.instances[ID] != nil, f.networkInterfaces[ID] != nil,
+		f.addresses[ID] != nil, f.natGateways[ID] != nil, f.eigws[ID] != nil,
+		f.endpoints[ID] != nil, f.flowLogs[ID] != nil,
+		f.transitGateways[ID] != nil, f.tgwRouteTables[ID] != nil, f.tgwAttachments[ID] != nil,
+		f.vpnGateways[ID] != nil, f.customerGateways[ID] != nil, f.vpnConnections[ID] != nil:
+		return true
+	}
+	return f.network.peeringOf(f, ID) != nil
+}
+
+func (f *EC2) ec2Tags(ID string) []*ec2.Tag {
+	var keys []string
+	for k := range f.tags[ID] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var tags []*ec2.Tag
+	for _, k := range keys {
+		tags = append(tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(f.tags[ID][k])})
+	}
+	return tags
+}
+
+//
+// Filters
+//
+
+// attributeFunc returns the values a resource has for a filter name, and
+// false if the filter is not supported for that kind of resource.
+type attributeFunc func(name string) ([]string, bool)
+
+// match reports whether the resource ID matches every filter.
+func (f *EC2) match(ID string, filters []*ec2.Filter, attr attributeFunc) (bool, error) {
+	for _, filter := range filters {
+		name := aws.StringValue(filter.Name)
+		var have []string
+		if strings.HasPrefix(name, "tag:") {
+			if v, ok := f.tags[ID][strings.TrimPrefix(name, "tag:")]; ok {
+				have = []string{v}
+			}
+		} else if name == "tag-key" {
+			for k := range f.tags[ID] {
+				have = append(have, k)
+			}
+		} else {
+			var ok bool
+			if have, ok = attr(name); !ok {
+				return false, apiError("InvalidParameterValue", "The filter '%s' is invalid", name)
+			}
+		}
+		if !anyEqual(have, aws.StringValueSlice(filter.Values)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func anyEqual(have []string, want []string) bool {
+	for _, h := range have {
+		for _, w := range want {
+			if h == w {
+				return true
+			}
+		}
+	}
+	return false
+}
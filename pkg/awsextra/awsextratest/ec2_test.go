@@ -0,0 +1,208 @@
+package awsextratest
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func code(err error) string {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code()
+	}
+	return ""
+}
+
+// newStack builds a VPC with a subnet, an attached IGW, a DHCP options set
+// and a security group referenced by another one.
+func newStack(t *testing.T) (f *EC2, vpcID, subnetID, igwID, doptID, sgID, refID *string) {
+	t.Helper()
+	f = NewEC2("us-west-2")
+	vpc, err := f.CreateVpc(&ec2.CreateVpcInput{CidrBlock: aws.String("10.0.0.0/16")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vpcID = vpc.Vpc.VpcId
+	subnet, err := f.CreateSubnet(&ec2.CreateSubnetInput{VpcId: vpcID, CidrBlock: aws.String("10.0.1.0/24")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	igw, _ := f.CreateInternetGateway(&ec2.CreateInternetGatewayInput{})
+	if _, err := f.AttachInternetGateway(&ec2.AttachInternetGatewayInput{InternetGatewayId: igw.InternetGateway.InternetGatewayId, VpcId: vpcID}); err != nil {
+		t.Fatal(err)
+	}
+	dopt, _ := f.CreateDhcpOptions(&ec2.CreateDhcpOptionsInput{})
+	if _, err := f.AssociateDhcpOptions(&ec2.AssociateDhcpOptionsInput{DhcpOptionsId: dopt.DhcpOptions.DhcpOptionsId, VpcId: vpcID}); err != nil {
+		t.Fatal(err)
+	}
+	sg, _ := f.CreateSecurityGroup(&ec2.CreateSecurityGroupInput{GroupName: aws.String("a"), Description: aws.String("a"), VpcId: vpcID})
+	ref, _ := f.CreateSecurityGroup(&ec2.CreateSecurityGroupInput{GroupName: aws.String("b"), Description: aws.String("b"), VpcId: vpcID})
+	_, err = f.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId: ref.GroupId,
+		IpPermissions: []*ec2.IpPermission{{
+			IpProtocol:       aws.String("tcp"),
+			FromPort:         aws.Int64(22),
+			ToPort:           aws.Int64(22),
+			UserIdGroupPairs: []*ec2.UserIdGroupPair{{GroupId: sg.GroupId}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, vpcID, subnet.Subnet.SubnetId, igw.InternetGateway.InternetGatewayId, dopt.DhcpOptions.DhcpOptionsId, sg.GroupId, ref.GroupId
+}
+
+func TestDependencyViolation(t *testing.T) {
+	f, vpcID, subnetID, igwID, doptID, sgID, refID := newStack(t)
+
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"vpc with dependencies", func() error {
+			_, err := f.DeleteVpc(&ec2.DeleteVpcInput{VpcId: vpcID})
+			return err
+		}},
+		{"attached internet gateway", func() error {
+			_, err := f.DeleteInternetGateway(&ec2.DeleteInternetGatewayInput{InternetGatewayId: igwID})
+			return err
+		}},
+		{"associated dhcp options", func() error {
+			_, err := f.DeleteDhcpOptions(&ec2.DeleteDhcpOptionsInput{DhcpOptionsId: doptID})
+			return err
+		}},
+		{"referenced security group", func() error {
+			_, err := f.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{GroupId: sgID})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := code(tt.call()); got != "DependencyViolation" {
+				t.Errorf("code = %q, want DependencyViolation", got)
+			}
+		})
+	}
+
+	// Unwinding in dependency order succeeds.
+	steps := []func() error{
+		func() error {
+			_, err := f.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{GroupId: refID})
+			return err
+		},
+		func() error {
+			_, err := f.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{GroupId: sgID})
+			return err
+		},
+		func() error {
+			_, err := f.DetachInternetGateway(&ec2.DetachInternetGatewayInput{InternetGatewayId: igwID, VpcId: vpcID})
+			return err
+		},
+		func() error {
+			_, err := f.DeleteInternetGateway(&ec2.DeleteInternetGatewayInput{InternetGatewayId: igwID})
+			return err
+		},
+		func() error {
+			_, err := f.DeleteSubnet(&ec2.DeleteSubnetInput{SubnetId: subnetID})
+			return err
+		},
+		func() error {
+			_, err := f.DeleteVpc(&ec2.DeleteVpcInput{VpcId: vpcID})
+			return err
+		},
+		func() error {
+			_, err := f.DeleteDhcpOptions(&ec2.DeleteDhcpOptionsInput{DhcpOptionsId: doptID})
+			return err
+		},
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+	if n := f.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left", n)
+	}
+}
+
+func TestCreateSubnetValidation(t *testing.T) {
+	f := NewEC2("us-west-2")
+	vpc, _ := f.CreateVpc(&ec2.CreateVpcInput{CidrBlock: aws.String("10.0.0.0/16")})
+	f.CreateSubnet(&ec2.CreateSubnetInput{VpcId: vpc.Vpc.VpcId, CidrBlock: aws.String("10.0.0.0/24")})
+
+	tests := []struct {
+		cidr string
+		zone string
+		want string
+	}{
+		{"10.1.0.0/24", "", "InvalidSubnet.Range"},
+		{"10.0.0.128/25", "", "InvalidSubnet.Conflict"},
+		{"10.0.1.0/24", "us-west-2z", "InvalidParameterValue"},
+		{"10.0.1.0/24", "us-west-2b", ""},
+	}
+	for _, tt := range tests {
+		in := &ec2.CreateSubnetInput{VpcId: vpc.Vpc.VpcId, CidrBlock: aws.String(tt.cidr)}
+		if tt.zone != "" {
+			in.AvailabilityZone = aws.String(tt.zone)
+		}
+		_, err := f.CreateSubnet(in)
+		if got := code(err); got != tt.want {
+			t.Errorf("CreateSubnet(%s, %s) code = %q, want %q", tt.cidr, tt.zone, got, tt.want)
+		}
+	}
+}
+
+func TestInjectError(t *testing.T) {
+	f := NewEC2("us-west-2")
+	f.InjectError("CreateVpc", awserr.New("RequestLimitExceeded", "slow down", nil))
+
+	if _, err := f.CreateVpc(&ec2.CreateVpcInput{CidrBlock: aws.String("10.0.0.0/16")}); code(err) != "RequestLimitExceeded" {
+		t.Fatalf("first call err = %v, want injected error", err)
+	}
+	if _, err := f.CreateVpc(&ec2.CreateVpcInput{CidrBlock: aws.String("10.0.0.0/16")}); err != nil {
+		t.Fatalf("second call err = %v, want success", err)
+	}
+}
+
+func TestTagFilters(t *testing.T) {
+	f := NewEC2("us-west-2")
+	a, _ := f.CreateVpc(&ec2.CreateVpcInput{CidrBlock: aws.String("10.0.0.0/16")})
+	f.CreateVpc(&ec2.CreateVpcInput{CidrBlock: aws.String("10.1.0.0/16")})
+	f.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{a.Vpc.VpcId},
+		Tags:      []*ec2.Tag{{Key: aws.String("env"), Value: aws.String("demo")}},
+	})
+
+	resp, err := f.DescribeVpcs(&ec2.DescribeVpcsInput{Filters: []*ec2.Filter{
+		{Name: aws.String("tag:env"), Values: []*string{aws.String("demo")}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Vpcs) != 1 || *resp.Vpcs[0].VpcId != *a.Vpc.VpcId {
+		t.Errorf("got %v, want only %s", resp.Vpcs, *a.Vpc.VpcId)
+	}
+
+	_, err = f.CreateTags(&ec2.CreateTagsInput{Resources: []*string{aws.String("vpc-nope")}})
+	if code(err) != "InvalidID" {
+		t.Errorf("tagging unknown resource: %v, want InvalidID", err)
+	}
+}
+
+func TestZoneIDs(t *testing.T) {
+	tests := []struct {
+		region, want string
+	}{
+		{"us-west-2", "usw2-az1"},
+		{"eu-central-1", "euc1-az1"},
+		{"ap-southeast-1", "apse1-az1"},
+	}
+	for _, tt := range tests {
+		resp, _ := NewEC2(tt.region).DescribeAvailabilityZones(&ec2.DescribeAvailabilityZonesInput{})
+		if got := aws.StringValue(resp.AvailabilityZones[0].ZoneId); got != tt.want {
+			t.Errorf("%s zone ID = %q, want %q", tt.region, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package awsextratest
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+//
+// VPC endpoints
+//
+
+// The services offering gateway endpoints.  Every service offers interface
+// endpoints.
+var gatewayServices = map[string]bool{"s3": true, "dynamodb": true}
+
+// CreateVpcEndpoint creates a gateway endpoint in the route tables, or an
+// interface endpoint with a requester managed network interface in each
+// subnet.  Interface endpoints are "pending" until they are next described.
+func (f *EC2) CreateVpcEndpoint(in *ec2.CreateVpcEndpointInput) (*ec2.CreateVpcEndpointOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateVpcEndpoint"); err != nil {
+		return nil, err
+	}
+	vpc := f.vpcs[aws.StringValue(in.VpcId)]
+	if vpc == nil {
+		return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", aws.StringValue(in.VpcId))
+	}
+	serviceName := aws.StringValue(in.ServiceName)
+	service := strings.TrimPrefix(serviceName, "com.amazonaws."+f.region+".")
+	if service == serviceName || service == "" {
+		return nil, apiError("InvalidServiceName", "The Vpc Endpoint Service '%s' does not exist", serviceName)
+	}
+	endpointType := aws.StringValue(in.VpcEndpointType)
+	if endpointType == "" {
+		endpointType = ec2.VpcEndpointTypeGateway
+	}
+	endpoint := &ec2.VpcEndpoint{
+		VpcEndpointId:     f.newID("vpce"),
+		VpcId:             vpc.VpcId,
+		ServiceName:       aws.String(serviceName),
+		VpcEndpointType:   aws.String(endpointType),
+		State:             aws.String("available"),
+		PrivateDnsEnabled: aws.Bool(false),
+	}
+
+	switch endpointType {
+	case ec2.VpcEndpointTypeGateway:
+		if !gatewayServices[service] {
+			return nil, apiError("InvalidServiceName", "The Vpc Endpoint Service '%s' does not support gateway endpoints", serviceName)
+		}
+		if len(in.SubnetIds) > 0 || aws.BoolValue(in.PrivateDnsEnabled) {
+			return nil, apiError("InvalidParameter", "gateway endpoints take route tables, not subnets or private DNS")
+		}
+		if err := f.checkEndpointRouteTables(vpc.VpcId, in.RouteTableIds); err != nil {
+			return nil, err
+		}
+		endpoint.RouteTableIds = in.RouteTableIds
+	case ec2.VpcEndpointTypeInterface:
+		if len(in.RouteTableIds) > 0 {
+			return nil, apiError("InvalidParameter", "interface endpoints take subnets, not route tables")
+		}
+		if aws.BoolValue(in.PrivateDnsEnabled) {
+			attrs := f.vpcAttributes[*vpc.VpcId]
+			if !attrs["enableDnsSupport"] || !attrs["enableDnsHostnames"] {
+				return nil, apiError("InvalidParameter", "private DNS needs the vpc's enableDnsSupport and enableDnsHostnames attributes set")
+			}
+		}
+		groups, err := f.groupsFor(vpc.VpcId, in.SecurityGroupIds)
+		if err != nil {
+			return nil, err
+		}
+		endpoint.Groups = make([]*ec2.SecurityGroupIdentifier, len(groups))
+		for i, g := range groups {
+			endpoint.Groups[i] = &ec2.SecurityGroupIdentifier{GroupId: g.GroupId, GroupName: g.GroupName}
+		}
+		endpoint.PrivateDnsEnabled = aws.Bool(aws.BoolValue(in.PrivateDnsEnabled))
+		endpoint.State = aws.String("pending")
+		if err := f.addEndpointSubnets(endpoint, in.SubnetIds); err != nil {
+			f.removeEndpointInterfaces(endpoint)
+			return nil, err
+		}
+	default:
+		return nil, apiError("InvalidParameterValue", "Value (%s) for parameter vpcEndpointType is invalid", endpointType)
+	}
+	f.endpoints[*endpoint.VpcEndpointId] = endpoint
+	return &ec2.CreateVpcEndpointOutput{VpcEndpoint: clone(endpoint).(*ec2.VpcEndpoint)}, nil
+}
+
+func (f *EC2) checkEndpointRouteTables(vpcID *string, IDs []*string) error {
+	for _, ID := range IDs {
+		rt := f.routeTables[aws.StringValue(ID)]
+		if rt == nil || *rt.VpcId != *vpcID {
+			return apiError("InvalidRouteTableId.NotFound", "The routeTable ID '%s' does not exist in %s", aws.StringValue(ID), *vpcID)
+		}
+	}
+	return nil
+}
+
+// addEndpointSubnets gives the interface endpoint a network interface in each
+// subnet.  An endpoint can only have one subnet per zone.
+func (f *EC2) addEndpointSubnets(endpoint *ec2.VpcEndpoint, IDs []*string) error {
+	zones := map[string]bool{}
+	for _, ID := range endpoint.SubnetIds {
+		zones[*f.subnets[*ID].AvailabilityZone] = true
+	}
+	var groups []*ec2.GroupIdentifier
+	for _, g := range endpoint.Groups {
+		groups = append(groups, &ec2.GroupIdentifier{GroupId: g.GroupId, GroupName: g.GroupName})
+	}
+	for _, ID := range IDs {
+		subnet := f.subnets[aws.StringValue(ID)]
+		if subnet == nil || *subnet.VpcId != *endpoint.VpcId {
+			return apiError("InvalidSubnetId.NotFound", "The subnet ID '%s' does not exist in %s", aws.StringValue(ID), *endpoint.VpcId)
+		}
+		if zones[*subnet.AvailabilityZone] {
+			return apiError("DuplicateSubnetsInSameZone", "Found another VPC endpoint subnet in the availability zone of %s", *subnet.SubnetId)
+		}
+		zones[*subnet.AvailabilityZone] = true
+		eni := f.newNetworkInterface(subnet, groups)
+		eni.InterfaceType = aws.String("vpc_endpoint")
+		eni.RequesterManaged = aws.Bool(true)
+		eni.Description = aws.String("VPC Endpoint Interface " + *endpoint.VpcEndpointId)
+		endpoint.SubnetIds = append(endpoint.SubnetIds, subnet.SubnetId)
+		endpoint.NetworkInterfaceIds = append(endpoint.NetworkInterfaceIds, eni.NetworkInterfaceId)
+	}
+	return nil
+}
+
+// removeEndpointInterfaces deletes the interface endpoint's network
+// interfaces, in the subnets given or all of them.
+func (f *EC2) removeEndpointInterfaces(endpoint *ec2.VpcEndpoint, subnetIDs ...*string) {
+	var keptSubnets, keptENIs []*string
+	for i, eniID := range endpoint.NetworkInterfaceIds {
+		subnetID := endpoint.SubnetIds[i]
+		if len(subnetIDs) > 0 && !includes(subnetIDs, *subnetID) {
+			keptSubnets = append(keptSubnets, subnetID)
+			keptENIs = append(keptENIs, eniID)
+			continue
+		}
+		f.forget(*eniID)
+		delete(f.networkInterfaces, *eniID)
+	}
+	endpoint.SubnetIds, endpoint.NetworkInterfaceIds = keptSubnets, keptENIs
+}
+
+// DescribeVpcEndpoints first moves each endpoint it describes on from
+// "pending" to "available", and drops endpoints that were "deleting" along
+// with their network interfaces, as EC2 does after a while.
+func (f *EC2) DescribeVpcEndpoints(in *ec2.DescribeVpcEndpointsInput) (*ec2.DescribeVpcEndpointsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeVpcEndpoints"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeVpcEndpointsOutput{}
+	for _, ID := range f.order {
+		endpoint := f.endpoints[ID]
+		if endpoint == nil || !wanted(ID, in.VpcEndpointIds) {
+			continue
+		}
+		switch *endpoint.State {
+		case "pending":
+			endpoint.State = aws.String("available")
+		case "deleting":
+			f.removeEndpointInterfaces(endpoint)
+			f.forget(ID)
+			delete(f.endpoints, ID)
+			continue
+		}
+		ok, err := f.match(ID, in.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "vpc-endpoint-id":
+				return []string{ID}, true
+			case "vpc-id":
+				return []string{*endpoint.VpcId}, true
+			case "service-name":
+				return []string{*endpoint.ServiceName}, true
+			case "vpc-endpoint-state":
+				return []string{*endpoint.State}, true
+			case "vpc-endpoint-type":
+				return []string{*endpoint.VpcEndpointType}, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			c := clone(endpoint).(*ec2.VpcEndpoint)
+			c.Tags = f.ec2Tags(ID)
+			out.VpcEndpoints = append(out.VpcEndpoints, c)
+		}
+	}
+	if err := notFound("InvalidVpcEndpointId.NotFound", in.VpcEndpointIds, len(out.VpcEndpoints)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ModifyVpcEndpoint adds and removes a gateway endpoint's route tables and
+// an interface endpoint's subnets.
+func (f *EC2) ModifyVpcEndpoint(in *ec2.ModifyVpcEndpointInput) (*ec2.ModifyVpcEndpointOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("ModifyVpcEndpoint"); err != nil {
+		return nil, err
+	}
+	ID := aws.StringValue(in.VpcEndpointId)
+	endpoint := f.endpoints[ID]
+	if endpoint == nil || *endpoint.State == "deleting" {
+		return nil, apiError("InvalidVpcEndpointId.NotFound", "The Vpc Endpoint Id '%s' does not exist", ID)
+	}
+	gateway := *endpoint.VpcEndpointType == ec2.VpcEndpointTypeGateway
+	if gateway && (len(in.AddSubnetIds) > 0 || len(in.RemoveSubnetIds) > 0) ||
+		!gateway && (len(in.AddRouteTableIds) > 0 || len(in.RemoveRouteTableIds) > 0) {
+		return nil, apiError("InvalidParameter", "gateway endpoints take route tables and interface endpoints subnets")
+	}
+
+	if err := f.checkEndpointRouteTables(endpoint.VpcId, in.AddRouteTableIds); err != nil {
+		return nil, err
+	}
+	for _, rtID := range in.AddRouteTableIds {
+		if !includes(endpoint.RouteTableIds, *rtID) {
+			endpoint.RouteTableIds = append(endpoint.RouteTableIds, rtID)
+		}
+	}
+	var keep []*string
+	for _, rtID := range endpoint.RouteTableIds {
+		if !includes(in.RemoveRouteTableIds, *rtID) {
+			keep = append(keep, rtID)
+		}
+	}
+	endpoint.RouteTableIds = keep
+
+	if len(in.RemoveSubnetIds) > 0 {
+		f.removeEndpointInterfaces(endpoint, in.RemoveSubnetIds...)
+	}
+	if err := f.addEndpointSubnets(endpoint, in.AddSubnetIds); err != nil {
+		return nil, err
+	}
+	return &ec2.ModifyVpcEndpointOutput{Return: aws.Bool(true)}, nil
+}
+
+// DeleteVpcEndpoints starts deleting the endpoints.  They are "deleting",
+// and an interface endpoint's network interfaces stay, until they are next
+// described.
+func (f *EC2) DeleteVpcEndpoints(in *ec2.DeleteVpcEndpointsInput) (*ec2.DeleteVpcEndpointsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteVpcEndpoints"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DeleteVpcEndpointsOutput{}
+	for _, ID := range in.VpcEndpointIds {
+		endpoint := f.endpoints[aws.StringValue(ID)]
+		if endpoint == nil {
+			out.Unsuccessful = append(out.Unsuccessful, &ec2.UnsuccessfulItem{
+				ResourceId: ID,
+				Error: &ec2.UnsuccessfulItemError{
+					Code:    aws.String("InvalidVpcEndpoint.NotFound"),
+					Message: aws.String("The VPC endpoint '" + aws.StringValue(ID) + "' does not exist"),
+				},
+			})
+			continue
+		}
+		endpoint.State = aws.String("deleting")
+	}
+	return out, nil
+}
+
+// endpointIn returns the ID of an endpoint in vpcID.
+func (f *EC2) endpointIn(vpcID string) string {
+	for ID, endpoint := range f.endpoints {
+		if *endpoint.VpcId == vpcID {
+			return ID
+		}
+	}
+	return ""
+}
+
+// includes reports whether ID is in IDs.
+func includes(IDs []*string, ID string) bool {
+	return len(IDs) > 0 && wanted(ID, IDs)
+}
@@ -0,0 +1,185 @@
+package awsextratest
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+//
+// Flow logs
+//
+
+// CreateFlowLogs creates a flow log for each VPC.  A VPC that doesn't exist,
+// or already has a flow log of the same traffic to the same destination, is
+// reported in Unsuccessful rather than failing the call, as EC2 does.
+func (f *EC2) CreateFlowLogs(in *ec2.CreateFlowLogsInput) (*ec2.CreateFlowLogsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateFlowLogs"); err != nil {
+		return nil, err
+	}
+	if aws.StringValue(in.ResourceType) != ec2.FlowLogsResourceTypeVpc {
+		return nil, apiError("InvalidParameterValue", "only VPC flow logs are modelled, not %s", aws.StringValue(in.ResourceType))
+	}
+	destinationType := aws.StringValue(in.LogDestinationType)
+	if destinationType == "" {
+		destinationType = ec2.LogDestinationTypeCloudWatchLogs
+	}
+	trafficType := aws.StringValue(in.TrafficType)
+	switch trafficType {
+	case ec2.TrafficTypeAll, ec2.TrafficTypeAccept, ec2.TrafficTypeReject:
+	default:
+		return nil, apiError("InvalidParameterValue", "Value (%s) for parameter trafficType is invalid", trafficType)
+	}
+	interval := aws.Int64Value(in.MaxAggregationInterval)
+	if interval == 0 {
+		interval = 600
+	}
+	if interval != 60 && interval != 600 {
+		return nil, apiError("InvalidParameterValue", "Value (%d) for parameter maxAggregationInterval is invalid", interval)
+	}
+	flowLog := &ec2.FlowLog{
+		LogDestinationType:     aws.String(destinationType),
+		TrafficType:            aws.String(trafficType),
+		MaxAggregationInterval: aws.Int64(interval),
+		FlowLogStatus:          aws.String("ACTIVE"),
+		DeliverLogsStatus:      aws.String("SUCCESS"),
+		LogFormat:              in.LogFormat,
+	}
+	if flowLog.LogFormat == nil {
+		flowLog.LogFormat = aws.String("${version} ${account-id} ${interface-id} ${srcaddr} ${dstaddr} ${srcport} ${dstport} ${protocol} ${packets} ${bytes} ${start} ${end} ${action} ${log-status}")
+	}
+	switch destinationType {
+	case ec2.LogDestinationTypeCloudWatchLogs:
+		if in.LogGroupName == nil || !strings.HasPrefix(aws.StringValue(in.DeliverLogsPermissionArn), "arn:aws:iam::") {
+			return nil, apiError("InvalidParameter", "cloud-watch-logs flow logs need a log group name and a deliverLogsPermissionArn")
+		}
+		flowLog.LogGroupName = in.LogGroupName
+		flowLog.LogDestination = aws.String("arn:aws:logs:" + f.region + ":" + f.account + ":log-group:" + *in.LogGroupName)
+		flowLog.DeliverLogsPermissionArn = in.DeliverLogsPermissionArn
+	case ec2.LogDestinationTypeS3:
+		if !strings.HasPrefix(aws.StringValue(in.LogDestination), "arn:aws:s3:::") || in.DeliverLogsPermissionArn != nil {
+			return nil, apiError("InvalidParameter", "s3 flow logs need a bucket ARN and no deliverLogsPermissionArn")
+		}
+		flowLog.LogDestination = in.LogDestination
+	default:
+		return nil, apiError("InvalidParameterValue", "Value (%s) for parameter logDestinationType is invalid", destinationType)
+	}
+
+	out := &ec2.CreateFlowLogsOutput{}
+	for _, vpcID := range in.ResourceIds {
+		unsuccessful := func(code string, message string) {
+			out.Unsuccessful = append(out.Unsuccessful, &ec2.UnsuccessfulItem{
+				ResourceId: vpcID,
+				Error:      &ec2.UnsuccessfulItemError{Code: aws.String(code), Message: aws.String(message)},
+			})
+		}
+		if f.vpcs[aws.StringValue(vpcID)] == nil {
+			unsuccessful("InvalidVpcID.NotFound", "The vpc ID '"+aws.StringValue(vpcID)+"' does not exist")
+			continue
+		}
+		if f.duplicateFlowLog(*vpcID, flowLog) {
+			unsuccessful("FlowLogAlreadyExists", "There is an existing Flow Log with the same configuration and log destination.")
+			continue
+		}
+		c := clone(flowLog).(*ec2.FlowLog)
+		c.FlowLogId = f.newID("fl")
+		c.ResourceId = vpcID
+		f.flowLogs[*c.FlowLogId] = c
+		out.FlowLogIds = append(out.FlowLogIds, c.FlowLogId)
+	}
+	return out, nil
+}
+
+func (f *EC2) duplicateFlowLog(vpcID string, flowLog *ec2.FlowLog) bool {
+	for _, other := range f.flowLogs {
+		if *other.ResourceId == vpcID && *other.TrafficType == *flowLog.TrafficType &&
+			*other.LogDestinationType == *flowLog.LogDestinationType && *other.LogDestination == *flowLog.LogDestination {
+			return true
+		}
+	}
+	return false
+}
+
+// DescribeFlowLogs describes flow logs, which EC2 filters through Filter
+// rather than Filters.
+func (f *EC2) DescribeFlowLogs(in *ec2.DescribeFlowLogsInput) (*ec2.DescribeFlowLogsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeFlowLogs"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeFlowLogsOutput{}
+	for _, ID := range f.order {
+		flowLog := f.flowLogs[ID]
+		if flowLog == nil || !wanted(ID, in.FlowLogIds) {
+			continue
+		}
+		ok, err := f.match(ID, in.Filter, func(name string) ([]string, bool) {
+			switch name {
+			case "flow-log-id":
+				return []string{ID}, true
+			case "resource-id":
+				return []string{*flowLog.ResourceId}, true
+			case "traffic-type":
+				return []string{*flowLog.TrafficType}, true
+			case "log-destination-type":
+				return []string{*flowLog.LogDestinationType}, true
+			case "log-group-name":
+				return []string{aws.StringValue(flowLog.LogGroupName)}, true
+			case "deliver-log-status":
+				return []string{*flowLog.DeliverLogsStatus}, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			c := clone(flowLog).(*ec2.FlowLog)
+			c.Tags = f.ec2Tags(ID)
+			out.FlowLogs = append(out.FlowLogs, c)
+		}
+	}
+	// EC2 describes no flow logs, rather than failing, for IDs it doesn't
+	// know.
+	return out, nil
+}
+
+// DeleteFlowLogs deletes the flow logs, reporting the ones that don't exist
+// in Unsuccessful.
+func (f *EC2) DeleteFlowLogs(in *ec2.DeleteFlowLogsInput) (*ec2.DeleteFlowLogsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteFlowLogs"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DeleteFlowLogsOutput{}
+	for _, ID := range in.FlowLogIds {
+		if f.flowLogs[aws.StringValue(ID)] == nil {
+			out.Unsuccessful = append(out.Unsuccessful, &ec2.UnsuccessfulItem{
+				ResourceId: ID,
+				Error: &ec2.UnsuccessfulItemError{
+					Code:    aws.String("InvalidFlowLogId.NotFound"),
+					Message: aws.String("The flow log '" + aws.StringValue(ID) + "' does not exist"),
+				},
+			})
+			continue
+		}
+		f.forget(*ID)
+		delete(f.flowLogs, *ID)
+	}
+	return out, nil
+}
+
+// flowLogOf returns the ID of a flow log of the VPC.
+func (f *EC2) flowLogOf(vpcID string) string {
+	for ID, flowLog := range f.flowLogs {
+		if *flowLog.ResourceId == vpcID {
+			return ID
+		}
+	}
+	return ""
+}
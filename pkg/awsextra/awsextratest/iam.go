@@ -0,0 +1,202 @@
+package awsextratest
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// IAM is an in-memory simulation of the IAM roles and inline role policies
+// awsextra uses, with the error codes IAM returns.  It is safe for
+// concurrent use.
+type IAM struct {
+	mu       sync.Mutex
+	nextID   int
+	calls    []string
+	faults   map[string]error
+	roles    map[string]*iam.Role
+	policies map[string]map[string]string // role name to policy name to document
+}
+
+// NewIAM returns an IAM with no roles.
+func NewIAM() *IAM {
+	return &IAM{
+		faults:   map[string]error{},
+		roles:    map[string]*iam.Role{},
+		policies: map[string]map[string]string{},
+	}
+}
+
+// InjectError makes the next call to operation (eg. "CreateRole") fail with
+// err instead of running.
+func (f *IAM) InjectError(operation string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults[operation] = err
+}
+
+// Calls returns the names of the operations called so far, in order.
+func (f *IAM) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// RolePolicy returns the document of the role's inline policy, or "".
+func (f *IAM) RolePolicy(roleName string, policyName string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.policies[roleName][policyName]
+}
+
+// ResourceCount returns how many roles exist.
+func (f *IAM) ResourceCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.roles)
+}
+
+func (f *IAM) begin(operation string) error {
+	f.calls = append(f.calls, operation)
+	if err, ok := f.faults[operation]; ok {
+		delete(f.faults, operation)
+		return err
+	}
+	return nil
+}
+
+func noSuchRole(name string) error {
+	return apiError(iam.ErrCodeNoSuchEntityException, "The role with name %s cannot be found.", name)
+}
+
+func (f *IAM) CreateRole(in *iam.CreateRoleInput) (*iam.CreateRoleOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateRole"); err != nil {
+		return nil, err
+	}
+	name := aws.StringValue(in.RoleName)
+	if name == "" || len(name) > 64 || aws.StringValue(in.AssumeRolePolicyDocument) == "" {
+		return nil, apiError(iam.ErrCodeInvalidInputException, "a role needs a name of up to 64 characters and an assume role policy")
+	}
+	if f.roles[name] != nil {
+		return nil, apiError(iam.ErrCodeEntityAlreadyExistsException, "Role with name %s already exists.", name)
+	}
+	f.nextID++
+	role := &iam.Role{
+		RoleName:                 in.RoleName,
+		RoleId:                   aws.String(fmt.Sprintf("AROA%016X", f.nextID)),
+		Arn:                      aws.String("arn:aws:iam::123456789012:role/" + name),
+		Path:                     aws.String("/"),
+		AssumeRolePolicyDocument: in.AssumeRolePolicyDocument,
+		Description:              in.Description,
+		CreateDate:               aws.Time(time.Now()),
+		Tags:                     in.Tags,
+	}
+	f.roles[name] = role
+	f.policies[name] = map[string]string{}
+	return &iam.CreateRoleOutput{Role: clone(role).(*iam.Role)}, nil
+}
+
+func (f *IAM) GetRole(in *iam.GetRoleInput) (*iam.GetRoleOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("GetRole"); err != nil {
+		return nil, err
+	}
+	role := f.roles[aws.StringValue(in.RoleName)]
+	if role == nil {
+		return nil, noSuchRole(aws.StringValue(in.RoleName))
+	}
+	return &iam.GetRoleOutput{Role: clone(role).(*iam.Role)}, nil
+}
+
+func (f *IAM) PutRolePolicy(in *iam.PutRolePolicyInput) (*iam.PutRolePolicyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("PutRolePolicy"); err != nil {
+		return nil, err
+	}
+	name := aws.StringValue(in.RoleName)
+	if f.roles[name] == nil {
+		return nil, noSuchRole(name)
+	}
+	if aws.StringValue(in.PolicyName) == "" || aws.StringValue(in.PolicyDocument) == "" {
+		return nil, apiError(iam.ErrCodeMalformedPolicyDocumentException, "a policy needs a name and a document")
+	}
+	f.policies[name][*in.PolicyName] = *in.PolicyDocument
+	return &iam.PutRolePolicyOutput{}, nil
+}
+
+func (f *IAM) DeleteRolePolicy(in *iam.DeleteRolePolicyInput) (*iam.DeleteRolePolicyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteRolePolicy"); err != nil {
+		return nil, err
+	}
+	name := aws.StringValue(in.RoleName)
+	if _, ok := f.policies[name][aws.StringValue(in.PolicyName)]; !ok {
+		return nil, apiError(iam.ErrCodeNoSuchEntityException, "The role policy with name %s cannot be found.", aws.StringValue(in.PolicyName))
+	}
+	delete(f.policies[name], *in.PolicyName)
+	return &iam.DeleteRolePolicyOutput{}, nil
+}
+
+// DeleteRole fails with DeleteConflict while the role has inline policies,
+// as IAM does.
+func (f *IAM) DeleteRole(in *iam.DeleteRoleInput) (*iam.DeleteRoleOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteRole"); err != nil {
+		return nil, err
+	}
+	name := aws.StringValue(in.RoleName)
+	if f.roles[name] == nil {
+		return nil, noSuchRole(name)
+	}
+	if len(f.policies[name]) > 0 {
+		return nil, apiError(iam.ErrCodeDeleteConflictException, "Cannot delete entity, must delete policies first.")
+	}
+	delete(f.roles, name)
+	delete(f.policies, name)
+	return &iam.DeleteRoleOutput{}, nil
+}
+
+func (f *IAM) CreateRoleWithContext(ctx aws.Context, in *iam.CreateRoleInput, _ ...request.Option) (*iam.CreateRoleOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateRole(in)
+}
+
+func (f *IAM) GetRoleWithContext(ctx aws.Context, in *iam.GetRoleInput, _ ...request.Option) (*iam.GetRoleOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.GetRole(in)
+}
+
+func (f *IAM) PutRolePolicyWithContext(ctx aws.Context, in *iam.PutRolePolicyInput, _ ...request.Option) (*iam.PutRolePolicyOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.PutRolePolicy(in)
+}
+
+func (f *IAM) DeleteRolePolicyWithContext(ctx aws.Context, in *iam.DeleteRolePolicyInput, _ ...request.Option) (*iam.DeleteRolePolicyOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteRolePolicy(in)
+}
+
+func (f *IAM) DeleteRoleWithContext(ctx aws.Context, in *iam.DeleteRoleInput, _ ...request.Option) (*iam.DeleteRoleOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteRole(in)
+}
@@ -0,0 +1,347 @@
+package awsextratest
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+//
+// Instances
+//
+
+// RunInstances launches one instance per call into in.SubnetId, with a
+// primary network interface that is deleted when the instance terminates.
+// Instances go straight to running and, when terminated, straight to
+// terminated.
+func (f *EC2) RunInstances(in *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("RunInstances"); err != nil {
+		return nil, err
+	}
+	subnet := f.subnets[aws.StringValue(in.SubnetId)]
+	if subnet == nil {
+		return nil, apiError("InvalidSubnetID.NotFound", "The subnet ID '%s' does not exist", aws.StringValue(in.SubnetId))
+	}
+	groups, err := f.groupsFor(subnet.VpcId, in.SecurityGroupIds)
+	if err != nil {
+		return nil, err
+	}
+	instance := &ec2.Instance{
+		InstanceId:     f.newID("i"),
+		ImageId:        in.ImageId,
+		InstanceType:   in.InstanceType,
+		SubnetId:       subnet.SubnetId,
+		VpcId:          subnet.VpcId,
+		Placement:      &ec2.Placement{AvailabilityZone: subnet.AvailabilityZone},
+		State:          &ec2.InstanceState{Code: aws.Int64(16), Name: aws.String("running")},
+		SecurityGroups: groups,
+	}
+	eni := f.newNetworkInterface(subnet, groups)
+	eni.Status = aws.String("in-use")
+	eni.Attachment = &ec2.NetworkInterfaceAttachment{
+		AttachmentId:        f.newID("eni-attach"),
+		InstanceId:          instance.InstanceId,
+		DeviceIndex:         aws.Int64(0),
+		DeleteOnTermination: aws.Bool(true),
+		Status:              aws.String("attached"),
+	}
+	f.instances[*instance.InstanceId] = instance
+	return &ec2.Reservation{
+		ReservationId: f.newID("r"),
+		Instances:     []*ec2.Instance{f.describeInstance(instance)},
+	}, nil
+}
+
+func (f *EC2) DescribeInstances(in *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeInstances"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeInstancesOutput{}
+	for _, ID := range f.order {
+		instance := f.instances[ID]
+		if instance == nil || !wanted(ID, in.InstanceIds) {
+			continue
+		}
+		ok, err := f.match(ID, in.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "instance-id":
+				return []string{ID}, true
+			case "vpc-id":
+				return []string{aws.StringValue(instance.VpcId)}, true
+			case "subnet-id":
+				return []string{aws.StringValue(instance.SubnetId)}, true
+			case "instance-state-name":
+				return []string{*instance.State.Name}, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out.Reservations = append(out.Reservations, &ec2.Reservation{
+				Instances: []*ec2.Instance{f.describeInstance(instance)},
+			})
+		}
+	}
+	if err := notFound("InvalidInstanceID.NotFound", in.InstanceIds, len(out.Reservations)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (f *EC2) TerminateInstances(in *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("TerminateInstances"); err != nil {
+		return nil, err
+	}
+	for _, ID := range in.InstanceIds {
+		if f.instances[aws.StringValue(ID)] == nil {
+			return nil, apiError("InvalidInstanceID.NotFound", "The instance ID '%s' does not exist", aws.StringValue(ID))
+		}
+	}
+	out := &ec2.TerminateInstancesOutput{}
+	for _, ID := range in.InstanceIds {
+		instance := f.instances[*ID]
+		previous := clone(instance.State).(*ec2.InstanceState)
+		instance.State = &ec2.InstanceState{Code: aws.Int64(48), Name: aws.String("terminated")}
+		// Attached interfaces go with the instance or are left available.
+		for eniID, eni := range f.networkInterfaces {
+			if eni.Attachment == nil || aws.StringValue(eni.Attachment.InstanceId) != *ID {
+				continue
+			}
+			if aws.BoolValue(eni.Attachment.DeleteOnTermination) {
+				f.forget(eniID)
+				delete(f.networkInterfaces, eniID)
+			} else {
+				eni.Attachment = nil
+				eni.Status = aws.String("available")
+			}
+		}
+		out.TerminatingInstances = append(out.TerminatingInstances, &ec2.InstanceStateChange{
+			InstanceId:    instance.InstanceId,
+			PreviousState: previous,
+			CurrentState:  clone(instance.State).(*ec2.InstanceState),
+		})
+	}
+	return out, nil
+}
+
+func (f *EC2) describeInstance(instance *ec2.Instance) *ec2.Instance {
+	c := clone(instance).(*ec2.Instance)
+	c.Tags = f.ec2Tags(*instance.InstanceId)
+	for _, ID := range f.order {
+		eni := f.networkInterfaces[ID]
+		if eni != nil && eni.Attachment != nil && aws.StringValue(eni.Attachment.InstanceId) == *instance.InstanceId {
+			c.NetworkInterfaces = append(c.NetworkInterfaces, &ec2.InstanceNetworkInterface{
+				NetworkInterfaceId: eni.NetworkInterfaceId,
+				SubnetId:           eni.SubnetId,
+				VpcId:              eni.VpcId,
+			})
+		}
+	}
+	return c
+}
+
+// running reports whether the instance has not been terminated.
+func running(instance *ec2.Instance) bool {
+	return aws.StringValue(instance.State.Name) != "terminated"
+}
+
+//
+// Network interfaces
+//
+
+func (f *EC2) newNetworkInterface(subnet *ec2.Subnet, groups []*ec2.GroupIdentifier) *ec2.NetworkInterface {
+	eni := &ec2.NetworkInterface{
+		NetworkInterfaceId: f.newID("eni"),
+		SubnetId:           subnet.SubnetId,
+		VpcId:              subnet.VpcId,
+		AvailabilityZone:   subnet.AvailabilityZone,
+		Groups:             groups,
+		Status:             aws.String("available"),
+		RequesterManaged:   aws.Bool(false),
+		InterfaceType:      aws.String("interface"),
+	}
+	f.networkInterfaces[*eni.NetworkInterfaceId] = eni
+	return eni
+}
+
+// groupsFor resolves security group IDs in vpcID, defaulting to the VPC's
+// default group.
+func (f *EC2) groupsFor(vpcID *string, IDs []*string) ([]*ec2.GroupIdentifier, error) {
+	var groups []*ec2.GroupIdentifier
+	for _, sg := range f.securityGroups {
+		if len(IDs) == 0 && aws.StringValue(sg.VpcId) == *vpcID && aws.StringValue(sg.GroupName) == "default" {
+			groups = append(groups, &ec2.GroupIdentifier{GroupId: sg.GroupId, GroupName: sg.GroupName})
+		}
+	}
+	for _, ID := range IDs {
+		sg := f.securityGroups[aws.StringValue(ID)]
+		if sg == nil || aws.StringValue(sg.VpcId) != *vpcID {
+			return nil, apiError("InvalidGroup.NotFound", "The security group '%s' does not exist in VPC '%s'", aws.StringValue(ID), *vpcID)
+		}
+		groups = append(groups, &ec2.GroupIdentifier{GroupId: sg.GroupId, GroupName: sg.GroupName})
+	}
+	return groups, nil
+}
+
+func (f *EC2) CreateNetworkInterface(in *ec2.CreateNetworkInterfaceInput) (*ec2.CreateNetworkInterfaceOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateNetworkInterface"); err != nil {
+		return nil, err
+	}
+	subnet := f.subnets[aws.StringValue(in.SubnetId)]
+	if subnet == nil {
+		return nil, apiError("InvalidSubnetID.NotFound", "The subnet ID '%s' does not exist", aws.StringValue(in.SubnetId))
+	}
+	groups, err := f.groupsFor(subnet.VpcId, in.Groups)
+	if err != nil {
+		return nil, err
+	}
+	eni := f.newNetworkInterface(subnet, groups)
+	eni.Description = in.Description
+	return &ec2.CreateNetworkInterfaceOutput{NetworkInterface: clone(eni).(*ec2.NetworkInterface)}, nil
+}
+
+// SetRequesterManaged marks a network interface as owned by the AWS service
+// requesterID, such as the ones NAT gateways and load balancers create, which
+// users cannot detach or delete.
+func (f *EC2) SetRequesterManaged(eniID string, requesterID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if eni := f.networkInterfaces[eniID]; eni != nil {
+		eni.RequesterManaged = aws.Bool(true)
+		eni.RequesterId = aws.String(requesterID)
+	}
+}
+
+func (f *EC2) AttachNetworkInterface(in *ec2.AttachNetworkInterfaceInput) (*ec2.AttachNetworkInterfaceOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("AttachNetworkInterface"); err != nil {
+		return nil, err
+	}
+	eni := f.networkInterfaces[aws.StringValue(in.NetworkInterfaceId)]
+	if eni == nil {
+		return nil, apiError("InvalidNetworkInterfaceID.NotFound", "The networkInterface ID '%s' does not exist", aws.StringValue(in.NetworkInterfaceId))
+	}
+	instance := f.instances[aws.StringValue(in.InstanceId)]
+	if instance == nil || !running(instance) {
+		return nil, apiError("InvalidInstanceID.NotFound", "The instance ID '%s' does not exist", aws.StringValue(in.InstanceId))
+	}
+	if eni.Attachment != nil {
+		return nil, apiError("InvalidNetworkInterface.InUse", "Interface: [%s] in use.", *eni.NetworkInterfaceId)
+	}
+	eni.Status = aws.String("in-use")
+	eni.Attachment = &ec2.NetworkInterfaceAttachment{
+		AttachmentId:        f.newID("eni-attach"),
+		InstanceId:          instance.InstanceId,
+		DeviceIndex:         in.DeviceIndex,
+		DeleteOnTermination: aws.Bool(false),
+		Status:              aws.String("attached"),
+	}
+	return &ec2.AttachNetworkInterfaceOutput{AttachmentId: aws.String(*eni.Attachment.AttachmentId)}, nil
+}
+
+func (f *EC2) DescribeNetworkInterfaces(in *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeNetworkInterfaces"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeNetworkInterfacesOutput{}
+	for _, ID := range f.order {
+		eni := f.networkInterfaces[ID]
+		if eni == nil || !wanted(ID, in.NetworkInterfaceIds) {
+			continue
+		}
+		ok, err := f.match(ID, in.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "network-interface-id":
+				return []string{ID}, true
+			case "vpc-id":
+				return []string{*eni.VpcId}, true
+			case "subnet-id":
+				return []string{*eni.SubnetId}, true
+			case "status":
+				return []string{*eni.Status}, true
+			case "group-id":
+				var groups []string
+				for _, g := range eni.Groups {
+					groups = append(groups, *g.GroupId)
+				}
+				return groups, true
+			case "attachment.instance-id":
+				if eni.Attachment != nil {
+					return []string{*eni.Attachment.InstanceId}, true
+				}
+				return nil, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			c := clone(eni).(*ec2.NetworkInterface)
+			c.TagSet = f.ec2Tags(ID)
+			out.NetworkInterfaces = append(out.NetworkInterfaces, c)
+		}
+	}
+	if err := notFound("InvalidNetworkInterfaceID.NotFound", in.NetworkInterfaceIds, len(out.NetworkInterfaces)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (f *EC2) DetachNetworkInterface(in *ec2.DetachNetworkInterfaceInput) (*ec2.DetachNetworkInterfaceOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DetachNetworkInterface"); err != nil {
+		return nil, err
+	}
+	attachmentID := aws.StringValue(in.AttachmentId)
+	for _, eni := range f.networkInterfaces {
+		if eni.Attachment == nil || aws.StringValue(eni.Attachment.AttachmentId) != attachmentID {
+			continue
+		}
+		if aws.BoolValue(eni.RequesterManaged) {
+			return nil, apiError("OperationNotPermitted", "You do not have permission to access the specified resource.")
+		}
+		if aws.Int64Value(eni.Attachment.DeviceIndex) == 0 {
+			return nil, apiError("OperationNotPermitted", "The network interface at device index 0 cannot be detached.")
+		}
+		eni.Attachment = nil
+		eni.Status = aws.String("available")
+		return &ec2.DetachNetworkInterfaceOutput{}, nil
+	}
+	return nil, apiError("InvalidAttachmentID.NotFound", "The attachment ID '%s' does not exist", attachmentID)
+}
+
+func (f *EC2) DeleteNetworkInterface(in *ec2.DeleteNetworkInterfaceInput) (*ec2.DeleteNetworkInterfaceOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteNetworkInterface"); err != nil {
+		return nil, err
+	}
+	eniID := aws.StringValue(in.NetworkInterfaceId)
+	eni := f.networkInterfaces[eniID]
+	if eni == nil {
+		return nil, apiError("InvalidNetworkInterfaceID.NotFound", "The networkInterface ID '%s' does not exist", eniID)
+	}
+	if aws.BoolValue(eni.RequesterManaged) {
+		return nil, apiError("OperationNotPermitted", "You do not have permission to access the specified resource.")
+	}
+	if eni.Attachment != nil {
+		return nil, apiError("InvalidNetworkInterface.InUse", "Interface: [%s] in use.", eniID)
+	}
+	f.forget(eniID)
+	delete(f.networkInterfaces, eniID)
+	return &ec2.DeleteNetworkInterfaceOutput{}, nil
+}
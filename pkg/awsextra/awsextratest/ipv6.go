@@ -0,0 +1,168 @@
+package awsextratest
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+//
+// IPv6 CIDR blocks
+//
+
+// newIPv6Block returns an Amazon provided /56 for a VPC, "associating" until
+// the VPC is next described.
+func (f *EC2) newIPv6Block() *ec2.VpcIpv6CidrBlockAssociation {
+	f.nextIPv6++
+	return &ec2.VpcIpv6CidrBlockAssociation{
+		AssociationId:      f.newID("vpc-cidr-assoc"),
+		Ipv6CidrBlock:      aws.String(fmt.Sprintf("2600:1f14:%x:%x00::/56", f.nextIPv6>>8, f.nextIPv6&0xff)),
+		Ipv6CidrBlockState: &ec2.VpcCidrBlockState{State: aws.String("associating")},
+		Ipv6Pool:           aws.String("Amazon"),
+	}
+}
+
+// AssociateVpcCidrBlock adds an Amazon provided IPv6 block to a VPC.  Extra
+// IPv4 blocks aren't modelled.
+func (f *EC2) AssociateVpcCidrBlock(in *ec2.AssociateVpcCidrBlockInput) (*ec2.AssociateVpcCidrBlockOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("AssociateVpcCidrBlock"); err != nil {
+		return nil, err
+	}
+	vpc := f.vpcs[aws.StringValue(in.VpcId)]
+	if vpc == nil {
+		return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", aws.StringValue(in.VpcId))
+	}
+	if !aws.BoolValue(in.AmazonProvidedIpv6CidrBlock) {
+		return nil, apiError("InvalidParameterValue", "only Amazon provided IPv6 blocks are modelled")
+	}
+	if len(vpc.Ipv6CidrBlockAssociationSet) > 0 {
+		return nil, apiError("CidrLimitExceeded", "The vpc '%s' already has an IPv6 CIDR block", *vpc.VpcId)
+	}
+	block := f.newIPv6Block()
+	vpc.Ipv6CidrBlockAssociationSet = append(vpc.Ipv6CidrBlockAssociationSet, block)
+	return &ec2.AssociateVpcCidrBlockOutput{VpcId: vpc.VpcId, Ipv6CidrBlockAssociation: clone(block).(*ec2.VpcIpv6CidrBlockAssociation)}, nil
+}
+
+// AssociateSubnetCidrBlock gives a subnet a /64 from its VPC's IPv6 block.
+func (f *EC2) AssociateSubnetCidrBlock(in *ec2.AssociateSubnetCidrBlockInput) (*ec2.AssociateSubnetCidrBlockOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("AssociateSubnetCidrBlock"); err != nil {
+		return nil, err
+	}
+	subnet := f.subnets[aws.StringValue(in.SubnetId)]
+	if subnet == nil {
+		return nil, apiError("InvalidSubnetID.NotFound", "The subnet ID '%s' does not exist", aws.StringValue(in.SubnetId))
+	}
+	if len(subnet.Ipv6CidrBlockAssociationSet) > 0 {
+		return nil, apiError("InvalidParameterValue", "The subnet '%s' already has an IPv6 CIDR block", *subnet.SubnetId)
+	}
+	block, err := f.checkSubnetIPv6(f.vpcs[*subnet.VpcId], aws.StringValue(in.Ipv6CidrBlock))
+	if err != nil {
+		return nil, err
+	}
+	subnet.Ipv6CidrBlockAssociationSet = []*ec2.SubnetIpv6CidrBlockAssociation{block}
+	return &ec2.AssociateSubnetCidrBlockOutput{SubnetId: subnet.SubnetId, Ipv6CidrBlockAssociation: clone(block).(*ec2.SubnetIpv6CidrBlockAssociation)}, nil
+}
+
+// checkSubnetIPv6 checks cidr is a /64 of the VPC's IPv6 block no other
+// subnet has, and returns its association.
+func (f *EC2) checkSubnetIPv6(vpc *ec2.Vpc, cidr string) (*ec2.SubnetIpv6CidrBlockAssociation, error) {
+	_, block, err := net.ParseCIDR(cidr)
+	if err != nil || block.IP.To4() != nil {
+		return nil, apiError("InvalidParameterValue", "Value (%s) for parameter ipv6CidrBlock is invalid", cidr)
+	}
+	if ones, _ := block.Mask.Size(); ones != 64 {
+		return nil, apiError("InvalidSubnet.Range", "The IPv6 CIDR '%s' is not a /64", cidr)
+	}
+	inVPC := false
+	for _, assoc := range vpc.Ipv6CidrBlockAssociationSet {
+		_, vpcBlock, _ := net.ParseCIDR(*assoc.Ipv6CidrBlock)
+		inVPC = inVPC || contains(vpcBlock, block)
+	}
+	if !inVPC {
+		return nil, apiError("InvalidSubnet.Range", "The IPv6 CIDR '%s' is not in the vpc's IPv6 block", cidr)
+	}
+	for _, other := range f.subnets {
+		for _, assoc := range other.Ipv6CidrBlockAssociationSet {
+			if aws.StringValue(assoc.Ipv6CidrBlock) == block.String() {
+				return nil, apiError("InvalidSubnet.Conflict", "The IPv6 CIDR '%s' conflicts with another subnet", cidr)
+			}
+		}
+	}
+	return &ec2.SubnetIpv6CidrBlockAssociation{
+		AssociationId:      f.newID("subnet-cidr-assoc"),
+		Ipv6CidrBlock:      aws.String(block.String()),
+		Ipv6CidrBlockState: &ec2.SubnetCidrBlockState{State: aws.String("associated")},
+	}, nil
+}
+
+//
+// Egress-only internet gateways
+//
+
+func (f *EC2) CreateEgressOnlyInternetGateway(in *ec2.CreateEgressOnlyInternetGatewayInput) (*ec2.CreateEgressOnlyInternetGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateEgressOnlyInternetGateway"); err != nil {
+		return nil, err
+	}
+	vpc := f.vpcs[aws.StringValue(in.VpcId)]
+	if vpc == nil {
+		return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", aws.StringValue(in.VpcId))
+	}
+	eigw := &ec2.EgressOnlyInternetGateway{
+		EgressOnlyInternetGatewayId: f.newID("eigw"),
+		Attachments:                 []*ec2.InternetGatewayAttachment{{VpcId: vpc.VpcId, State: aws.String("attached")}},
+	}
+	f.eigws[*eigw.EgressOnlyInternetGatewayId] = eigw
+	return &ec2.CreateEgressOnlyInternetGatewayOutput{EgressOnlyInternetGateway: clone(eigw).(*ec2.EgressOnlyInternetGateway)}, nil
+}
+
+// DescribeEgressOnlyInternetGateways, like EC2, only filters on tags.
+func (f *EC2) DescribeEgressOnlyInternetGateways(in *ec2.DescribeEgressOnlyInternetGatewaysInput) (*ec2.DescribeEgressOnlyInternetGatewaysOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeEgressOnlyInternetGateways"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeEgressOnlyInternetGatewaysOutput{}
+	for _, ID := range f.order {
+		eigw := f.eigws[ID]
+		if eigw == nil || !wanted(ID, in.EgressOnlyInternetGatewayIds) {
+			continue
+		}
+		ok, err := f.match(ID, in.Filters, func(string) ([]string, bool) { return nil, false })
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			c := clone(eigw).(*ec2.EgressOnlyInternetGateway)
+			c.Tags = f.ec2Tags(ID)
+			out.EgressOnlyInternetGateways = append(out.EgressOnlyInternetGateways, c)
+		}
+	}
+	if err := notFound("InvalidEgressOnlyInternetGatewayId.NotFound", in.EgressOnlyInternetGatewayIds, len(out.EgressOnlyInternetGateways)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (f *EC2) DeleteEgressOnlyInternetGateway(in *ec2.DeleteEgressOnlyInternetGatewayInput) (*ec2.DeleteEgressOnlyInternetGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteEgressOnlyInternetGateway"); err != nil {
+		return nil, err
+	}
+	ID := aws.StringValue(in.EgressOnlyInternetGatewayId)
+	if f.eigws[ID] == nil {
+		return nil, apiError("InvalidEgressOnlyInternetGatewayId.NotFound", "The egress-only internet gateway ID '%s' does not exist", ID)
+	}
+	f.forget(ID)
+	delete(f.eigws, ID)
+	return &ec2.DeleteEgressOnlyInternetGatewayOutput{ReturnCode: aws.Bool(true)}, nil
+}
@@ -0,0 +1,177 @@
+package awsextratest
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// Logs is an in-memory simulation of the CloudWatch Logs log groups awsextra
+// uses, with the error codes CloudWatch Logs returns.  It is safe for
+// concurrent use.
+type Logs struct {
+	mu     sync.Mutex
+	region string
+	calls  []string
+	faults map[string]error
+	groups map[string]*cloudwatchlogs.LogGroup
+	tags   map[string]map[string]*string
+}
+
+// NewLogs returns a Logs for region with no log groups.
+func NewLogs(region string) *Logs {
+	return &Logs{
+		region: region,
+		faults: map[string]error{},
+		groups: map[string]*cloudwatchlogs.LogGroup{},
+		tags:   map[string]map[string]*string{},
+	}
+}
+
+// InjectError makes the next call to operation (eg. "CreateLogGroup") fail
+// with err instead of running.
+func (f *Logs) InjectError(operation string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults[operation] = err
+}
+
+// Calls returns the names of the operations called so far, in order.
+func (f *Logs) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// ResourceCount returns how many log groups exist.
+func (f *Logs) ResourceCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.groups)
+}
+
+func (f *Logs) begin(operation string) error {
+	f.calls = append(f.calls, operation)
+	if err, ok := f.faults[operation]; ok {
+		delete(f.faults, operation)
+		return err
+	}
+	return nil
+}
+
+func noSuchLogGroup() error {
+	return apiError(cloudwatchlogs.ErrCodeResourceNotFoundException, "The specified log group does not exist.")
+}
+
+func (f *Logs) CreateLogGroup(in *cloudwatchlogs.CreateLogGroupInput) (*cloudwatchlogs.CreateLogGroupOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateLogGroup"); err != nil {
+		return nil, err
+	}
+	name := aws.StringValue(in.LogGroupName)
+	if name == "" {
+		return nil, apiError(cloudwatchlogs.ErrCodeInvalidParameterException, "logGroupName is required")
+	}
+	if f.groups[name] != nil {
+		return nil, apiError(cloudwatchlogs.ErrCodeResourceAlreadyExistsException, "The specified log group already exists")
+	}
+	f.groups[name] = &cloudwatchlogs.LogGroup{
+		LogGroupName: aws.String(name),
+		Arn:          aws.String("arn:aws:logs:" + f.region + ":123456789012:log-group:" + name + ":*"),
+		CreationTime: aws.Int64(time.Now().UnixNano() / int64(time.Millisecond)),
+		StoredBytes:  aws.Int64(0),
+	}
+	f.tags[name] = map[string]*string{}
+	for k, v := range in.Tags {
+		f.tags[name][k] = aws.String(aws.StringValue(v))
+	}
+	return &cloudwatchlogs.CreateLogGroupOutput{}, nil
+}
+
+// DescribeLogGroups describes the log groups whose names start with
+// LogGroupNamePrefix, in name order.
+func (f *Logs) DescribeLogGroups(in *cloudwatchlogs.DescribeLogGroupsInput) (*cloudwatchlogs.DescribeLogGroupsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeLogGroups"); err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range f.groups {
+		if strings.HasPrefix(name, aws.StringValue(in.LogGroupNamePrefix)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	out := &cloudwatchlogs.DescribeLogGroupsOutput{}
+	for _, name := range names {
+		out.LogGroups = append(out.LogGroups, clone(f.groups[name]).(*cloudwatchlogs.LogGroup))
+	}
+	return out, nil
+}
+
+func (f *Logs) ListTagsLogGroup(in *cloudwatchlogs.ListTagsLogGroupInput) (*cloudwatchlogs.ListTagsLogGroupOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("ListTagsLogGroup"); err != nil {
+		return nil, err
+	}
+	tags, ok := f.tags[aws.StringValue(in.LogGroupName)]
+	if !ok {
+		return nil, noSuchLogGroup()
+	}
+	out := &cloudwatchlogs.ListTagsLogGroupOutput{Tags: map[string]*string{}}
+	for k, v := range tags {
+		out.Tags[k] = aws.String(*v)
+	}
+	return out, nil
+}
+
+func (f *Logs) DeleteLogGroup(in *cloudwatchlogs.DeleteLogGroupInput) (*cloudwatchlogs.DeleteLogGroupOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteLogGroup"); err != nil {
+		return nil, err
+	}
+	name := aws.StringValue(in.LogGroupName)
+	if f.groups[name] == nil {
+		return nil, noSuchLogGroup()
+	}
+	delete(f.groups, name)
+	delete(f.tags, name)
+	return &cloudwatchlogs.DeleteLogGroupOutput{}, nil
+}
+
+func (f *Logs) CreateLogGroupWithContext(ctx aws.Context, in *cloudwatchlogs.CreateLogGroupInput, _ ...request.Option) (*cloudwatchlogs.CreateLogGroupOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.CreateLogGroup(in)
+}
+
+func (f *Logs) DescribeLogGroupsWithContext(ctx aws.Context, in *cloudwatchlogs.DescribeLogGroupsInput, _ ...request.Option) (*cloudwatchlogs.DescribeLogGroupsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DescribeLogGroups(in)
+}
+
+func (f *Logs) ListTagsLogGroupWithContext(ctx aws.Context, in *cloudwatchlogs.ListTagsLogGroupInput, _ ...request.Option) (*cloudwatchlogs.ListTagsLogGroupOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.ListTagsLogGroup(in)
+}
+
+func (f *Logs) DeleteLogGroupWithContext(ctx aws.Context, in *cloudwatchlogs.DeleteLogGroupInput, _ ...request.Option) (*cloudwatchlogs.DeleteLogGroupOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	return f.DeleteLogGroup(in)
+}
@@ -0,0 +1,318 @@
+package awsextratest
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+//
+// Network ACLs
+//
+
+// newNetworkACL adds a network ACL to the VPC with EC2's final rules, which
+// deny everything, and for the default one rules allowing everything before
+// them.  The caller must hold f.mu.
+func (f *EC2) newNetworkACL(vpcID *string, isDefault bool) *ec2.NetworkAcl {
+	acl := &ec2.NetworkAcl{
+		NetworkAclId: f.newID("acl"),
+		VpcId:        vpcID,
+		IsDefault:    aws.Bool(isDefault),
+		OwnerId:      aws.String(f.account),
+	}
+	for _, egress := range []bool{false, true} {
+		if isDefault {
+			acl.Entries = append(acl.Entries, &ec2.NetworkAclEntry{
+				RuleNumber: aws.Int64(100),
+				Egress:     aws.Bool(egress),
+				RuleAction: aws.String("allow"),
+				Protocol:   aws.String("-1"),
+				CidrBlock:  aws.String("0.0.0.0/0"),
+			})
+		}
+		acl.Entries = append(acl.Entries, &ec2.NetworkAclEntry{
+			RuleNumber: aws.Int64(32767),
+			Egress:     aws.Bool(egress),
+			RuleAction: aws.String("deny"),
+			Protocol:   aws.String("-1"),
+			CidrBlock:  aws.String("0.0.0.0/0"),
+		})
+	}
+	f.networkACLs[*acl.NetworkAclId] = acl
+	return acl
+}
+
+// defaultNetworkACL returns the network ACL the VPC came with.  The caller
+// must hold f.mu.
+func (f *EC2) defaultNetworkACL(vpcID string) *ec2.NetworkAcl {
+	for _, acl := range f.networkACLs {
+		if *acl.VpcId == vpcID && *acl.IsDefault {
+			return acl
+		}
+	}
+	return nil
+}
+
+// associateNetworkACL moves the subnet's association to acl, or gives it
+// one, and returns the new association's ID.  The caller must hold f.mu.
+func (f *EC2) associateNetworkACL(acl *ec2.NetworkAcl, subnetID *string) *string {
+	f.disassociateNetworkACL(*subnetID)
+	assoc := &ec2.NetworkAclAssociation{
+		NetworkAclAssociationId: f.newID("aclassoc"),
+		NetworkAclId:            acl.NetworkAclId,
+		SubnetId:                subnetID,
+	}
+	acl.Associations = append(acl.Associations, assoc)
+	return assoc.NetworkAclAssociationId
+}
+
+// disassociateNetworkACL drops the subnet's association.  The caller must
+// hold f.mu.
+func (f *EC2) disassociateNetworkACL(subnetID string) {
+	for _, acl := range f.networkACLs {
+		var keep []*ec2.NetworkAclAssociation
+		for _, assoc := range acl.Associations {
+			if *assoc.SubnetId != subnetID {
+				keep = append(keep, assoc)
+			}
+		}
+		acl.Associations = keep
+	}
+}
+
+func (f *EC2) CreateNetworkAcl(in *ec2.CreateNetworkAclInput) (*ec2.CreateNetworkAclOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateNetworkAcl"); err != nil {
+		return nil, err
+	}
+	vpc := f.vpcs[aws.StringValue(in.VpcId)]
+	if vpc == nil {
+		return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", aws.StringValue(in.VpcId))
+	}
+	acl := f.newNetworkACL(vpc.VpcId, false)
+	return &ec2.CreateNetworkAclOutput{NetworkAcl: clone(acl).(*ec2.NetworkAcl)}, nil
+}
+
+func (f *EC2) DescribeNetworkAcls(in *ec2.DescribeNetworkAclsInput) (*ec2.DescribeNetworkAclsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeNetworkAcls"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeNetworkAclsOutput{}
+	for _, ID := range f.order {
+		acl := f.networkACLs[ID]
+		if acl == nil || !wanted(ID, in.NetworkAclIds) {
+			continue
+		}
+		ok, err := f.match(ID, in.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "network-acl-id":
+				return []string{ID}, true
+			case "vpc-id":
+				return []string{*acl.VpcId}, true
+			case "default":
+				return []string{strconv.FormatBool(*acl.IsDefault)}, true
+			case "association.subnet-id", "association.association-id":
+				var values []string
+				for _, assoc := range acl.Associations {
+					if name == "association.subnet-id" {
+						values = append(values, *assoc.SubnetId)
+					} else {
+						values = append(values, *assoc.NetworkAclAssociationId)
+					}
+				}
+				return values, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			c := clone(acl).(*ec2.NetworkAcl)
+			c.Tags = f.ec2Tags(ID)
+			out.NetworkAcls = append(out.NetworkAcls, c)
+		}
+	}
+	if err := notFound("InvalidNetworkAclID.NotFound", in.NetworkAclIds, len(out.NetworkAcls)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// networkACLEntry checks an entry the way EC2 does: a rule number below
+// EC2's final rules, an action, a protocol number, exactly one CIDR block, a
+// port range for tcp and udp and a type and code for ICMP.
+func networkACLEntry(number *int64, egress *bool, action *string, protocol *string, cidr *string, ipv6Cidr *string, ports *ec2.PortRange, icmp *ec2.IcmpTypeCode) (*ec2.NetworkAclEntry, error) {
+	if n := aws.Int64Value(number); n < 1 || n > 32766 {
+		return nil, apiError("InvalidParameterValue", "Invalid value '%d' for ruleNumber", n)
+	}
+	switch aws.StringValue(action) {
+	case "allow", "deny":
+	default:
+		return nil, apiError("InvalidParameterValue", "Invalid value '%s' for ruleAction", aws.StringValue(action))
+	}
+	p, err := strconv.Atoi(aws.StringValue(protocol))
+	if err != nil || p < -1 || p > 255 {
+		return nil, apiError("InvalidParameterValue", "Invalid value '%s' for protocol", aws.StringValue(protocol))
+	}
+	if (cidr == nil) == (ipv6Cidr == nil) {
+		return nil, apiError("InvalidParameterCombination", "Exactly one of cidrBlock and ipv6CidrBlock is required")
+	}
+	for _, block := range []*string{cidr, ipv6Cidr} {
+		if block == nil {
+			continue
+		}
+		_, parsed, err := net.ParseCIDR(*block)
+		if err != nil || parsed.String() != *block || (parsed.IP.To4() != nil) != (block == cidr) {
+			return nil, apiError("InvalidParameterValue", "Invalid value '%s' for CIDR block", *block)
+		}
+	}
+	e := &ec2.NetworkAclEntry{
+		RuleNumber:    number,
+		Egress:        aws.Bool(aws.BoolValue(egress)),
+		RuleAction:    action,
+		Protocol:      protocol,
+		CidrBlock:     cidr,
+		Ipv6CidrBlock: ipv6Cidr,
+	}
+	switch p {
+	case 6, 17:
+		if ports == nil || aws.Int64Value(ports.From) > aws.Int64Value(ports.To) {
+			return nil, apiError("InvalidParameterValue", "TCP and UDP rules need a port range")
+		}
+		e.PortRange = clone(ports).(*ec2.PortRange)
+	case 1, 58:
+		if icmp == nil {
+			return nil, apiError("InvalidParameterValue", "ICMP rules need a type and code")
+		}
+		e.IcmpTypeCode = clone(icmp).(*ec2.IcmpTypeCode)
+	}
+	return e, nil
+}
+
+func entryIndex(acl *ec2.NetworkAcl, number *int64, egress *bool) int {
+	for i, e := range acl.Entries {
+		if *e.RuleNumber == aws.Int64Value(number) && *e.Egress == aws.BoolValue(egress) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *EC2) CreateNetworkAclEntry(in *ec2.CreateNetworkAclEntryInput) (*ec2.CreateNetworkAclEntryOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateNetworkAclEntry"); err != nil {
+		return nil, err
+	}
+	acl := f.networkACLs[aws.StringValue(in.NetworkAclId)]
+	if acl == nil {
+		return nil, apiError("InvalidNetworkAclID.NotFound", "The network ACL ID '%s' does not exist", aws.StringValue(in.NetworkAclId))
+	}
+	e, err := networkACLEntry(in.RuleNumber, in.Egress, in.RuleAction, in.Protocol, in.CidrBlock, in.Ipv6CidrBlock, in.PortRange, in.IcmpTypeCode)
+	if err != nil {
+		return nil, err
+	}
+	if entryIndex(acl, in.RuleNumber, in.Egress) >= 0 {
+		return nil, apiError("NetworkAclEntryAlreadyExists", "The network acl entry identified by %d already exists", *in.RuleNumber)
+	}
+	acl.Entries = append(acl.Entries, e)
+	return &ec2.CreateNetworkAclEntryOutput{}, nil
+}
+
+func (f *EC2) ReplaceNetworkAclEntry(in *ec2.ReplaceNetworkAclEntryInput) (*ec2.ReplaceNetworkAclEntryOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("ReplaceNetworkAclEntry"); err != nil {
+		return nil, err
+	}
+	acl := f.networkACLs[aws.StringValue(in.NetworkAclId)]
+	if acl == nil {
+		return nil, apiError("InvalidNetworkAclID.NotFound", "The network ACL ID '%s' does not exist", aws.StringValue(in.NetworkAclId))
+	}
+	e, err := networkACLEntry(in.RuleNumber, in.Egress, in.RuleAction, in.Protocol, in.CidrBlock, in.Ipv6CidrBlock, in.PortRange, in.IcmpTypeCode)
+	if err != nil {
+		return nil, err
+	}
+	i := entryIndex(acl, in.RuleNumber, in.Egress)
+	if i < 0 {
+		return nil, apiError("InvalidNetworkAclEntry.NotFound", "The network acl entry identified by %d does not exist", *in.RuleNumber)
+	}
+	acl.Entries[i] = e
+	return &ec2.ReplaceNetworkAclEntryOutput{}, nil
+}
+
+func (f *EC2) DeleteNetworkAclEntry(in *ec2.DeleteNetworkAclEntryInput) (*ec2.DeleteNetworkAclEntryOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteNetworkAclEntry"); err != nil {
+		return nil, err
+	}
+	acl := f.networkACLs[aws.StringValue(in.NetworkAclId)]
+	if acl == nil {
+		return nil, apiError("InvalidNetworkAclID.NotFound", "The network ACL ID '%s' does not exist", aws.StringValue(in.NetworkAclId))
+	}
+	i := entryIndex(acl, in.RuleNumber, in.Egress)
+	if i < 0 || aws.Int64Value(in.RuleNumber) > 32766 {
+		return nil, apiError("InvalidNetworkAclEntry.NotFound", "The network acl entry identified by %d does not exist", aws.Int64Value(in.RuleNumber))
+	}
+	acl.Entries = append(acl.Entries[:i], acl.Entries[i+1:]...)
+	return &ec2.DeleteNetworkAclEntryOutput{}, nil
+}
+
+// ReplaceNetworkAclAssociation moves a subnet to another network ACL in its
+// VPC.  The association gets a new ID.
+func (f *EC2) ReplaceNetworkAclAssociation(in *ec2.ReplaceNetworkAclAssociationInput) (*ec2.ReplaceNetworkAclAssociationOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("ReplaceNetworkAclAssociation"); err != nil {
+		return nil, err
+	}
+	acl := f.networkACLs[aws.StringValue(in.NetworkAclId)]
+	if acl == nil {
+		return nil, apiError("InvalidNetworkAclID.NotFound", "The network ACL ID '%s' does not exist", aws.StringValue(in.NetworkAclId))
+	}
+	assocID := aws.StringValue(in.AssociationId)
+	for _, other := range f.networkACLs {
+		for _, assoc := range other.Associations {
+			if *assoc.NetworkAclAssociationId != assocID {
+				continue
+			}
+			if *other.VpcId != *acl.VpcId {
+				return nil, apiError("InvalidParameterValue", "network ACL %s and subnet %s belong to different networks", *acl.NetworkAclId, *assoc.SubnetId)
+			}
+			newID := f.associateNetworkACL(acl, assoc.SubnetId)
+			return &ec2.ReplaceNetworkAclAssociationOutput{NewAssociationId: newID}, nil
+		}
+	}
+	return nil, apiError("InvalidAssociationID.NotFound", "The association ID '%s' does not exist", assocID)
+}
+
+// DeleteNetworkAcl refuses the default network ACL and ones subnets are
+// still associated with.
+func (f *EC2) DeleteNetworkAcl(in *ec2.DeleteNetworkAclInput) (*ec2.DeleteNetworkAclOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteNetworkAcl"); err != nil {
+		return nil, err
+	}
+	aclID := aws.StringValue(in.NetworkAclId)
+	acl := f.networkACLs[aclID]
+	if acl == nil {
+		return nil, apiError("InvalidNetworkAclID.NotFound", "The network ACL ID '%s' does not exist", aclID)
+	}
+	if *acl.IsDefault {
+		return nil, apiError("InvalidParameterValue", "cannot delete default network ACL %s", aclID)
+	}
+	if len(acl.Associations) > 0 {
+		return nil, apiError("DependencyViolation", "The network ACL '%s' has dependencies and cannot be deleted", aclID)
+	}
+	f.forget(aclID)
+	delete(f.networkACLs, aclID)
+	return &ec2.DeleteNetworkAclOutput{}, nil
+}
@@ -0,0 +1,223 @@
+package awsextratest
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+//
+// Elastic IPs
+//
+
+func (f *EC2) AllocateAddress(in *ec2.AllocateAddressInput) (*ec2.AllocateAddressOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("AllocateAddress"); err != nil {
+		return nil, err
+	}
+	if aws.StringValue(in.Domain) != "vpc" {
+		return nil, apiError("InvalidParameterValue", "only vpc addresses are modelled")
+	}
+	address := &ec2.Address{
+		AllocationId: f.newID("eipalloc"),
+		Domain:       aws.String("vpc"),
+		PublicIp:     aws.String(fmt.Sprintf("198.51.100.%d", f.nextID%256)),
+	}
+	f.addresses[*address.AllocationId] = address
+	return &ec2.AllocateAddressOutput{
+		AllocationId: address.AllocationId,
+		Domain:       address.Domain,
+		PublicIp:     address.PublicIp,
+	}, nil
+}
+
+func (f *EC2) DescribeAddresses(in *ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeAddresses"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeAddressesOutput{}
+	for _, ID := range f.order {
+		address := f.addresses[ID]
+		if address == nil || !wanted(ID, in.AllocationIds) {
+			continue
+		}
+		ok, err := f.match(ID, in.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "allocation-id":
+				return []string{ID}, true
+			case "domain":
+				return []string{*address.Domain}, true
+			case "public-ip":
+				return []string{*address.PublicIp}, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			c := clone(address).(*ec2.Address)
+			c.Tags = f.ec2Tags(ID)
+			out.Addresses = append(out.Addresses, c)
+		}
+	}
+	if err := notFound("InvalidAllocationID.NotFound", in.AllocationIds, len(out.Addresses)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ReleaseAddress releases an address.  Like EC2 it refuses one still in use
+// by a NAT gateway.
+func (f *EC2) ReleaseAddress(in *ec2.ReleaseAddressInput) (*ec2.ReleaseAddressOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("ReleaseAddress"); err != nil {
+		return nil, err
+	}
+	ID := aws.StringValue(in.AllocationId)
+	address := f.addresses[ID]
+	if address == nil {
+		return nil, apiError("InvalidAllocationID.NotFound", "The allocation ID '%s' does not exist", ID)
+	}
+	if address.AssociationId != nil {
+		return nil, apiError("InvalidIPAddress.InUse", "Address %s is in use", *address.PublicIp)
+	}
+	f.forget(ID)
+	delete(f.addresses, ID)
+	return &ec2.ReleaseAddressOutput{}, nil
+}
+
+//
+// NAT gateways
+//
+
+// CreateNatGateway creates a NAT gateway in the "pending" state.  It is
+// "available" by the time it is next described.
+func (f *EC2) CreateNatGateway(in *ec2.CreateNatGatewayInput) (*ec2.CreateNatGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateNatGateway"); err != nil {
+		return nil, err
+	}
+	subnet := f.subnets[aws.StringValue(in.SubnetId)]
+	if subnet == nil {
+		return nil, apiError("InvalidSubnetID.NotFound", "The subnet ID '%s' does not exist", aws.StringValue(in.SubnetId))
+	}
+	address := f.addresses[aws.StringValue(in.AllocationId)]
+	if address == nil {
+		return nil, apiError("InvalidAllocationID.NotFound", "The allocation ID '%s' does not exist", aws.StringValue(in.AllocationId))
+	}
+	if address.AssociationId != nil {
+		return nil, apiError("Resource.AlreadyAssociated", "Elastic IP address '%s' is already associated", *address.AllocationId)
+	}
+	nat := &ec2.NatGateway{
+		NatGatewayId: f.newID("nat"),
+		SubnetId:     subnet.SubnetId,
+		VpcId:        subnet.VpcId,
+		State:        aws.String("pending"),
+		NatGatewayAddresses: []*ec2.NatGatewayAddress{{
+			AllocationId: address.AllocationId,
+			PublicIp:     address.PublicIp,
+		}},
+	}
+	address.AssociationId = f.newID("eipassoc")
+	f.natGateways[*nat.NatGatewayId] = nat
+	return &ec2.CreateNatGatewayOutput{NatGateway: clone(nat).(*ec2.NatGateway)}, nil
+}
+
+// DescribeNatGateways first moves each gateway it describes on from "pending"
+// to "available" or from "deleting" to "deleted", as EC2 does after a while.
+func (f *EC2) DescribeNatGateways(in *ec2.DescribeNatGatewaysInput) (*ec2.DescribeNatGatewaysOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeNatGateways"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeNatGatewaysOutput{}
+	for _, ID := range f.order {
+		nat := f.natGateways[ID]
+		if nat == nil || !wanted(ID, in.NatGatewayIds) {
+			continue
+		}
+		switch *nat.State {
+		case "pending":
+			nat.State = aws.String("available")
+		case "deleting":
+			nat.State = aws.String("deleted")
+			for _, a := range nat.NatGatewayAddresses {
+				if address := f.addresses[aws.StringValue(a.AllocationId)]; address != nil {
+					address.AssociationId = nil
+				}
+			}
+		}
+		ok, err := f.match(ID, in.Filter, func(name string) ([]string, bool) {
+			switch name {
+			case "nat-gateway-id":
+				return []string{ID}, true
+			case "vpc-id":
+				return []string{*nat.VpcId}, true
+			case "subnet-id":
+				return []string{*nat.SubnetId}, true
+			case "state":
+				return []string{*nat.State}, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		c := clone(nat).(*ec2.NatGateway)
+		c.Tags = f.ec2Tags(ID)
+		out.NatGateways = append(out.NatGateways, c)
+	}
+	if err := notFound("NatGatewayNotFound", in.NatGatewayIds, len(out.NatGateways)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DeleteNatGateway starts deleting a NAT gateway.  It is "deleting", and its
+// address stays in use, until it is next described.
+func (f *EC2) DeleteNatGateway(in *ec2.DeleteNatGatewayInput) (*ec2.DeleteNatGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteNatGateway"); err != nil {
+		return nil, err
+	}
+	ID := aws.StringValue(in.NatGatewayId)
+	nat := f.natGateways[ID]
+	if nat == nil || *nat.State == "deleted" {
+		return nil, apiError("NatGatewayNotFound", "NAT gateway %s was not found", ID)
+	}
+	if *nat.State != "deleting" {
+		nat.State = aws.String("deleting")
+	}
+	return &ec2.DeleteNatGatewayOutput{NatGatewayId: nat.NatGatewayId}, nil
+}
+
+// live reports whether a NAT gateway still holds its subnet and address.
+func live(nat *ec2.NatGateway) bool {
+	return aws.StringValue(nat.State) != "deleted" && aws.StringValue(nat.State) != "failed"
+}
+
+// natGatewayIn returns the ID of a live NAT gateway whose vpc-id or
+// subnet-id, as named, is ID.
+func (f *EC2) natGatewayIn(name string, ID string) string {
+	for natID, nat := range f.natGateways {
+		if !live(nat) {
+			continue
+		}
+		if name == "vpc-id" && *nat.VpcId == ID || name == "subnet-id" && *nat.SubnetId == ID {
+			return natID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,344 @@
+package awsextratest
+
+import (
+	"net"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The account that owns a fake's resources unless SetAccount says otherwise.
+const defaultAccount = "123456789012"
+
+// network is what linked fakes share: the VPCs each of them has, and the VPC
+// peering connections between those.  Its lock is only ever taken by a fake
+// already holding its own, and no fake's lock is taken while holding it.
+type network struct {
+	mu       sync.Mutex
+	vpcs     map[string]networkVPC
+	peerings map[string]*ec2.VpcPeeringConnection
+	order    []string // peering connection IDs in creation order
+}
+
+// networkVPC is a VPC as seen from the other linked fakes.
+type networkVPC struct {
+	region  string
+	account string
+	cidr    string
+}
+
+func newNetwork() *network {
+	return &network{
+		vpcs:     map[string]networkVPC{},
+		peerings: map[string]*ec2.VpcPeeringConnection{},
+	}
+}
+
+// Link connects fakes standing for different regions or accounts, so VPCs in
+// one can be peered with VPCs in another.  Call it before creating anything in
+// them.  It also keeps the IDs the fakes hand out apart, as EC2's are unique
+// everywhere.
+func Link(fakes ...*EC2) {
+	n := newNetwork()
+	for i, f := range fakes {
+		f.mu.Lock()
+		f.network = n
+		f.nextID += i << 32
+		f.mu.Unlock()
+	}
+}
+
+// publish makes a new VPC of f's known to the fakes linked with it.
+func (n *network) publish(f *EC2, vpc *ec2.Vpc) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.vpcs[*vpc.VpcId] = networkVPC{region: f.region, account: f.account, cidr: *vpc.CidrBlock}
+}
+
+func (n *network) unpublish(vpcID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.vpcs, vpcID)
+}
+
+// visible reports whether f is on either side of the peering connection.
+// The caller must hold n.mu.
+func visible(f *EC2, peering *ec2.VpcPeeringConnection) bool {
+	return onSide(f, peering.RequesterVpcInfo) || onSide(f, peering.AccepterVpcInfo)
+}
+
+func onSide(f *EC2, info *ec2.VpcPeeringConnectionVpcInfo) bool {
+	return aws.StringValue(info.Region) == f.region && aws.StringValue(info.OwnerId) == f.account
+}
+
+// peeringsOf returns copies of the peering connections f can see.
+func (n *network) peeringsOf(f *EC2) []*ec2.VpcPeeringConnection {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var peerings []*ec2.VpcPeeringConnection
+	for _, ID := range n.order {
+		if peering := n.peerings[ID]; visible(f, peering) {
+			peerings = append(peerings, clone(peering).(*ec2.VpcPeeringConnection))
+		}
+	}
+	return peerings
+}
+
+// peeringOf returns a copy of the peering connection ID if f can see it, or
+// nil.
+func (n *network) peeringOf(f *EC2, ID string) *ec2.VpcPeeringConnection {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if peering := n.peerings[ID]; peering != nil && visible(f, peering) {
+		return clone(peering).(*ec2.VpcPeeringConnection)
+	}
+	return nil
+}
+
+// peeringIn returns the ID of a live peering connection of the VPC.
+func (n *network) peeringIn(vpcID string) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, ID := range n.order {
+		peering := n.peerings[ID]
+		if livePeering(peering) && (*peering.RequesterVpcInfo.VpcId == vpcID || *peering.AccepterVpcInfo.VpcId == vpcID) {
+			return ID
+		}
+	}
+	return ""
+}
+
+// livePeering reports whether the peering connection is being set up or is
+// active, rather than failed, rejected, expired or deleted.
+func livePeering(peering *ec2.VpcPeeringConnection) bool {
+	switch aws.StringValue(peering.Status.Code) {
+	case "initiating-request", "pending-acceptance", "provisioning", "active":
+		return true
+	}
+	return false
+}
+
+func setStatus(peering *ec2.VpcPeeringConnection, code string, message string) {
+	peering.Status = &ec2.VpcPeeringConnectionStateReason{Code: aws.String(code), Message: aws.String(message)}
+}
+
+func noSuchPeering(ID string) error {
+	return apiError("InvalidVpcPeeringConnectionID.NotFound", "The vpcPeeringConnection ID '%s' does not exist", ID)
+}
+
+//
+// VPC peering connections
+//
+
+// CreateVpcPeeringConnection requests a peering connection from a VPC of f's
+// to a VPC in any linked fake.  Like EC2, it fails straight away only for a
+// missing peer VPC in f's own region and account; otherwise the connection
+// is created in the "failed" state when the peer VPC doesn't exist or the
+// CIDR blocks overlap.  A connection to another region is
+// "initiating-request" until it is next described.
+func (f *EC2) CreateVpcPeeringConnection(in *ec2.CreateVpcPeeringConnectionInput) (*ec2.CreateVpcPeeringConnectionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateVpcPeeringConnection"); err != nil {
+		return nil, err
+	}
+	vpc := f.vpcs[aws.StringValue(in.VpcId)]
+	if vpc == nil {
+		return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", aws.StringValue(in.VpcId))
+	}
+	account := aws.StringValue(in.PeerOwnerId)
+	if account == "" {
+		account = f.account
+	}
+	region := aws.StringValue(in.PeerRegion)
+	if region == "" {
+		region = f.region
+	}
+
+	n := f.network
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	peer, ok := n.vpcs[aws.StringValue(in.PeerVpcId)]
+	ok = ok && peer.account == account && peer.region == region
+	if !ok && account == f.account && region == f.region {
+		return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", aws.StringValue(in.PeerVpcId))
+	}
+	peering := &ec2.VpcPeeringConnection{
+		VpcPeeringConnectionId: f.newID("pcx"),
+		RequesterVpcInfo: &ec2.VpcPeeringConnectionVpcInfo{
+			VpcId:          vpc.VpcId,
+			OwnerId:        aws.String(f.account),
+			Region:         aws.String(f.region),
+			CidrBlock:      vpc.CidrBlock,
+			PeeringOptions: &ec2.VpcPeeringConnectionOptionsDescription{AllowDnsResolutionFromRemoteVpc: aws.Bool(false)},
+		},
+		AccepterVpcInfo: &ec2.VpcPeeringConnectionVpcInfo{
+			VpcId:   in.PeerVpcId,
+			OwnerId: aws.String(account),
+			Region:  aws.String(region),
+		},
+	}
+	_, mine, _ := net.ParseCIDR(*vpc.CidrBlock)
+	_, theirs, _ := net.ParseCIDR(peer.cidr)
+	switch {
+	case !ok || overlaps(mine, theirs):
+		setStatus(peering, "failed", "Failed due to incorrect VPC-ID, Account ID, or overlapping CIDR range")
+	case region != f.region:
+		setStatus(peering, "initiating-request", "Initiating Request to "+account)
+	default:
+		setStatus(peering, "pending-acceptance", "Pending Acceptance by "+account)
+	}
+	n.peerings[*peering.VpcPeeringConnectionId] = peering
+	n.order = append(n.order, *peering.VpcPeeringConnectionId)
+	return &ec2.CreateVpcPeeringConnectionOutput{VpcPeeringConnection: clone(peering).(*ec2.VpcPeeringConnection)}, nil
+}
+
+// AcceptVpcPeeringConnection accepts a pending connection from the accepter
+// side.  It is "provisioning" until it is next described, then "active".
+func (f *EC2) AcceptVpcPeeringConnection(in *ec2.AcceptVpcPeeringConnectionInput) (*ec2.AcceptVpcPeeringConnectionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("AcceptVpcPeeringConnection"); err != nil {
+		return nil, err
+	}
+	ID := aws.StringValue(in.VpcPeeringConnectionId)
+	n := f.network
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	peering := n.peerings[ID]
+	if peering == nil || !visible(f, peering) {
+		return nil, noSuchPeering(ID)
+	}
+	if !onSide(f, peering.AccepterVpcInfo) {
+		return nil, apiError("OperationNotPermitted", "Account %s cannot accept peering %s from the requester side", f.account, ID)
+	}
+	if code := aws.StringValue(peering.Status.Code); code != "pending-acceptance" {
+		return nil, apiError("InvalidStateTransition", "Invalid state transition for %s, attempted to transition from %s to provisioning", ID, code)
+	}
+	setStatus(peering, "provisioning", "Provisioning")
+	peering.AccepterVpcInfo.CidrBlock = aws.String(n.vpcs[*peering.AccepterVpcInfo.VpcId].cidr)
+	peering.AccepterVpcInfo.PeeringOptions = &ec2.VpcPeeringConnectionOptionsDescription{AllowDnsResolutionFromRemoteVpc: aws.Bool(false)}
+	return &ec2.AcceptVpcPeeringConnectionOutput{VpcPeeringConnection: clone(peering).(*ec2.VpcPeeringConnection)}, nil
+}
+
+// DescribeVpcPeeringConnections describes the connections either side of
+// which is in f's region and account, including deleted ones, as EC2 does
+// for a while.
+func (f *EC2) DescribeVpcPeeringConnections(in *ec2.DescribeVpcPeeringConnectionsInput) (*ec2.DescribeVpcPeeringConnectionsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeVpcPeeringConnections"); err != nil {
+		return nil, err
+	}
+	n := f.network
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	out := &ec2.DescribeVpcPeeringConnectionsOutput{}
+	for _, ID := range n.order {
+		peering := n.peerings[ID]
+		if !visible(f, peering) || !wanted(ID, in.VpcPeeringConnectionIds) {
+			continue
+		}
+		// Requests reach the peer region, and accepted connections finish
+		// provisioning, by the time they are described.
+		switch aws.StringValue(peering.Status.Code) {
+		case "initiating-request":
+			setStatus(peering, "pending-acceptance", "Pending Acceptance by "+*peering.AccepterVpcInfo.OwnerId)
+		case "provisioning":
+			setStatus(peering, "active", "Active")
+		}
+		ok, err := f.match(ID, in.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "vpc-peering-connection-id":
+				return []string{ID}, true
+			case "status-code":
+				return []string{*peering.Status.Code}, true
+			case "requester-vpc-info.vpc-id":
+				return []string{*peering.RequesterVpcInfo.VpcId}, true
+			case "requester-vpc-info.owner-id":
+				return []string{*peering.RequesterVpcInfo.OwnerId}, true
+			case "requester-vpc-info.cidr-block":
+				return []string{*peering.RequesterVpcInfo.CidrBlock}, true
+			case "accepter-vpc-info.vpc-id":
+				return []string{*peering.AccepterVpcInfo.VpcId}, true
+			case "accepter-vpc-info.owner-id":
+				return []string{*peering.AccepterVpcInfo.OwnerId}, true
+			case "accepter-vpc-info.cidr-block":
+				return []string{aws.StringValue(peering.AccepterVpcInfo.CidrBlock)}, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			c := clone(peering).(*ec2.VpcPeeringConnection)
+			c.Tags = f.ec2Tags(ID)
+			out.VpcPeeringConnections = append(out.VpcPeeringConnections, c)
+		}
+	}
+	if err := notFound("InvalidVpcPeeringConnectionID.NotFound", in.VpcPeeringConnectionIds, len(out.VpcPeeringConnections)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ModifyVpcPeeringConnectionOptions sets the options of an active
+// connection.  Each side's options can only be set from its own region and
+// account.
+func (f *EC2) ModifyVpcPeeringConnectionOptions(in *ec2.ModifyVpcPeeringConnectionOptionsInput) (*ec2.ModifyVpcPeeringConnectionOptionsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("ModifyVpcPeeringConnectionOptions"); err != nil {
+		return nil, err
+	}
+	ID := aws.StringValue(in.VpcPeeringConnectionId)
+	n := f.network
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	peering := n.peerings[ID]
+	if peering == nil || !visible(f, peering) {
+		return nil, noSuchPeering(ID)
+	}
+	if aws.StringValue(peering.Status.Code) != "active" {
+		return nil, apiError("OperationNotPermitted", "Peering %s is not active", ID)
+	}
+	if in.RequesterPeeringConnectionOptions != nil && !onSide(f, peering.RequesterVpcInfo) ||
+		in.AccepterPeeringConnectionOptions != nil && !onSide(f, peering.AccepterVpcInfo) {
+		return nil, apiError("OperationNotPermitted", "Account %s cannot modify the other side's options of peering %s", f.account, ID)
+	}
+	out := &ec2.ModifyVpcPeeringConnectionOptionsOutput{}
+	if opts := in.RequesterPeeringConnectionOptions; opts != nil {
+		peering.RequesterVpcInfo.PeeringOptions.AllowDnsResolutionFromRemoteVpc = aws.Bool(aws.BoolValue(opts.AllowDnsResolutionFromRemoteVpc))
+		out.RequesterPeeringConnectionOptions = &ec2.PeeringConnectionOptions{AllowDnsResolutionFromRemoteVpc: opts.AllowDnsResolutionFromRemoteVpc}
+	}
+	if opts := in.AccepterPeeringConnectionOptions; opts != nil {
+		peering.AccepterVpcInfo.PeeringOptions.AllowDnsResolutionFromRemoteVpc = aws.Bool(aws.BoolValue(opts.AllowDnsResolutionFromRemoteVpc))
+		out.AccepterPeeringConnectionOptions = &ec2.PeeringConnectionOptions{AllowDnsResolutionFromRemoteVpc: opts.AllowDnsResolutionFromRemoteVpc}
+	}
+	return out, nil
+}
+
+// DeleteVpcPeeringConnection deletes a live connection from either side.
+// Routes to it are left in place, as EC2 leaves them, blackholed.
+func (f *EC2) DeleteVpcPeeringConnection(in *ec2.DeleteVpcPeeringConnectionInput) (*ec2.DeleteVpcPeeringConnectionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteVpcPeeringConnection"); err != nil {
+		return nil, err
+	}
+	ID := aws.StringValue(in.VpcPeeringConnectionId)
+	n := f.network
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	peering := n.peerings[ID]
+	if peering == nil || !visible(f, peering) {
+		return nil, noSuchPeering(ID)
+	}
+	if !livePeering(peering) {
+		return nil, apiError("InvalidStateTransition", "Invalid state transition for %s, attempted to transition from %s to deleted", ID, *peering.Status.Code)
+	}
+	setStatus(peering, "deleted", "Deleted by "+f.account)
+	return &ec2.DeleteVpcPeeringConnectionOutput{Return: aws.Bool(true)}, nil
+}
@@ -0,0 +1,359 @@
+package awsextratest
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// securityGroup keeps rules flattened to one source per rule, which is how
+// EC2 matches duplicates and revokes, and regroups them when described.
+type securityGroup struct {
+	ec2.SecurityGroup
+	ingress []rule
+	egress  []rule
+}
+
+type rule struct {
+	protocol   string
+	fromPort   int64
+	toPort     int64
+	cidr       string
+	ipv6Cidr   string
+	groupID    string
+	prefixList string
+}
+
+func (f *EC2) newSecurityGroup(vpcID *string, name string, description string) *securityGroup {
+	sg := &securityGroup{
+		SecurityGroup: ec2.SecurityGroup{
+			GroupId:     f.newID("sg"),
+			GroupName:   aws.String(name),
+			Description: aws.String(description),
+			VpcId:       vpcID,
+			OwnerId:     aws.String(f.account),
+		},
+		egress: []rule{{protocol: "-1", cidr: "0.0.0.0/0"}},
+	}
+	if name == "default" {
+		sg.ingress = []rule{{protocol: "-1", groupID: *sg.GroupId}}
+	}
+	f.securityGroups[*sg.GroupId] = sg
+	return sg
+}
+
+func (f *EC2) CreateSecurityGroup(in *ec2.CreateSecurityGroupInput) (*ec2.CreateSecurityGroupOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateSecurityGroup"); err != nil {
+		return nil, err
+	}
+	vpcID := aws.StringValue(in.VpcId)
+	if f.vpcs[vpcID] == nil {
+		return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", vpcID)
+	}
+	name := aws.StringValue(in.GroupName)
+	if name == "" || aws.StringValue(in.Description) == "" {
+		return nil, apiError("MissingParameter", "GroupName and GroupDescription are required")
+	}
+	for _, sg := range f.securityGroups {
+		if aws.StringValue(sg.VpcId) == vpcID && aws.StringValue(sg.GroupName) == name {
+			return nil, apiError("InvalidGroup.Duplicate", "The security group '%s' already exists for VPC '%s'", name, vpcID)
+		}
+	}
+	sg := f.newSecurityGroup(in.VpcId, name, aws.StringValue(in.Description))
+	return &ec2.CreateSecurityGroupOutput{GroupId: aws.String(*sg.GroupId)}, nil
+}
+
+func (f *EC2) DescribeSecurityGroups(in *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeSecurityGroups"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeSecurityGroupsOutput{}
+	for _, ID := range f.order {
+		sg := f.securityGroups[ID]
+		if sg == nil || !wanted(ID, in.GroupIds) {
+			continue
+		}
+		ok, err := f.match(ID, in.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "group-id":
+				return []string{ID}, true
+			case "group-name":
+				return []string{*sg.GroupName}, true
+			case "vpc-id":
+				return []string{*sg.VpcId}, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			c := clone(&sg.SecurityGroup).(*ec2.SecurityGroup)
+			c.IpPermissions = permissions(sg.ingress)
+			c.IpPermissionsEgress = permissions(sg.egress)
+			c.Tags = f.ec2Tags(ID)
+			out.SecurityGroups = append(out.SecurityGroups, c)
+		}
+	}
+	if err := notFound("InvalidGroup.NotFound", in.GroupIds, len(out.SecurityGroups)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (f *EC2) AuthorizeSecurityGroupIngress(in *ec2.AuthorizeSecurityGroupIngressInput) (*ec2.AuthorizeSecurityGroupIngressOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("AuthorizeSecurityGroupIngress"); err != nil {
+		return nil, err
+	}
+	sg := f.securityGroups[aws.StringValue(in.GroupId)]
+	if sg == nil {
+		return nil, apiError("InvalidGroup.NotFound", "The security group '%s' does not exist", aws.StringValue(in.GroupId))
+	}
+	perms := in.IpPermissions
+	if in.CidrIp != nil {
+		perms = append(perms, &ec2.IpPermission{
+			IpProtocol: in.IpProtocol,
+			FromPort:   in.FromPort,
+			ToPort:     in.ToPort,
+			IpRanges:   []*ec2.IpRange{{CidrIp: in.CidrIp}},
+		})
+	}
+	rules, err := f.flatten(perms)
+	if err != nil {
+		return nil, err
+	}
+	sg.ingress, err = authorize(sg.ingress, rules)
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.AuthorizeSecurityGroupIngressOutput{}, nil
+}
+
+func (f *EC2) RevokeSecurityGroupIngress(in *ec2.RevokeSecurityGroupIngressInput) (*ec2.RevokeSecurityGroupIngressOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("RevokeSecurityGroupIngress"); err != nil {
+		return nil, err
+	}
+	sg := f.securityGroups[aws.StringValue(in.GroupId)]
+	if sg == nil {
+		return nil, apiError("InvalidGroup.NotFound", "The security group '%s' does not exist", aws.StringValue(in.GroupId))
+	}
+	rules, err := f.flatten(in.IpPermissions)
+	if err != nil {
+		return nil, err
+	}
+	sg.ingress, err = revoke(sg.ingress, rules)
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.RevokeSecurityGroupIngressOutput{}, nil
+}
+
+func (f *EC2) AuthorizeSecurityGroupEgress(in *ec2.AuthorizeSecurityGroupEgressInput) (*ec2.AuthorizeSecurityGroupEgressOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("AuthorizeSecurityGroupEgress"); err != nil {
+		return nil, err
+	}
+	sg := f.securityGroups[aws.StringValue(in.GroupId)]
+	if sg == nil {
+		return nil, apiError("InvalidGroup.NotFound", "The security group '%s' does not exist", aws.StringValue(in.GroupId))
+	}
+	rules, err := f.flatten(in.IpPermissions)
+	if err != nil {
+		return nil, err
+	}
+	sg.egress, err = authorize(sg.egress, rules)
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.AuthorizeSecurityGroupEgressOutput{}, nil
+}
+
+func (f *EC2) RevokeSecurityGroupEgress(in *ec2.RevokeSecurityGroupEgressInput) (*ec2.RevokeSecurityGroupEgressOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("RevokeSecurityGroupEgress"); err != nil {
+		return nil, err
+	}
+	sg := f.securityGroups[aws.StringValue(in.GroupId)]
+	if sg == nil {
+		return nil, apiError("InvalidGroup.NotFound", "The security group '%s' does not exist", aws.StringValue(in.GroupId))
+	}
+	rules, err := f.flatten(in.IpPermissions)
+	if err != nil {
+		return nil, err
+	}
+	sg.egress, err = revoke(sg.egress, rules)
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.RevokeSecurityGroupEgressOutput{}, nil
+}
+
+func (f *EC2) DeleteSecurityGroup(in *ec2.DeleteSecurityGroupInput) (*ec2.DeleteSecurityGroupOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteSecurityGroup"); err != nil {
+		return nil, err
+	}
+	groupID := aws.StringValue(in.GroupId)
+	sg := f.securityGroups[groupID]
+	if sg == nil {
+		return nil, apiError("InvalidGroup.NotFound", "The security group '%s' does not exist", groupID)
+	}
+	if aws.StringValue(sg.GroupName) == "default" {
+		return nil, apiError("CannotDelete", "the specified group: \"%s\" name: \"default\" cannot be deleted by a user", groupID)
+	}
+	for eniID, eni := range f.networkInterfaces {
+		for _, g := range eni.Groups {
+			if aws.StringValue(g.GroupId) == groupID {
+				return nil, apiError("DependencyViolation", "resource %s has a dependent object (%s)", groupID, eniID)
+			}
+		}
+	}
+	for otherID, other := range f.securityGroups {
+		if otherID == groupID {
+			continue
+		}
+		for _, r := range append(append([]rule(nil), other.ingress...), other.egress...) {
+			if r.groupID == groupID {
+				return nil, apiError("DependencyViolation", "resource %s has a dependent object (%s)", groupID, otherID)
+			}
+		}
+	}
+	f.forget(groupID)
+	delete(f.securityGroups, groupID)
+	return &ec2.DeleteSecurityGroupOutput{}, nil
+}
+
+// flatten splits permissions into one rule per source, validating the
+// protocol and any referenced groups.
+func (f *EC2) flatten(perms []*ec2.IpPermission) ([]rule, error) {
+	var rules []rule
+	for _, p := range perms {
+		base := rule{protocol: normalizeProtocol(aws.StringValue(p.IpProtocol))}
+		switch base.protocol {
+		case "tcp", "udp", "icmp", "icmpv6":
+			if p.FromPort == nil || p.ToPort == nil {
+				return nil, apiError("InvalidParameterValue", "Invalid value for portRange. Must specify both from and to ports with TCP/UDP.")
+			}
+			base.fromPort, base.toPort = *p.FromPort, *p.ToPort
+		case "-1":
+		default:
+			return nil, apiError("InvalidParameterValue", "Invalid value '%s' for IP protocol", aws.StringValue(p.IpProtocol))
+		}
+		before := len(rules)
+		for _, r := range p.IpRanges {
+			nr := base
+			nr.cidr = aws.StringValue(r.CidrIp)
+			rules = append(rules, nr)
+		}
+		for _, r := range p.Ipv6Ranges {
+			nr := base
+			nr.ipv6Cidr = aws.StringValue(r.CidrIpv6)
+			rules = append(rules, nr)
+		}
+		for _, pair := range p.UserIdGroupPairs {
+			if f.securityGroups[aws.StringValue(pair.GroupId)] == nil {
+				return nil, apiError("InvalidGroup.NotFound", "The security group '%s' does not exist", aws.StringValue(pair.GroupId))
+			}
+			nr := base
+			nr.groupID = aws.StringValue(pair.GroupId)
+			rules = append(rules, nr)
+		}
+		for _, pl := range p.PrefixListIds {
+			nr := base
+			nr.prefixList = aws.StringValue(pl.PrefixListId)
+			rules = append(rules, nr)
+		}
+		if len(rules) == before {
+			return nil, apiError("MissingParameter", "A source or destination is required")
+		}
+	}
+	return rules, nil
+}
+
+func authorize(have []rule, add []rule) ([]rule, error) {
+	for _, r := range add {
+		for _, h := range have {
+			if h == r {
+				return nil, apiError("InvalidPermission.Duplicate", "the specified rule already exists")
+			}
+		}
+		have = append(have, r)
+	}
+	return have, nil
+}
+
+func revoke(have []rule, remove []rule) ([]rule, error) {
+	for _, r := range remove {
+		found := false
+		for i, h := range have {
+			if h == r {
+				have = append(have[:i:i], have[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, apiError("InvalidPermission.NotFound", "The specified rule does not exist in this security group.")
+		}
+	}
+	return have, nil
+}
+
+// permissions regroups flattened rules by protocol and port range, the way
+// DescribeSecurityGroups reports them.
+func permissions(rules []rule) []*ec2.IpPermission {
+	var perms []*ec2.IpPermission
+	index := map[[3]interface{}]*ec2.IpPermission{}
+	for _, r := range rules {
+		key := [3]interface{}{r.protocol, r.fromPort, r.toPort}
+		p := index[key]
+		if p == nil {
+			p = &ec2.IpPermission{IpProtocol: aws.String(r.protocol)}
+			if r.protocol != "-1" {
+				p.FromPort = aws.Int64(r.fromPort)
+				p.ToPort = aws.Int64(r.toPort)
+			}
+			index[key] = p
+			perms = append(perms, p)
+		}
+		switch {
+		case r.cidr != "":
+			p.IpRanges = append(p.IpRanges, &ec2.IpRange{CidrIp: aws.String(r.cidr)})
+		case r.ipv6Cidr != "":
+			p.Ipv6Ranges = append(p.Ipv6Ranges, &ec2.Ipv6Range{CidrIpv6: aws.String(r.ipv6Cidr)})
+		case r.groupID != "":
+			p.UserIdGroupPairs = append(p.UserIdGroupPairs, &ec2.UserIdGroupPair{GroupId: aws.String(r.groupID), UserId: aws.String("123456789012")})
+		case r.prefixList != "":
+			p.PrefixListIds = append(p.PrefixListIds, &ec2.PrefixListId{PrefixListId: aws.String(r.prefixList)})
+		}
+	}
+	return perms
+}
+
+func normalizeProtocol(protocol string) string {
+	switch strings.ToLower(protocol) {
+	case "6", "tcp":
+		return "tcp"
+	case "17", "udp":
+		return "udp"
+	case "1", "icmp":
+		return "icmp"
+	case "58", "icmpv6":
+		return "icmpv6"
+	case "-1", "all":
+		return "-1"
+	}
+	return protocol
+}
@@ -0,0 +1,577 @@
+package awsextratest
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+//
+// Transit gateways
+//
+
+// transitGatewayAttachment is a VPC attachment with its route table
+// association and the route tables it propagates to.
+type transitGatewayAttachment struct {
+	*ec2.TransitGatewayVpcAttachment
+	association  *ec2.TransitGatewayAttachmentAssociation
+	propagations []string
+}
+
+// CreateTransitGateway creates a "pending" transit gateway, available once it
+// is next described, with a default route table that new attachments are
+// associated with and propagate to unless the options turn that off.
+func (f *EC2) CreateTransitGateway(in *ec2.CreateTransitGatewayInput) (*ec2.CreateTransitGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateTransitGateway"); err != nil {
+		return nil, err
+	}
+	options := &ec2.TransitGatewayOptions{
+		AmazonSideAsn:                aws.Int64(64512),
+		AutoAcceptSharedAttachments:  aws.String("disable"),
+		DefaultRouteTableAssociation: aws.String("enable"),
+		DefaultRouteTablePropagation: aws.String("enable"),
+		DnsSupport:                   aws.String("enable"),
+		VpnEcmpSupport:               aws.String("enable"),
+	}
+	if in.Options != nil {
+		if in.Options.DefaultRouteTableAssociation != nil {
+			options.DefaultRouteTableAssociation = in.Options.DefaultRouteTableAssociation
+		}
+		if in.Options.DefaultRouteTablePropagation != nil {
+			options.DefaultRouteTablePropagation = in.Options.DefaultRouteTablePropagation
+		}
+	}
+	tgw := &ec2.TransitGateway{
+		TransitGatewayId: f.newID("tgw"),
+		Description:      in.Description,
+		OwnerId:          aws.String(f.account),
+		State:            aws.String("pending"),
+		Options:          options,
+	}
+	tgw.TransitGatewayArn = aws.String("arn:aws:ec2:" + f.region + ":" + f.account + ":transit-gateway/" + *tgw.TransitGatewayId)
+	rtb := f.newTGWRouteTable(tgw.TransitGatewayId, true)
+	if *options.DefaultRouteTableAssociation == "enable" {
+		options.AssociationDefaultRouteTableId = rtb.TransitGatewayRouteTableId
+	}
+	if *options.DefaultRouteTablePropagation == "enable" {
+		options.PropagationDefaultRouteTableId = rtb.TransitGatewayRouteTableId
+	}
+	f.transitGateways[*tgw.TransitGatewayId] = tgw
+	return &ec2.CreateTransitGatewayOutput{TransitGateway: clone(tgw).(*ec2.TransitGateway)}, nil
+}
+
+func (f *EC2) newTGWRouteTable(tgwID *string, isDefault bool) *ec2.TransitGatewayRouteTable {
+	rtb := &ec2.TransitGatewayRouteTable{
+		TransitGatewayRouteTableId:   f.newID("tgw-rtb"),
+		TransitGatewayId:             tgwID,
+		State:                        aws.String("available"),
+		DefaultAssociationRouteTable: aws.Bool(isDefault),
+		DefaultPropagationRouteTable: aws.Bool(isDefault),
+	}
+	f.tgwRouteTables[*rtb.TransitGatewayRouteTableId] = rtb
+	return rtb
+}
+
+// CreateTransitGatewayRouteTable adds a route table to a transit gateway, so
+// tests can associate and propagate attachments to it.  awsextra only uses
+// route tables that already exist.
+func (f *EC2) CreateTransitGatewayRouteTable(in *ec2.CreateTransitGatewayRouteTableInput) (*ec2.CreateTransitGatewayRouteTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateTransitGatewayRouteTable"); err != nil {
+		return nil, err
+	}
+	tgw, err := f.liveTransitGateway(aws.StringValue(in.TransitGatewayId))
+	if err != nil {
+		return nil, err
+	}
+	rtb := f.newTGWRouteTable(tgw.TransitGatewayId, false)
+	return &ec2.CreateTransitGatewayRouteTableOutput{TransitGatewayRouteTable: clone(rtb).(*ec2.TransitGatewayRouteTable)}, nil
+}
+
+func (f *EC2) liveTransitGateway(ID string) (*ec2.TransitGateway, error) {
+	tgw := f.transitGateways[ID]
+	if tgw == nil || *tgw.State == "deleted" {
+		return nil, apiError("InvalidTransitGatewayID.NotFound", "Transit Gateway %s was deleted or does not exist.", ID)
+	}
+	return tgw, nil
+}
+
+// DescribeTransitGateways describes deleted transit gateways too, as EC2 does
+// for a while.
+func (f *EC2) DescribeTransitGateways(in *ec2.DescribeTransitGatewaysInput) (*ec2.DescribeTransitGatewaysOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeTransitGateways"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeTransitGatewaysOutput{}
+	for _, ID := range f.order {
+		tgw := f.transitGateways[ID]
+		if tgw == nil || !wanted(ID, in.TransitGatewayIds) {
+			continue
+		}
+		switch *tgw.State {
+		case "pending", "modifying":
+			tgw.State = aws.String("available")
+		case "deleting":
+			tgw.State = aws.String("deleted")
+		}
+		ok, err := f.match(ID, in.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "transit-gateway-id":
+				return []string{ID}, true
+			case "state":
+				return []string{*tgw.State}, true
+			case "owner-id":
+				return []string{*tgw.OwnerId}, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		c := clone(tgw).(*ec2.TransitGateway)
+		c.Tags = f.ec2Tags(ID)
+		out.TransitGateways = append(out.TransitGateways, c)
+	}
+	if err := notFound("InvalidTransitGatewayID.NotFound", in.TransitGatewayIds, len(out.TransitGateways)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DeleteTransitGateway fails while it has attachments that aren't deleted.
+// Its route tables go with it.
+func (f *EC2) DeleteTransitGateway(in *ec2.DeleteTransitGatewayInput) (*ec2.DeleteTransitGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteTransitGateway"); err != nil {
+		return nil, err
+	}
+	ID := aws.StringValue(in.TransitGatewayId)
+	tgw, err := f.liveTransitGateway(ID)
+	if err != nil {
+		return nil, err
+	}
+	for attachmentID, a := range f.tgwAttachments {
+		if *a.TransitGatewayId == ID && *a.State != "deleted" {
+			return nil, apiError("IncorrectState", "%s is in invalid state: it has attachment %s", ID, attachmentID)
+		}
+	}
+	for rtbID, rtb := range f.tgwRouteTables {
+		if *rtb.TransitGatewayId == ID {
+			delete(f.tgwRouteTables, rtbID)
+		}
+	}
+	tgw.State = aws.String("deleting")
+	return &ec2.DeleteTransitGatewayOutput{TransitGateway: clone(tgw).(*ec2.TransitGateway)}, nil
+}
+
+//
+// Transit gateway VPC attachments
+//
+
+// CreateTransitGatewayVpcAttachment attaches a VPC through at most one subnet
+// per zone.  The attachment is "pending" until it is next described.
+func (f *EC2) CreateTransitGatewayVpcAttachment(in *ec2.CreateTransitGatewayVpcAttachmentInput) (*ec2.CreateTransitGatewayVpcAttachmentOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateTransitGatewayVpcAttachment"); err != nil {
+		return nil, err
+	}
+	tgw, err := f.liveTransitGateway(aws.StringValue(in.TransitGatewayId))
+	if err != nil {
+		return nil, err
+	}
+	if *tgw.State != "available" {
+		return nil, apiError("IncorrectState", "%s is in invalid state %s", *tgw.TransitGatewayId, *tgw.State)
+	}
+	vpc := f.vpcs[aws.StringValue(in.VpcId)]
+	if vpc == nil {
+		return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", aws.StringValue(in.VpcId))
+	}
+	for ID, a := range f.tgwAttachments {
+		if *a.VpcId == *vpc.VpcId && *a.TransitGatewayId == *tgw.TransitGatewayId && *a.State != "deleted" {
+			return nil, apiError("DuplicateTransitGatewayAttachment", "%s has non-deleted Transit Gateway Attachments with same VPC ID (%s)", *tgw.TransitGatewayId, ID)
+		}
+	}
+	if len(in.SubnetIds) == 0 {
+		return nil, apiError("MissingParameter", "The request must contain the parameter SubnetIds")
+	}
+	if err := f.checkAttachmentSubnets(vpc.VpcId, nil, in.SubnetIds); err != nil {
+		return nil, err
+	}
+	a := &transitGatewayAttachment{TransitGatewayVpcAttachment: &ec2.TransitGatewayVpcAttachment{
+		TransitGatewayAttachmentId: f.newID("tgw-attach"),
+		TransitGatewayId:           tgw.TransitGatewayId,
+		VpcId:                      vpc.VpcId,
+		VpcOwnerId:                 vpc.OwnerId,
+		SubnetIds:                  aws.StringSlice(aws.StringValueSlice(in.SubnetIds)),
+		State:                      aws.String("pending"),
+	}}
+	if rtbID := tgw.Options.AssociationDefaultRouteTableId; rtbID != nil {
+		a.association = &ec2.TransitGatewayAttachmentAssociation{TransitGatewayRouteTableId: rtbID, State: aws.String("associating")}
+	}
+	if rtbID := tgw.Options.PropagationDefaultRouteTableId; rtbID != nil {
+		a.propagations = []string{*rtbID}
+	}
+	f.tgwAttachments[*a.TransitGatewayAttachmentId] = a
+	return &ec2.CreateTransitGatewayVpcAttachmentOutput{
+		TransitGatewayVpcAttachment: clone(a.TransitGatewayVpcAttachment).(*ec2.TransitGatewayVpcAttachment),
+	}, nil
+}
+
+// checkAttachmentSubnets checks that the subnets an attachment has, and
+// those added to it, are in the VPC and each in a zone of its own.
+func (f *EC2) checkAttachmentSubnets(vpcID *string, have []*string, add []*string) error {
+	zones := map[string]bool{}
+	for _, ID := range append(append([]*string{}, have...), add...) {
+		subnet := f.subnets[aws.StringValue(ID)]
+		if subnet == nil {
+			return apiError("InvalidSubnetID.NotFound", "The subnet ID '%s' does not exist", aws.StringValue(ID))
+		}
+		if *subnet.VpcId != *vpcID {
+			return apiError("InvalidParameterValue", "subnet %s is not in %s", *ID, *vpcID)
+		}
+		if zones[*subnet.AvailabilityZone] {
+			return apiError("DuplicateSubnetsInSameZone", "Duplicate Subnets for same AZ %s", *subnet.AvailabilityZone)
+		}
+		zones[*subnet.AvailabilityZone] = true
+	}
+	return nil
+}
+
+func (f *EC2) liveAttachment(ID string) (*transitGatewayAttachment, error) {
+	a := f.tgwAttachments[ID]
+	if a == nil || *a.State == "deleted" {
+		return nil, apiError("InvalidTransitGatewayAttachmentID.NotFound", "Transit Gateway Attachment %s was deleted or does not exist.", ID)
+	}
+	return a, nil
+}
+
+// advance moves the attachment, and its association, on to where they were
+// going.
+func (a *transitGatewayAttachment) advance() {
+	switch *a.State {
+	case "pending", "modifying":
+		a.State = aws.String("available")
+	case "deleting":
+		a.State = aws.String("deleted")
+	}
+	if a.association != nil {
+		switch *a.association.State {
+		case "associating":
+			a.association.State = aws.String("associated")
+		case "disassociating":
+			a.association = nil
+		}
+	}
+}
+
+// DescribeTransitGatewayVpcAttachments describes deleted attachments too, as
+// EC2 does for a while.
+func (f *EC2) DescribeTransitGatewayVpcAttachments(in *ec2.DescribeTransitGatewayVpcAttachmentsInput) (*ec2.DescribeTransitGatewayVpcAttachmentsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeTransitGatewayVpcAttachments"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeTransitGatewayVpcAttachmentsOutput{}
+	for _, ID := range f.order {
+		a := f.tgwAttachments[ID]
+		if a == nil || !wanted(ID, in.TransitGatewayAttachmentIds) {
+			continue
+		}
+		a.advance()
+		ok, err := f.match(ID, in.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "transit-gateway-attachment-id":
+				return []string{ID}, true
+			case "transit-gateway-id":
+				return []string{*a.TransitGatewayId}, true
+			case "vpc-id":
+				return []string{*a.VpcId}, true
+			case "state":
+				return []string{*a.State}, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		c := clone(a.TransitGatewayVpcAttachment).(*ec2.TransitGatewayVpcAttachment)
+		c.Tags = f.ec2Tags(ID)
+		out.TransitGatewayVpcAttachments = append(out.TransitGatewayVpcAttachments, c)
+	}
+	if err := notFound("InvalidTransitGatewayAttachmentID.NotFound", in.TransitGatewayAttachmentIds, len(out.TransitGatewayVpcAttachments)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ModifyTransitGatewayVpcAttachment adds and removes subnets of an available
+// attachment, which is "modifying" until it is next described.
+func (f *EC2) ModifyTransitGatewayVpcAttachment(in *ec2.ModifyTransitGatewayVpcAttachmentInput) (*ec2.ModifyTransitGatewayVpcAttachmentOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("ModifyTransitGatewayVpcAttachment"); err != nil {
+		return nil, err
+	}
+	a, err := f.liveAttachment(aws.StringValue(in.TransitGatewayAttachmentId))
+	if err != nil {
+		return nil, err
+	}
+	if *a.State != "available" {
+		return nil, apiError("IncorrectState", "%s is in invalid state %s", *a.TransitGatewayAttachmentId, *a.State)
+	}
+	var keep []*string
+	for _, ID := range a.SubnetIds {
+		removed := false
+		for _, r := range in.RemoveSubnetIds {
+			removed = removed || aws.StringValue(r) == *ID
+		}
+		if !removed {
+			keep = append(keep, ID)
+		}
+	}
+	if err := f.checkAttachmentSubnets(a.VpcId, keep, in.AddSubnetIds); err != nil {
+		return nil, err
+	}
+	a.SubnetIds = append(keep, aws.StringSlice(aws.StringValueSlice(in.AddSubnetIds))...)
+	a.State = aws.String("modifying")
+	return &ec2.ModifyTransitGatewayVpcAttachmentOutput{
+		TransitGatewayVpcAttachment: clone(a.TransitGatewayVpcAttachment).(*ec2.TransitGatewayVpcAttachment),
+	}, nil
+}
+
+// DeleteTransitGatewayVpcAttachment starts deleting an attachment, which is
+// "deleting", and keeps its subnets in use, until it is next described.
+func (f *EC2) DeleteTransitGatewayVpcAttachment(in *ec2.DeleteTransitGatewayVpcAttachmentInput) (*ec2.DeleteTransitGatewayVpcAttachmentOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteTransitGatewayVpcAttachment"); err != nil {
+		return nil, err
+	}
+	a, err := f.liveAttachment(aws.StringValue(in.TransitGatewayAttachmentId))
+	if err != nil {
+		return nil, err
+	}
+	if *a.State == "deleting" {
+		return nil, apiError("IncorrectState", "%s is in invalid state deleting", *a.TransitGatewayAttachmentId)
+	}
+	a.State = aws.String("deleting")
+	a.association = nil
+	a.propagations = nil
+	return &ec2.DeleteTransitGatewayVpcAttachmentOutput{
+		TransitGatewayVpcAttachment: clone(a.TransitGatewayVpcAttachment).(*ec2.TransitGatewayVpcAttachment),
+	}, nil
+}
+
+// DescribeTransitGatewayAttachments describes the VPC attachments as EC2's
+// view of every kind of attachment does, with their associations.
+func (f *EC2) DescribeTransitGatewayAttachments(in *ec2.DescribeTransitGatewayAttachmentsInput) (*ec2.DescribeTransitGatewayAttachmentsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeTransitGatewayAttachments"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeTransitGatewayAttachmentsOutput{}
+	for _, ID := range f.order {
+		a := f.tgwAttachments[ID]
+		if a == nil || !wanted(ID, in.TransitGatewayAttachmentIds) {
+			continue
+		}
+		a.advance()
+		ok, err := f.match(ID, in.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "transit-gateway-attachment-id":
+				return []string{ID}, true
+			case "transit-gateway-id":
+				return []string{*a.TransitGatewayId}, true
+			case "resource-id":
+				return []string{*a.VpcId}, true
+			case "resource-type":
+				return []string{"vpc"}, true
+			case "state":
+				return []string{*a.State}, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		c := &ec2.TransitGatewayAttachment{
+			TransitGatewayAttachmentId: a.TransitGatewayAttachmentId,
+			TransitGatewayId:           a.TransitGatewayId,
+			TransitGatewayOwnerId:      f.transitGateways[*a.TransitGatewayId].OwnerId,
+			ResourceId:                 a.VpcId,
+			ResourceOwnerId:            a.VpcOwnerId,
+			ResourceType:               aws.String("vpc"),
+			State:                      a.State,
+			Association:                a.association,
+			Tags:                       f.ec2Tags(ID),
+		}
+		out.TransitGatewayAttachments = append(out.TransitGatewayAttachments, clone(c).(*ec2.TransitGatewayAttachment))
+	}
+	if err := notFound("InvalidTransitGatewayAttachmentID.NotFound", in.TransitGatewayAttachmentIds, len(out.TransitGatewayAttachments)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// attachmentRouteTable returns the available attachment and the route table
+// of the same transit gateway.
+func (f *EC2) attachmentRouteTable(attachmentID *string, rtbID *string) (*transitGatewayAttachment, error) {
+	a, err := f.liveAttachment(aws.StringValue(attachmentID))
+	if err != nil {
+		return nil, err
+	}
+	if *a.State != "available" {
+		return nil, apiError("IncorrectState", "%s is in invalid state %s", *a.TransitGatewayAttachmentId, *a.State)
+	}
+	rtb := f.tgwRouteTables[aws.StringValue(rtbID)]
+	if rtb == nil || *rtb.TransitGatewayId != *a.TransitGatewayId {
+		return nil, apiError("InvalidRouteTableID.NotFound", "Transit Gateway Route Table %s was deleted or does not exist.", aws.StringValue(rtbID))
+	}
+	return a, nil
+}
+
+// AssociateTransitGatewayRouteTable associates an attachment that has no
+// association yet.  It is "associating" until next described.
+func (f *EC2) AssociateTransitGatewayRouteTable(in *ec2.AssociateTransitGatewayRouteTableInput) (*ec2.AssociateTransitGatewayRouteTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("AssociateTransitGatewayRouteTable"); err != nil {
+		return nil, err
+	}
+	a, err := f.attachmentRouteTable(in.TransitGatewayAttachmentId, in.TransitGatewayRouteTableId)
+	if err != nil {
+		return nil, err
+	}
+	if a.association != nil {
+		return nil, apiError("Resource.AlreadyAssociated", "Transit Gateway Attachment %s is already associated to a route table.", *a.TransitGatewayAttachmentId)
+	}
+	a.association = &ec2.TransitGatewayAttachmentAssociation{
+		TransitGatewayRouteTableId: aws.String(*in.TransitGatewayRouteTableId),
+		State:                      aws.String("associating"),
+	}
+	return &ec2.AssociateTransitGatewayRouteTableOutput{Association: f.tgwAssociation(a)}, nil
+}
+
+// DisassociateTransitGatewayRouteTable leaves the association
+// "disassociating" until the attachment is next described.
+func (f *EC2) DisassociateTransitGatewayRouteTable(in *ec2.DisassociateTransitGatewayRouteTableInput) (*ec2.DisassociateTransitGatewayRouteTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DisassociateTransitGatewayRouteTable"); err != nil {
+		return nil, err
+	}
+	a, err := f.attachmentRouteTable(in.TransitGatewayAttachmentId, in.TransitGatewayRouteTableId)
+	if err != nil {
+		return nil, err
+	}
+	if a.association == nil || *a.association.TransitGatewayRouteTableId != *in.TransitGatewayRouteTableId || *a.association.State == "disassociating" {
+		return nil, apiError("InvalidAssociation.NotFound", "%s is not associated with %s", *a.TransitGatewayAttachmentId, *in.TransitGatewayRouteTableId)
+	}
+	a.association.State = aws.String("disassociating")
+	return &ec2.DisassociateTransitGatewayRouteTableOutput{Association: f.tgwAssociation(a)}, nil
+}
+
+func (f *EC2) tgwAssociation(a *transitGatewayAttachment) *ec2.TransitGatewayAssociation {
+	return &ec2.TransitGatewayAssociation{
+		TransitGatewayAttachmentId: a.TransitGatewayAttachmentId,
+		TransitGatewayRouteTableId: aws.String(*a.association.TransitGatewayRouteTableId),
+		ResourceId:                 a.VpcId,
+		ResourceType:               aws.String("vpc"),
+		State:                      aws.String(*a.association.State),
+	}
+}
+
+func (f *EC2) GetTransitGatewayAttachmentPropagations(in *ec2.GetTransitGatewayAttachmentPropagationsInput) (*ec2.GetTransitGatewayAttachmentPropagationsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("GetTransitGatewayAttachmentPropagations"); err != nil {
+		return nil, err
+	}
+	a, err := f.liveAttachment(aws.StringValue(in.TransitGatewayAttachmentId))
+	if err != nil {
+		return nil, err
+	}
+	out := &ec2.GetTransitGatewayAttachmentPropagationsOutput{}
+	for _, rtbID := range a.propagations {
+		out.TransitGatewayAttachmentPropagations = append(out.TransitGatewayAttachmentPropagations, &ec2.TransitGatewayAttachmentPropagation{
+			TransitGatewayRouteTableId: aws.String(rtbID),
+			State:                      aws.String("enabled"),
+		})
+	}
+	return out, nil
+}
+
+func (f *EC2) EnableTransitGatewayRouteTablePropagation(in *ec2.EnableTransitGatewayRouteTablePropagationInput) (*ec2.EnableTransitGatewayRouteTablePropagationOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("EnableTransitGatewayRouteTablePropagation"); err != nil {
+		return nil, err
+	}
+	a, err := f.attachmentRouteTable(in.TransitGatewayAttachmentId, in.TransitGatewayRouteTableId)
+	if err != nil {
+		return nil, err
+	}
+	rtbID := *in.TransitGatewayRouteTableId
+	for _, ID := range a.propagations {
+		if ID == rtbID {
+			return nil, apiError("TransitGatewayRouteTablePropagation.Duplicate", "Propagation for %s already exists in %s", *a.TransitGatewayAttachmentId, rtbID)
+		}
+	}
+	a.propagations = append(a.propagations, rtbID)
+	return &ec2.EnableTransitGatewayRouteTablePropagationOutput{
+		Propagation: &ec2.TransitGatewayPropagation{
+			TransitGatewayAttachmentId: a.TransitGatewayAttachmentId,
+			TransitGatewayRouteTableId: aws.String(rtbID),
+			ResourceId:                 a.VpcId,
+			ResourceType:               aws.String("vpc"),
+			State:                      aws.String("enabled"),
+		},
+	}, nil
+}
+
+// tgwAttachmentUsing returns an attachment, not yet deleted, with the given
+// subnet or in the given VPC.
+func (f *EC2) tgwAttachmentUsing(filter string, ID string) string {
+	for attachmentID, a := range f.tgwAttachments {
+		if *a.State == "deleted" {
+			continue
+		}
+		if filter == "vpc-id" && *a.VpcId == ID {
+			return attachmentID
+		}
+		for _, subnetID := range a.SubnetIds {
+			if filter == "subnet-id" && *subnetID == ID {
+				return attachmentID
+			}
+		}
+	}
+	return ""
+}
+
+// attachedToTGW reports whether the VPC has an attachment to the transit
+// gateway that routes can use.
+func (f *EC2) attachedToTGW(vpcID string, tgwID string) bool {
+	for _, a := range f.tgwAttachments {
+		if *a.VpcId == vpcID && *a.TransitGatewayId == tgwID && (*a.State == "available" || *a.State == "modifying") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,1065 @@
+package awsextratest
+
+import (
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+//
+// VPCs
+//
+
+func (f *EC2) CreateVpc(in *ec2.CreateVpcInput) (*ec2.CreateVpcOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateVpc"); err != nil {
+		return nil, err
+	}
+	_, block, err := net.ParseCIDR(aws.StringValue(in.CidrBlock))
+	if err != nil {
+		return nil, apiError("InvalidParameterValue", "Value (%s) for parameter cidrBlock is invalid", aws.StringValue(in.CidrBlock))
+	}
+	if ones, _ := block.Mask.Size(); ones < 16 || ones > 28 {
+		return nil, apiError("InvalidVpc.Range", "The CIDR '%s' is invalid", aws.StringValue(in.CidrBlock))
+	}
+	vpc := &ec2.Vpc{
+		VpcId:         f.newID("vpc"),
+		OwnerId:       aws.String(f.account),
+		CidrBlock:     aws.String(block.String()),
+		DhcpOptionsId: aws.String("default"),
+		State:         aws.String("available"),
+		IsDefault:     aws.Bool(false),
+	}
+	if aws.BoolValue(in.AmazonProvidedIpv6CidrBlock) {
+		vpc.Ipv6CidrBlockAssociationSet = []*ec2.VpcIpv6CidrBlockAssociation{f.newIPv6Block()}
+	}
+	f.vpcs[*vpc.VpcId] = vpc
+	f.vpcAttributes[*vpc.VpcId] = map[string]bool{"enableDnsSupport": true}
+	f.network.publish(f, vpc)
+
+	// Every VPC comes with a main route table, a default network ACL and a
+	// default security group.
+	rtID := f.newID("rtb")
+	f.routeTables[*rtID] = &ec2.RouteTable{
+		RouteTableId: rtID,
+		VpcId:        vpc.VpcId,
+		Routes: []*ec2.Route{{
+			DestinationCidrBlock: vpc.CidrBlock,
+			GatewayId:            aws.String("local"),
+			State:                aws.String("active"),
+			Origin:               aws.String("CreateRouteTable"),
+		}},
+		Associations: []*ec2.RouteTableAssociation{{
+			Main:                    aws.Bool(true),
+			RouteTableId:            rtID,
+			RouteTableAssociationId: f.newID("rtbassoc"),
+		}},
+	}
+	f.newNetworkACL(vpc.VpcId, true)
+	f.newSecurityGroup(vpc.VpcId, "default", "default VPC security group")
+
+	return &ec2.CreateVpcOutput{Vpc: clone(vpc).(*ec2.Vpc)}, nil
+}
+
+func (f *EC2) DescribeVpcs(in *ec2.DescribeVpcsInput) (*ec2.DescribeVpcsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeVpcs"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeVpcsOutput{}
+	for _, ID := range f.order {
+		vpc := f.vpcs[ID]
+		if vpc == nil || !wanted(ID, in.VpcIds) {
+			continue
+		}
+		// IPv6 blocks are associated by the time the VPC is described.
+		for _, assoc := range vpc.Ipv6CidrBlockAssociationSet {
+			assoc.Ipv6CidrBlockState.State = aws.String("associated")
+		}
+		ok, err := f.match(ID, in.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "vpc-id":
+				return []string{ID}, true
+			case "owner-id":
+				return []string{*vpc.OwnerId}, true
+			case "ipv6-cidr-block-association.ipv6-cidr-block":
+				var blocks []string
+				for _, assoc := range vpc.Ipv6CidrBlockAssociationSet {
+					blocks = append(blocks, *assoc.Ipv6CidrBlock)
+				}
+				return blocks, true
+			case "cidr", "cidr-block-association.cidr-block":
+				return []string{*vpc.CidrBlock}, true
+			case "dhcp-options-id":
+				return []string{*vpc.DhcpOptionsId}, true
+			case "state":
+				return []string{*vpc.State}, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			c := clone(vpc).(*ec2.Vpc)
+			c.Tags = f.ec2Tags(ID)
+			out.Vpcs = append(out.Vpcs, c)
+		}
+	}
+	if err := notFound("InvalidVpcID.NotFound", in.VpcIds, len(out.Vpcs)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (f *EC2) DescribeVpcAttribute(in *ec2.DescribeVpcAttributeInput) (*ec2.DescribeVpcAttributeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeVpcAttribute"); err != nil {
+		return nil, err
+	}
+	attrs, ok := f.vpcAttributes[aws.StringValue(in.VpcId)]
+	if !ok {
+		return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", aws.StringValue(in.VpcId))
+	}
+	out := &ec2.DescribeVpcAttributeOutput{VpcId: in.VpcId}
+	switch attribute := aws.StringValue(in.Attribute); attribute {
+	case "enableDnsSupport":
+		out.EnableDnsSupport = &ec2.AttributeBooleanValue{Value: aws.Bool(attrs[attribute])}
+	case "enableDnsHostnames":
+		out.EnableDnsHostnames = &ec2.AttributeBooleanValue{Value: aws.Bool(attrs[attribute])}
+	default:
+		return nil, apiError("InvalidParameterValue", "Value (%s) for parameter attribute is invalid", attribute)
+	}
+	return out, nil
+}
+
+func (f *EC2) ModifyVpcAttribute(in *ec2.ModifyVpcAttributeInput) (*ec2.ModifyVpcAttributeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("ModifyVpcAttribute"); err != nil {
+		return nil, err
+	}
+	attrs, ok := f.vpcAttributes[aws.StringValue(in.VpcId)]
+	if !ok {
+		return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", aws.StringValue(in.VpcId))
+	}
+	if in.EnableDnsSupport != nil && in.EnableDnsHostnames != nil {
+		return nil, apiError("InvalidParameterCombination", "Only one attribute can be modified at a time")
+	}
+	if in.EnableDnsSupport != nil {
+		if !aws.BoolValue(in.EnableDnsSupport.Value) && attrs["enableDnsHostnames"] {
+			return nil, apiError("InvalidParameterValue", "DNS support is required by DNS hostnames")
+		}
+		attrs["enableDnsSupport"] = aws.BoolValue(in.EnableDnsSupport.Value)
+	}
+	if in.EnableDnsHostnames != nil {
+		if aws.BoolValue(in.EnableDnsHostnames.Value) && !attrs["enableDnsSupport"] {
+			return nil, apiError("InvalidParameterValue", "DNS hostnames require DNS support")
+		}
+		attrs["enableDnsHostnames"] = aws.BoolValue(in.EnableDnsHostnames.Value)
+	}
+	return &ec2.ModifyVpcAttributeOutput{}, nil
+}
+
+func (f *EC2) DeleteVpc(in *ec2.DeleteVpcInput) (*ec2.DeleteVpcOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteVpc"); err != nil {
+		return nil, err
+	}
+	vpcID := aws.StringValue(in.VpcId)
+	if f.vpcs[vpcID] == nil {
+		return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", vpcID)
+	}
+	if dependent := f.vpcDependent(vpcID); dependent != "" {
+		return nil, apiError("DependencyViolation", "The vpc '%s' has dependencies and cannot be deleted (%s)", vpcID, dependent)
+	}
+	for ID, rt := range f.routeTables {
+		if aws.StringValue(rt.VpcId) == vpcID {
+			f.forget(ID)
+			delete(f.routeTables, ID)
+		}
+	}
+	for ID, acl := range f.networkACLs {
+		if aws.StringValue(acl.VpcId) == vpcID {
+			f.forget(ID)
+			delete(f.networkACLs, ID)
+		}
+	}
+	for ID, sg := range f.securityGroups {
+		if aws.StringValue(sg.VpcId) == vpcID {
+			f.forget(ID)
+			delete(f.securityGroups, ID)
+		}
+	}
+	f.forget(vpcID)
+	delete(f.vpcs, vpcID)
+	delete(f.vpcAttributes, vpcID)
+	f.network.unpublish(vpcID)
+	return &ec2.DeleteVpcOutput{}, nil
+}
+
+// vpcDependent returns the ID of something that stops vpcID being deleted.
+func (f *EC2) vpcDependent(vpcID string) string {
+	for ID, subnet := range f.subnets {
+		if aws.StringValue(subnet.VpcId) == vpcID {
+			return ID
+		}
+	}
+	for ID, igw := range f.igws {
+		if attachedTo(igw, vpcID) {
+			return ID
+		}
+	}
+	if natID := f.natGatewayIn("vpc-id", vpcID); natID != "" {
+		return natID
+	}
+	for ID, eigw := range f.eigws {
+		if aws.StringValue(eigw.Attachments[0].VpcId) == vpcID {
+			return ID
+		}
+	}
+	if ID := f.endpointIn(vpcID); ID != "" {
+		return ID
+	}
+	if ID := f.flowLogOf(vpcID); ID != "" {
+		return ID
+	}
+	if ID := f.network.peeringIn(vpcID); ID != "" {
+		return ID
+	}
+	if ID := f.tgwAttachmentUsing("vpc-id", vpcID); ID != "" {
+		return ID
+	}
+	if ID := f.vgwOf(vpcID); ID != "" {
+		return ID
+	}
+	for ID, rt := range f.routeTables {
+		if aws.StringValue(rt.VpcId) == vpcID && !isMain(rt) {
+			return ID
+		}
+	}
+	for ID, acl := range f.networkACLs {
+		if aws.StringValue(acl.VpcId) == vpcID && !*acl.IsDefault {
+			return ID
+		}
+	}
+	for ID, sg := range f.securityGroups {
+		if aws.StringValue(sg.VpcId) == vpcID && aws.StringValue(sg.GroupName) != "default" {
+			return ID
+		}
+	}
+	return ""
+}
+
+//
+// DHCP options sets
+//
+
+func (f *EC2) CreateDhcpOptions(in *ec2.CreateDhcpOptionsInput) (*ec2.CreateDhcpOptionsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateDhcpOptions"); err != nil {
+		return nil, err
+	}
+	opts := &ec2.DhcpOptions{DhcpOptionsId: f.newID("dopt")}
+	for _, conf := range in.DhcpConfigurations {
+		c := &ec2.DhcpConfiguration{Key: conf.Key}
+		for _, v := range conf.Values {
+			c.Values = append(c.Values, &ec2.AttributeValue{Value: v})
+		}
+		opts.DhcpConfigurations = append(opts.DhcpConfigurations, c)
+	}
+	f.dhcpOptions[*opts.DhcpOptionsId] = opts
+	return &ec2.CreateDhcpOptionsOutput{DhcpOptions: clone(opts).(*ec2.DhcpOptions)}, nil
+}
+
+func (f *EC2) AssociateDhcpOptions(in *ec2.AssociateDhcpOptionsInput) (*ec2.AssociateDhcpOptionsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("AssociateDhcpOptions"); err != nil {
+		return nil, err
+	}
+	vpc := f.vpcs[aws.StringValue(in.VpcId)]
+	if vpc == nil {
+		return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", aws.StringValue(in.VpcId))
+	}
+	optsID := aws.StringValue(in.DhcpOptionsId)
+	if optsID != "default" && f.dhcpOptions[optsID] == nil {
+		return nil, apiError("InvalidDhcpOptionID.NotFound", "The dhcpOption ID '%s' does not exist", optsID)
+	}
+	vpc.DhcpOptionsId = aws.String(optsID)
+	return &ec2.AssociateDhcpOptionsOutput{}, nil
+}
+
+func (f *EC2) DescribeDhcpOptions(in *ec2.DescribeDhcpOptionsInput) (*ec2.DescribeDhcpOptionsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeDhcpOptions"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeDhcpOptionsOutput{}
+	for _, ID := range f.order {
+		opts := f.dhcpOptions[ID]
+		if opts == nil || !wanted(ID, in.DhcpOptionsIds) {
+			continue
+		}
+		ok, err := f.match(ID, in.Filters, func(name string) ([]string, bool) {
+			if name == "dhcp-options-id" {
+				return []string{ID}, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			c := clone(opts).(*ec2.DhcpOptions)
+			c.Tags = f.ec2Tags(ID)
+			out.DhcpOptions = append(out.DhcpOptions, c)
+		}
+	}
+	if err := notFound("InvalidDhcpOptionID.NotFound", in.DhcpOptionsIds, len(out.DhcpOptions)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (f *EC2) DeleteDhcpOptions(in *ec2.DeleteDhcpOptionsInput) (*ec2.DeleteDhcpOptionsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteDhcpOptions"); err != nil {
+		return nil, err
+	}
+	optsID := aws.StringValue(in.DhcpOptionsId)
+	if f.dhcpOptions[optsID] == nil {
+		return nil, apiError("InvalidDhcpOptionID.NotFound", "The dhcpOption ID '%s' does not exist", optsID)
+	}
+	for vpcID, vpc := range f.vpcs {
+		if aws.StringValue(vpc.DhcpOptionsId) == optsID {
+			return nil, apiError("DependencyViolation", "The dhcpOptions '%s' has dependencies and cannot be deleted (%s)", optsID, vpcID)
+		}
+	}
+	f.forget(optsID)
+	delete(f.dhcpOptions, optsID)
+	return &ec2.DeleteDhcpOptionsOutput{}, nil
+}
+
+//
+// Subnets
+//
+
+func (f *EC2) DescribeAvailabilityZones(in *ec2.DescribeAvailabilityZonesInput) (*ec2.DescribeAvailabilityZonesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeAvailabilityZones"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeAvailabilityZonesOutput{}
+	for _, zone := range f.zones {
+		if !wanted(*zone.ZoneName, in.ZoneNames) || !wanted(*zone.ZoneId, in.ZoneIds) {
+			continue
+		}
+		ok, err := f.match("", in.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "zone-name":
+				return []string{*zone.ZoneName}, true
+			case "zone-id":
+				return []string{*zone.ZoneId}, true
+			case "zone-type":
+				return []string{*zone.ZoneType}, true
+			case "state":
+				return []string{*zone.State}, true
+			case "region-name":
+				return []string{*zone.RegionName}, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out.AvailabilityZones = append(out.AvailabilityZones, clone(zone).(*ec2.AvailabilityZone))
+		}
+	}
+	return out, nil
+}
+
+func (f *EC2) CreateSubnet(in *ec2.CreateSubnetInput) (*ec2.CreateSubnetOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateSubnet"); err != nil {
+		return nil, err
+	}
+	vpc := f.vpcs[aws.StringValue(in.VpcId)]
+	if vpc == nil {
+		return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", aws.StringValue(in.VpcId))
+	}
+	_, block, err := net.ParseCIDR(aws.StringValue(in.CidrBlock))
+	if err != nil {
+		return nil, apiError("InvalidParameterValue", "Value (%s) for parameter cidrBlock is invalid", aws.StringValue(in.CidrBlock))
+	}
+	_, vpcBlock, _ := net.ParseCIDR(*vpc.CidrBlock)
+	if !contains(vpcBlock, block) {
+		return nil, apiError("InvalidSubnet.Range", "The CIDR '%s' is invalid", block)
+	}
+	for _, subnet := range f.subnets {
+		_, other, _ := net.ParseCIDR(*subnet.CidrBlock)
+		if *subnet.VpcId == *vpc.VpcId && overlaps(block, other) {
+			return nil, apiError("InvalidSubnet.Conflict", "The CIDR '%s' conflicts with another subnet", block)
+		}
+	}
+	var zone *ec2.AvailabilityZone
+	for _, z := range f.zones {
+		if in.AvailabilityZone == nil && in.AvailabilityZoneId == nil ||
+			aws.StringValue(in.AvailabilityZone) == *z.ZoneName ||
+			aws.StringValue(in.AvailabilityZoneId) == *z.ZoneId {
+			zone = z
+			break
+		}
+	}
+	if zone == nil {
+		return nil, apiError("InvalidParameterValue", "Value (%s) for parameter availabilityZone is invalid", aws.StringValue(in.AvailabilityZone))
+	}
+	subnet := &ec2.Subnet{
+		SubnetId:            f.newID("subnet"),
+		VpcId:               vpc.VpcId,
+		CidrBlock:           aws.String(block.String()),
+		AvailabilityZone:    zone.ZoneName,
+		AvailabilityZoneId:  zone.ZoneId,
+		MapPublicIpOnLaunch: aws.Bool(false),
+		State:               aws.String("available"),
+
+		AssignIpv6AddressOnCreation: aws.Bool(false),
+	}
+	if in.Ipv6CidrBlock != nil {
+		block, err := f.checkSubnetIPv6(vpc, *in.Ipv6CidrBlock)
+		if err != nil {
+			return nil, err
+		}
+		subnet.Ipv6CidrBlockAssociationSet = []*ec2.SubnetIpv6CidrBlockAssociation{block}
+	}
+	f.subnets[*subnet.SubnetId] = subnet
+	f.associateNetworkACL(f.defaultNetworkACL(*vpc.VpcId), subnet.SubnetId)
+	return &ec2.CreateSubnetOutput{Subnet: clone(subnet).(*ec2.Subnet)}, nil
+}
+
+func (f *EC2) ModifySubnetAttribute(in *ec2.ModifySubnetAttributeInput) (*ec2.ModifySubnetAttributeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("ModifySubnetAttribute"); err != nil {
+		return nil, err
+	}
+	subnet := f.subnets[aws.StringValue(in.SubnetId)]
+	if subnet == nil {
+		return nil, apiError("InvalidSubnetID.NotFound", "The subnet ID '%s' does not exist", aws.StringValue(in.SubnetId))
+	}
+	if in.MapPublicIpOnLaunch != nil && in.AssignIpv6AddressOnCreation != nil {
+		return nil, apiError("InvalidParameterCombination", "Only one attribute can be modified at a time")
+	}
+	if in.MapPublicIpOnLaunch != nil {
+		subnet.MapPublicIpOnLaunch = in.MapPublicIpOnLaunch.Value
+	}
+	if in.AssignIpv6AddressOnCreation != nil {
+		if aws.BoolValue(in.AssignIpv6AddressOnCreation.Value) && len(subnet.Ipv6CidrBlockAssociationSet) == 0 {
+			return nil, apiError("InvalidParameterValue", "The subnet '%s' has no IPv6 CIDR block", *subnet.SubnetId)
+		}
+		subnet.AssignIpv6AddressOnCreation = in.AssignIpv6AddressOnCreation.Value
+	}
+	return &ec2.ModifySubnetAttributeOutput{}, nil
+}
+
+func (f *EC2) DescribeSubnets(in *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeSubnets"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeSubnetsOutput{}
+	for _, ID := range f.order {
+		subnet := f.subnets[ID]
+		if subnet == nil || !wanted(ID, in.SubnetIds) {
+			continue
+		}
+		ok, err := f.match(ID, in.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "subnet-id":
+				return []string{ID}, true
+			case "vpc-id":
+				return []string{*subnet.VpcId}, true
+			case "cidr-block", "cidr":
+				return []string{*subnet.CidrBlock}, true
+			case "availability-zone":
+				return []string{*subnet.AvailabilityZone}, true
+			case "availability-zone-id":
+				return []string{*subnet.AvailabilityZoneId}, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			c := clone(subnet).(*ec2.Subnet)
+			c.Tags = f.ec2Tags(ID)
+			out.Subnets = append(out.Subnets, c)
+		}
+	}
+	if err := notFound("InvalidSubnetID.NotFound", in.SubnetIds, len(out.Subnets)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (f *EC2) DeleteSubnet(in *ec2.DeleteSubnetInput) (*ec2.DeleteSubnetOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteSubnet"); err != nil {
+		return nil, err
+	}
+	subnetID := aws.StringValue(in.SubnetId)
+	if f.subnets[subnetID] == nil {
+		return nil, apiError("InvalidSubnetID.NotFound", "The subnet ID '%s' does not exist", subnetID)
+	}
+	for eniID, eni := range f.networkInterfaces {
+		if aws.StringValue(eni.SubnetId) == subnetID {
+			return nil, apiError("DependencyViolation", "The subnet '%s' has dependencies and cannot be deleted (%s)", subnetID, eniID)
+		}
+	}
+	if natID := f.natGatewayIn("subnet-id", subnetID); natID != "" {
+		return nil, apiError("DependencyViolation", "The subnet '%s' has dependencies and cannot be deleted (%s)", subnetID, natID)
+	}
+	if ID := f.tgwAttachmentUsing("subnet-id", subnetID); ID != "" {
+		return nil, apiError("DependencyViolation", "The subnet '%s' has dependencies and cannot be deleted (%s)", subnetID, ID)
+	}
+	// Explicit route table associations go away with the subnet.
+	for _, rt := range f.routeTables {
+		var keep []*ec2.RouteTableAssociation
+		for _, assoc := range rt.Associations {
+			if aws.StringValue(assoc.SubnetId) != subnetID {
+				keep = append(keep, assoc)
+			}
+		}
+		rt.Associations = keep
+	}
+	f.disassociateNetworkACL(subnetID)
+	f.forget(subnetID)
+	delete(f.subnets, subnetID)
+	return &ec2.DeleteSubnetOutput{}, nil
+}
+
+//
+// Internet gateways
+//
+
+func (f *EC2) CreateInternetGateway(in *ec2.CreateInternetGatewayInput) (*ec2.CreateInternetGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateInternetGateway"); err != nil {
+		return nil, err
+	}
+	igw := &ec2.InternetGateway{InternetGatewayId: f.newID("igw")}
+	f.igws[*igw.InternetGatewayId] = igw
+	return &ec2.CreateInternetGatewayOutput{InternetGateway: clone(igw).(*ec2.InternetGateway)}, nil
+}
+
+func (f *EC2) AttachInternetGateway(in *ec2.AttachInternetGatewayInput) (*ec2.AttachInternetGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("AttachInternetGateway"); err != nil {
+		return nil, err
+	}
+	igw := f.igws[aws.StringValue(in.InternetGatewayId)]
+	if igw == nil {
+		return nil, apiError("InvalidInternetGatewayID.NotFound", "The internetGateway ID '%s' does not exist", aws.StringValue(in.InternetGatewayId))
+	}
+	vpcID := aws.StringValue(in.VpcId)
+	if f.vpcs[vpcID] == nil {
+		return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", vpcID)
+	}
+	if len(igw.Attachments) > 0 {
+		return nil, apiError("Resource.AlreadyAssociated", "resource %s is already attached to network %s", *igw.InternetGatewayId, *igw.Attachments[0].VpcId)
+	}
+	for _, other := range f.igws {
+		if attachedTo(other, vpcID) {
+			return nil, apiError("Resource.AlreadyAssociated", "network %s already has an internet gateway attached", vpcID)
+		}
+	}
+	igw.Attachments = []*ec2.InternetGatewayAttachment{{VpcId: aws.String(vpcID), State: aws.String("available")}}
+	return &ec2.AttachInternetGatewayOutput{}, nil
+}
+
+func (f *EC2) DescribeInternetGateways(in *ec2.DescribeInternetGatewaysInput) (*ec2.DescribeInternetGatewaysOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeInternetGateways"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeInternetGatewaysOutput{}
+	for _, ID := range f.order {
+		igw := f.igws[ID]
+		if igw == nil || !wanted(ID, in.InternetGatewayIds) {
+			continue
+		}
+		ok, err := f.match(ID, in.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "internet-gateway-id":
+				return []string{ID}, true
+			case "attachment.vpc-id":
+				var vpcs []string
+				for _, a := range igw.Attachments {
+					vpcs = append(vpcs, *a.VpcId)
+				}
+				return vpcs, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			c := clone(igw).(*ec2.InternetGateway)
+			c.Tags = f.ec2Tags(ID)
+			out.InternetGateways = append(out.InternetGateways, c)
+		}
+	}
+	if err := notFound("InvalidInternetGatewayID.NotFound", in.InternetGatewayIds, len(out.InternetGateways)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (f *EC2) DetachInternetGateway(in *ec2.DetachInternetGatewayInput) (*ec2.DetachInternetGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DetachInternetGateway"); err != nil {
+		return nil, err
+	}
+	igw := f.igws[aws.StringValue(in.InternetGatewayId)]
+	if igw == nil {
+		return nil, apiError("InvalidInternetGatewayID.NotFound", "The internetGateway ID '%s' does not exist", aws.StringValue(in.InternetGatewayId))
+	}
+	if !attachedTo(igw, aws.StringValue(in.VpcId)) {
+		return nil, apiError("Gateway.NotAttached", "resource %s is not attached to network %s", *igw.InternetGatewayId, aws.StringValue(in.VpcId))
+	}
+	if natID := f.natGatewayIn("vpc-id", aws.StringValue(in.VpcId)); natID != "" {
+		return nil, apiError("DependencyViolation", "Network %s has some mapped public address(es) (%s)", aws.StringValue(in.VpcId), natID)
+	}
+	igw.Attachments = nil
+	return &ec2.DetachInternetGatewayOutput{}, nil
+}
+
+func (f *EC2) DeleteInternetGateway(in *ec2.DeleteInternetGatewayInput) (*ec2.DeleteInternetGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteInternetGateway"); err != nil {
+		return nil, err
+	}
+	igwID := aws.StringValue(in.InternetGatewayId)
+	igw := f.igws[igwID]
+	if igw == nil {
+		return nil, apiError("InvalidInternetGatewayID.NotFound", "The internetGateway ID '%s' does not exist", igwID)
+	}
+	if len(igw.Attachments) > 0 {
+		return nil, apiError("DependencyViolation", "The internetGateway '%s' has dependencies and cannot be deleted", igwID)
+	}
+	f.forget(igwID)
+	delete(f.igws, igwID)
+	return &ec2.DeleteInternetGatewayOutput{}, nil
+}
+
+func attachedTo(igw *ec2.InternetGateway, vpcID string) bool {
+	for _, a := range igw.Attachments {
+		if aws.StringValue(a.VpcId) == vpcID {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Route tables
+//
+
+func (f *EC2) DescribeRouteTables(in *ec2.DescribeRouteTablesInput) (*ec2.DescribeRouteTablesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeRouteTables"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeRouteTablesOutput{}
+	for _, ID := range f.order {
+		rt := f.routeTables[ID]
+		if rt == nil || !wanted(ID, in.RouteTableIds) {
+			continue
+		}
+		ok, err := f.match(ID, in.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "route-table-id":
+				return []string{ID}, true
+			case "vpc-id":
+				return []string{*rt.VpcId}, true
+			case "association.main":
+				if isMain(rt) {
+					return []string{"true"}, true
+				}
+				return []string{"false"}, true
+			case "association.subnet-id":
+				var subnets []string
+				for _, a := range rt.Associations {
+					if a.SubnetId != nil {
+						subnets = append(subnets, *a.SubnetId)
+					}
+				}
+				return subnets, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			c := clone(rt).(*ec2.RouteTable)
+			c.Tags = f.ec2Tags(ID)
+			out.RouteTables = append(out.RouteTables, c)
+		}
+	}
+	if err := notFound("InvalidRouteTableID.NotFound", in.RouteTableIds, len(out.RouteTables)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (f *EC2) CreateRoute(in *ec2.CreateRouteInput) (*ec2.CreateRouteOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateRoute"); err != nil {
+		return nil, err
+	}
+	rt := f.routeTables[aws.StringValue(in.RouteTableId)]
+	if rt == nil {
+		return nil, apiError("InvalidRouteTableID.NotFound", "The routeTable ID '%s' does not exist", aws.StringValue(in.RouteTableId))
+	}
+	if findRoute(rt, in.DestinationCidrBlock, in.DestinationIpv6CidrBlock, in.DestinationPrefixListId) >= 0 {
+		return nil, apiError("RouteAlreadyExists", "The route identified by %s already exists", destination(in.DestinationCidrBlock, in.DestinationIpv6CidrBlock, in.DestinationPrefixListId))
+	}
+	route := &ec2.Route{
+		DestinationCidrBlock:     in.DestinationCidrBlock,
+		DestinationIpv6CidrBlock: in.DestinationIpv6CidrBlock,
+		DestinationPrefixListId:  in.DestinationPrefixListId,
+		State:                    aws.String("active"),
+		Origin:                   aws.String("CreateRoute"),
+		GatewayId:                in.GatewayId,
+		NatGatewayId:             in.NatGatewayId,
+		VpcPeeringConnectionId:   in.VpcPeeringConnectionId,
+		TransitGatewayId:         in.TransitGatewayId,
+		NetworkInterfaceId:       in.NetworkInterfaceId,
+
+		EgressOnlyInternetGatewayId: in.EgressOnlyInternetGatewayId,
+	}
+	if err := f.checkRoute(rt, route); err != nil {
+		return nil, err
+	}
+	rt.Routes = append(rt.Routes, route)
+	return &ec2.CreateRouteOutput{Return: aws.Bool(true)}, nil
+}
+
+// ReplaceRoute points an existing route somewhere else.
+func (f *EC2) ReplaceRoute(in *ec2.ReplaceRouteInput) (*ec2.ReplaceRouteOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("ReplaceRoute"); err != nil {
+		return nil, err
+	}
+	rt := f.routeTables[aws.StringValue(in.RouteTableId)]
+	if rt == nil {
+		return nil, apiError("InvalidRouteTableID.NotFound", "The routeTable ID '%s' does not exist", aws.StringValue(in.RouteTableId))
+	}
+	i := findRoute(rt, in.DestinationCidrBlock, in.DestinationIpv6CidrBlock, in.DestinationPrefixListId)
+	if i < 0 {
+		return nil, apiError("InvalidRoute.NotFound", "no route with destination %s in %s", destination(in.DestinationCidrBlock, in.DestinationIpv6CidrBlock, in.DestinationPrefixListId), *rt.RouteTableId)
+	}
+	route := &ec2.Route{
+		DestinationCidrBlock:     in.DestinationCidrBlock,
+		DestinationIpv6CidrBlock: in.DestinationIpv6CidrBlock,
+		DestinationPrefixListId:  in.DestinationPrefixListId,
+		State:                    aws.String("active"),
+		Origin:                   aws.String("CreateRoute"),
+		GatewayId:                in.GatewayId,
+		NatGatewayId:             in.NatGatewayId,
+		VpcPeeringConnectionId:   in.VpcPeeringConnectionId,
+		TransitGatewayId:         in.TransitGatewayId,
+		NetworkInterfaceId:       in.NetworkInterfaceId,
+
+		EgressOnlyInternetGatewayId: in.EgressOnlyInternetGatewayId,
+	}
+	if err := f.checkRoute(rt, route); err != nil {
+		return nil, err
+	}
+	rt.Routes[i] = route
+	return &ec2.ReplaceRouteOutput{}, nil
+}
+
+func (f *EC2) DeleteRoute(in *ec2.DeleteRouteInput) (*ec2.DeleteRouteOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteRoute"); err != nil {
+		return nil, err
+	}
+	rt := f.routeTables[aws.StringValue(in.RouteTableId)]
+	if rt == nil {
+		return nil, apiError("InvalidRouteTableID.NotFound", "The routeTable ID '%s' does not exist", aws.StringValue(in.RouteTableId))
+	}
+	i := findRoute(rt, in.DestinationCidrBlock, in.DestinationIpv6CidrBlock, in.DestinationPrefixListId)
+	if i < 0 {
+		return nil, apiError("InvalidRoute.NotFound", "no route with destination %s in %s", destination(in.DestinationCidrBlock, in.DestinationIpv6CidrBlock, in.DestinationPrefixListId), *rt.RouteTableId)
+	}
+	if aws.StringValue(rt.Routes[i].GatewayId) == "local" {
+		return nil, apiError("InvalidParameterValue", "cannot remove local route %s in route table %s", *rt.Routes[i].DestinationCidrBlock, *rt.RouteTableId)
+	}
+	rt.Routes = append(rt.Routes[:i], rt.Routes[i+1:]...)
+	return &ec2.DeleteRouteOutput{}, nil
+}
+
+// checkRoute checks a new route has exactly one target and, for the kinds of
+// target the fake models, that it exists in the route table's VPC, or for a
+// transit gateway or virtual private gateway that the VPC is attached to it.
+// Peering connections, gateways it doesn't know and network interfaces are
+// taken on trust.
+func (f *EC2) checkRoute(rt *ec2.RouteTable, route *ec2.Route) error {
+	destinations := 0
+	for _, dest := range []*string{route.DestinationCidrBlock, route.DestinationIpv6CidrBlock, route.DestinationPrefixListId} {
+		if dest != nil {
+			destinations++
+		}
+	}
+	if destinations != 1 {
+		return apiError("InvalidParameterCombination", "exactly one of a destination CIDR block, IPv6 CIDR block or prefix list is required")
+	}
+	targets := 0
+	for _, ID := range []*string{route.GatewayId, route.NatGatewayId, route.VpcPeeringConnectionId, route.TransitGatewayId, route.NetworkInterfaceId, route.EgressOnlyInternetGatewayId} {
+		if ID != nil {
+			targets++
+		}
+	}
+	switch {
+	case targets == 0:
+		return apiError("MissingParameter", "A route target is required")
+	case targets > 1:
+		return apiError("InvalidParameterCombination", "only one route target may be given")
+	case route.GatewayId != nil && strings.HasPrefix(*route.GatewayId, "igw-"):
+		igw := f.igws[*route.GatewayId]
+		if igw == nil {
+			return apiError("InvalidGatewayID.NotFound", "The gateway ID '%s' does not exist", *route.GatewayId)
+		}
+		if !attachedTo(igw, *rt.VpcId) {
+			return apiError("InvalidParameterValue", "route table %s and network gateway %s belong to different networks", *rt.RouteTableId, *route.GatewayId)
+		}
+	case route.EgressOnlyInternetGatewayId != nil:
+		eigw := f.eigws[*route.EgressOnlyInternetGatewayId]
+		if eigw == nil {
+			return apiError("InvalidGatewayID.NotFound", "The gateway ID '%s' does not exist", *route.EgressOnlyInternetGatewayId)
+		}
+		if *eigw.Attachments[0].VpcId != *rt.VpcId {
+			return apiError("InvalidParameterValue", "route table %s and network gateway %s belong to different networks", *rt.RouteTableId, *route.EgressOnlyInternetGatewayId)
+		}
+		if route.DestinationIpv6CidrBlock == nil {
+			return apiError("InvalidParameterValue", "an egress-only internet gateway only routes IPv6 traffic")
+		}
+	case route.NatGatewayId != nil:
+		nat := f.natGateways[*route.NatGatewayId]
+		if nat == nil || !live(nat) {
+			return apiError("InvalidNatGatewayID.NotFound", "The nat gateway ID '%s' does not exist", *route.NatGatewayId)
+		}
+		if *nat.VpcId != *rt.VpcId {
+			return apiError("InvalidParameterValue", "route table %s and nat gateway %s belong to different networks", *rt.RouteTableId, *route.NatGatewayId)
+		}
+	case route.GatewayId != nil && f.vpnGateways[*route.GatewayId] != nil:
+		if vgwAttachedTo(f.vpnGateways[*route.GatewayId]) != *rt.VpcId {
+			return apiError("Gateway.NotAttached", "resource %s is not attached to network %s", *route.GatewayId, *rt.VpcId)
+		}
+	case route.TransitGatewayId != nil && f.transitGateways[*route.TransitGatewayId] != nil:
+		if !f.attachedToTGW(*rt.VpcId, *route.TransitGatewayId) {
+			return apiError("InvalidTransitGatewayID.NotFound", "The transitGateway ID '%s' does not exist or is not attached to %s", *route.TransitGatewayId, *rt.VpcId)
+		}
+	}
+	return nil
+}
+
+// findRoute returns the index of the route table's route to the CIDR block,
+// IPv6 CIDR block or prefix list, or -1.
+func findRoute(rt *ec2.RouteTable, cidr *string, ipv6Cidr *string, prefixList *string) int {
+	for i, r := range rt.Routes {
+		if cidr != nil && aws.StringValue(r.DestinationCidrBlock) == *cidr ||
+			ipv6Cidr != nil && aws.StringValue(r.DestinationIpv6CidrBlock) == *ipv6Cidr ||
+			prefixList != nil && aws.StringValue(r.DestinationPrefixListId) == *prefixList {
+			return i
+		}
+	}
+	return -1
+}
+
+func destination(cidr *string, ipv6Cidr *string, prefixList *string) string {
+	for _, dest := range []*string{cidr, ipv6Cidr} {
+		if dest != nil {
+			return *dest
+		}
+	}
+	return aws.StringValue(prefixList)
+}
+
+func (f *EC2) CreateRouteTable(in *ec2.CreateRouteTableInput) (*ec2.CreateRouteTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateRouteTable"); err != nil {
+		return nil, err
+	}
+	vpc := f.vpcs[aws.StringValue(in.VpcId)]
+	if vpc == nil {
+		return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", aws.StringValue(in.VpcId))
+	}
+	rt := &ec2.RouteTable{
+		RouteTableId: f.newID("rtb"),
+		VpcId:        vpc.VpcId,
+		Routes: []*ec2.Route{{
+			DestinationCidrBlock: vpc.CidrBlock,
+			GatewayId:            aws.String("local"),
+			State:                aws.String("active"),
+			Origin:               aws.String("CreateRouteTable"),
+		}},
+	}
+	f.routeTables[*rt.RouteTableId] = rt
+	return &ec2.CreateRouteTableOutput{RouteTable: clone(rt).(*ec2.RouteTable)}, nil
+}
+
+// AssociateRouteTable associates a subnet with a route table.  Like EC2 it
+// refuses a subnet that already has an explicit association.
+func (f *EC2) AssociateRouteTable(in *ec2.AssociateRouteTableInput) (*ec2.AssociateRouteTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("AssociateRouteTable"); err != nil {
+		return nil, err
+	}
+	rt := f.routeTables[aws.StringValue(in.RouteTableId)]
+	if rt == nil {
+		return nil, apiError("InvalidRouteTableID.NotFound", "The routeTable ID '%s' does not exist", aws.StringValue(in.RouteTableId))
+	}
+	subnet := f.subnets[aws.StringValue(in.SubnetId)]
+	if subnet == nil {
+		return nil, apiError("InvalidSubnetID.NotFound", "The subnet ID '%s' does not exist", aws.StringValue(in.SubnetId))
+	}
+	if *subnet.VpcId != *rt.VpcId {
+		return nil, apiError("InvalidParameterValue", "route table %s and subnet %s belong to different networks", *rt.RouteTableId, *subnet.SubnetId)
+	}
+	for _, other := range f.routeTables {
+		for _, assoc := range other.Associations {
+			if aws.StringValue(assoc.SubnetId) == *subnet.SubnetId {
+				return nil, apiError("Resource.AlreadyAssociated", "the specified association for route table %s conflicts with an existing association", *other.RouteTableId)
+			}
+		}
+	}
+	assoc := &ec2.RouteTableAssociation{
+		Main:                    aws.Bool(false),
+		RouteTableId:            rt.RouteTableId,
+		RouteTableAssociationId: f.newID("rtbassoc"),
+		SubnetId:                subnet.SubnetId,
+	}
+	rt.Associations = append(rt.Associations, assoc)
+	return &ec2.AssociateRouteTableOutput{AssociationId: assoc.RouteTableAssociationId}, nil
+}
+
+func (f *EC2) DisassociateRouteTable(in *ec2.DisassociateRouteTableInput) (*ec2.DisassociateRouteTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DisassociateRouteTable"); err != nil {
+		return nil, err
+	}
+	assocID := aws.StringValue(in.AssociationId)
+	for _, rt := range f.routeTables {
+		for i, assoc := range rt.Associations {
+			if *assoc.RouteTableAssociationId != assocID {
+				continue
+			}
+			if aws.BoolValue(assoc.Main) {
+				return nil, apiError("InvalidParameterValue", "cannot disassociate the main route table association %s", assocID)
+			}
+			rt.Associations = append(rt.Associations[:i], rt.Associations[i+1:]...)
+			return &ec2.DisassociateRouteTableOutput{}, nil
+		}
+	}
+	return nil, apiError("InvalidAssociationID.NotFound", "The association ID '%s' does not exist", assocID)
+}
+
+func (f *EC2) DeleteRouteTable(in *ec2.DeleteRouteTableInput) (*ec2.DeleteRouteTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteRouteTable"); err != nil {
+		return nil, err
+	}
+	rtID := aws.StringValue(in.RouteTableId)
+	rt := f.routeTables[rtID]
+	if rt == nil {
+		return nil, apiError("InvalidRouteTableID.NotFound", "The routeTable ID '%s' does not exist", rtID)
+	}
+	if len(rt.Associations) > 0 {
+		return nil, apiError("DependencyViolation", "The routeTable '%s' has dependencies and cannot be deleted", rtID)
+	}
+	f.forget(rtID)
+	delete(f.routeTables, rtID)
+	return &ec2.DeleteRouteTableOutput{}, nil
+}
+
+func isMain(rt *ec2.RouteTable) bool {
+	for _, a := range rt.Associations {
+		if aws.BoolValue(a.Main) {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Helpers
+//
+
+// forget drops the tags of a deleted resource.
+func (f *EC2) forget(ID string) {
+	delete(f.tags, ID)
+}
+
+// wanted reports whether ID is in IDs, or IDs is empty.
+func wanted(ID string, IDs []*string) bool {
+	if len(IDs) == 0 {
+		return true
+	}
+	for _, want := range IDs {
+		if aws.StringValue(want) == ID {
+			return true
+		}
+	}
+	return false
+}
+
+// notFound returns a code error when explicit IDs were asked for but fewer
+// resources were found.
+func notFound(code string, IDs []*string, found int) error {
+	if len(IDs) > found {
+		return apiError(code, "One or more of %v do not exist", aws.StringValueSlice(IDs))
+	}
+	return nil
+}
+
+func contains(outer *net.IPNet, inner *net.IPNet) bool {
+	outerOnes, _ := outer.Mask.Size()
+	innerOnes, _ := inner.Mask.Size()
+	return outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+func overlaps(a *net.IPNet, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
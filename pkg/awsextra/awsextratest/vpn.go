@@ -0,0 +1,538 @@
+package awsextratest
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+//
+// Virtual private gateways
+//
+
+// CreateVpnGateway creates a "pending" virtual private gateway, available
+// once it is next described.
+func (f *EC2) CreateVpnGateway(in *ec2.CreateVpnGatewayInput) (*ec2.CreateVpnGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateVpnGateway"); err != nil {
+		return nil, err
+	}
+	if aws.StringValue(in.Type) != "ipsec.1" {
+		return nil, apiError("InvalidParameterValue", "Invalid value '%s' for type", aws.StringValue(in.Type))
+	}
+	asn := aws.Int64Value(in.AmazonSideAsn)
+	if asn == 0 {
+		asn = 64512
+	}
+	vgw := &ec2.VpnGateway{
+		VpnGatewayId:  f.newID("vgw"),
+		Type:          in.Type,
+		AmazonSideAsn: aws.Int64(asn),
+		State:         aws.String("pending"),
+	}
+	f.vpnGateways[*vgw.VpnGatewayId] = vgw
+	return &ec2.CreateVpnGatewayOutput{VpnGateway: clone(vgw).(*ec2.VpnGateway)}, nil
+}
+
+func (f *EC2) liveVPNGateway(ID string) (*ec2.VpnGateway, error) {
+	vgw := f.vpnGateways[ID]
+	if vgw == nil || *vgw.State == "deleted" {
+		return nil, apiError("InvalidVpnGatewayID.NotFound", "The vpnGateway ID '%s' does not exist", ID)
+	}
+	return vgw, nil
+}
+
+// vgwAttachedTo returns the VPC the gateway is attached, attaching or
+// detaching to, or "".
+func vgwAttachedTo(vgw *ec2.VpnGateway) string {
+	for _, a := range vgw.VpcAttachments {
+		if *a.State != "detached" {
+			return *a.VpcId
+		}
+	}
+	return ""
+}
+
+// DescribeVpnGateways describes deleted gateways, and detached attachments,
+// too, as EC2 does for a while.
+func (f *EC2) DescribeVpnGateways(in *ec2.DescribeVpnGatewaysInput) (*ec2.DescribeVpnGatewaysOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeVpnGateways"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeVpnGatewaysOutput{}
+	for _, ID := range f.order {
+		vgw := f.vpnGateways[ID]
+		if vgw == nil || !wanted(ID, in.VpnGatewayIds) {
+			continue
+		}
+		switch *vgw.State {
+		case "pending":
+			vgw.State = aws.String("available")
+		case "deleting":
+			vgw.State = aws.String("deleted")
+		}
+		for _, a := range vgw.VpcAttachments {
+			switch *a.State {
+			case "attaching":
+				a.State = aws.String("attached")
+			case "detaching":
+				a.State = aws.String("detached")
+			}
+		}
+		ok, err := f.match(ID, in.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "vpn-gateway-id":
+				return []string{ID}, true
+			case "state":
+				return []string{*vgw.State}, true
+			case "type":
+				return []string{*vgw.Type}, true
+			case "amazon-side-asn":
+				return []string{strconv.FormatInt(*vgw.AmazonSideAsn, 10)}, true
+			case "attachment.vpc-id", "attachment.state":
+				var values []string
+				for _, a := range vgw.VpcAttachments {
+					if name == "attachment.vpc-id" {
+						values = append(values, *a.VpcId)
+					} else {
+						values = append(values, *a.State)
+					}
+				}
+				return values, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		c := clone(vgw).(*ec2.VpnGateway)
+		c.Tags = f.ec2Tags(ID)
+		out.VpnGateways = append(out.VpnGateways, c)
+	}
+	if err := notFound("InvalidVpnGatewayID.NotFound", in.VpnGatewayIds, len(out.VpnGateways)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AttachVpnGateway attaches an available gateway to a VPC that has none.  It
+// is "attaching" until the gateway is next described.
+func (f *EC2) AttachVpnGateway(in *ec2.AttachVpnGatewayInput) (*ec2.AttachVpnGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("AttachVpnGateway"); err != nil {
+		return nil, err
+	}
+	vgw, err := f.liveVPNGateway(aws.StringValue(in.VpnGatewayId))
+	if err != nil {
+		return nil, err
+	}
+	vpcID := aws.StringValue(in.VpcId)
+	if f.vpcs[vpcID] == nil {
+		return nil, apiError("InvalidVpcID.NotFound", "The vpc ID '%s' does not exist", vpcID)
+	}
+	if *vgw.State != "available" {
+		return nil, apiError("IncorrectState", "The vpnGateway '%s' is in invalid state %s", *vgw.VpnGatewayId, *vgw.State)
+	}
+	if attached := vgwAttachedTo(vgw); attached != "" {
+		return nil, apiError("VpnGatewayAttachmentLimitExceeded", "The vpnGateway '%s' is already attached to %s", *vgw.VpnGatewayId, attached)
+	}
+	if other := f.vgwOf(vpcID); other != "" {
+		return nil, apiError("VpnGatewayAttachmentLimitExceeded", "The vpc '%s' already has vpnGateway %s attached", vpcID, other)
+	}
+	attachment := &ec2.VpcAttachment{VpcId: aws.String(vpcID), State: aws.String("attaching")}
+	vgw.VpcAttachments = []*ec2.VpcAttachment{attachment}
+	return &ec2.AttachVpnGatewayOutput{VpcAttachment: clone(attachment).(*ec2.VpcAttachment)}, nil
+}
+
+// vgwOf returns the virtual private gateway attached to vpcID, or "".
+func (f *EC2) vgwOf(vpcID string) string {
+	for ID, vgw := range f.vpnGateways {
+		if vgwAttachedTo(vgw) == vpcID {
+			return ID
+		}
+	}
+	return ""
+}
+
+// DetachVpnGateway leaves the attachment "detaching" until the gateway is
+// next described.  The gateway's routes stop propagating to the VPC's route
+// tables.
+func (f *EC2) DetachVpnGateway(in *ec2.DetachVpnGatewayInput) (*ec2.DetachVpnGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DetachVpnGateway"); err != nil {
+		return nil, err
+	}
+	vgw, err := f.liveVPNGateway(aws.StringValue(in.VpnGatewayId))
+	if err != nil {
+		return nil, err
+	}
+	vpcID := aws.StringValue(in.VpcId)
+	var attachment *ec2.VpcAttachment
+	for _, a := range vgw.VpcAttachments {
+		if *a.VpcId == vpcID && (*a.State == "attaching" || *a.State == "attached") {
+			attachment = a
+		}
+	}
+	if attachment == nil {
+		return nil, apiError("InvalidVpnGatewayAttachment.NotFound", "The attachment with vpn gateway ID '%s' and vpc ID '%s' does not exist", *vgw.VpnGatewayId, vpcID)
+	}
+	attachment.State = aws.String("detaching")
+	for _, rt := range f.routeTables {
+		if *rt.VpcId != vpcID {
+			continue
+		}
+		var keep []*ec2.PropagatingVgw
+		for _, p := range rt.PropagatingVgws {
+			if *p.GatewayId != *vgw.VpnGatewayId {
+				keep = append(keep, p)
+			}
+		}
+		rt.PropagatingVgws = keep
+	}
+	return &ec2.DetachVpnGatewayOutput{}, nil
+}
+
+// DeleteVpnGateway fails while the gateway is attached or has VPN
+// connections that aren't deleted.
+func (f *EC2) DeleteVpnGateway(in *ec2.DeleteVpnGatewayInput) (*ec2.DeleteVpnGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteVpnGateway"); err != nil {
+		return nil, err
+	}
+	vgw, err := f.liveVPNGateway(aws.StringValue(in.VpnGatewayId))
+	if err != nil {
+		return nil, err
+	}
+	if attached := vgwAttachedTo(vgw); attached != "" {
+		return nil, apiError("IncorrectState", "The vpnGateway '%s' is attached to %s", *vgw.VpnGatewayId, attached)
+	}
+	if connID := f.vpnConnectionUsing(*vgw.VpnGatewayId); connID != "" {
+		return nil, apiError("IncorrectState", "The vpnGateway '%s' has vpn connection %s", *vgw.VpnGatewayId, connID)
+	}
+	vgw.State = aws.String("deleting")
+	return &ec2.DeleteVpnGatewayOutput{}, nil
+}
+
+// EnableVgwRoutePropagation propagates the routes of a gateway attached to
+// the route table's VPC.
+func (f *EC2) EnableVgwRoutePropagation(in *ec2.EnableVgwRoutePropagationInput) (*ec2.EnableVgwRoutePropagationOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("EnableVgwRoutePropagation"); err != nil {
+		return nil, err
+	}
+	rt := f.routeTables[aws.StringValue(in.RouteTableId)]
+	if rt == nil {
+		return nil, apiError("InvalidRouteTableID.NotFound", "The routeTable ID '%s' does not exist", aws.StringValue(in.RouteTableId))
+	}
+	vgw, err := f.liveVPNGateway(aws.StringValue(in.GatewayId))
+	if err != nil {
+		return nil, err
+	}
+	if vgwAttachedTo(vgw) != *rt.VpcId {
+		return nil, apiError("Gateway.NotAttached", "resource %s is not attached to network %s", *vgw.VpnGatewayId, *rt.VpcId)
+	}
+	for _, p := range rt.PropagatingVgws {
+		if *p.GatewayId == *vgw.VpnGatewayId {
+			return &ec2.EnableVgwRoutePropagationOutput{}, nil
+		}
+	}
+	rt.PropagatingVgws = append(rt.PropagatingVgws, &ec2.PropagatingVgw{GatewayId: aws.String(*vgw.VpnGatewayId)})
+	return &ec2.EnableVgwRoutePropagationOutput{}, nil
+}
+
+//
+// Customer gateways
+//
+
+// CreateCustomerGateway creates a "pending" customer gateway, available once
+// it is next described.
+func (f *EC2) CreateCustomerGateway(in *ec2.CreateCustomerGatewayInput) (*ec2.CreateCustomerGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateCustomerGateway"); err != nil {
+		return nil, err
+	}
+	if aws.StringValue(in.Type) != "ipsec.1" {
+		return nil, apiError("InvalidParameterValue", "Invalid value '%s' for type", aws.StringValue(in.Type))
+	}
+	if in.BgpAsn == nil {
+		return nil, apiError("MissingParameter", "The request must contain the parameter BgpAsn")
+	}
+	cgw := &ec2.CustomerGateway{
+		CustomerGatewayId: f.newID("cgw"),
+		Type:              in.Type,
+		BgpAsn:            aws.String(strconv.FormatInt(*in.BgpAsn, 10)),
+		IpAddress:         in.PublicIp,
+		State:             aws.String("pending"),
+	}
+	f.customerGateways[*cgw.CustomerGatewayId] = cgw
+	return &ec2.CreateCustomerGatewayOutput{CustomerGateway: clone(cgw).(*ec2.CustomerGateway)}, nil
+}
+
+func (f *EC2) liveCustomerGateway(ID string) (*ec2.CustomerGateway, error) {
+	cgw := f.customerGateways[ID]
+	if cgw == nil || *cgw.State == "deleted" {
+		return nil, apiError("InvalidCustomerGatewayID.NotFound", "The customerGateway ID '%s' does not exist", ID)
+	}
+	return cgw, nil
+}
+
+// DescribeCustomerGateways describes deleted customer gateways too, as EC2
+// does for a while.
+func (f *EC2) DescribeCustomerGateways(in *ec2.DescribeCustomerGatewaysInput) (*ec2.DescribeCustomerGatewaysOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeCustomerGateways"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeCustomerGatewaysOutput{}
+	for _, ID := range f.order {
+		cgw := f.customerGateways[ID]
+		if cgw == nil || !wanted(ID, in.CustomerGatewayIds) {
+			continue
+		}
+		switch *cgw.State {
+		case "pending":
+			cgw.State = aws.String("available")
+		case "deleting":
+			cgw.State = aws.String("deleted")
+		}
+		ok, err := f.match(ID, in.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "customer-gateway-id":
+				return []string{ID}, true
+			case "state":
+				return []string{*cgw.State}, true
+			case "type":
+				return []string{*cgw.Type}, true
+			case "bgp-asn":
+				return []string{*cgw.BgpAsn}, true
+			case "ip-address":
+				return []string{aws.StringValue(cgw.IpAddress)}, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		c := clone(cgw).(*ec2.CustomerGateway)
+		c.Tags = f.ec2Tags(ID)
+		out.CustomerGateways = append(out.CustomerGateways, c)
+	}
+	if err := notFound("InvalidCustomerGatewayID.NotFound", in.CustomerGatewayIds, len(out.CustomerGateways)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DeleteCustomerGateway fails while VPN connections that aren't deleted use
+// the customer gateway.
+func (f *EC2) DeleteCustomerGateway(in *ec2.DeleteCustomerGatewayInput) (*ec2.DeleteCustomerGatewayOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteCustomerGateway"); err != nil {
+		return nil, err
+	}
+	cgw, err := f.liveCustomerGateway(aws.StringValue(in.CustomerGatewayId))
+	if err != nil {
+		return nil, err
+	}
+	if connID := f.vpnConnectionUsing(*cgw.CustomerGatewayId); connID != "" {
+		return nil, apiError("IncorrectState", "The customerGateway '%s' is used by vpn connection %s", *cgw.CustomerGatewayId, connID)
+	}
+	cgw.State = aws.String("deleting")
+	return &ec2.DeleteCustomerGatewayOutput{}, nil
+}
+
+//
+// VPN connections
+//
+
+// CreateVpnConnection connects a virtual private gateway and a customer
+// gateway.  The connection is "pending" until it is next described, and comes
+// with the customer gateway's tunnel configuration.
+func (f *EC2) CreateVpnConnection(in *ec2.CreateVpnConnectionInput) (*ec2.CreateVpnConnectionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateVpnConnection"); err != nil {
+		return nil, err
+	}
+	if aws.StringValue(in.Type) != "ipsec.1" {
+		return nil, apiError("InvalidParameterValue", "Invalid value '%s' for type", aws.StringValue(in.Type))
+	}
+	vgw, err := f.liveVPNGateway(aws.StringValue(in.VpnGatewayId))
+	if err != nil {
+		return nil, err
+	}
+	cgw, err := f.liveCustomerGateway(aws.StringValue(in.CustomerGatewayId))
+	if err != nil {
+		return nil, err
+	}
+	staticRoutesOnly := in.Options != nil && aws.BoolValue(in.Options.StaticRoutesOnly)
+	conn := &ec2.VpnConnection{
+		VpnConnectionId:   f.newID("vpn"),
+		Type:              in.Type,
+		VpnGatewayId:      vgw.VpnGatewayId,
+		CustomerGatewayId: cgw.CustomerGatewayId,
+		Category:          aws.String("VPN"),
+		State:             aws.String("pending"),
+		Options:           &ec2.VpnConnectionOptions{StaticRoutesOnly: aws.Bool(staticRoutesOnly)},
+	}
+	var tunnels string
+	for i := 1; i <= 2; i++ {
+		outside := fmt.Sprintf("198.51.100.%d", f.nextID%100*2+i)
+		conn.VgwTelemetry = append(conn.VgwTelemetry, &ec2.VgwTelemetry{OutsideIpAddress: aws.String(outside), Status: aws.String("DOWN")})
+		tunnels += fmt.Sprintf("<ipsec_tunnel><customer_gateway><tunnel_outside_address><ip_address>%s</ip_address></tunnel_outside_address></customer_gateway>"+
+			"<vpn_gateway><tunnel_outside_address><ip_address>%s</ip_address></tunnel_outside_address></vpn_gateway>"+
+			"<ike><pre_shared_key>fake-key-%s-%d</pre_shared_key></ike></ipsec_tunnel>", aws.StringValue(cgw.IpAddress), outside, *conn.VpnConnectionId, i)
+	}
+	conn.CustomerGatewayConfiguration = aws.String(`<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<vpn_connection id="` + *conn.VpnConnectionId + `"><customer_gateway_id>` + *cgw.CustomerGatewayId + `</customer_gateway_id>` +
+		`<vpn_gateway_id>` + *vgw.VpnGatewayId + `</vpn_gateway_id><vpn_connection_type>ipsec.1</vpn_connection_type>` + tunnels + `</vpn_connection>`)
+	f.vpnConnections[*conn.VpnConnectionId] = conn
+	return &ec2.CreateVpnConnectionOutput{VpnConnection: clone(conn).(*ec2.VpnConnection)}, nil
+}
+
+func (f *EC2) liveVPNConnection(ID string) (*ec2.VpnConnection, error) {
+	conn := f.vpnConnections[ID]
+	if conn == nil || *conn.State == "deleted" {
+		return nil, apiError("InvalidVpnConnectionID.NotFound", "The vpnConnection ID '%s' does not exist", ID)
+	}
+	return conn, nil
+}
+
+// vpnConnectionUsing returns a VPN connection that isn't deleted using the
+// gateway, or "".
+func (f *EC2) vpnConnectionUsing(gatewayID string) string {
+	for ID, conn := range f.vpnConnections {
+		if *conn.State != "deleted" && (*conn.VpnGatewayId == gatewayID || *conn.CustomerGatewayId == gatewayID) {
+			return ID
+		}
+	}
+	return ""
+}
+
+// DescribeVpnConnections describes deleted connections too, as EC2 does for a
+// while.
+func (f *EC2) DescribeVpnConnections(in *ec2.DescribeVpnConnectionsInput) (*ec2.DescribeVpnConnectionsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DescribeVpnConnections"); err != nil {
+		return nil, err
+	}
+	out := &ec2.DescribeVpnConnectionsOutput{}
+	for _, ID := range f.order {
+		conn := f.vpnConnections[ID]
+		if conn == nil || !wanted(ID, in.VpnConnectionIds) {
+			continue
+		}
+		switch *conn.State {
+		case "pending":
+			conn.State = aws.String("available")
+		case "deleting":
+			conn.State = aws.String("deleted")
+		}
+		for _, r := range conn.Routes {
+			if *r.State == "pending" {
+				r.State = aws.String("available")
+			}
+		}
+		ok, err := f.match(ID, in.Filters, func(name string) ([]string, bool) {
+			switch name {
+			case "vpn-connection-id":
+				return []string{ID}, true
+			case "state":
+				return []string{*conn.State}, true
+			case "type":
+				return []string{*conn.Type}, true
+			case "vpn-gateway-id":
+				return []string{*conn.VpnGatewayId}, true
+			case "customer-gateway-id":
+				return []string{*conn.CustomerGatewayId}, true
+			case "option.static-routes-only":
+				return []string{strconv.FormatBool(*conn.Options.StaticRoutesOnly)}, true
+			case "route.destination-cidr-block":
+				var cidrs []string
+				for _, r := range conn.Routes {
+					cidrs = append(cidrs, *r.DestinationCidrBlock)
+				}
+				return cidrs, true
+			}
+			return nil, false
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		c := clone(conn).(*ec2.VpnConnection)
+		c.Tags = f.ec2Tags(ID)
+		out.VpnConnections = append(out.VpnConnections, c)
+	}
+	if err := notFound("InvalidVpnConnectionID.NotFound", in.VpnConnectionIds, len(out.VpnConnections)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateVpnConnectionRoute adds a static route, "pending" until the
+// connection is next described, to a connection using static routes.
+func (f *EC2) CreateVpnConnectionRoute(in *ec2.CreateVpnConnectionRouteInput) (*ec2.CreateVpnConnectionRouteOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("CreateVpnConnectionRoute"); err != nil {
+		return nil, err
+	}
+	conn, err := f.liveVPNConnection(aws.StringValue(in.VpnConnectionId))
+	if err != nil {
+		return nil, err
+	}
+	if !*conn.Options.StaticRoutesOnly {
+		return nil, apiError("InvalidVpnConnection.InvalidType", "The vpnConnection '%s' does not use static routes", *conn.VpnConnectionId)
+	}
+	cidr := aws.StringValue(in.DestinationCidrBlock)
+	for _, r := range conn.Routes {
+		if *r.DestinationCidrBlock == cidr {
+			return nil, apiError("RouteAlreadyExists", "The route identified by %s already exists", cidr)
+		}
+	}
+	conn.Routes = append(conn.Routes, &ec2.VpnStaticRoute{
+		DestinationCidrBlock: aws.String(cidr),
+		Source:               aws.String("Static"),
+		State:                aws.String("pending"),
+	})
+	return &ec2.CreateVpnConnectionRouteOutput{}, nil
+}
+
+// DeleteVpnConnection starts deleting a connection, which is "deleting", and
+// keeps its gateways in use, until it is next described.
+func (f *EC2) DeleteVpnConnection(in *ec2.DeleteVpnConnectionInput) (*ec2.DeleteVpnConnectionOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("DeleteVpnConnection"); err != nil {
+		return nil, err
+	}
+	conn, err := f.liveVPNConnection(aws.StringValue(in.VpnConnectionId))
+	if err != nil {
+		return nil, err
+	}
+	conn.State = aws.String("deleting")
+	conn.Routes = nil
+	return &ec2.DeleteVpnConnectionOutput{}, nil
+}
@@ -0,0 +1,165 @@
+package awsextra
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"net"
+)
+
+// AWS keeps the first four addresses and the last address of every subnet.
+const reservedSubnetAddresses = 5
+
+// PrefixLengthForHosts returns the longest IPv4 prefix length whose subnets
+// have room for hosts addresses besides the ones AWS reserves.
+func PrefixLengthForHosts(hosts int) int {
+	if hosts < 1 {
+		hosts = 1
+	}
+	return 32 - bits.Len32(uint32(hosts+reservedSubnetAddresses-1))
+}
+
+// CarveSubnets lays out zones subnets in each of tiers tiers, eg. public and
+// private, as consecutive blocks of prefixLength out of vpcCIDR, skipping
+// blocks overlapping any of taken.  The layout depends only on its
+// arguments, so every run carves the same CIDRs.  The result is indexed by
+// tier, then zone.
+func CarveSubnets(vpcCIDR string, zones int, tiers int, prefixLength int, taken []string) ([][]string, error) {
+	_, vpc, err := net.ParseCIDR(vpcCIDR)
+	if err != nil || vpc.IP.To4() == nil {
+		return nil, fmt.Errorf("%q is not an IPv4 CIDR block", vpcCIDR)
+	}
+	vpcLength, _ := vpc.Mask.Size()
+	if prefixLength < vpcLength || prefixLength > 28 {
+		return nil, fmt.Errorf("subnet prefix length /%d must be between the VPC's /%d and /28", prefixLength, vpcLength)
+	}
+	var takenNets []*net.IPNet
+	for _, cidr := range taken {
+		if _, n, err := net.ParseCIDR(cidr); err == nil {
+			takenNets = append(takenNets, n)
+		}
+	}
+
+	layout := make([][]string, tiers)
+	start := binary.BigEndian.Uint32(vpc.IP.To4())
+	size := uint64(1) << (32 - prefixLength)
+	blocks := uint64(1) << (prefixLength - vpcLength)
+	next := uint64(0)
+	for tier := range layout {
+		for zone := 0; zone < zones; zone++ {
+			for {
+				if next == blocks {
+					return nil, fmt.Errorf("%s has no room for %d more /%d subnets", vpcCIDR, (tiers-tier)*zones-zone, prefixLength)
+				}
+				ip := make(net.IP, 4)
+				binary.BigEndian.PutUint32(ip, start+uint32(next*size))
+				block := &net.IPNet{IP: ip, Mask: net.CIDRMask(prefixLength, 32)}
+				next++
+				if !overlapsAny(block, takenNets) {
+					layout[tier] = append(layout[tier], block.String())
+					break
+				}
+			}
+		}
+	}
+	return layout, nil
+}
+
+// CarveSubnets fills in the empty entries of SubnetCIDRs and
+// PrivateSubnetCIDRs, laying them out with the package CarveSubnets around
+// the entries that are set.  It does nothing unless SubnetPrefixLength or
+// SubnetHosts says how big the subnets are.
+func (cfg *Config) CarveSubnets() error {
+	prefixLength := cfg.SubnetPrefixLength
+	if prefixLength == 0 && cfg.SubnetHosts > 0 {
+		prefixLength = PrefixLengthForHosts(cfg.SubnetHosts)
+	}
+	if prefixLength == 0 {
+		return nil
+	}
+
+	// Public subnets come first, so adding private ones later moves nothing.
+	tiers := []*[]string{&cfg.SubnetCIDRs}
+	if len(cfg.PrivateSubnetCIDRs) > 0 {
+		tiers = append(tiers, &cfg.PrivateSubnetCIDRs)
+	}
+	var taken []string
+	zones := 0
+	for _, cidrs := range tiers {
+		if len(*cidrs) > zones {
+			zones = len(*cidrs)
+		}
+		for _, cidr := range *cidrs {
+			if cidr != "" {
+				taken = append(taken, cidr)
+			}
+		}
+	}
+	layout, err := CarveSubnets(cfg.VPCCIDRBlock, zones, len(tiers), prefixLength, taken)
+	if err != nil {
+		return fmt.Errorf("carving subnets: %v", err)
+	}
+	if cfg.carved == nil {
+		cfg.carved = map[string]bool{}
+	}
+	for tier, cidrs := range tiers {
+		next := 0
+		for i := range *cidrs {
+			if (*cidrs)[i] == "" {
+				(*cidrs)[i] = layout[tier][next]
+				cfg.carved[(*cidrs)[i]] = true
+				next++
+			}
+		}
+	}
+	return nil
+}
+
+// Check every subnet is inside the VPC and overlaps no other subnet.
+func (cfg *Config) validateSubnetLayout() error {
+	_, vpc, _ := net.ParseCIDR(cfg.VPCCIDRBlock)
+	type subnet struct {
+		name string
+		net  *net.IPNet
+	}
+	var subnets []subnet
+	for _, tier := range []struct {
+		key   string
+		cidrs []string
+	}{{"subnet", cfg.SubnetCIDRs}, {"private-subnet", cfg.PrivateSubnetCIDRs}} {
+		for i, cidr := range tier.cidrs {
+			_, n, _ := net.ParseCIDR(cidr)
+			name := fmt.Sprintf("%s-%d-cidr %s", tier.key, i, cidr)
+			if !contained(n, vpc) {
+				return fmt.Errorf("%s is outside vpc-cidr-block %s", name, cfg.VPCCIDRBlock)
+			}
+			for _, other := range subnets {
+				if overlaps(n, other.net) {
+					return fmt.Errorf("%s overlaps %s", name, other.name)
+				}
+			}
+			subnets = append(subnets, subnet{name, n})
+		}
+	}
+	return nil
+}
+
+func overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+func overlapsAny(n *net.IPNet, others []*net.IPNet) bool {
+	for _, other := range others {
+		if overlaps(n, other) {
+			return true
+		}
+	}
+	return false
+}
+
+// Is inner entirely inside outer?
+func contained(inner, outer *net.IPNet) bool {
+	innerLength, innerBits := inner.Mask.Size()
+	outerLength, outerBits := outer.Mask.Size()
+	return innerBits == outerBits && innerLength >= outerLength && outer.Contains(inner.IP)
+}
@@ -0,0 +1,101 @@
+package awsextra
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPrefixLengthForHosts(t *testing.T) {
+	tests := []struct{ hosts, want int }{
+		{1, 29},
+		{3, 29},
+		{11, 28},
+		{250, 24},
+		{251, 24},
+		{252, 23},
+		{4000, 20},
+	}
+	for _, tt := range tests {
+		if got := PrefixLengthForHosts(tt.hosts); got != tt.want {
+			t.Errorf("PrefixLengthForHosts(%d) = %d, want %d", tt.hosts, got, tt.want)
+		}
+	}
+}
+
+func TestCarveSubnets(t *testing.T) {
+	tests := []struct {
+		name         string
+		vpc          string
+		zones, tiers int
+		prefixLength int
+		taken        []string
+		want         [][]string
+		wantErr      bool
+	}{
+		{
+			name: "public and private", vpc: "172.25.0.0/16", zones: 3, tiers: 2, prefixLength: 24,
+			want: [][]string{
+				{"172.25.0.0/24", "172.25.1.0/24", "172.25.2.0/24"},
+				{"172.25.3.0/24", "172.25.4.0/24", "172.25.5.0/24"},
+			},
+		},
+		{
+			name: "around taken", vpc: "10.0.0.0/16", zones: 2, tiers: 1, prefixLength: 20,
+			taken: []string{"10.0.0.0/24", "10.0.32.0/19"},
+			want:  [][]string{{"10.0.16.0/20", "10.0.64.0/20"}},
+		},
+		{
+			name: "full", vpc: "10.0.0.0/24", zones: 3, tiers: 2, prefixLength: 26,
+			wantErr: true,
+		},
+		{
+			name: "prefix shorter than vpc", vpc: "10.0.0.0/24", zones: 1, tiers: 1, prefixLength: 16,
+			wantErr: true,
+		},
+		{
+			name: "ipv6", vpc: "2001:db8::/56", zones: 1, tiers: 1, prefixLength: 64,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CarveSubnets(tt.vpc, tt.zones, tt.tiers, tt.prefixLength, tt.taken)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CarveSubnets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CarveSubnets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigCarveSubnets(t *testing.T) {
+	cfg := &Config{
+		VPCCIDRBlock:       "172.25.0.0/16",
+		SubnetCIDRs:        []string{"", "172.25.0.0/24", ""},
+		PrivateSubnetCIDRs: []string{"", "", ""},
+		SubnetHosts:        1000,
+	}
+	if err := cfg.CarveSubnets(); err != nil {
+		t.Fatal(err)
+	}
+	wantPublic := []string{"172.25.4.0/22", "172.25.0.0/24", "172.25.8.0/22"}
+	// Each tier has a block per zone, so the private tier starts after the
+	// third public block even though only two were needed.
+	wantPrivate := []string{"172.25.16.0/22", "172.25.20.0/22", "172.25.24.0/22"}
+	if !reflect.DeepEqual(cfg.SubnetCIDRs, wantPublic) || !reflect.DeepEqual(cfg.PrivateSubnetCIDRs, wantPrivate) {
+		t.Errorf("carved %v and %v, want %v and %v", cfg.SubnetCIDRs, cfg.PrivateSubnetCIDRs, wantPublic, wantPrivate)
+	}
+	if cfg.carved["172.25.0.0/24"] || !cfg.carved["172.25.4.0/22"] {
+		t.Errorf("carved = %v, want only the filled in entries", cfg.carved)
+	}
+
+	// Carving again, as every run does, changes nothing.
+	if err := cfg.CarveSubnets(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.SubnetCIDRs, wantPublic) {
+		t.Errorf("second carve gave %v", cfg.SubnetCIDRs)
+	}
+}
@@ -0,0 +1,259 @@
+package awsextra
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Config describes one stack.  Every resource awsextra creates is tagged
+// TagKey=TagValue and later found again by that tag, so several stacks can be
+// managed from one process as long as each has its own Config.
+type Config struct {
+	// Aws Region, eg. "us-west-2"
+	Region string
+
+	// VPC address range, eg. "172.25.0.0/16"
+	VPCCIDRBlock string
+
+	// One public subnet is created per entry, in turn across the zones.
+	SubnetCIDRs []string
+
+	// One private subnet is created per entry, across the zones the same way
+	// as the public subnets.  Their traffic to the internet goes through NAT
+	// gateways in the public subnets, placed as NATGateways says.
+	PrivateSubnetCIDRs []string
+	NATGateways        string
+
+	// The availability zones subnets are spread over, by name, eg.
+	// "us-west-2a", or by zone ID, eg. "usw2-az1", which names the same zone
+	// in every account.  If empty, the first NumZones of the region's
+	// available zones are used or, if NumZones is 0 too, all of them.
+	Zones    []string
+	NumZones int
+
+	// How big CarveSubnets makes the subnets whose CIDR is left empty: a
+	// prefix length, eg. 24, or failing that the number of hosts each must
+	// have room for.
+	SubnetPrefixLength int
+	SubnetHosts        int
+
+	// Make the VPC dual-stack: it gets an Amazon provided IPv6 /56 and each
+	// subnet a /64 of it, instances get IPv6 addresses on launch, public
+	// subnets route IPv6 to the internet gateway and private subnets to an
+	// egress-only internet gateway.
+	IPv6 bool
+
+	// Static routes added to the stack's route tables, on top of the route
+	// to the internet gateway or NAT gateway each has anyway.
+	Routes []Route
+
+	// Network ACL rules for the public and private subnets, in order.  A
+	// tier with rules gets a network ACL of its own, the others keep the
+	// VPC's default one.
+	NetworkACLRules []NetworkACLRule
+
+	// The stack's security groups, created in the VPC by
+	// CreateSecurityGroups.  If empty, it creates the default group with
+	// internal TCP and SSH from anywhere.
+	SecurityGroups []SecurityGroup
+
+	// Only report the rules found on the stack's security groups that aren't
+	// in the config, instead of revoking them.
+	NoPrune bool
+
+	// VPC endpoints for AWS services, eg. S3 or ECR, so instances reach them
+	// without going through the internet or NAT gateways.
+	Endpoints []Endpoint
+
+	// The VPC's flow log, or nil for none.
+	FlowLog *FlowLog
+
+	// The transit gateway the VPC is attached to, or nil for none.
+	TransitGateway *TransitGateway
+
+	// The site-to-site VPN connecting the VPC to a remote network, or nil for
+	// none.
+	VPN *VPN
+
+	// Tag lookup using Tag=TagKey=TagValue
+	TagKey   string
+	TagValue string
+
+	// VPC DNS attributes.
+	EnableDNSSupport   bool
+	EnableDNSHostnames bool
+
+	// DHCP options.  DomainName defaults to the region's internal domain and
+	// DomainNameServers to AmazonProvidedDNS.
+	DomainName        string
+	DomainNameServers []string
+
+	// How calls that fail with a transient error are retried.  The zero
+	// value retries as DefaultRetryPolicy does.
+	Retry RetryPolicy
+
+	// The subnet CIDRs CarveSubnets filled in.
+	carved map[string]bool
+}
+
+// Values of Config.NATGateways.
+const (
+	// One NAT gateway, in the first public subnet, shared by every private
+	// subnet.  This is the default.
+	NATGatewaysSingle = "single"
+
+	// One NAT gateway per zone with private subnets, in that zone's first
+	// public subnet, so a zone failing doesn't cut the others off.
+	NATGatewaysPerZone = "per-zone"
+)
+
+// NewConfig returns a Config with the defaults structureag has always used:
+// DNS support and hostnames enabled and the Amazon provided DNS servers.
+func NewConfig() *Config {
+	return &Config{
+		EnableDNSSupport:   true,
+		EnableDNSHostnames: true,
+		DomainNameServers:  []string{"AmazonProvidedDNS"},
+	}
+}
+
+// Validate checks the Config is complete and its CIDR blocks parse.
+func (cfg *Config) Validate() error {
+	if cfg.Region == "" {
+		return fmt.Errorf("region is required")
+	}
+	if cfg.TagKey == "" || cfg.TagValue == "" {
+		return fmt.Errorf("tagkey and tagvalue are required")
+	}
+	if _, _, err := net.ParseCIDR(cfg.VPCCIDRBlock); err != nil {
+		return fmt.Errorf("vpc-cidr-block: %v", err)
+	}
+	for i, cidr := range cfg.SubnetCIDRs {
+		if err := validateSubnetCIDR(cidr); err != nil {
+			return fmt.Errorf("subnet-%d-cidr: %v", i, err)
+		}
+	}
+	for i, cidr := range cfg.PrivateSubnetCIDRs {
+		if err := validateSubnetCIDR(cidr); err != nil {
+			return fmt.Errorf("private-subnet-%d-cidr: %v", i, err)
+		}
+	}
+	if err := cfg.validateSubnetLayout(); err != nil {
+		return err
+	}
+	if len(cfg.PrivateSubnetCIDRs) > 0 && len(cfg.SubnetCIDRs) == 0 {
+		return fmt.Errorf("private subnets need a public subnet for their NAT gateway")
+	}
+	switch cfg.NATGateways {
+	case "", NATGatewaysSingle, NATGatewaysPerZone:
+	default:
+		return fmt.Errorf("nat-gateways: %q is not %q or %q", cfg.NATGateways, NATGatewaysSingle, NATGatewaysPerZone)
+	}
+	if len(cfg.Zones) > 0 && cfg.NumZones > 0 {
+		return fmt.Errorf("zones and num-zones can't both be set")
+	}
+	if cfg.NumZones < 0 {
+		return fmt.Errorf("num-zones: %d is negative", cfg.NumZones)
+	}
+	if cfg.IPv6 && (len(cfg.SubnetCIDRs) > ipv6PrivateOffset || len(cfg.PrivateSubnetCIDRs) > ipv6PrivateOffset) {
+		return fmt.Errorf("ipv6: the VPC's /56 has room for %d public and %d private subnets", ipv6PrivateOffset, ipv6PrivateOffset)
+	}
+	for i, route := range cfg.Routes {
+		if err := route.validate(); err != nil {
+			return fmt.Errorf("route-%d-%v", i, err)
+		}
+		if route.Tables == RouteTablesPrivate && len(cfg.PrivateSubnetCIDRs) == 0 {
+			return fmt.Errorf("route-%d-tables: there are no private subnets", i)
+		}
+	}
+	for i, rule := range cfg.NetworkACLRules {
+		if err := rule.validate(cfg); err != nil {
+			return fmt.Errorf("nacl-%d-%v", i, err)
+		}
+	}
+	kinds := map[string]bool{}
+	for i, g := range cfg.SecurityGroups {
+		switch {
+		case g.Kind == "":
+			return fmt.Errorf("security-group-%d-kind: not set", i)
+		case g.Kind == endpointsGroup:
+			return fmt.Errorf("security-group-%d-kind: %q is the interface endpoints' group", i, g.Kind)
+		case kinds[g.Kind]:
+			return fmt.Errorf("security-group-%d-kind: %q has two groups", i, g.Kind)
+		}
+		kinds[g.Kind] = true
+	}
+	for i, g := range cfg.SecurityGroups {
+		if err := g.validate(kinds); err != nil {
+			return fmt.Errorf("security-group-%d-%v", i, err)
+		}
+	}
+	if cfg.FlowLog != nil {
+		if err := cfg.FlowLog.validate(cfg); err != nil {
+			return fmt.Errorf("flow-log-%v", err)
+		}
+	}
+	if cfg.TransitGateway != nil {
+		if err := cfg.TransitGateway.validate(cfg); err != nil {
+			return fmt.Errorf("transit-gateway-%v", err)
+		}
+	}
+	if cfg.VPN != nil {
+		if err := cfg.VPN.validate(); err != nil {
+			return fmt.Errorf("vpn-%v", err)
+		}
+	}
+	services := map[string]bool{}
+	for i, e := range cfg.Endpoints {
+		if err := e.validate(cfg); err != nil {
+			return fmt.Errorf("endpoint-%d-%v", i, err)
+		}
+		if services[e.serviceName(cfg.Region)] {
+			return fmt.Errorf("endpoint-%d-service: %s has two endpoints", i, e.Service)
+		}
+		services[e.serviceName(cfg.Region)] = true
+	}
+	if cfg.EnableDNSHostnames && !cfg.EnableDNSSupport {
+		return fmt.Errorf("enable-dns-hostnames requires enable-dns-support")
+	}
+	return nil
+}
+
+func validateSubnetCIDR(cidr string) error {
+	if cidr == "" {
+		return fmt.Errorf("not set, and neither subnet-prefix-length nor subnet-hosts says how to carve it")
+	}
+	_, _, err := net.ParseCIDR(cidr)
+	return err
+}
+
+// The region's internal domain, which is what EC2 uses by default.
+func (cfg *Config) domainName() string {
+	if cfg.DomainName != "" {
+		return cfg.DomainName
+	}
+	if cfg.Region == "us-east-1" {
+		return "ec2.internal"
+	}
+	return cfg.Region + ".compute.internal"
+}
+
+func (cfg *Config) domainNameServers() []string {
+	if len(cfg.DomainNameServers) == 0 {
+		return []string{"AmazonProvidedDNS"}
+	}
+	return cfg.DomainNameServers
+}
+
+// tagFilter matches resources belonging to this stack.
+func (cfg *Config) tagFilter() *ec2.Filter {
+	return &ec2.Filter{
+		Name: aws.String("tag:" + cfg.TagKey),
+		Values: []*string{
+			aws.String(cfg.TagValue),
+		},
+	}
+}
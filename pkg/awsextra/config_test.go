@@ -0,0 +1,203 @@
+package awsextra
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	valid := func() *Config {
+		cfg := NewConfig()
+		cfg.Region = "us-west-2"
+		cfg.VPCCIDRBlock = "172.25.0.0/16"
+		cfg.SubnetCIDRs = []string{"172.25.0.0/24"}
+		cfg.TagKey = "MYTAG"
+		cfg.TagValue = "livedemo"
+		return cfg
+	}
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr bool
+	}{
+		{"valid", func(*Config) {}, false},
+		{"no region", func(c *Config) { c.Region = "" }, true},
+		{"no tag value", func(c *Config) { c.TagValue = "" }, true},
+		{"bad vpc cidr", func(c *Config) { c.VPCCIDRBlock = "172.25.0.0" }, true},
+		{"bad subnet cidr", func(c *Config) { c.SubnetCIDRs = []string{""} }, true},
+		{"hostnames without dns", func(c *Config) { c.EnableDNSSupport = false }, true},
+		{"private subnets", func(c *Config) { c.PrivateSubnetCIDRs = []string{"172.25.10.0/24"} }, false},
+		{"bad private subnet cidr", func(c *Config) { c.PrivateSubnetCIDRs = []string{"172.25.10.0"} }, true},
+		{"subnet outside vpc", func(c *Config) { c.SubnetCIDRs = []string{"10.0.0.0/24"} }, true},
+		{"subnet larger than vpc", func(c *Config) { c.SubnetCIDRs = []string{"172.0.0.0/8"} }, true},
+		{"overlapping subnets", func(c *Config) { c.PrivateSubnetCIDRs = []string{"172.25.0.128/25"} }, true},
+		{"subnet left to carve", func(c *Config) { c.SubnetCIDRs = []string{"172.25.0.0/24", ""} }, true},
+		{"private without public", func(c *Config) { c.SubnetCIDRs, c.PrivateSubnetCIDRs = nil, []string{"172.25.10.0/24"} }, true},
+		{"nat per zone", func(c *Config) { c.NATGateways = NATGatewaysPerZone }, false},
+		{"bad nat gateways", func(c *Config) { c.NATGateways = "many" }, true},
+		{"zones by id", func(c *Config) { c.Zones = []string{"usw2-az2", "usw2-az1"} }, false},
+		{"num zones", func(c *Config) { c.NumZones = 2 }, false},
+		{"zones and num zones", func(c *Config) { c.Zones, c.NumZones = []string{"us-west-2a"}, 1 }, true},
+		{"route", func(c *Config) { c.Routes = []Route{{Destination: "10.1.0.0/16", Target: "pcx-1"}} }, false},
+		{"prefix list route", func(c *Config) {
+			c.Routes = []Route{{Destination: "pl-68a54001", Target: "vgw-1", Tables: RouteTablesPublic}}
+		}, false},
+		{"bad route destination", func(c *Config) { c.Routes = []Route{{Destination: "10.1.0.0", Target: "pcx-1"}} }, true},
+		{"internet route", func(c *Config) { c.Routes = []Route{{Destination: "0.0.0.0/0", Target: "vgw-1"}} }, true},
+		{"IPv6 internet route", func(c *Config) { c.Routes = []Route{{Destination: "::/0", Target: "eigw-1"}} }, true},
+		{"IPv6 route", func(c *Config) { c.Routes = []Route{{Destination: "2001:db8::/32", Target: "pcx-1"}} }, false},
+		{"bad route target", func(c *Config) { c.Routes = []Route{{Destination: "10.1.0.0/16", Target: "i-1"}} }, true},
+		{"private route without private subnets", func(c *Config) {
+			c.Routes = []Route{{Destination: "10.1.0.0/16", Target: "pcx-1", Tables: RouteTablesPrivate}}
+		}, true},
+		{"endpoints", func(c *Config) {
+			c.Endpoints = []Endpoint{{Service: "s3"}, {Service: "com.amazonaws.us-west-2.ecr.api"}}
+		}, false},
+		{"endpoint without service", func(c *Config) { c.Endpoints = []Endpoint{{Tier: RouteTablesPublic}} }, true},
+		{"bad endpoint type", func(c *Config) { c.Endpoints = []Endpoint{{Service: "s3", Type: "Gateway"}} }, true},
+		{"gateway endpoint for interface service", func(c *Config) {
+			c.Endpoints = []Endpoint{{Service: "ecr.api", Type: EndpointTypeGateway}}
+		}, true},
+		{"private endpoint without private subnets", func(c *Config) {
+			c.Endpoints = []Endpoint{{Service: "ssm", Tier: RouteTablesPrivate}}
+		}, true},
+		{"interface endpoint without dns hostnames", func(c *Config) {
+			c.EnableDNSHostnames, c.Endpoints = false, []Endpoint{{Service: "sts"}}
+		}, true},
+		{"flow log", func(c *Config) {
+			c.FlowLog = &FlowLog{Destination: "/flow-logs", RoleARN: "arn:aws:iam::123456789012:role/flowlogs"}
+		}, false},
+		{"flow log to s3", func(c *Config) { c.FlowLog = &FlowLog{DestinationType: "s3", Destination: "arn:aws:s3:::bucket"} }, false},
+		{"flow log without role", func(c *Config) { c.FlowLog = &FlowLog{Destination: "/flow-logs"} }, true},
+		{"flow log with two roles", func(c *Config) {
+			c.FlowLog = &FlowLog{Destination: "/flow-logs", RoleARN: "arn:aws:iam::123456789012:role/flowlogs", CreateRole: true}
+		}, true},
+		{"flow log to s3 with role", func(c *Config) {
+			c.FlowLog = &FlowLog{DestinationType: "s3", Destination: "arn:aws:s3:::bucket", CreateRole: true}
+		}, true},
+		{"flow log to bucket name", func(c *Config) { c.FlowLog = &FlowLog{DestinationType: "s3", Destination: "bucket"} }, true},
+		{"bad flow log traffic type", func(c *Config) {
+			c.FlowLog = &FlowLog{TrafficType: "all", Destination: "/flow-logs", CreateRole: true}
+		}, true},
+		{"bad flow log interval", func(c *Config) {
+			c.FlowLog = &FlowLog{Destination: "/flow-logs", CreateRole: true, MaxAggregationInterval: 300}
+		}, true},
+		{"flow log role name too long", func(c *Config) {
+			c.TagValue = strings.Repeat("x", 50)
+			c.FlowLog = &FlowLog{Destination: "/flow-logs", CreateRole: true}
+		}, true},
+		{"duplicate endpoints", func(c *Config) { c.Endpoints = []Endpoint{{Service: "s3"}, {Service: "com.amazonaws.us-west-2.s3"}} }, true},
+		{"transit gateway", func(c *Config) {
+			c.TransitGateway = &TransitGateway{ID: "tgw-1", AssociateRouteTable: "tgw-rtb-1", Destinations: []string{"10.0.0.0/8", "pl-1"}}
+		}, false},
+		{"new transit gateway", func(c *Config) { c.TransitGateway = &TransitGateway{Create: true} }, false},
+		{"transit gateway id and create", func(c *Config) { c.TransitGateway = &TransitGateway{ID: "tgw-1", Create: true} }, true},
+		{"transit gateway without id", func(c *Config) { c.TransitGateway = &TransitGateway{} }, true},
+		{"new transit gateway's route table", func(c *Config) {
+			c.TransitGateway = &TransitGateway{Create: true, PropagateRouteTables: []string{"tgw-rtb-1"}}
+		}, true},
+		{"bad transit gateway route table", func(c *Config) { c.TransitGateway = &TransitGateway{ID: "tgw-1", AssociateRouteTable: "rtb-1"} }, true},
+		{"transit gateway in missing private subnets", func(c *Config) {
+			c.TransitGateway = &TransitGateway{ID: "tgw-1", Tier: RouteTablesPrivate}
+		}, true},
+		{"transit gateway internet route", func(c *Config) { c.TransitGateway = &TransitGateway{ID: "tgw-1", Destinations: []string{"0.0.0.0/0"}} }, true},
+		{"transit gateway destination routed elsewhere", func(c *Config) {
+			c.Routes = []Route{{Destination: "10.0.0.0/8", Target: "pcx-1"}}
+			c.TransitGateway = &TransitGateway{ID: "tgw-1", Destinations: []string{"10.0.0.0/8"}}
+		}, true},
+		{"nacl rules", func(c *Config) {
+			c.NetworkACLRules = []NetworkACLRule{
+				{Tier: "public", Action: "allow", Protocol: "tcp", FromPort: 443, CIDR: "0.0.0.0/0"},
+				{Egress: true, Action: "deny", Protocol: "icmpv6", CIDR: "::/0"},
+				{Action: "allow", Protocol: "50", CIDR: "10.0.0.0/8"},
+			}
+		}, false},
+		{"nacl rule bad action", func(c *Config) {
+			c.NetworkACLRules = []NetworkACLRule{{Action: "permit", Protocol: "all", CIDR: "0.0.0.0/0"}}
+		}, true},
+		{"nacl rule bad protocol", func(c *Config) {
+			c.NetworkACLRules = []NetworkACLRule{{Action: "allow", Protocol: "gre", CIDR: "0.0.0.0/0"}}
+		}, true},
+		{"nacl rule icmp to ipv6", func(c *Config) {
+			c.NetworkACLRules = []NetworkACLRule{{Action: "allow", Protocol: "icmp", CIDR: "::/0"}}
+		}, true},
+		{"nacl rule ports without tcp or udp", func(c *Config) {
+			c.NetworkACLRules = []NetworkACLRule{{Action: "allow", Protocol: "all", FromPort: 22, CIDR: "0.0.0.0/0"}}
+		}, true},
+		{"nacl rule backwards ports", func(c *Config) {
+			c.NetworkACLRules = []NetworkACLRule{{Action: "allow", Protocol: "udp", FromPort: 53, ToPort: 1, CIDR: "0.0.0.0/0"}}
+		}, true},
+		{"nacl rule for missing private subnets", func(c *Config) {
+			c.NetworkACLRules = []NetworkACLRule{{Tier: "private", Action: "allow", Protocol: "all", CIDR: "0.0.0.0/0"}}
+		}, true},
+		{"security groups", func(c *Config) {
+			c.SecurityGroups = []SecurityGroup{
+				{Kind: "web", Ingress: []SecurityGroupRule{
+					{Protocol: "tcp", FromPort: 443, CIDR: "0.0.0.0/0"},
+					{Protocol: "tcp", FromPort: 22, Group: "bastion"},
+					{Protocol: "all", Group: "web"},
+				}, Egress: []SecurityGroupRule{{Protocol: "udp", FromPort: 53, PrefixList: "pl-1"}}},
+				{Kind: "bastion", Ingress: []SecurityGroupRule{{Protocol: "icmp", IPv6CIDR: "::/0"}}},
+			}
+		}, false},
+		{"security group without kind", func(c *Config) {
+			c.SecurityGroups = []SecurityGroup{{}}
+		}, true},
+		{"two security groups of a kind", func(c *Config) {
+			c.SecurityGroups = []SecurityGroup{{Kind: "web"}, {Kind: "web"}}
+		}, true},
+		{"security group rule bad protocol", func(c *Config) {
+			c.SecurityGroups = []SecurityGroup{{Kind: "web", Ingress: []SecurityGroupRule{{Protocol: "gre", CIDR: "0.0.0.0/0"}}}}
+		}, true},
+		{"security group rule with two sources", func(c *Config) {
+			c.SecurityGroups = []SecurityGroup{{Kind: "web", Ingress: []SecurityGroupRule{{Protocol: "all", CIDR: "0.0.0.0/0", IPv6CIDR: "::/0"}}}}
+		}, true},
+		{"security group rule ipv6 in cidr", func(c *Config) {
+			c.SecurityGroups = []SecurityGroup{{Kind: "web", Egress: []SecurityGroupRule{{Protocol: "all", CIDR: "::/0"}}}}
+		}, true},
+		{"security group rule for unknown group", func(c *Config) {
+			c.SecurityGroups = []SecurityGroup{{Kind: "web", Ingress: []SecurityGroupRule{{Protocol: "tcp", Group: "db"}}}}
+		}, true},
+		{"security groups referencing each other", func(c *Config) {
+			c.SecurityGroups = []SecurityGroup{
+				{Kind: "web", Ingress: []SecurityGroupRule{{Protocol: "tcp", Group: "db"}}},
+				{Kind: "db", Ingress: []SecurityGroupRule{{Protocol: "tcp", Group: "web"}}},
+			}
+		}, false},
+		{"vpn with static routes", func(c *Config) {
+			c.VPN = &VPN{CustomerGatewayIP: "203.0.113.12", StaticRoutes: []string{"192.168.0.0/16"}}
+		}, false},
+		{"vpn over bgp", func(c *Config) { c.VPN = &VPN{CustomerGatewayIP: "203.0.113.12", BGPASN: 65010, AmazonSideASN: 64600} }, false},
+		{"vpn without customer gateway", func(c *Config) { c.VPN = &VPN{} }, true},
+		{"vpn to ipv6 customer gateway", func(c *Config) { c.VPN = &VPN{CustomerGatewayIP: "2001:db8::1"} }, true},
+		{"vpn public amazon side asn", func(c *Config) { c.VPN = &VPN{CustomerGatewayIP: "203.0.113.12", AmazonSideASN: 7224} }, true},
+		{"bad vpn static route", func(c *Config) {
+			c.VPN = &VPN{CustomerGatewayIP: "203.0.113.12", StaticRoutes: []string{"192.168.0.0"}}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(cfg)
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDomainName(t *testing.T) {
+	tests := []struct {
+		region, domain, want string
+	}{
+		{"us-east-1", "", "ec2.internal"},
+		{"us-west-2", "", "us-west-2.compute.internal"},
+		{"us-west-2", "corp.example", "corp.example"},
+	}
+	for _, tt := range tests {
+		cfg := &Config{Region: tt.region, DomainName: tt.domain}
+		if got := cfg.domainName(); got != tt.want {
+			t.Errorf("domainName(%s, %q) = %q, want %q", tt.region, tt.domain, got, tt.want)
+		}
+	}
+}
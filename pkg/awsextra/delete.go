@@ -0,0 +1,75 @@
+package awsextra
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Report lists the resources a teardown removed, in the order they went, and
+// the ones it had to leave.
+type Report struct {
+	Removed []Removed
+	Blocked []Blocked
+}
+
+// Removed is one resource a teardown deleted.
+type Removed struct {
+	Resource string // Kind of resource, eg. "subnet"
+	ID       string
+}
+
+// Blocked is a resource a teardown left because other resources still
+// depend on it.
+type Blocked struct {
+	Resource string
+	ID       string
+	By       []string // IDs of the resources depending on it
+}
+
+// add records a removed resource.  It does nothing on a nil Report so the
+// delete helpers can be used without one.
+func (r *Report) add(resource string, ID *string) {
+	if r == nil {
+		return
+	}
+	r.Removed = append(r.Removed, Removed{Resource: resource, ID: aws.StringValue(ID)})
+}
+
+// block records a resource left behind.
+func (r *Report) block(resource string, ID string, by []string) {
+	if r == nil {
+		return
+	}
+	r.Blocked = append(r.Blocked, Blocked{Resource: resource, ID: ID, By: by})
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "Removed:")
+	for _, removed := range r.Removed {
+		fmt.Fprintf(&b, "%s %s\n", removed.Resource, removed.ID)
+	}
+	if len(r.Blocked) > 0 {
+		fmt.Fprintln(&b, "Blocked:")
+	}
+	for _, blocked := range r.Blocked {
+		fmt.Fprintf(&b, "%s %s (in use by %s)\n", blocked.Resource, blocked.ID, strings.Join(blocked.By, ", "))
+	}
+	return b.String()
+}
+
+// ForceDeleteVPCNetworking ... tears down the stack's VPC whatever is still
+// running in it.  It works like DeleteVPCNetworking except that instances,
+// network interfaces, security groups and everything else in the VPC are
+// deleted whether or not they carry the stack's tag.  Network interfaces
+// owned by another AWS service, eg. a load balancer, can't be removed by us;
+// the error then wraps ErrManagedInterface and names the interface and its
+// owner, since its subnet and the VPC can't go until that service is deleted.
+// The Report lists everything removed, including when an error stops the
+// teardown part way through.
+func ForceDeleteVPCNetworking(ctx context.Context, svc EC2API, cfg *Config) (*Report, error) {
+	return teardownStack(ctx, svc, cfg, true)
+}
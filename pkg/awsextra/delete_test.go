@@ -0,0 +1,278 @@
+package awsextra_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/jeremyd/structureag/pkg/awsextra"
+	"github.com/jeremyd/structureag/pkg/awsextra/awsextratest"
+)
+
+// busyStack builds a stack with two cross referencing security groups, an
+// instance in each subnet and an extra network interface attached to the
+// first instance, none of which DeleteVPCNetworking can get past.
+func busyStack(t *testing.T) (*awsextratest.EC2, *awsextra.Config) {
+	t.Helper()
+	cfg := testConfig(2)
+	svc := awsextratest.NewEC2("us-west-2")
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	web, err := awsextra.CreateSecurityGroup(context.Background(), svc, cfg, "web", vpcID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := awsextra.CreateSecurityGroup(context.Background(), svc, cfg, "db", vpcID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := awsextra.AuthorizeSecurityGroupsInternalSSH(context.Background(), svc, cfg, web); err != nil {
+		t.Fatal(err)
+	}
+	_, err = svc.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId: db,
+		IpPermissions: []*ec2.IpPermission{{
+			IpProtocol:       aws.String("tcp"),
+			FromPort:         aws.Int64(5432),
+			ToPort:           aws.Int64(5432),
+			UserIdGroupPairs: []*ec2.UserIdGroupPair{{GroupId: web}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subnets, _ := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{})
+	var first *string
+	for i, subnet := range subnets.Subnets {
+		group := web
+		if i > 0 {
+			group = db
+		}
+		r, err := svc.RunInstances(&ec2.RunInstancesInput{SubnetId: subnet.SubnetId, SecurityGroupIds: []*string{group}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == nil {
+			first = r.Instances[0].InstanceId
+		}
+	}
+	eni, err := svc.CreateNetworkInterface(&ec2.CreateNetworkInterfaceInput{SubnetId: subnets.Subnets[0].SubnetId, Groups: []*string{db}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = svc.AttachNetworkInterface(&ec2.AttachNetworkInterfaceInput{
+		NetworkInterfaceId: eni.NetworkInterface.NetworkInterfaceId,
+		InstanceId:         first,
+		DeviceIndex:        aws.Int64(1),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc, cfg
+}
+
+func TestForceDeleteVPCNetworking(t *testing.T) {
+	svc, cfg := busyStack(t)
+
+	report, err := awsextra.ForceDeleteVPCNetworking(context.Background(), svc, cfg)
+	if err != nil {
+		t.Fatalf("ForceDeleteVPCNetworking: %v", err)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left after delete", n)
+	}
+
+	counts := map[string]int{}
+	for _, removed := range report.Removed {
+		counts[removed.Resource]++
+	}
+	want := map[string]int{
+		"instance":          2,
+		"network interface": 1,
+		"security group":    2,
+		"internet gateway":  1,
+		"subnet":            2,
+		"vpc":               1,
+		"dhcp options set":  1,
+	}
+	for resource, n := range want {
+		if counts[resource] != n {
+			t.Errorf("report has %d %s, want %d\n%s", counts[resource], resource, n, report)
+		}
+	}
+}
+
+func TestForceDeleteVPCNetworkingRequesterManaged(t *testing.T) {
+	svc, cfg := busyStack(t)
+	subnets, _ := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{})
+	eni, _ := svc.CreateNetworkInterface(&ec2.CreateNetworkInterfaceInput{
+		SubnetId:    subnets.Subnets[1].SubnetId,
+		Description: aws.String("ELB app/demo"),
+	})
+	eniID := *eni.NetworkInterface.NetworkInterfaceId
+	svc.SetRequesterManaged(eniID, "amazon-elb")
+
+	report, err := awsextra.ForceDeleteVPCNetworking(context.Background(), svc, cfg)
+	if !errors.Is(err, awsextra.ErrManagedInterface) {
+		t.Fatalf("err = %v, want ErrManagedInterface", err)
+	}
+	var e *awsextra.Error
+	if !errors.As(err, &e) || e.ID != eniID || !strings.Contains(e.Error(), "amazon-elb") {
+		t.Errorf("err = %v, want it to name %s and its requester", err, eniID)
+	}
+	blockedSubnet := *subnets.Subnets[1].SubnetId
+	for _, removed := range report.Removed {
+		if removed.ID == eniID || removed.ID == blockedSubnet || removed.Resource == "vpc" {
+			t.Errorf("report claims %s %s was removed", removed.Resource, removed.ID)
+		}
+	}
+	blocked := map[string]bool{}
+	for _, b := range report.Blocked {
+		blocked[b.Resource] = true
+	}
+	if len(report.Blocked) != 3 || !blocked["subnet"] || !blocked["vpc"] || !blocked["dhcp options set"] {
+		t.Errorf("report blocks %v, want the interface's subnet, the vpc and its dhcp options set", report.Blocked)
+	}
+	if len(report.Removed) == 0 {
+		t.Errorf("report is empty, want what was removed around the interface")
+	}
+}
+
+func TestForceDeleteVPCNetworkingNothingToDelete(t *testing.T) {
+	svc := awsextratest.NewEC2("us-west-2")
+	report, err := awsextra.ForceDeleteVPCNetworking(context.Background(), svc, testConfig(0))
+	if err != nil {
+		t.Fatalf("ForceDeleteVPCNetworking: %v", err)
+	}
+	if len(report.Removed) != 0 {
+		t.Errorf("removed %v from an empty account", report.Removed)
+	}
+}
+
+// emptyDescribes describes resources asked for by ID as if they had just
+// gone: EC2 can return an empty list rather than a NotFound error for them.
+// The fake still describes them, which moves along whatever is pending.
+type emptyDescribes struct {
+	*awsextratest.EC2
+}
+
+func (e *emptyDescribes) DescribeInternetGatewaysWithContext(ctx aws.Context, in *ec2.DescribeInternetGatewaysInput, opts ...request.Option) (*ec2.DescribeInternetGatewaysOutput, error) {
+	out, err := e.EC2.DescribeInternetGatewaysWithContext(ctx, in, opts...)
+	if len(in.InternetGatewayIds) > 0 {
+		return &ec2.DescribeInternetGatewaysOutput{}, nil
+	}
+	return out, err
+}
+
+func (e *emptyDescribes) DescribeRouteTablesWithContext(ctx aws.Context, in *ec2.DescribeRouteTablesInput, opts ...request.Option) (*ec2.DescribeRouteTablesOutput, error) {
+	out, err := e.EC2.DescribeRouteTablesWithContext(ctx, in, opts...)
+	if len(in.RouteTableIds) > 0 {
+		return &ec2.DescribeRouteTablesOutput{}, nil
+	}
+	return out, err
+}
+
+func (e *emptyDescribes) DescribeNetworkInterfacesWithContext(ctx aws.Context, in *ec2.DescribeNetworkInterfacesInput, opts ...request.Option) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	out, err := e.EC2.DescribeNetworkInterfacesWithContext(ctx, in, opts...)
+	if len(in.NetworkInterfaceIds) > 0 {
+		return &ec2.DescribeNetworkInterfacesOutput{}, nil
+	}
+	return out, err
+}
+
+func (e *emptyDescribes) DescribeNatGatewaysWithContext(ctx aws.Context, in *ec2.DescribeNatGatewaysInput, opts ...request.Option) (*ec2.DescribeNatGatewaysOutput, error) {
+	out, err := e.EC2.DescribeNatGatewaysWithContext(ctx, in, opts...)
+	if len(in.NatGatewayIds) > 0 {
+		return &ec2.DescribeNatGatewaysOutput{}, nil
+	}
+	return out, err
+}
+
+func (e *emptyDescribes) DescribeTransitGatewaysWithContext(ctx aws.Context, in *ec2.DescribeTransitGatewaysInput, opts ...request.Option) (*ec2.DescribeTransitGatewaysOutput, error) {
+	out, err := e.EC2.DescribeTransitGatewaysWithContext(ctx, in, opts...)
+	if len(in.TransitGatewayIds) > 0 {
+		return &ec2.DescribeTransitGatewaysOutput{}, nil
+	}
+	return out, err
+}
+
+func (e *emptyDescribes) DescribeTransitGatewayVpcAttachmentsWithContext(ctx aws.Context, in *ec2.DescribeTransitGatewayVpcAttachmentsInput, opts ...request.Option) (*ec2.DescribeTransitGatewayVpcAttachmentsOutput, error) {
+	out, err := e.EC2.DescribeTransitGatewayVpcAttachmentsWithContext(ctx, in, opts...)
+	if len(in.TransitGatewayAttachmentIds) > 0 {
+		return &ec2.DescribeTransitGatewayVpcAttachmentsOutput{}, nil
+	}
+	return out, err
+}
+
+func (e *emptyDescribes) DescribeVpnConnectionsWithContext(ctx aws.Context, in *ec2.DescribeVpnConnectionsInput, opts ...request.Option) (*ec2.DescribeVpnConnectionsOutput, error) {
+	out, err := e.EC2.DescribeVpnConnectionsWithContext(ctx, in, opts...)
+	if len(in.VpnConnectionIds) > 0 {
+		return &ec2.DescribeVpnConnectionsOutput{}, nil
+	}
+	return out, err
+}
+
+func (e *emptyDescribes) DescribeVpnGatewaysWithContext(ctx aws.Context, in *ec2.DescribeVpnGatewaysInput, opts ...request.Option) (*ec2.DescribeVpnGatewaysOutput, error) {
+	out, err := e.EC2.DescribeVpnGatewaysWithContext(ctx, in, opts...)
+	if len(in.VpnGatewayIds) > 0 {
+		return &ec2.DescribeVpnGatewaysOutput{}, nil
+	}
+	return out, err
+}
+
+func (e *emptyDescribes) DescribeCustomerGatewaysWithContext(ctx aws.Context, in *ec2.DescribeCustomerGatewaysInput, opts ...request.Option) (*ec2.DescribeCustomerGatewaysOutput, error) {
+	out, err := e.EC2.DescribeCustomerGatewaysWithContext(ctx, in, opts...)
+	if len(in.CustomerGatewayIds) > 0 {
+		return &ec2.DescribeCustomerGatewaysOutput{}, nil
+	}
+	return out, err
+}
+
+func (e *emptyDescribes) DescribeNetworkAclsWithContext(ctx aws.Context, in *ec2.DescribeNetworkAclsInput, opts ...request.Option) (*ec2.DescribeNetworkAclsOutput, error) {
+	out, err := e.EC2.DescribeNetworkAclsWithContext(ctx, in, opts...)
+	if len(in.NetworkAclIds) > 0 {
+		return &ec2.DescribeNetworkAclsOutput{}, nil
+	}
+	return out, err
+}
+
+func (e *emptyDescribes) DescribeSecurityGroupsWithContext(ctx aws.Context, in *ec2.DescribeSecurityGroupsInput, opts ...request.Option) (*ec2.DescribeSecurityGroupsOutput, error) {
+	out, err := e.EC2.DescribeSecurityGroupsWithContext(ctx, in, opts...)
+	if len(in.GroupIds) > 0 {
+		return &ec2.DescribeSecurityGroupsOutput{}, nil
+	}
+	return out, err
+}
+
+func TestDeleteStateResourcesEmptyDescribe(t *testing.T) {
+	cfg := privateConfig(1, awsextra.NATGatewaysSingle)
+	svc := awsextratest.NewEC2("us-west-2")
+	up := upWithState(t, svc, cfg)
+
+	// The NAT gateway is deleted and then waited on; the rest are described
+	// first and so found already gone.
+	state := awsextra.NewState(cfg)
+	state.Resources = []awsextra.Resource{
+		{Type: "nat gateway", ID: inState(up, "nat gateway")[0]},
+		{Type: "internet gateway", ID: "igw-gone"},
+		{Type: "route table", ID: "rtb-gone"},
+		{Type: "network interface", ID: "eni-gone"},
+	}
+	report, err := awsextra.DeleteStateResources(context.Background(), &emptyDescribes{svc}, cfg, state)
+	if err != nil {
+		t.Fatalf("DeleteStateResources: %v", err)
+	}
+	if len(state.Resources) != 0 {
+		t.Errorf("state still lists\n%s", state)
+	}
+	if len(report.Removed) != 1 {
+		t.Errorf("removed %v, want the NAT gateway", report.Removed)
+	}
+}
@@ -0,0 +1,375 @@
+package awsextra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Endpoint is a VPC endpoint, through which the stack's subnets reach an AWS
+// service without going out to the internet.
+type Endpoint struct {
+	// The service's short name, eg. "s3" or "ecr.api", or its full name,
+	// eg. "com.amazonaws.us-west-2.s3".
+	Service string
+
+	// EndpointTypeGateway or EndpointTypeInterface.  If empty, S3 and DynamoDB
+	// get gateway endpoints, which cost nothing, and other services interface
+	// endpoints.
+	Type string
+
+	// Which subnets use the endpoint: RouteTablesPublic, RouteTablesPrivate or
+	// "".  A gateway endpoint is added to that tier's route tables, or every
+	// route table if "".  An interface endpoint gets a network interface in
+	// the tier's first subnet in each zone; "" means the private subnets if
+	// there are any, else the public ones.
+	Tier string
+}
+
+// Values of Endpoint.Type.
+const (
+	EndpointTypeGateway   = "gateway"
+	EndpointTypeInterface = "interface"
+)
+
+// The services with gateway endpoints.
+var gatewayServices = []string{"s3", "dynamodb"}
+
+// The security group of the stack's interface endpoints.
+const endpointsGroup = "endpoints"
+
+func (e Endpoint) shortName() string {
+	if !strings.HasPrefix(e.Service, "com.amazonaws.") {
+		return e.Service
+	}
+	parts := strings.SplitN(e.Service, ".", 4)
+	return parts[len(parts)-1]
+}
+
+func (e Endpoint) serviceName(region string) string {
+	if strings.HasPrefix(e.Service, "com.amazonaws.") {
+		return e.Service
+	}
+	return "com.amazonaws." + region + "." + e.Service
+}
+
+func (e Endpoint) endpointType() string {
+	if e.Type != "" {
+		return e.Type
+	}
+	if contains(gatewayServices, e.shortName()) {
+		return EndpointTypeGateway
+	}
+	return EndpointTypeInterface
+}
+
+// The tier whose subnets, or route tables, use the endpoint, or "" for all
+// route tables.
+func (e Endpoint) tier(cfg *Config) string {
+	if e.Tier == "" && e.endpointType() == EndpointTypeInterface {
+		if len(cfg.PrivateSubnetCIDRs) > 0 {
+			return RouteTablesPrivate
+		}
+		return RouteTablesPublic
+	}
+	return e.Tier
+}
+
+func (e Endpoint) validate(cfg *Config) error {
+	if e.Service == "" {
+		return errors.New("service: is required")
+	}
+	switch e.Type {
+	case "", EndpointTypeInterface:
+	case EndpointTypeGateway:
+		if !contains(gatewayServices, e.shortName()) {
+			return fmt.Errorf("type: %s has no gateway endpoint, only %s do", e.Service, strings.Join(gatewayServices, " and "))
+		}
+	default:
+		return fmt.Errorf("type: %q is not %q or %q", e.Type, EndpointTypeGateway, EndpointTypeInterface)
+	}
+	switch e.Tier {
+	case "", RouteTablesPublic:
+	case RouteTablesPrivate:
+		if len(cfg.PrivateSubnetCIDRs) == 0 {
+			return errors.New("tier: there are no private subnets")
+		}
+	default:
+		return fmt.Errorf("tier: %q is not %q or %q", e.Tier, RouteTablesPublic, RouteTablesPrivate)
+	}
+	// Private DNS makes the service's usual hostname resolve to the endpoint.
+	if e.endpointType() == EndpointTypeInterface && !(cfg.EnableDNSSupport && cfg.EnableDNSHostnames) {
+		return errors.New("service: interface endpoints use private DNS, which needs enable-dns-support and enable-dns-hostnames")
+	}
+	return nil
+}
+
+func (e Endpoint) String() string {
+	return e.Service + " " + e.endpointType()
+}
+
+// Interface endpoints accept HTTPS from anywhere in the VPC.
+func endpointRules(cfg *Config) []groupRule {
+	return []groupRule{
+		{step: "authorize HTTPS from the VPC for", permission: &ec2.IpPermission{
+			FromPort:   aws.Int64(443),
+			IpProtocol: aws.String("tcp"),
+			ToPort:     aws.Int64(443),
+			IpRanges: []*ec2.IpRange{
+				{CidrIp: aws.String(cfg.VPCCIDRBlock)},
+			},
+		}},
+	}
+}
+
+func (cfg *Config) hasInterfaceEndpoints() bool {
+	for _, e := range cfg.Endpoints {
+		if e.endpointType() == EndpointTypeInterface {
+			return true
+		}
+	}
+	return false
+}
+
+// createEndpoints makes sure each configured endpoint exists and is attached
+// to its route tables or subnets.  Interface endpoints share a security group
+// of their own.
+func createEndpoints(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, state *State) error {
+	var groupID *string
+	if cfg.hasInterfaceEndpoints() {
+		var err error
+		groupID, err = CreateSecurityGroup(ctx, svc, cfg, endpointsGroup, vpcID, state)
+		if err != nil {
+			return err
+		}
+		if err := reconcileRules(ctx, svc, cfg, groupID, endpointRules(cfg)); err != nil {
+			return err
+		}
+	}
+	for _, e := range cfg.Endpoints {
+		if err := ensureEndpoint(ctx, svc, cfg, vpcID, e, groupID, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Find the stack's endpoint for the service or create it, then add any of
+// its route tables or subnets it is missing.
+func ensureEndpoint(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, e Endpoint, groupID *string, state *State) error {
+	routeTableIDs, subnetIDs, _, err := endpointTargets(ctx, svc, cfg, vpcID, e)
+	if err != nil {
+		return err
+	}
+	dependsOn := append(append([]*string{vpcID}, routeTableIDs...), subnetIDs...)
+	if groupID != nil && e.endpointType() == EndpointTypeInterface {
+		dependsOn = append(dependsOn, groupID)
+	}
+
+	endpoint, err := findEndpoint(ctx, svc, cfg, vpcID, e.serviceName(cfg.Region))
+	if err != nil {
+		return err
+	}
+	if endpoint == nil {
+		params := &ec2.CreateVpcEndpointInput{
+			VpcId:       vpcID,                                 // Required
+			ServiceName: aws.String(e.serviceName(cfg.Region)), // Required
+		}
+		if e.endpointType() == EndpointTypeGateway {
+			params.VpcEndpointType = aws.String(ec2.VpcEndpointTypeGateway)
+			params.RouteTableIds = routeTableIDs
+		} else {
+			params.VpcEndpointType = aws.String(ec2.VpcEndpointTypeInterface)
+			params.SubnetIds = subnetIDs
+			params.SecurityGroupIds = []*string{groupID}
+			params.PrivateDnsEnabled = aws.Bool(true)
+		}
+		resp, err := svc.CreateVpcEndpointWithContext(ctx, params)
+		if err != nil {
+			return newError("create", "vpc endpoint "+e.String(), nil, err)
+		}
+		endpointID := resp.VpcEndpoint.VpcEndpointId
+		fmt.Println("Created VPC endpoint " + *endpointID + " for " + e.String())
+		state.record("vpc endpoint", endpointID, dependsOn...)
+		return tagIt(ctx, svc, cfg, "vpc endpoint", endpointID, cfg.TagKey, cfg.TagValue)
+	}
+
+	endpointID := endpoint.VpcEndpointId
+	fmt.Println("Found VPC endpoint " + *endpointID + " for " + e.String())
+	state.record("vpc endpoint", endpointID, dependsOn...)
+	params := &ec2.ModifyVpcEndpointInput{
+		VpcEndpointId:    endpointID,
+		AddRouteTableIds: missingIDs(endpoint.RouteTableIds, routeTableIDs),
+		AddSubnetIds:     missingIDs(endpoint.SubnetIds, subnetIDs),
+	}
+	if len(params.AddRouteTableIds) == 0 && len(params.AddSubnetIds) == 0 {
+		return nil
+	}
+	if _, err := svc.ModifyVpcEndpointWithContext(ctx, params); err != nil {
+		return newError("add route tables and subnets to", "vpc endpoint", endpointID, err)
+	}
+	fmt.Println("Added " + strings.Join(aws.StringValueSlice(append(params.AddRouteTableIds, params.AddSubnetIds...)), ", ") + " to VPC endpoint " + *endpointID)
+	return nil
+}
+
+// The IDs in want that aren't in have.
+func missingIDs(have []*string, want []*string) []*string {
+	var missing []*string
+	for _, ID := range want {
+		if !contains(aws.StringValueSlice(have), *ID) {
+			missing = append(missing, ID)
+		}
+	}
+	return missing
+}
+
+// The route tables a gateway endpoint goes in, or the subnets an interface
+// endpoint goes in, that are there so far.  pending is set if some are still
+// to be created.
+func endpointTargets(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, e Endpoint) (routeTableIDs []*string, subnetIDs []*string, pending bool, err error) {
+	tier := e.tier(cfg)
+	if e.endpointType() == EndpointTypeGateway {
+		var purposes []string
+		if tier != RouteTablesPrivate {
+			purposes = append(purposes, RouteTablesPublic)
+		}
+		if tier != RouteTablesPublic {
+			_, zones, err := tierSubnets(ctx, svc, cfg, vpcID, cfg.PrivateSubnetCIDRs)
+			if err != nil {
+				return nil, nil, false, err
+			}
+			for _, zone := range zones {
+				if purpose := "private " + zone; !contains(purposes, purpose) {
+					purposes = append(purposes, purpose)
+				}
+			}
+		}
+		for _, purpose := range purposes {
+			rt, err := findRouteTable(ctx, svc, cfg, vpcID, purpose)
+			if err != nil {
+				return nil, nil, false, err
+			}
+			if rt == nil {
+				pending = true
+				continue
+			}
+			routeTableIDs = append(routeTableIDs, rt.RouteTableId)
+		}
+		return routeTableIDs, nil, pending, nil
+	}
+
+	subnetIDs, pending, err = zoneSubnets(ctx, svc, cfg, vpcID, tier)
+	return nil, subnetIDs, pending, err
+}
+
+// The tier's first subnet in each zone, of those there so far.  pending is
+// set if some are still to be created.
+func zoneSubnets(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, tier string) (subnetIDs []*string, pending bool, err error) {
+	cidrs := cfg.SubnetCIDRs
+	if tier == RouteTablesPrivate {
+		cidrs = cfg.PrivateSubnetCIDRs
+	}
+	subnets, zones, err := tierSubnets(ctx, svc, cfg, vpcID, cidrs)
+	if err != nil {
+		return nil, false, err
+	}
+	covered := map[string]bool{}
+	for i, subnet := range subnets {
+		if covered[zones[i]] {
+			continue
+		}
+		covered[zones[i]] = true
+		if subnet == nil {
+			pending = true
+			continue
+		}
+		subnetIDs = append(subnetIDs, subnet.SubnetId)
+	}
+	return subnetIDs, pending, nil
+}
+
+// The subnets with the CIDRs, nil for those not created yet, and the zone
+// each is or will be in.
+func tierSubnets(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, cidrs []string) ([]*ec2.Subnet, []string, error) {
+	planned, err := subnetZones(ctx, svc, cfg, cidrs)
+	if err != nil {
+		return nil, nil, err
+	}
+	subnets := make([]*ec2.Subnet, len(cidrs))
+	zones := make([]string, len(cidrs))
+	for i, cidr := range cidrs {
+		if subnets[i], err = findSubnet(ctx, svc, vpcID, cidr); err != nil {
+			return nil, nil, err
+		}
+		zones[i] = aws.StringValue(planned[i])
+		if subnets[i] != nil {
+			zones[i] = aws.StringValue(subnets[i].AvailabilityZone)
+		}
+	}
+	return subnets, zones, nil
+}
+
+// The stack's endpoint in the VPC for the service, unless it is going away.
+func findEndpoint(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, serviceName string) (*ec2.VpcEndpoint, error) {
+	resp, err := svc.DescribeVpcEndpointsWithContext(ctx, &ec2.DescribeVpcEndpointsInput{
+		Filters: []*ec2.Filter{
+			cfg.tagFilter(),
+			{Name: aws.String("vpc-id"), Values: []*string{vpcID}},
+			{Name: aws.String("service-name"), Values: []*string{aws.String(serviceName)}},
+			{Name: aws.String("vpc-endpoint-state"), Values: aws.StringSlice([]string{"pendingAcceptance", "pending", "available"})},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "vpc endpoints", vpcID, err)
+	}
+	if len(resp.VpcEndpoints) == 0 {
+		return nil, nil
+	}
+	return resp.VpcEndpoints[0], nil
+}
+
+// planEndpoints adds what createEndpoints would do to plan.  vpcID is nil
+// when the VPC is still to be created.
+func planEndpoints(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, plan *Plan) error {
+	if cfg.hasInterfaceEndpoints() {
+		err := planSecurityGroup(ctx, svc, cfg, endpointsGroup, func(*string) []groupRule {
+			return endpointRules(cfg)
+		}, true, plan)
+		if err != nil {
+			return err
+		}
+	}
+	for _, e := range cfg.Endpoints {
+		var endpoint *ec2.VpcEndpoint
+		if vpcID != nil {
+			var err error
+			if endpoint, err = findEndpoint(ctx, svc, cfg, vpcID, e.serviceName(cfg.Region)); err != nil {
+				return err
+			}
+		}
+		if endpoint == nil {
+			plan.add("create", "vpc endpoint", nil, e.String())
+			continue
+		}
+		routeTableIDs, subnetIDs, pending, err := endpointTargets(ctx, svc, cfg, vpcID, e)
+		if err != nil {
+			return err
+		}
+		missing := aws.StringValueSlice(append(missingIDs(endpoint.RouteTableIds, routeTableIDs), missingIDs(endpoint.SubnetIds, subnetIDs)...))
+		if pending && e.endpointType() == EndpointTypeGateway {
+			missing = append(missing, "the new route tables")
+		} else if pending {
+			missing = append(missing, "the new subnets")
+		}
+		if len(missing) > 0 {
+			plan.add("update", "vpc endpoint", endpoint.VpcEndpointId, e.String()+", add "+strings.Join(missing, ", "))
+		} else {
+			plan.add("exists", "vpc endpoint", endpoint.VpcEndpointId, e.String())
+		}
+	}
+	return nil
+}
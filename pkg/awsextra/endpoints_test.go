@@ -0,0 +1,171 @@
+package awsextra_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/jeremyd/structureag/pkg/awsextra"
+	"github.com/jeremyd/structureag/pkg/awsextra/awsextratest"
+)
+
+func endpointConfig() *awsextra.Config {
+	cfg := privateConfig(2, awsextra.NATGatewaysSingle)
+	cfg.Endpoints = []awsextra.Endpoint{
+		{Service: "s3"},
+		{Service: "ecr.api"},
+		{Service: "com.amazonaws.us-west-2.ssm", Tier: awsextra.RouteTablesPublic},
+	}
+	return cfg
+}
+
+// The stack's endpoints in the VPC by service name.
+func endpoints(t *testing.T, svc *awsextratest.EC2, vpcID *string) map[string]*ec2.VpcEndpoint {
+	t.Helper()
+	resp, err := svc.DescribeVpcEndpoints(&ec2.DescribeVpcEndpointsInput{Filters: []*ec2.Filter{
+		{Name: aws.String("vpc-id"), Values: []*string{vpcID}},
+		{Name: aws.String("tag:MYTAG"), Values: []*string{aws.String("test")}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]*ec2.VpcEndpoint{}
+	for _, endpoint := range resp.VpcEndpoints {
+		found[*endpoint.ServiceName] = endpoint
+	}
+	return found
+}
+
+// The CIDR blocks of the subnets.
+func subnetCIDRs(t *testing.T, svc *awsextratest.EC2, IDs []*string) []string {
+	t.Helper()
+	resp, err := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{SubnetIds: IDs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cidrs []string
+	for _, subnet := range resp.Subnets {
+		cidrs = append(cidrs, *subnet.CidrBlock)
+	}
+	return cidrs
+}
+
+func TestCreateVPCNetworkingEndpoints(t *testing.T) {
+	cfg := endpointConfig()
+	svc := awsextratest.NewEC2("us-west-2")
+	state := awsextra.NewState(cfg)
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := endpoints(t, svc, vpcID)
+	if len(found) != 3 {
+		t.Fatalf("%d endpoints, want 3", len(found))
+	}
+	// S3 goes in the public and both private route tables.
+	s3 := found["com.amazonaws.us-west-2.s3"]
+	if s3 == nil || *s3.VpcEndpointType != ec2.VpcEndpointTypeGateway || len(s3.RouteTableIds) != 3 {
+		t.Errorf("s3 endpoint = %v, want a gateway in 3 route tables", s3)
+	}
+	ecr := found["com.amazonaws.us-west-2.ecr.api"]
+	if ecr == nil || *ecr.VpcEndpointType != ec2.VpcEndpointTypeInterface || !aws.BoolValue(ecr.PrivateDnsEnabled) {
+		t.Fatalf("ecr.api endpoint = %v, want an interface with private DNS", ecr)
+	}
+	if got := subnetCIDRs(t, svc, ecr.SubnetIds); len(got) != 2 || got[0] != cfg.PrivateSubnetCIDRs[0] || got[1] != cfg.PrivateSubnetCIDRs[1] {
+		t.Errorf("ecr.api endpoint is in %v, want the private subnets", got)
+	}
+	ssm := found["com.amazonaws.us-west-2.ssm"]
+	if got := subnetCIDRs(t, svc, ssm.SubnetIds); len(got) != 2 || got[0] != cfg.SubnetCIDRs[0] || got[1] != cfg.SubnetCIDRs[1] {
+		t.Errorf("ssm endpoint is in %v, want the public subnets", got)
+	}
+
+	// The interface endpoints share a group taking HTTPS from the VPC.
+	if len(ecr.Groups) != 1 || len(ssm.Groups) != 1 || *ecr.Groups[0].GroupId != *ssm.Groups[0].GroupId {
+		t.Fatalf("endpoint groups = %v and %v, want one shared group", ecr.Groups, ssm.Groups)
+	}
+	groupID := ecr.Groups[0].GroupId
+	if got := svc.Tags(*groupID)["for"]; got != "endpoints" {
+		t.Errorf("endpoint group is for %q, want endpoints", got)
+	}
+	groups, _ := svc.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{GroupIds: []*string{groupID}})
+	var https bool
+	for _, p := range groups.SecurityGroups[0].IpPermissions {
+		for _, r := range p.IpRanges {
+			https = https || aws.Int64Value(p.FromPort) == 443 && aws.StringValue(r.CidrIp) == cfg.VPCCIDRBlock
+		}
+	}
+	if !https {
+		t.Errorf("endpoint group rules = %v, want HTTPS from the VPC", groups.SecurityGroups[0].IpPermissions)
+	}
+	if n := types(state)["vpc endpoint"]; n != 3 {
+		t.Errorf("state has %d vpc endpoints, want 3", n)
+	}
+
+	// A second run finds everything.
+	before := svc.ResourceCount()
+	plan := &awsextra.Plan{}
+	if err := awsextra.PlanVPCNetworking(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	if n := plan.Count("create") + plan.Count("update"); n != 0 {
+		t.Errorf("plan after up changes %d things, want none\n%s", n, plan)
+	}
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, state); err != nil {
+		t.Fatal(err)
+	}
+	if after := svc.ResourceCount(); after != before {
+		t.Errorf("second run went from %d resources to %d", before, after)
+	}
+
+	if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, cfg); err != nil {
+		t.Fatalf("DeleteVPCNetworking: %v", err)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left after delete", n)
+	}
+}
+
+// Adding a private subnet adds it to the endpoints already there.
+func TestCreateVPCNetworkingEndpointsGrow(t *testing.T) {
+	cfg := endpointConfig()
+	cfg.SubnetCIDRs, cfg.PrivateSubnetCIDRs = cfg.SubnetCIDRs[:1], cfg.PrivateSubnetCIDRs[:1]
+	svc := awsextratest.NewEC2("us-west-2")
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	grown := endpointConfig()
+	plan := &awsextra.Plan{}
+	if err := awsextra.PlanVPCNetworking(context.Background(), svc, grown, plan); err != nil {
+		t.Fatal(err)
+	}
+	if got := actions(plan, "vpc endpoint"); len(got) != 3 || got[0] != "update" || got[1] != "update" || got[2] != "update" {
+		t.Errorf("plan for the endpoints = %v, want 3 updates\n%s", got, plan)
+	}
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, grown, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := endpoints(t, svc, vpcID)
+	if n := len(found["com.amazonaws.us-west-2.s3"].RouteTableIds); n != 3 {
+		t.Errorf("s3 endpoint is in %d route tables, want 3", n)
+	}
+	if n := len(found["com.amazonaws.us-west-2.ecr.api"].SubnetIds); n != 2 {
+		t.Errorf("ecr.api endpoint is in %d subnets, want 2", n)
+	}
+}
+
+func TestDeleteStateResourcesEndpoints(t *testing.T) {
+	cfg := endpointConfig()
+	svc := awsextratest.NewEC2("us-west-2")
+	state := upWithState(t, svc, cfg)
+
+	if _, err := awsextra.DeleteStateResources(context.Background(), svc, cfg, state); err != nil {
+		t.Fatalf("DeleteStateResources: %v", err)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left after delete", n)
+	}
+}
@@ -1,32 +1,86 @@
 package awsextra
 
 import (
-	"fmt"
-	"os"
+	"errors"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
 )
 
+// ErrCIDRConflict is wrapped by the error returned from CreateVPCNetworking
+// when another VPC in the region already uses the configured CIDR block, and
+// from PeerVPCs when the two VPCs' CIDR blocks overlap.
+var ErrCIDRConflict = errors.New("conflicting VPC CIDR block")
+
+// ErrManagedInterface is wrapped by the error returned from
+// ForceDeleteVPCNetworking when a network interface owned by another AWS
+// service is left in the VPC.  That service has to be deleted first.
+var ErrManagedInterface = errors.New("network interface is managed by another service")
+
+// Error is returned by every awsextra call that fails.  It records which step
+// of the stack failed, which kind of resource it was working on and, when
+// known, the resource ID and the AWS error code.
+type Error struct {
+	Op       string // Step that failed, eg. "create", "attach", "delete"
+	Resource string // Kind of resource, eg. "vpc", "subnet", "security group"
+	ID       string // Resource ID, if one was known at the time
+	Code     string // AWS error code, eg. "DependencyViolation"
+	Err      error  // Underlying error
+}
+
+func (e *Error) Error() string {
+	msg := e.Op + " " + e.Resource
+	if e.ID != "" {
+		msg += " " + e.ID
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying AWS SDK error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// newError wraps err with the step and resource that failed.  It returns nil
+// if err is nil so it can be used directly on SDK results.
+func newError(op string, resource string, ID *string, err error) error {
+	if err == nil {
+		return nil
+	}
+	e := &Error{Op: op, Resource: resource, Err: err}
+	if ID != nil {
+		e.ID = *ID
+	}
+	if code := errorCode(err); code != nil {
+		e.Code = *code
+	}
+	return e
+}
+
+// isNotFound reports whether err says the resource doesn't exist, which for
+// most kinds of resource is a code ending in ".NotFound".
+func isNotFound(err error) bool {
+	code := errorCode(err)
+	return code != nil && (strings.HasSuffix(*code, ".NotFound") || *code == "NatGatewayNotFound")
+}
+
+// errNotFound returns the error for a describe by ID that came back empty,
+// with the NotFound code EC2 would otherwise have returned for it, so that
+// isNotFound holds for it either way.
+func errNotFound(op string, resource string, ID *string, code string) error {
+	return newError(op, resource, ID, awserr.New(code, "The "+resource+" does not exist", nil))
+}
+
 // Handle various AWS errors
 func errorCode(err error) (errorCode *string) {
-	if awsErr, ok := err.(awserr.Error); ok {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
 		// Eg, "DependencyViolation"
 		newCode := awsErr.Code()
 		return &newCode
 	}
 	return nil
 }
-
-// If an error happened, halt and print this message.
-func haltOnError(err error, message string) {
-	if err != nil {
-		fmt.Println(err)
-		haltError(message)
-	}
-}
-
-// Print message to stderr and exit.
-func haltError(message string) {
-	os.Stderr.WriteString(message)
-	os.Exit(1)
-}
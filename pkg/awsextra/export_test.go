@@ -0,0 +1,17 @@
+package awsextra
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// SetSleep replaces the pause between retries with f until the test ends.
+func SetSleep(t testing.TB, f func(time.Duration)) {
+	old := sleep
+	sleep = func(ctx context.Context, d time.Duration) error {
+		f(d)
+		return ctx.Err()
+	}
+	t.Cleanup(func() { sleep = old })
+}
@@ -0,0 +1,531 @@
+package awsextra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// IAMAPI is the subset of the IAM client used to give flow logs a role to
+// deliver to CloudWatch Logs with.  *iam.IAM satisfies it.
+type IAMAPI interface {
+	GetRoleWithContext(aws.Context, *iam.GetRoleInput, ...request.Option) (*iam.GetRoleOutput, error)
+	CreateRoleWithContext(aws.Context, *iam.CreateRoleInput, ...request.Option) (*iam.CreateRoleOutput, error)
+	PutRolePolicyWithContext(aws.Context, *iam.PutRolePolicyInput, ...request.Option) (*iam.PutRolePolicyOutput, error)
+	DeleteRolePolicyWithContext(aws.Context, *iam.DeleteRolePolicyInput, ...request.Option) (*iam.DeleteRolePolicyOutput, error)
+	DeleteRoleWithContext(aws.Context, *iam.DeleteRoleInput, ...request.Option) (*iam.DeleteRoleOutput, error)
+}
+
+// LogsAPI is the subset of the CloudWatch Logs client used to manage the flow
+// log's log group.  *cloudwatchlogs.CloudWatchLogs satisfies it.
+type LogsAPI interface {
+	CreateLogGroupWithContext(aws.Context, *cloudwatchlogs.CreateLogGroupInput, ...request.Option) (*cloudwatchlogs.CreateLogGroupOutput, error)
+	DescribeLogGroupsWithContext(aws.Context, *cloudwatchlogs.DescribeLogGroupsInput, ...request.Option) (*cloudwatchlogs.DescribeLogGroupsOutput, error)
+	ListTagsLogGroupWithContext(aws.Context, *cloudwatchlogs.ListTagsLogGroupInput, ...request.Option) (*cloudwatchlogs.ListTagsLogGroupOutput, error)
+	DeleteLogGroupWithContext(aws.Context, *cloudwatchlogs.DeleteLogGroupInput, ...request.Option) (*cloudwatchlogs.DeleteLogGroupOutput, error)
+}
+
+// FlowLog is the VPC's flow log, which records the traffic through every
+// network interface in it.
+type FlowLog struct {
+	// ec2.TrafficTypeAll, the default, ec2.TrafficTypeAccept or
+	// ec2.TrafficTypeReject.
+	TrafficType string
+
+	// ec2.LogDestinationTypeCloudWatchLogs, the default, or
+	// ec2.LogDestinationTypeS3.
+	DestinationType string
+
+	// The log group's name, or the bucket's ARN with an optional folder, eg.
+	// "arn:aws:s3:::my-bucket/flow-logs/".
+	Destination string
+
+	// The fields of each record, eg. "${srcaddr} ${dstaddr} ${action}".  If
+	// empty, EC2's default format is used.
+	LogFormat string
+
+	// The seconds over which a record aggregates packets: 60 or 600, the
+	// default.
+	MaxAggregationInterval int64
+
+	// Delivery to CloudWatch Logs goes through an IAM role, either RoleARN or,
+	// if CreateRole is set, one made for the stack.
+	RoleARN    string
+	CreateRole bool
+
+	// Create the log group with the stack's tag if it doesn't exist, and
+	// delete it with the stack.  Otherwise EC2 creates the group itself and
+	// it stays behind.
+	CreateLogGroup bool
+	DeleteLogGroup bool
+}
+
+// The role's name, which is the same in every region so it includes the
+// region to keep stacks apart, as well as the stack's tag.  Characters IAM
+// doesn't allow in a name become dashes.
+func (cfg *Config) flowLogRoleName() string {
+	name := "flowlogs-" + cfg.TagKey + "-" + cfg.TagValue + "-" + cfg.Region
+	return strings.Map(func(r rune) rune {
+		if r < 0x80 && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_+=,.@-", r)) {
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+func (fl *FlowLog) trafficType() string {
+	if fl.TrafficType == "" {
+		return ec2.TrafficTypeAll
+	}
+	return fl.TrafficType
+}
+
+func (fl *FlowLog) destinationType() string {
+	if fl.DestinationType == "" {
+		return ec2.LogDestinationTypeCloudWatchLogs
+	}
+	return fl.DestinationType
+}
+
+func (fl *FlowLog) maxAggregationInterval() int64 {
+	if fl.MaxAggregationInterval == 0 {
+		return 600
+	}
+	return fl.MaxAggregationInterval
+}
+
+func (fl *FlowLog) validate(cfg *Config) error {
+	switch fl.trafficType() {
+	case ec2.TrafficTypeAll, ec2.TrafficTypeAccept, ec2.TrafficTypeReject:
+	default:
+		return fmt.Errorf("traffic-type: %q is not %s, %s or %s", fl.TrafficType, ec2.TrafficTypeAll, ec2.TrafficTypeAccept, ec2.TrafficTypeReject)
+	}
+	switch fl.maxAggregationInterval() {
+	case 60, 600:
+	default:
+		return fmt.Errorf("max-aggregation-interval: %d is not 60 or 600", fl.MaxAggregationInterval)
+	}
+	if fl.Destination == "" {
+		return errors.New("destination: is required")
+	}
+	switch fl.destinationType() {
+	case ec2.LogDestinationTypeCloudWatchLogs:
+		if (fl.RoleARN == "") == !fl.CreateRole {
+			return errors.New("role-arn: one of role-arn and create-role is required")
+		}
+		if name := cfg.flowLogRoleName(); fl.CreateRole && len(name) > 64 {
+			return fmt.Errorf("create-role: the role name %s is longer than IAM's 64 characters", name)
+		}
+	case ec2.LogDestinationTypeS3:
+		if !strings.HasPrefix(fl.Destination, "arn:aws:s3:::") {
+			return fmt.Errorf("destination: %q is not an S3 bucket ARN", fl.Destination)
+		}
+		if fl.RoleARN != "" || fl.CreateRole || fl.CreateLogGroup || fl.DeleteLogGroup {
+			return errors.New("destination-type: s3 takes no role or log group")
+		}
+	default:
+		return fmt.Errorf("destination-type: %q is not %q or %q", fl.DestinationType, ec2.LogDestinationTypeCloudWatchLogs, ec2.LogDestinationTypeS3)
+	}
+	return nil
+}
+
+// Does the flow log deliver where and what fl says, to roleARN?
+func (fl *FlowLog) matches(flowLog *ec2.FlowLog, roleARN string) bool {
+	destination := aws.StringValue(flowLog.LogDestination)
+	if fl.destinationType() == ec2.LogDestinationTypeCloudWatchLogs {
+		destination = aws.StringValue(flowLog.LogGroupName)
+	}
+	return destination == fl.Destination &&
+		aws.StringValue(flowLog.LogDestinationType) == fl.destinationType() &&
+		aws.StringValue(flowLog.TrafficType) == fl.trafficType() &&
+		aws.Int64Value(flowLog.MaxAggregationInterval) == fl.maxAggregationInterval() &&
+		(fl.LogFormat == "" || aws.StringValue(flowLog.LogFormat) == fl.LogFormat) &&
+		aws.StringValue(flowLog.DeliverLogsPermissionArn) == roleARN
+}
+
+func (fl *FlowLog) String() string {
+	return fl.trafficType() + " traffic to " + fl.destinationType() + " " + fl.Destination
+}
+
+// CreateFlowLog ... makes sure the VPC has the flow log cfg.FlowLog
+// describes, and first the log group and IAM role if it asks for them.  A
+// flow log can't be changed, so one delivering elsewhere is replaced.  The
+// flow log is recorded in state, which may be nil; the log group and role
+// are not EC2 resources and are found again by name.
+func CreateFlowLog(ctx context.Context, svc EC2API, iamSvc IAMAPI, logsSvc LogsAPI, cfg *Config, vpcID *string, state *State) error {
+	fl := cfg.FlowLog
+	if fl == nil {
+		return nil
+	}
+	if fl.CreateLogGroup {
+		if err := ensureLogGroup(ctx, logsSvc, cfg); err != nil {
+			return err
+		}
+	}
+	roleARN := fl.RoleARN
+	if fl.CreateRole {
+		var err error
+		if roleARN, err = ensureFlowLogRole(ctx, iamSvc, cfg); err != nil {
+			return err
+		}
+	}
+
+	flowLog, err := findFlowLog(ctx, svc, cfg, vpcID)
+	if err != nil {
+		return err
+	}
+	if flowLog != nil && fl.matches(flowLog, roleARN) {
+		fmt.Println("Found flow log " + *flowLog.FlowLogId + " of " + fl.String())
+		state.record("flow log", flowLog.FlowLogId, vpcID)
+		return nil
+	}
+	if flowLog != nil {
+		if err := deleteFlowLog(ctx, svc, flowLog.FlowLogId); err != nil {
+			return err
+		}
+		fmt.Println("Deleted flow log " + *flowLog.FlowLogId + " to replace it")
+		state.forget(*flowLog.FlowLogId)
+	}
+
+	params := &ec2.CreateFlowLogsInput{
+		ResourceIds:            []*string{vpcID},                        // Required
+		ResourceType:           aws.String(ec2.FlowLogsResourceTypeVpc), // Required
+		TrafficType:            aws.String(fl.trafficType()),
+		LogDestinationType:     aws.String(fl.destinationType()),
+		MaxAggregationInterval: aws.Int64(fl.maxAggregationInterval()),
+	}
+	if fl.destinationType() == ec2.LogDestinationTypeCloudWatchLogs {
+		params.LogGroupName = aws.String(fl.Destination)
+		params.DeliverLogsPermissionArn = aws.String(roleARN)
+	} else {
+		params.LogDestination = aws.String(fl.Destination)
+	}
+	if fl.LogFormat != "" {
+		params.LogFormat = aws.String(fl.LogFormat)
+	}
+	// A new role takes a while to be assumable everywhere.
+	var flowLogID *string
+	err = cfg.Retry.do(ctx, isPending, func() error {
+		resp, err := svc.CreateFlowLogsWithContext(ctx, params)
+		if err != nil {
+			return newError("create", "flow log", vpcID, err)
+		}
+		for _, item := range resp.Unsuccessful {
+			message := aws.StringValue(item.Error.Message)
+			if strings.Contains(message, "assume") {
+				return newError("create", "flow log", vpcID, fmt.Errorf("%w: %s", errPending, message))
+			}
+			return newError("create", "flow log", vpcID, errors.New(message))
+		}
+		flowLogID = resp.FlowLogIds[0]
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("Created flow log " + *flowLogID + " of " + fl.String())
+	state.record("flow log", flowLogID, vpcID)
+	return tagIt(ctx, svc, cfg, "flow log", flowLogID, cfg.TagKey, cfg.TagValue)
+}
+
+// The stack's flow log of the VPC.
+func findFlowLog(ctx context.Context, svc EC2API, cfg *Config, vpcID *string) (*ec2.FlowLog, error) {
+	resp, err := svc.DescribeFlowLogsWithContext(ctx, &ec2.DescribeFlowLogsInput{
+		Filter: []*ec2.Filter{
+			cfg.tagFilter(),
+			{Name: aws.String("resource-id"), Values: []*string{vpcID}},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "flow logs", vpcID, err)
+	}
+	if len(resp.FlowLogs) == 0 {
+		return nil, nil
+	}
+	return resp.FlowLogs[0], nil
+}
+
+func deleteFlowLog(ctx context.Context, svc EC2API, flowLogID *string) error {
+	resp, err := svc.DeleteFlowLogsWithContext(ctx, &ec2.DeleteFlowLogsInput{FlowLogIds: []*string{flowLogID}})
+	if err != nil {
+		return newError("delete", "flow log", flowLogID, err)
+	}
+	for _, item := range resp.Unsuccessful {
+		err := awserr.New(aws.StringValue(item.Error.Code), aws.StringValue(item.Error.Message), nil)
+		return newError("delete", "flow log", flowLogID, err)
+	}
+	return nil
+}
+
+// The log group, or nil.
+func findLogGroup(ctx context.Context, logsSvc LogsAPI, name string) (*cloudwatchlogs.LogGroup, error) {
+	resp, err := logsSvc.DescribeLogGroupsWithContext(ctx, &cloudwatchlogs.DescribeLogGroupsInput{
+		LogGroupNamePrefix: aws.String(name),
+	})
+	if err != nil {
+		return nil, newError("describe", "log group", aws.String(name), err)
+	}
+	for _, group := range resp.LogGroups {
+		if aws.StringValue(group.LogGroupName) == name {
+			return group, nil
+		}
+	}
+	return nil, nil
+}
+
+func ensureLogGroup(ctx context.Context, logsSvc LogsAPI, cfg *Config) error {
+	name := aws.String(cfg.FlowLog.Destination)
+	group, err := findLogGroup(ctx, logsSvc, *name)
+	if err != nil {
+		return err
+	}
+	if group != nil {
+		fmt.Println("Found log group " + *name)
+		return nil
+	}
+	_, err = logsSvc.CreateLogGroupWithContext(ctx, &cloudwatchlogs.CreateLogGroupInput{
+		LogGroupName: name,
+		Tags:         map[string]*string{cfg.TagKey: aws.String(cfg.TagValue)},
+	})
+	if err != nil {
+		return newError("create", "log group", name, err)
+	}
+	fmt.Println("Created log group " + *name)
+	return nil
+}
+
+// The policy letting the flow logs service assume the role.
+const flowLogTrustPolicy = `{
+  "Version": "2012-10-17",
+  "Statement": [{
+    "Effect": "Allow",
+    "Principal": {"Service": "vpc-flow-logs.amazonaws.com"},
+    "Action": "sts:AssumeRole"
+  }]
+}`
+
+// The policy letting the role write to the log group and nothing else.
+func flowLogRolePolicy(cfg *Config) string {
+	group := "arn:aws:logs:" + cfg.Region + ":*:log-group:" + cfg.FlowLog.Destination
+	return `{
+  "Version": "2012-10-17",
+  "Statement": [{
+    "Effect": "Allow",
+    "Action": ["logs:CreateLogGroup", "logs:CreateLogStream", "logs:PutLogEvents", "logs:DescribeLogGroups", "logs:DescribeLogStreams"],
+    "Resource": ["` + group + `", "` + group + `:*"]
+  }]
+}`
+}
+
+const flowLogRolePolicyName = "deliver-flow-logs"
+
+// The stack's flow log role, or nil.  A role of that name without the
+// stack's tag is an error rather than something to take over.
+func findFlowLogRole(ctx context.Context, iamSvc IAMAPI, cfg *Config) (*iam.Role, error) {
+	name := aws.String(cfg.flowLogRoleName())
+	resp, err := iamSvc.GetRoleWithContext(ctx, &iam.GetRoleInput{RoleName: name})
+	if code := errorCode(err); code != nil && *code == iam.ErrCodeNoSuchEntityException {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, newError("get", "iam role", name, err)
+	}
+	for _, tag := range resp.Role.Tags {
+		if aws.StringValue(tag.Key) == cfg.TagKey && aws.StringValue(tag.Value) == cfg.TagValue {
+			return resp.Role, nil
+		}
+	}
+	return nil, newError("get", "iam role", name, ErrNotInStack)
+}
+
+// Find the stack's flow log role or create it, and give it the policy to
+// write to the log group.  It returns the role's ARN.
+func ensureFlowLogRole(ctx context.Context, iamSvc IAMAPI, cfg *Config) (string, error) {
+	name := aws.String(cfg.flowLogRoleName())
+	role, err := findFlowLogRole(ctx, iamSvc, cfg)
+	if err != nil {
+		return "", err
+	}
+	if role != nil {
+		fmt.Println("Found IAM role " + *name)
+	} else {
+		resp, err := iamSvc.CreateRoleWithContext(ctx, &iam.CreateRoleInput{
+			RoleName:                 name,                           // Required
+			AssumeRolePolicyDocument: aws.String(flowLogTrustPolicy), // Required
+			Description:              aws.String("Delivers the flow logs of VPC " + cfg.TagKey + "=" + cfg.TagValue),
+			Tags:                     []*iam.Tag{{Key: aws.String(cfg.TagKey), Value: aws.String(cfg.TagValue)}},
+		})
+		if err != nil {
+			return "", newError("create", "iam role", name, err)
+		}
+		role = resp.Role
+		fmt.Println("Created IAM role " + *name)
+	}
+
+	_, err = iamSvc.PutRolePolicyWithContext(ctx, &iam.PutRolePolicyInput{
+		RoleName:       name,
+		PolicyName:     aws.String(flowLogRolePolicyName),
+		PolicyDocument: aws.String(flowLogRolePolicy(cfg)),
+	})
+	if err != nil {
+		return "", newError("put policy on", "iam role", name, err)
+	}
+	return aws.StringValue(role.Arn), nil
+}
+
+// DeleteFlowLogResources ... deletes the IAM role CreateFlowLog made, and
+// the log group if cfg.FlowLog.DeleteLogGroup is set, once the stack's VPC
+// and its flow log are gone.  Only a role and group carrying the stack's tag
+// are deleted, and ones already gone are skipped.
+func DeleteFlowLogResources(ctx context.Context, iamSvc IAMAPI, logsSvc LogsAPI, cfg *Config) error {
+	fl := cfg.FlowLog
+	if fl == nil {
+		return nil
+	}
+	if fl.CreateRole {
+		role, err := findFlowLogRole(ctx, iamSvc, cfg)
+		if err != nil && !errors.Is(err, ErrNotInStack) {
+			return err
+		}
+		if role != nil {
+			if err := deleteFlowLogRole(ctx, iamSvc, role.RoleName); err != nil {
+				return err
+			}
+		}
+	}
+	if fl.DeleteLogGroup {
+		owned, err := ownedLogGroup(ctx, logsSvc, cfg)
+		if err != nil || !owned {
+			return err
+		}
+		name := aws.String(fl.Destination)
+		_, err = logsSvc.DeleteLogGroupWithContext(ctx, &cloudwatchlogs.DeleteLogGroupInput{LogGroupName: name})
+		if code := errorCode(err); code != nil && *code == cloudwatchlogs.ErrCodeResourceNotFoundException {
+			return nil
+		}
+		if err != nil {
+			return newError("delete", "log group", name, err)
+		}
+		fmt.Println("Deleted log group " + *name)
+	}
+	return nil
+}
+
+// Delete the flow log role and its policy.
+func deleteFlowLogRole(ctx context.Context, iamSvc IAMAPI, name *string) error {
+	_, err := iamSvc.DeleteRolePolicyWithContext(ctx, &iam.DeleteRolePolicyInput{
+		RoleName:   name,
+		PolicyName: aws.String(flowLogRolePolicyName),
+	})
+	if code := errorCode(err); err != nil && (code == nil || *code != iam.ErrCodeNoSuchEntityException) {
+		return newError("delete policy from", "iam role", name, err)
+	}
+	if _, err := iamSvc.DeleteRoleWithContext(ctx, &iam.DeleteRoleInput{RoleName: name}); err != nil {
+		return newError("delete", "iam role", name, err)
+	}
+	fmt.Println("Deleted IAM role " + *name)
+	return nil
+}
+
+// Is the flow log's log group there and tagged as part of the stack?
+func ownedLogGroup(ctx context.Context, logsSvc LogsAPI, cfg *Config) (bool, error) {
+	name := aws.String(cfg.FlowLog.Destination)
+	group, err := findLogGroup(ctx, logsSvc, *name)
+	if err != nil || group == nil {
+		return false, err
+	}
+	resp, err := logsSvc.ListTagsLogGroupWithContext(ctx, &cloudwatchlogs.ListTagsLogGroupInput{LogGroupName: name})
+	if err != nil {
+		return false, newError("list tags of", "log group", name, err)
+	}
+	return aws.StringValue(resp.Tags[cfg.TagKey]) == cfg.TagValue, nil
+}
+
+// PlanFlowLog ... adds what CreateFlowLog would do to plan.
+func PlanFlowLog(ctx context.Context, svc EC2API, iamSvc IAMAPI, logsSvc LogsAPI, cfg *Config, plan *Plan) error {
+	fl := cfg.FlowLog
+	if fl == nil {
+		return nil
+	}
+	if fl.CreateLogGroup {
+		group, err := findLogGroup(ctx, logsSvc, fl.Destination)
+		if err != nil {
+			return err
+		}
+		if group != nil {
+			plan.add("exists", "log group", nil, fl.Destination)
+		} else {
+			plan.add("create", "log group", nil, fl.Destination)
+		}
+	}
+	roleARN := fl.RoleARN
+	if fl.CreateRole {
+		role, err := findFlowLogRole(ctx, iamSvc, cfg)
+		if err != nil {
+			return err
+		}
+		if role != nil {
+			roleARN = aws.StringValue(role.Arn)
+			plan.add("exists", "iam role", nil, cfg.flowLogRoleName())
+		} else {
+			plan.add("create", "iam role", nil, cfg.flowLogRoleName())
+		}
+	}
+
+	vpc, err := findVPC(ctx, svc, cfg)
+	if err != nil {
+		return err
+	}
+	var flowLog *ec2.FlowLog
+	if vpc != nil {
+		if flowLog, err = findFlowLog(ctx, svc, cfg, vpc.VpcId); err != nil {
+			return err
+		}
+	}
+	switch {
+	case flowLog == nil:
+		plan.add("create", "flow log", nil, fl.String())
+	case !fl.matches(flowLog, roleARN):
+		plan.add("update", "flow log", flowLog.FlowLogId, "replace with "+fl.String())
+	default:
+		plan.add("exists", "flow log", flowLog.FlowLogId, fl.String())
+	}
+	return nil
+}
+
+// PlanDeleteFlowLogResources ... adds what DeleteFlowLogResources would
+// delete to plan.
+func PlanDeleteFlowLogResources(ctx context.Context, iamSvc IAMAPI, logsSvc LogsAPI, cfg *Config, plan *Plan) error {
+	fl := cfg.FlowLog
+	if fl == nil {
+		return nil
+	}
+	if fl.CreateRole {
+		role, err := findFlowLogRole(ctx, iamSvc, cfg)
+		if err != nil && !errors.Is(err, ErrNotInStack) {
+			return err
+		}
+		if role != nil {
+			plan.add("delete", "iam role", nil, cfg.flowLogRoleName())
+		}
+	}
+	if fl.DeleteLogGroup {
+		owned, err := ownedLogGroup(ctx, logsSvc, cfg)
+		if err != nil {
+			return err
+		}
+		if owned {
+			plan.add("delete", "log group", nil, fl.Destination)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package awsextra_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/jeremyd/structureag/pkg/awsextra"
+	"github.com/jeremyd/structureag/pkg/awsextra/awsextratest"
+)
+
+func flowLogConfig() *awsextra.Config {
+	cfg := testConfig(1)
+	cfg.FlowLog = &awsextra.FlowLog{
+		Destination:    "/structureag/test/flow-logs",
+		CreateRole:     true,
+		CreateLogGroup: true,
+		DeleteLogGroup: true,
+	}
+	return cfg
+}
+
+func flowLogs(t *testing.T, svc *awsextratest.EC2) []*ec2.FlowLog {
+	t.Helper()
+	resp, err := svc.DescribeFlowLogs(&ec2.DescribeFlowLogsInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.FlowLogs
+}
+
+func count(list []string, s string) int {
+	n := 0
+	for _, item := range list {
+		if item == s {
+			n++
+		}
+	}
+	return n
+}
+
+// The IDs of the resources of the type in state.
+func inState(state *awsextra.State, resourceType string) []string {
+	var IDs []string
+	for _, r := range state.Resources {
+		if r.Type == resourceType {
+			IDs = append(IDs, r.ID)
+		}
+	}
+	return IDs
+}
+
+func TestCreateFlowLog(t *testing.T) {
+	cfg := flowLogConfig()
+	svc := awsextratest.NewEC2("us-west-2")
+	iamSvc := awsextratest.NewIAM()
+	logsSvc := awsextratest.NewLogs("us-west-2")
+	state := awsextra.NewState(cfg)
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := awsextra.CreateFlowLog(context.Background(), svc, iamSvc, logsSvc, cfg, vpcID, state); err != nil {
+		t.Fatal(err)
+	}
+
+	fls := flowLogs(t, svc)
+	if len(fls) != 1 {
+		t.Fatalf("%d flow logs, want 1", len(fls))
+	}
+	fl := fls[0]
+	if *fl.ResourceId != *vpcID || *fl.TrafficType != ec2.TrafficTypeAll || *fl.LogGroupName != cfg.FlowLog.Destination {
+		t.Errorf("flow log = %v, want all of %s's traffic to %s", fl, *vpcID, cfg.FlowLog.Destination)
+	}
+	if got := svc.Tags(*fl.FlowLogId)["MYTAG"]; got != "test" {
+		t.Errorf("flow log tag = %q, want test", got)
+	}
+	role, err := iamSvc.GetRole(&iam.GetRoleInput{RoleName: aws.String("flowlogs-MYTAG-test-us-west-2")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *fl.DeliverLogsPermissionArn != *role.Role.Arn {
+		t.Errorf("flow log delivers through %s, want %s", *fl.DeliverLogsPermissionArn, *role.Role.Arn)
+	}
+	if policy := iamSvc.RolePolicy("flowlogs-MYTAG-test-us-west-2", "deliver-flow-logs"); !strings.Contains(policy, cfg.FlowLog.Destination) {
+		t.Errorf("role policy %s doesn't name the log group", policy)
+	}
+	if n := logsSvc.ResourceCount(); n != 1 {
+		t.Errorf("%d log groups, want 1", n)
+	}
+	if n := types(state)["flow log"]; n != 1 {
+		t.Errorf("state has %d flow logs, want 1", n)
+	}
+
+	// A second run finds everything.
+	plan := &awsextra.Plan{}
+	if err := awsextra.PlanFlowLog(context.Background(), svc, iamSvc, logsSvc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	if n := plan.Count("exists"); n != 3 || len(plan.Changes) != 3 {
+		t.Errorf("plan after up = \n%s, want the log group, role and flow log to exist", plan)
+	}
+	if err := awsextra.CreateFlowLog(context.Background(), svc, iamSvc, logsSvc, cfg, vpcID, state); err != nil {
+		t.Fatal(err)
+	}
+	for _, calls := range [][]string{svc.Calls(), iamSvc.Calls(), logsSvc.Calls()} {
+		for _, op := range []string{"CreateFlowLogs", "CreateRole", "CreateLogGroup"} {
+			if n := count(calls, op); n > 1 {
+				t.Errorf("%s called %d times, want once", op, n)
+			}
+		}
+	}
+
+	plan = &awsextra.Plan{}
+	if err := awsextra.PlanDeleteFlowLogResources(context.Background(), iamSvc, logsSvc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	if n := plan.Count("delete"); n != 2 {
+		t.Errorf("plan deletes %d things, want the role and log group\n%s", n, plan)
+	}
+	if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, cfg); err != nil {
+		t.Fatalf("DeleteVPCNetworking: %v", err)
+	}
+	if err := awsextra.DeleteFlowLogResources(context.Background(), iamSvc, logsSvc, cfg); err != nil {
+		t.Fatalf("DeleteFlowLogResources: %v", err)
+	}
+	if n := svc.ResourceCount() + iamSvc.ResourceCount() + logsSvc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left after delete", n)
+	}
+	// Running down again finds nothing to do.
+	if err := awsextra.DeleteFlowLogResources(context.Background(), iamSvc, logsSvc, cfg); err != nil {
+		t.Errorf("second DeleteFlowLogResources: %v", err)
+	}
+}
+
+// Stacks with the same tag value under different keys get a role each.
+func TestCreateFlowLogSameTagValue(t *testing.T) {
+	cfg := flowLogConfig()
+	other := flowLogConfig()
+	other.TagKey = "OTHERTAG"
+	other.VPCCIDRBlock = "10.1.0.0/16"
+	other.SubnetCIDRs = []string{"10.1.0.0/24"}
+	other.FlowLog.Destination = "/structureag/other/flow-logs"
+	svc := awsextratest.NewEC2("us-west-2")
+	iamSvc := awsextratest.NewIAM()
+	logsSvc := awsextratest.NewLogs("us-west-2")
+	for _, c := range []*awsextra.Config{cfg, other} {
+		vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, c, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := awsextra.CreateFlowLog(context.Background(), svc, iamSvc, logsSvc, c, vpcID, nil); err != nil {
+			t.Fatalf("CreateFlowLog for %s: %v", c.TagKey, err)
+		}
+	}
+	if n := iamSvc.ResourceCount(); n != 2 {
+		t.Fatalf("%d roles, want 2", n)
+	}
+
+	if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := awsextra.DeleteFlowLogResources(context.Background(), iamSvc, logsSvc, other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := iamSvc.GetRole(&iam.GetRoleInput{RoleName: aws.String("flowlogs-MYTAG-test-us-west-2")}); err != nil {
+		t.Errorf("the first stack's role: %v", err)
+	}
+}
+
+// A flow log can't be changed, so up replaces one delivering differently.
+func TestCreateFlowLogReplace(t *testing.T) {
+	cfg := testConfig(1)
+	cfg.FlowLog = &awsextra.FlowLog{
+		DestinationType: ec2.LogDestinationTypeS3,
+		Destination:     "arn:aws:s3:::audit-bucket/flow-logs/",
+	}
+	svc := awsextratest.NewEC2("us-west-2")
+	state := awsextra.NewState(cfg)
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := awsextra.CreateFlowLog(context.Background(), svc, nil, nil, cfg, vpcID, state); err != nil {
+		t.Fatal(err)
+	}
+	old := *flowLogs(t, svc)[0].FlowLogId
+
+	cfg.FlowLog.TrafficType = ec2.TrafficTypeReject
+	cfg.FlowLog.MaxAggregationInterval = 60
+	plan := &awsextra.Plan{}
+	if err := awsextra.PlanFlowLog(context.Background(), svc, nil, nil, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	if got := actions(plan, "flow log"); len(got) != 1 || got[0] != "update" {
+		t.Errorf("plan for the flow log = %v, want an update\n%s", got, plan)
+	}
+	if err := awsextra.CreateFlowLog(context.Background(), svc, nil, nil, cfg, vpcID, state); err != nil {
+		t.Fatal(err)
+	}
+	fls := flowLogs(t, svc)
+	if len(fls) != 1 || *fls[0].FlowLogId == old || *fls[0].TrafficType != ec2.TrafficTypeReject || *fls[0].MaxAggregationInterval != 60 {
+		t.Fatalf("flow logs = %v, want one new one of rejected traffic every minute", fls)
+	}
+	if got := inState(state, "flow log"); len(got) != 1 || got[0] != *fls[0].FlowLogId {
+		t.Errorf("state has flow logs %v, want just %s", got, *fls[0].FlowLogId)
+	}
+
+	if _, err := awsextra.DeleteStateResources(context.Background(), svc, cfg, state); err != nil {
+		t.Fatalf("DeleteStateResources: %v", err)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left after delete", n)
+	}
+}
+
+// A role of the stack's name that isn't tagged for it is not taken over, nor
+// deleted.
+func TestCreateFlowLogForeignRole(t *testing.T) {
+	cfg := flowLogConfig()
+	svc := awsextratest.NewEC2("us-west-2")
+	iamSvc := awsextratest.NewIAM()
+	logsSvc := awsextratest.NewLogs("us-west-2")
+	_, err := iamSvc.CreateRole(&iam.CreateRoleInput{
+		RoleName:                 aws.String("flowlogs-MYTAG-test-us-west-2"),
+		AssumeRolePolicyDocument: aws.String("{}"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := awsextra.CreateFlowLog(context.Background(), svc, iamSvc, logsSvc, cfg, vpcID, nil); !errors.Is(err, awsextra.ErrNotInStack) {
+		t.Errorf("CreateFlowLog = %v, want ErrNotInStack", err)
+	}
+	if err := awsextra.DeleteFlowLogResources(context.Background(), iamSvc, logsSvc, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if n := iamSvc.ResourceCount(); n != 1 {
+		t.Errorf("%d roles left, want the foreign one", n)
+	}
+}
@@ -0,0 +1,189 @@
+package awsextra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The /64 of each subnet is fixed by its tier and position in the config, so
+// every run gives a subnet the same block.  Public subnets take the first
+// half of the VPC's /56 and private subnets the second.
+const (
+	ipv6PublicOffset  = 0
+	ipv6PrivateOffset = 128
+)
+
+// The VPC's IPv6 block, unless it is being taken away.
+func vpcIPv6Block(vpc *ec2.Vpc) *ec2.VpcIpv6CidrBlockAssociation {
+	for _, assoc := range vpc.Ipv6CidrBlockAssociationSet {
+		switch aws.StringValue(assoc.Ipv6CidrBlockState.State) {
+		case "associating", "associated":
+			return assoc
+		}
+	}
+	return nil
+}
+
+// The subnet's IPv6 block, or "".
+func subnetIPv6Block(subnet *ec2.Subnet) string {
+	for _, assoc := range subnet.Ipv6CidrBlockAssociationSet {
+		switch aws.StringValue(assoc.Ipv6CidrBlockState.State) {
+		case "associating", "associated":
+			return aws.StringValue(assoc.Ipv6CidrBlock)
+		}
+	}
+	return ""
+}
+
+// The index'th /64 of the VPC's /56.
+func subnetIPv6CIDR(vpcBlock *net.IPNet, index int) string {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, vpcBlock.IP.To16())
+	ip[7] = byte(index)
+	return (&net.IPNet{IP: ip, Mask: net.CIDRMask(64, 128)}).String()
+}
+
+// Give the VPC an Amazon provided /56 unless it has one, and wait for the
+// block to be associated.  It returns the block.
+func ensureVPCIPv6(ctx context.Context, svc EC2API, cfg *Config, vpcID *string) (*net.IPNet, error) {
+	describe := func() (*ec2.VpcIpv6CidrBlockAssociation, error) {
+		resp, err := svc.DescribeVpcsWithContext(ctx, &ec2.DescribeVpcsInput{VpcIds: []*string{vpcID}})
+		if err != nil {
+			return nil, newError("describe", "vpc", vpcID, err)
+		}
+		return vpcIPv6Block(resp.Vpcs[0]), nil
+	}
+	assoc, err := describe()
+	if err != nil {
+		return nil, err
+	}
+	if assoc == nil {
+		resp, err := svc.AssociateVpcCidrBlockWithContext(ctx, &ec2.AssociateVpcCidrBlockInput{
+			VpcId:                       vpcID,
+			AmazonProvidedIpv6CidrBlock: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, newError("associate an IPv6 block with", "vpc", vpcID, err)
+		}
+		assoc = resp.Ipv6CidrBlockAssociation
+		fmt.Println("Requested an IPv6 block for VPC " + *vpcID)
+	}
+
+	err = cfg.Retry.do(ctx, isPending, func() error {
+		if aws.StringValue(assoc.Ipv6CidrBlockState.State) == "associated" {
+			return nil
+		}
+		var err error
+		if assoc, err = describe(); err != nil {
+			return err
+		}
+		if assoc == nil {
+			return newError("associate an IPv6 block with", "vpc", vpcID, errors.New("the block went away"))
+		}
+		if state := aws.StringValue(assoc.Ipv6CidrBlockState.State); state != "associated" {
+			return newError("associate an IPv6 block with", "vpc", vpcID, fmt.Errorf("%w: still %s", errPending, state))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, block, err := net.ParseCIDR(aws.StringValue(assoc.Ipv6CidrBlock))
+	if err != nil {
+		return nil, newError("associate an IPv6 block with", "vpc", vpcID, err)
+	}
+	fmt.Println("VPC " + *vpcID + " has IPv6 block " + block.String())
+	return block, nil
+}
+
+// Give a subnet made before the stack was dual-stack its /64, and have new
+// instances in it get an IPv6 address.
+func ensureSubnetIPv6(ctx context.Context, svc EC2API, subnet *ec2.Subnet, cidr string) error {
+	if subnetIPv6Block(subnet) == "" {
+		_, err := svc.AssociateSubnetCidrBlockWithContext(ctx, &ec2.AssociateSubnetCidrBlockInput{
+			SubnetId:      subnet.SubnetId,  // Required
+			Ipv6CidrBlock: aws.String(cidr), // Required
+		})
+		if err != nil {
+			return newError("associate IPv6 block "+cidr+" with", "subnet", subnet.SubnetId, err)
+		}
+		fmt.Println("Associated IPv6 block " + cidr + " with subnet " + *subnet.SubnetId)
+	}
+	if aws.BoolValue(subnet.AssignIpv6AddressOnCreation) {
+		return nil
+	}
+	_, err := svc.ModifySubnetAttributeWithContext(ctx, &ec2.ModifySubnetAttributeInput{
+		SubnetId: subnet.SubnetId,
+		AssignIpv6AddressOnCreation: &ec2.AttributeBooleanValue{
+			Value: aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return newError("enable auto assign IPv6 address on", "subnet", subnet.SubnetId, err)
+	}
+	return nil
+}
+
+// Find the stack's egress-only internet gateway in the VPC or create it.
+// Private subnets send their IPv6 traffic out through it, since IPv6
+// addresses are public and NAT gateways only translate IPv4.
+func ensureEgressOnlyIGW(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, state *State) (*string, error) {
+	eigw, err := findEgressOnlyIGW(ctx, svc, cfg, vpcID)
+	if err != nil {
+		return nil, err
+	}
+	if eigw != nil {
+		fmt.Println("Found egress-only IGW " + *eigw.EgressOnlyInternetGatewayId)
+		state.record("egress-only internet gateway", eigw.EgressOnlyInternetGatewayId, vpcID)
+		return eigw.EgressOnlyInternetGatewayId, nil
+	}
+
+	resp, err := svc.CreateEgressOnlyInternetGatewayWithContext(ctx, &ec2.CreateEgressOnlyInternetGatewayInput{
+		VpcId: vpcID, // Required
+	})
+	if err != nil {
+		return nil, newError("create", "egress-only internet gateway", nil, err)
+	}
+	ID := resp.EgressOnlyInternetGateway.EgressOnlyInternetGatewayId
+	fmt.Println("Created egress-only IGW " + *ID)
+	state.record("egress-only internet gateway", ID, vpcID)
+	return ID, tagIt(ctx, svc, cfg, "egress-only internet gateway", ID, cfg.TagKey, cfg.TagValue)
+}
+
+// The stack's egress-only internet gateway attached to the VPC.  EC2 only
+// filters these on tags.
+func findEgressOnlyIGW(ctx context.Context, svc EC2API, cfg *Config, vpcID *string) (*ec2.EgressOnlyInternetGateway, error) {
+	resp, err := svc.DescribeEgressOnlyInternetGatewaysWithContext(ctx, &ec2.DescribeEgressOnlyInternetGatewaysInput{
+		Filters: []*ec2.Filter{cfg.tagFilter()},
+	})
+	if err != nil {
+		return nil, newError("describe", "egress-only internet gateways", vpcID, err)
+	}
+	for _, eigw := range resp.EgressOnlyInternetGateways {
+		for _, attachment := range eigw.Attachments {
+			if aws.StringValue(attachment.VpcId) == aws.StringValue(vpcID) {
+				return eigw, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// planSubnetIPv6 adds what ensureSubnetIPv6 would do to an existing subnet
+// to plan.
+func planSubnetIPv6(cfg *Config, subnet *ec2.Subnet, plan *Plan) {
+	if !cfg.IPv6 {
+		return
+	}
+	switch {
+	case subnetIPv6Block(subnet) == "":
+		plan.add("update", "subnet", subnet.SubnetId, "add an IPv6 /64")
+	case !aws.BoolValue(subnet.AssignIpv6AddressOnCreation):
+		plan.add("update", "subnet", subnet.SubnetId, "assign IPv6 addresses on launch")
+	}
+}
@@ -0,0 +1,192 @@
+package awsextra_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/jeremyd/structureag/pkg/awsextra"
+	"github.com/jeremyd/structureag/pkg/awsextra/awsextratest"
+)
+
+// checkDualStack checks the VPC has a /56, each subnet a /64 of it assigning
+// addresses on launch, and that public subnets route IPv6 to the internet
+// gateway and private ones to an egress-only internet gateway.
+func checkDualStack(t *testing.T, svc *awsextratest.EC2, cfg *awsextra.Config, vpcID *string) {
+	t.Helper()
+	vpcs, _ := svc.DescribeVpcs(&ec2.DescribeVpcsInput{VpcIds: []*string{vpcID}})
+	blocks := vpcs.Vpcs[0].Ipv6CidrBlockAssociationSet
+	if len(blocks) != 1 || aws.StringValue(blocks[0].Ipv6CidrBlockState.State) != "associated" {
+		t.Fatalf("VPC IPv6 blocks = %v, want one associated", blocks)
+	}
+	_, vpcBlock, _ := net.ParseCIDR(*blocks[0].Ipv6CidrBlock)
+	if ones, _ := vpcBlock.Mask.Size(); ones != 56 {
+		t.Errorf("VPC IPv6 block %s is not a /56", vpcBlock)
+	}
+
+	subnets, _ := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{})
+	if want := len(cfg.SubnetCIDRs) + len(cfg.PrivateSubnetCIDRs); len(subnets.Subnets) != want {
+		t.Fatalf("%d subnets, want %d", len(subnets.Subnets), want)
+	}
+	seen := map[string]bool{}
+	for _, subnet := range subnets.Subnets {
+		if len(subnet.Ipv6CidrBlockAssociationSet) != 1 {
+			t.Errorf("subnet %s has %d IPv6 blocks, want 1", *subnet.SubnetId, len(subnet.Ipv6CidrBlockAssociationSet))
+			continue
+		}
+		cidr := *subnet.Ipv6CidrBlockAssociationSet[0].Ipv6CidrBlock
+		ip, block, _ := net.ParseCIDR(cidr)
+		if ones, _ := block.Mask.Size(); ones != 64 || !vpcBlock.Contains(ip) || seen[cidr] {
+			t.Errorf("subnet %s IPv6 block %s is not its own /64 of %s", *subnet.SubnetId, cidr, vpcBlock)
+		}
+		seen[cidr] = true
+		if !aws.BoolValue(subnet.AssignIpv6AddressOnCreation) {
+			t.Errorf("subnet %s doesn't assign IPv6 addresses", *subnet.SubnetId)
+		}
+	}
+
+	if got := routeTo(t, svc, "public", "::/0"); !strings.HasPrefix(got, "igw-") {
+		t.Errorf("public ::/0 goes to %q, want the internet gateway", got)
+	}
+	if len(cfg.PrivateSubnetCIDRs) > 0 {
+		if got := routeTo(t, svc, "private us-west-2a", "::/0"); !strings.HasPrefix(got, "eigw-") {
+			t.Errorf("private ::/0 goes to %q, want an egress-only internet gateway", got)
+		}
+	}
+}
+
+func TestCreateVPCNetworkingIPv6(t *testing.T) {
+	cfg := privateConfig(2, awsextra.NATGatewaysSingle)
+	cfg.IPv6 = true
+	svc := awsextratest.NewEC2("us-west-2")
+	state := awsextra.NewState(cfg)
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDualStack(t, svc, cfg, vpcID)
+	if n := types(state)["egress-only internet gateway"]; n != 1 {
+		t.Errorf("state has %d egress-only internet gateways, want 1", n)
+	}
+
+	// A second run finds everything.
+	before := svc.ResourceCount()
+	plan := &awsextra.Plan{}
+	if err := awsextra.PlanVPCNetworking(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	if n := plan.Count("create") + plan.Count("update"); n != 0 {
+		t.Errorf("plan after up changes %d things, want none\n%s", n, plan)
+	}
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, state); err != nil {
+		t.Fatal(err)
+	}
+	if after := svc.ResourceCount(); after != before {
+		t.Errorf("second run went from %d resources to %d", before, after)
+	}
+
+	if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, cfg); err != nil {
+		t.Fatalf("DeleteVPCNetworking: %v", err)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left after delete", n)
+	}
+}
+
+// Turning ipv6 on for a stack brought up without it adds IPv6 to what is
+// there.
+func TestCreateVPCNetworkingIPv6Upgrade(t *testing.T) {
+	cfg := privateConfig(2, awsextra.NATGatewaysPerZone)
+	svc := awsextratest.NewEC2("us-west-2")
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.IPv6 = true
+	plan := &awsextra.Plan{}
+	if err := awsextra.PlanVPCNetworking(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	if n := plan.Count("update"); n != 4 {
+		t.Errorf("plan updates %d things, want the 4 subnets\n%s", n, plan)
+	}
+	// The /56, the egress-only internet gateway and a ::/0 route in the public
+	// and each private route table.
+	if n := plan.Count("create"); n != 5 {
+		t.Errorf("plan creates %d things, want 5\n%s", n, plan)
+	}
+
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDualStack(t, svc, cfg, vpcID)
+
+	// Going back to IPv4 only leaves the IPv6 routes alone, so down has to
+	// cope with them.
+	cfg.IPv6 = false
+	if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, cfg); err != nil {
+		t.Fatalf("DeleteVPCNetworking: %v", err)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left after delete", n)
+	}
+}
+
+func TestDeleteStateResourcesIPv6(t *testing.T) {
+	cfg := privateConfig(1, awsextra.NATGatewaysSingle)
+	cfg.IPv6 = true
+	svc := awsextratest.NewEC2("us-west-2")
+	state := upWithState(t, svc, cfg)
+
+	if _, err := awsextra.DeleteStateResources(context.Background(), svc, cfg, state); err != nil {
+		t.Fatalf("DeleteStateResources: %v", err)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left after delete", n)
+	}
+}
+
+func TestAuthorizeSecurityGroupsInternalSSHIPv6(t *testing.T) {
+	cfg := testConfig(1)
+	svc := awsextratest.NewEC2("us-west-2")
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sgID, err := awsextra.CreateSecurityGroup(context.Background(), svc, cfg, "default", vpcID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := awsextra.AuthorizeSecurityGroupsInternalSSH(context.Background(), svc, cfg, sgID); err != nil {
+		t.Fatal(err)
+	}
+
+	// A group from before the stack was dual-stack gets just the IPv6 rule.
+	cfg.IPv6 = true
+	plan := &awsextra.Plan{}
+	if err := awsextra.PlanSecurityGroup(context.Background(), svc, cfg, "default", plan); err != nil {
+		t.Fatal(err)
+	}
+	if n := plan.Count("create"); n != 1 {
+		t.Errorf("plan creates %d rules, want the IPv6 SSH one\n%s", n, plan)
+	}
+	for i := 0; i < 2; i++ {
+		if err := awsextra.AuthorizeSecurityGroupsInternalSSH(context.Background(), svc, cfg, sgID); err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+	}
+	resp, _ := svc.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{GroupIds: []*string{sgID}})
+	var ssh6 bool
+	for _, p := range resp.SecurityGroups[0].IpPermissions {
+		for _, r := range p.Ipv6Ranges {
+			ssh6 = ssh6 || aws.Int64Value(p.FromPort) == 22 && aws.StringValue(r.CidrIpv6) == "::/0"
+		}
+	}
+	if !ssh6 {
+		t.Errorf("rules = %v, want SSH from ::/0", resp.SecurityGroups[0].IpPermissions)
+	}
+}
@@ -0,0 +1,519 @@
+package awsextra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// NetworkACLRule is a rule of the network ACL of a tier's subnets.  Each tier
+// with rules gets a network ACL of its own holding them, in the order they
+// are configured, and EC2's final rule denies whatever they don't allow.
+// Tiers without rules keep the VPC's default network ACL, which allows
+// everything.
+type NetworkACLRule struct {
+	// Which subnets the rule is for: RouteTablesPublic, RouteTablesPrivate
+	// or, if empty, both.
+	Tier string
+
+	// Egress rules match traffic leaving the subnets, the others traffic
+	// coming in.
+	Egress bool
+
+	// NetworkACLAllow or NetworkACLDeny.
+	Action string
+
+	// "tcp", "udp", "icmp", "icmpv6", "all" or an IP protocol number.
+	Protocol string
+
+	// The ports a tcp or udp rule matches, all of them if both are 0.
+	// ToPort defaults to FromPort.  ICMP rules match every type and code.
+	FromPort int64
+	ToPort   int64
+
+	// The IPv4 or IPv6 CIDR block the traffic comes from or goes to.
+	CIDR string
+}
+
+// Values of NetworkACLRule.Action.
+const (
+	NetworkACLAllow = "allow"
+	NetworkACLDeny  = "deny"
+)
+
+// Rules are numbered in steps of naclRuleStep, in order, separately for each
+// tier and direction, which leaves room for rules added by hand in between.
+const naclRuleStep = 100
+
+// The highest rule number that can be used; EC2's final rules come after.
+const naclLastRule = 32766
+
+// The protocol numbers of the protocols known by name.
+var naclProtocols = map[string]string{"tcp": "6", "udp": "17", "icmp": "1", "icmpv6": "58", "all": "-1"}
+
+// The rule's IP protocol number, as EC2 reports it.
+func (r NetworkACLRule) protocol() string {
+	if number, ok := naclProtocols[strings.ToLower(r.Protocol)]; ok {
+		return number
+	}
+	return r.Protocol
+}
+
+func (r NetworkACLRule) ports() (int64, int64) {
+	if r.ToPort == 0 && r.FromPort == 0 {
+		return 0, 65535
+	}
+	if r.ToPort == 0 {
+		return r.FromPort, r.FromPort
+	}
+	return r.FromPort, r.ToPort
+}
+
+func (r NetworkACLRule) validate(cfg *Config) error {
+	switch r.Tier {
+	case "", RouteTablesPublic:
+	case RouteTablesPrivate:
+		if len(cfg.PrivateSubnetCIDRs) == 0 {
+			return errors.New("tier: there are no private subnets")
+		}
+	default:
+		return fmt.Errorf("tier: %q is not %q or %q", r.Tier, RouteTablesPublic, RouteTablesPrivate)
+	}
+	switch r.Action {
+	case NetworkACLAllow, NetworkACLDeny:
+	default:
+		return fmt.Errorf("action: %q is not %q or %q", r.Action, NetworkACLAllow, NetworkACLDeny)
+	}
+	protocol := r.protocol()
+	if n, err := strconv.Atoi(protocol); err != nil || n < -1 || n > 255 {
+		return fmt.Errorf("protocol: %q is not tcp, udp, icmp, icmpv6, all or a protocol number", r.Protocol)
+	}
+	ip, _, err := net.ParseCIDR(r.CIDR)
+	if err != nil {
+		return fmt.Errorf("cidr: %v", err)
+	}
+	switch {
+	case protocol == "1" && ip.To4() == nil:
+		return errors.New("protocol: icmp rules are for IPv4, use icmpv6")
+	case protocol == "58" && ip.To4() != nil:
+		return errors.New("protocol: icmpv6 rules are for IPv6, use icmp")
+	}
+	from, to := r.ports()
+	if protocol != "6" && protocol != "17" {
+		if r.FromPort != 0 || r.ToPort != 0 {
+			return errors.New("from-port: only tcp and udp rules have ports")
+		}
+	} else if from < 0 || to > 65535 || from > to {
+		return fmt.Errorf("from-port: %d-%d is not a port range", from, to)
+	}
+	return nil
+}
+
+// The entry for the rule, numbered n.
+func (r NetworkACLRule) entry(n int64) *ec2.NetworkAclEntry {
+	e := &ec2.NetworkAclEntry{
+		RuleNumber: aws.Int64(n),
+		Egress:     aws.Bool(r.Egress),
+		RuleAction: aws.String(r.Action),
+		Protocol:   aws.String(r.protocol()),
+	}
+	// EC2 reports the block the way ParseCIDR prints it.
+	ip, block, _ := net.ParseCIDR(r.CIDR)
+	if ip.To4() != nil {
+		e.CidrBlock = aws.String(block.String())
+	} else {
+		e.Ipv6CidrBlock = aws.String(block.String())
+	}
+	switch *e.Protocol {
+	case "6", "17":
+		from, to := r.ports()
+		e.PortRange = &ec2.PortRange{From: aws.Int64(from), To: aws.Int64(to)}
+	case "1", "58":
+		e.IcmpTypeCode = &ec2.IcmpTypeCode{Type: aws.Int64(-1), Code: aws.Int64(-1)}
+	}
+	return e
+}
+
+// The tier's network ACL entries, numbered, or none if it keeps the default
+// network ACL.
+func (cfg *Config) networkACLEntries(tier string) []*ec2.NetworkAclEntry {
+	if tier == RouteTablesPrivate && len(cfg.PrivateSubnetCIDRs) == 0 {
+		return nil
+	}
+	next := map[bool]int64{}
+	var entries []*ec2.NetworkAclEntry
+	for _, r := range cfg.NetworkACLRules {
+		if r.Tier != "" && r.Tier != tier {
+			continue
+		}
+		next[r.Egress] += naclRuleStep
+		entries = append(entries, r.entry(next[r.Egress]))
+	}
+	return entries
+}
+
+// Eg. "ingress 100 allow tcp 443-443 from 0.0.0.0/0".  Entries that differ in
+// anything awsextra sets have different details.
+func entryDetail(e *ec2.NetworkAclEntry) string {
+	direction, way := "ingress", "from"
+	if aws.BoolValue(e.Egress) {
+		direction, way = "egress", "to"
+	}
+	protocol := aws.StringValue(e.Protocol)
+	for name, number := range naclProtocols {
+		if number == protocol {
+			protocol = name
+		}
+	}
+	detail := fmt.Sprintf("%s %d %s %s", direction, aws.Int64Value(e.RuleNumber), aws.StringValue(e.RuleAction), protocol)
+	if p := e.PortRange; p != nil {
+		detail += fmt.Sprintf(" %d-%d", aws.Int64Value(p.From), aws.Int64Value(p.To))
+	}
+	if icmp := e.IcmpTypeCode; icmp != nil && (aws.Int64Value(icmp.Type) != -1 || aws.Int64Value(icmp.Code) != -1) {
+		detail += fmt.Sprintf(" type %d code %d", aws.Int64Value(icmp.Type), aws.Int64Value(icmp.Code))
+	}
+	cidr := aws.StringValue(e.CidrBlock)
+	if e.Ipv6CidrBlock != nil {
+		cidr = *e.Ipv6CidrBlock
+	}
+	return detail + " " + way + " " + cidr
+}
+
+// The entry with the rule number in that direction.
+func findNetworkACLEntry(entries []*ec2.NetworkAclEntry, egress bool, number int64) *ec2.NetworkAclEntry {
+	for _, e := range entries {
+		if aws.BoolValue(e.Egress) == egress && aws.Int64Value(e.RuleNumber) == number {
+			return e
+		}
+	}
+	return nil
+}
+
+// createNetworkACLs gives each tier with rules a network ACL of its own with
+// exactly those entries, associated with the tier's subnets.  A tier whose
+// rules were all removed goes back to the VPC's default network ACL.
+func createNetworkACLs(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, state *State) error {
+	for _, tier := range []string{RouteTablesPublic, RouteTablesPrivate} {
+		entries := cfg.networkACLEntries(tier)
+		acl, err := findNetworkACL(ctx, svc, cfg, vpcID, tier)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			if acl == nil {
+				continue
+			}
+			if err := deleteNetworkACL(ctx, svc, acl.NetworkAclId); err != nil {
+				return err
+			}
+			state.forget(*acl.NetworkAclId)
+			fmt.Println("Deleted network acl " + *acl.NetworkAclId + " for " + tier + ", which has no rules left")
+			continue
+		}
+		if err := ensureNetworkACL(ctx, svc, cfg, vpcID, tier, acl, entries, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create the tier's network ACL unless acl is it already, then make its
+// entries match and associate it with the tier's subnets.
+func ensureNetworkACL(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, tier string, acl *ec2.NetworkAcl, entries []*ec2.NetworkAclEntry, state *State) error {
+	if acl != nil {
+		fmt.Println("Found network acl " + *acl.NetworkAclId + " for " + tier)
+	} else {
+		resp, err := svc.CreateNetworkAclWithContext(ctx, &ec2.CreateNetworkAclInput{VpcId: vpcID})
+		if err != nil {
+			return newError("create", "network acl", nil, err)
+		}
+		acl = resp.NetworkAcl
+		fmt.Println("Created network acl " + *acl.NetworkAclId + " for " + tier)
+	}
+	cidrs := cfg.SubnetCIDRs
+	if tier == RouteTablesPrivate {
+		cidrs = cfg.PrivateSubnetCIDRs
+	}
+	subnets, _, err := tierSubnets(ctx, svc, cfg, vpcID, cidrs)
+	if err != nil {
+		return err
+	}
+	var subnetIDs []*string
+	for _, subnet := range subnets {
+		if subnet != nil {
+			subnetIDs = append(subnetIDs, subnet.SubnetId)
+		}
+	}
+	// It goes before the subnets, handing them back to the default one.
+	state.record("network acl", acl.NetworkAclId, append([]*string{vpcID}, subnetIDs...)...)
+	if err := tagIt(ctx, svc, cfg, "network acl", acl.NetworkAclId, cfg.TagKey, cfg.TagValue); err != nil {
+		return err
+	}
+	if err := tagIt(ctx, svc, cfg, "network acl", acl.NetworkAclId, "for", tier); err != nil {
+		return err
+	}
+
+	if err := ensureNetworkACLEntries(ctx, svc, acl, entries); err != nil {
+		return err
+	}
+
+	if len(subnetIDs) == 0 {
+		return nil
+	}
+	associations, err := networkACLAssociations(ctx, svc, vpcID, subnetIDs)
+	if err != nil {
+		return err
+	}
+	for _, subnetID := range subnetIDs {
+		assoc := associations[*subnetID]
+		if assoc == nil {
+			return newError("associate", "network acl", acl.NetworkAclId, fmt.Errorf("with %s: the subnet has no network acl association", *subnetID))
+		}
+		if aws.StringValue(assoc.NetworkAclId) == *acl.NetworkAclId {
+			continue
+		}
+		_, err := svc.ReplaceNetworkAclAssociationWithContext(ctx, &ec2.ReplaceNetworkAclAssociationInput{
+			AssociationId: assoc.NetworkAclAssociationId,
+			NetworkAclId:  acl.NetworkAclId,
+		})
+		if err != nil {
+			return newError("associate", "network acl", acl.NetworkAclId, fmt.Errorf("with %s: %w", *subnetID, err))
+		}
+		fmt.Println("Associated network acl " + *acl.NetworkAclId + " with subnet " + *subnetID)
+	}
+	return nil
+}
+
+// Add the missing entries to the network ACL, replace the ones that differ
+// and delete the ones no longer configured, leaving EC2's final rules.
+func ensureNetworkACLEntries(ctx context.Context, svc EC2API, acl *ec2.NetworkAcl, entries []*ec2.NetworkAclEntry) error {
+	for _, want := range entries {
+		have := findNetworkACLEntry(acl.Entries, *want.Egress, *want.RuleNumber)
+		if have != nil && entryDetail(have) == entryDetail(want) {
+			fmt.Println("Found network acl entry " + entryDetail(want))
+			continue
+		}
+		if have == nil {
+			_, err := svc.CreateNetworkAclEntryWithContext(ctx, &ec2.CreateNetworkAclEntryInput{
+				NetworkAclId:  acl.NetworkAclId,
+				RuleNumber:    want.RuleNumber,
+				Egress:        want.Egress,
+				RuleAction:    want.RuleAction,
+				Protocol:      want.Protocol,
+				CidrBlock:     want.CidrBlock,
+				Ipv6CidrBlock: want.Ipv6CidrBlock,
+				PortRange:     want.PortRange,
+				IcmpTypeCode:  want.IcmpTypeCode,
+			})
+			if err != nil {
+				return newError("create entry "+entryDetail(want)+" in", "network acl", acl.NetworkAclId, err)
+			}
+			fmt.Println("Created network acl entry " + entryDetail(want))
+			continue
+		}
+		_, err := svc.ReplaceNetworkAclEntryWithContext(ctx, &ec2.ReplaceNetworkAclEntryInput{
+			NetworkAclId:  acl.NetworkAclId,
+			RuleNumber:    want.RuleNumber,
+			Egress:        want.Egress,
+			RuleAction:    want.RuleAction,
+			Protocol:      want.Protocol,
+			CidrBlock:     want.CidrBlock,
+			Ipv6CidrBlock: want.Ipv6CidrBlock,
+			PortRange:     want.PortRange,
+			IcmpTypeCode:  want.IcmpTypeCode,
+		})
+		if err != nil {
+			return newError("replace entry "+entryDetail(want)+" in", "network acl", acl.NetworkAclId, err)
+		}
+		fmt.Println("Replaced network acl entry " + entryDetail(want) + ", was " + entryDetail(have))
+	}
+	for _, have := range acl.Entries {
+		if aws.Int64Value(have.RuleNumber) > naclLastRule || findNetworkACLEntry(entries, aws.BoolValue(have.Egress), aws.Int64Value(have.RuleNumber)) != nil {
+			continue
+		}
+		_, err := svc.DeleteNetworkAclEntryWithContext(ctx, &ec2.DeleteNetworkAclEntryInput{
+			NetworkAclId: acl.NetworkAclId,
+			RuleNumber:   have.RuleNumber,
+			Egress:       have.Egress,
+		})
+		if err != nil {
+			return newError("delete entry "+entryDetail(have)+" in", "network acl", acl.NetworkAclId, err)
+		}
+		fmt.Println("Deleted network acl entry " + entryDetail(have))
+	}
+	return nil
+}
+
+// The stack's network ACL for the tier.
+func findNetworkACL(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, tier string) (*ec2.NetworkAcl, error) {
+	resp, err := svc.DescribeNetworkAclsWithContext(ctx, &ec2.DescribeNetworkAclsInput{
+		Filters: []*ec2.Filter{
+			cfg.tagFilter(),
+			{Name: aws.String("vpc-id"), Values: []*string{vpcID}},
+			{Name: aws.String("tag:for"), Values: []*string{aws.String(tier)}},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "network acls", vpcID, err)
+	}
+	if len(resp.NetworkAcls) == 0 {
+		return nil, nil
+	}
+	return resp.NetworkAcls[0], nil
+}
+
+// The network ACL association of each of the subnets.  Every subnet has
+// one, with the default network ACL unless it was replaced.
+func networkACLAssociations(ctx context.Context, svc EC2API, vpcID *string, subnetIDs []*string) (map[string]*ec2.NetworkAclAssociation, error) {
+	resp, err := svc.DescribeNetworkAclsWithContext(ctx, &ec2.DescribeNetworkAclsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("vpc-id"), Values: []*string{vpcID}},
+			{Name: aws.String("association.subnet-id"), Values: subnetIDs},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "network acls", vpcID, err)
+	}
+	associations := map[string]*ec2.NetworkAclAssociation{}
+	for _, acl := range resp.NetworkAcls {
+		for _, assoc := range acl.Associations {
+			associations[aws.StringValue(assoc.SubnetId)] = assoc
+		}
+	}
+	return associations, nil
+}
+
+// The network ACL EC2 created along with the VPC.
+func defaultNetworkACL(ctx context.Context, svc EC2API, vpcID *string) (*ec2.NetworkAcl, error) {
+	resp, err := svc.DescribeNetworkAclsWithContext(ctx, &ec2.DescribeNetworkAclsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("vpc-id"), Values: []*string{vpcID}},
+			{Name: aws.String("default"), Values: []*string{aws.String("true")}},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "network acls", vpcID, err)
+	}
+	if len(resp.NetworkAcls) == 0 {
+		return nil, newError("describe", "network acls", vpcID, fmt.Errorf("the VPC has no default network acl"))
+	}
+	return resp.NetworkAcls[0], nil
+}
+
+// deleteNetworkACL hands the network ACL's subnets back to the VPC's default
+// network ACL, which EC2 requires before it goes, and deletes it.  One that
+// is already gone is left at that.
+func deleteNetworkACL(ctx context.Context, svc EC2API, aclID *string) error {
+	resp, err := svc.DescribeNetworkAclsWithContext(ctx, &ec2.DescribeNetworkAclsInput{NetworkAclIds: []*string{aclID}})
+	if err != nil {
+		return newError("describe", "network acl", aclID, err)
+	}
+	if len(resp.NetworkAcls) == 0 {
+		return nil
+	}
+	acl := resp.NetworkAcls[0]
+	if len(acl.Associations) > 0 {
+		defaultACL, err := defaultNetworkACL(ctx, svc, acl.VpcId)
+		if err != nil {
+			return err
+		}
+		for _, assoc := range acl.Associations {
+			_, err := svc.ReplaceNetworkAclAssociationWithContext(ctx, &ec2.ReplaceNetworkAclAssociationInput{
+				AssociationId: assoc.NetworkAclAssociationId,
+				NetworkAclId:  defaultACL.NetworkAclId,
+			})
+			if err != nil {
+				return newError("restore the default network acl of "+aws.StringValue(assoc.SubnetId)+" from", "network acl", aclID, err)
+			}
+			fmt.Println("Restored the default network acl of subnet " + aws.StringValue(assoc.SubnetId))
+		}
+	}
+	_, err = svc.DeleteNetworkAclWithContext(ctx, &ec2.DeleteNetworkAclInput{NetworkAclId: aclID})
+	return newError("delete", "network acl", aclID, err)
+}
+
+// planNetworkACLs adds what createNetworkACLs would do to plan.  vpcID is nil
+// when the VPC is still to be created.
+func planNetworkACLs(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, plan *Plan) error {
+	for _, tier := range []string{RouteTablesPublic, RouteTablesPrivate} {
+		entries := cfg.networkACLEntries(tier)
+		var acl *ec2.NetworkAcl
+		if vpcID != nil {
+			var err error
+			if acl, err = findNetworkACL(ctx, svc, cfg, vpcID, tier); err != nil {
+				return err
+			}
+		}
+		if len(entries) == 0 {
+			if acl != nil {
+				plan.add("delete", "network acl", acl.NetworkAclId, tier+", no rules left")
+			}
+			continue
+		}
+		if acl == nil {
+			plan.add("create", "network acl", nil, tier)
+			for _, e := range entries {
+				plan.add("create", "network acl entry", nil, entryDetail(e))
+			}
+			continue
+		}
+
+		plan.add("exists", "network acl", acl.NetworkAclId, tier)
+		for _, want := range entries {
+			have := findNetworkACLEntry(acl.Entries, *want.Egress, *want.RuleNumber)
+			switch {
+			case have == nil:
+				plan.add("create", "network acl entry", acl.NetworkAclId, entryDetail(want))
+			case entryDetail(have) != entryDetail(want):
+				plan.add("update", "network acl entry", acl.NetworkAclId, entryDetail(want)+", was "+entryDetail(have))
+			default:
+				plan.add("exists", "network acl entry", acl.NetworkAclId, entryDetail(want))
+			}
+		}
+		for _, have := range acl.Entries {
+			if aws.Int64Value(have.RuleNumber) <= naclLastRule && findNetworkACLEntry(entries, aws.BoolValue(have.Egress), aws.Int64Value(have.RuleNumber)) == nil {
+				plan.add("delete", "network acl entry", acl.NetworkAclId, entryDetail(have))
+			}
+		}
+
+		cidrs := cfg.SubnetCIDRs
+		if tier == RouteTablesPrivate {
+			cidrs = cfg.PrivateSubnetCIDRs
+		}
+		subnets, _, err := tierSubnets(ctx, svc, cfg, vpcID, cidrs)
+		if err != nil {
+			return err
+		}
+		var missing []string
+		pending := false
+		for _, subnet := range subnets {
+			if subnet == nil {
+				pending = true
+			} else if !aclAssociated(acl, *subnet.SubnetId) {
+				missing = append(missing, *subnet.SubnetId)
+			}
+		}
+		if pending {
+			missing = append(missing, "the new subnets")
+		}
+		if len(missing) > 0 {
+			plan.add("update", "network acl", acl.NetworkAclId, tier+", associate with "+strings.Join(missing, ", "))
+		}
+	}
+	return nil
+}
+
+func aclAssociated(acl *ec2.NetworkAcl, subnetID string) bool {
+	for _, assoc := range acl.Associations {
+		if aws.StringValue(assoc.SubnetId) == subnetID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,245 @@
+package awsextra_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/jeremyd/structureag/pkg/awsextra"
+	"github.com/jeremyd/structureag/pkg/awsextra/awsextratest"
+)
+
+func naclConfig() *awsextra.Config {
+	cfg := privateConfig(2, awsextra.NATGatewaysSingle)
+	cfg.NetworkACLRules = []awsextra.NetworkACLRule{
+		{Tier: "public", Action: "allow", Protocol: "tcp", FromPort: 443, CIDR: "0.0.0.0/0"},
+		{Tier: "public", Action: "allow", Protocol: "tcp", FromPort: 1024, ToPort: 65535, CIDR: "0.0.0.0/0"},
+		{Tier: "private", Action: "allow", Protocol: "all", CIDR: "172.25.0.0/16"},
+		{Egress: true, Action: "allow", Protocol: "all", CIDR: "0.0.0.0/0"},
+	}
+	return cfg
+}
+
+func networkACL(t *testing.T, svc *awsextratest.EC2, aclID string) *ec2.NetworkAcl {
+	t.Helper()
+	resp, err := svc.DescribeNetworkAcls(&ec2.DescribeNetworkAclsInput{NetworkAclIds: []*string{aws.String(aclID)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.NetworkAcls[0]
+}
+
+// numbers lists the ACL's rule numbers in one direction, less the catch-all.
+func numbers(acl *ec2.NetworkAcl, egress bool) []int64 {
+	var n []int64
+	for _, e := range acl.Entries {
+		if *e.Egress == egress && *e.RuleNumber != 32767 {
+			n = append(n, *e.RuleNumber)
+		}
+	}
+	return n
+}
+
+func equalNumbers(got []int64, want ...int64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCreateVPCNetworkingNetworkACLs(t *testing.T) {
+	cfg := naclConfig()
+	svc := awsextratest.NewEC2("us-west-2")
+	state := upWithState(t, svc, cfg)
+
+	IDs := inState(state, "network acl")
+	if len(IDs) != 2 {
+		t.Fatalf("state has network acls %v, want 2", IDs)
+	}
+	byTier := map[string]*ec2.NetworkAcl{}
+	for _, ID := range IDs {
+		tags := svc.Tags(ID)
+		if tags["MYTAG"] != "test" {
+			t.Errorf("%s tags = %v, want tagged to the stack", ID, tags)
+		}
+		byTier[tags["for"]] = networkACL(t, svc, ID)
+	}
+	public, private := byTier["public"], byTier["private"]
+	if public == nil || private == nil {
+		t.Fatalf("network acls by tier = %v, want public and private", byTier)
+	}
+	if got := numbers(public, false); !equalNumbers(got, 100, 200) {
+		t.Errorf("public ingress rules = %v, want [100 200]", got)
+	}
+	if got := numbers(public, true); !equalNumbers(got, 100) {
+		t.Errorf("public egress rules = %v, want [100]", got)
+	}
+	if got := numbers(private, false); !equalNumbers(got, 100) {
+		t.Errorf("private ingress rules = %v, want [100]", got)
+	}
+	if len(public.Associations) != 2 || len(private.Associations) != 2 {
+		t.Errorf("associations = %v and %v, want each tier's 2 subnets", public.Associations, private.Associations)
+	}
+	for _, a := range private.Associations {
+		subnets, err := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{SubnetIds: []*string{a.SubnetId}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cidr := *subnets.Subnets[0].CidrBlock; !strings.HasPrefix(cidr, "172.25.10") {
+			t.Errorf("private network acl associated with public subnet %s", cidr)
+		}
+	}
+
+	// Running up again finds what is there.
+	before := len(svc.Calls())
+	up(t, svc, cfg)
+	for _, call := range svc.Calls()[before:] {
+		switch call {
+		case "CreateNetworkAcl", "CreateNetworkAclEntry", "ReplaceNetworkAclEntry", "DeleteNetworkAclEntry", "ReplaceNetworkAclAssociation":
+			t.Errorf("second run called %s", call)
+		}
+	}
+
+	// Each subnet goes back to the default network ACL before it is deleted.
+	before = len(svc.Calls())
+	if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, cfg); err != nil {
+		t.Fatal(err)
+	}
+	calls := svc.Calls()[before:]
+	replaced, deleted := -1, -1
+	for i := len(calls) - 1; i >= 0; i-- {
+		switch calls[i] {
+		case "ReplaceNetworkAclAssociation":
+			replaced = i
+		case "DeleteSubnet":
+			deleted = i
+		}
+	}
+	if replaced < 0 || deleted < 0 || replaced > deleted {
+		t.Errorf("ReplaceNetworkAclAssociation not called before DeleteSubnet: %v", calls)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left", n)
+	}
+}
+
+func TestCreateVPCNetworkingNetworkACLRulesChanged(t *testing.T) {
+	cfg := naclConfig()
+	svc := awsextratest.NewEC2("us-west-2")
+	state := upWithState(t, svc, cfg)
+
+	// Narrow the second public rule and drop the first.
+	cfg.NetworkACLRules = []awsextra.NetworkACLRule{
+		{Tier: "public", Action: "allow", Protocol: "tcp", FromPort: 32768, ToPort: 65535, CIDR: "0.0.0.0/0"},
+		cfg.NetworkACLRules[2],
+		cfg.NetworkACLRules[3],
+	}
+	before := len(svc.Calls())
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, state); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(svc.Calls()[before:], "ReplaceNetworkAclEntry"); n != 1 {
+		t.Errorf("%d ReplaceNetworkAclEntry calls, want 1", n)
+	}
+	if n := count(svc.Calls()[before:], "DeleteNetworkAclEntry"); n != 1 {
+		t.Errorf("%d DeleteNetworkAclEntry calls, want 1", n)
+	}
+	for _, ID := range inState(state, "network acl") {
+		if svc.Tags(ID)["for"] != "public" {
+			continue
+		}
+		acl := networkACL(t, svc, ID)
+		if got := numbers(acl, false); !equalNumbers(got, 100) {
+			t.Errorf("public ingress rules = %v, want [100]", got)
+		}
+		for _, e := range acl.Entries {
+			if *e.RuleNumber == 100 && !*e.Egress && *e.PortRange.From != 32768 {
+				t.Errorf("rule 100 = %v, want from port 32768", e)
+			}
+		}
+	}
+
+	// Without rules, the private subnets go back to the default network ACL.
+	cfg.NetworkACLRules = []awsextra.NetworkACLRule{cfg.NetworkACLRules[0]}
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, state); err != nil {
+		t.Fatal(err)
+	}
+	IDs := inState(state, "network acl")
+	if len(IDs) != 1 || svc.Tags(IDs[0])["for"] != "public" {
+		t.Fatalf("state has network acls %v, want the public one", IDs)
+	}
+	resp, err := svc.DescribeNetworkAcls(&ec2.DescribeNetworkAclsInput{Filters: []*ec2.Filter{
+		{Name: aws.String("default"), Values: []*string{aws.String("true")}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(resp.NetworkAcls[0].Associations); n != 2 {
+		t.Errorf("default network acl has %d associations, want the 2 private subnets", n)
+	}
+}
+
+func TestDeleteStateResourcesNetworkACLs(t *testing.T) {
+	cfg := naclConfig()
+	svc := awsextratest.NewEC2("us-west-2")
+	state := upWithState(t, svc, cfg)
+
+	if _, err := awsextra.DeleteStateResources(context.Background(), svc, cfg, state); err != nil {
+		t.Fatalf("DeleteStateResources: %v", err)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left after delete", n)
+	}
+	if len(state.Resources) != 0 {
+		t.Errorf("state still lists\n%s", state)
+	}
+}
+
+func TestDeleteStateResourcesNetworkACLEmptyDescribe(t *testing.T) {
+	cfg := naclConfig()
+	svc := awsextratest.NewEC2("us-west-2")
+	state := awsextra.NewState(cfg)
+	state.Resources = []awsextra.Resource{{Type: "network acl", ID: "acl-gone"}}
+
+	if _, err := awsextra.DeleteStateResources(context.Background(), &emptyDescribes{svc}, cfg, state); err != nil {
+		t.Fatalf("DeleteStateResources: %v", err)
+	}
+	if len(state.Resources) != 0 {
+		t.Errorf("state still lists\n%s", state)
+	}
+}
+
+func TestPlanVPCNetworkingNetworkACLs(t *testing.T) {
+	cfg := naclConfig()
+	svc := awsextratest.NewEC2("us-west-2")
+
+	plan := &awsextra.Plan{}
+	if err := awsextra.PlanVPCNetworking(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	if got := actions(plan, "network acl"); len(got) != 2 || got[0] != "create" || got[1] != "create" {
+		t.Errorf("network acl actions = %v, want [create create]", got)
+	}
+	if got := actions(plan, "network acl entry"); len(got) != 5 {
+		t.Errorf("network acl entry actions = %v, want 5 creates", got)
+	}
+
+	up(t, svc, cfg)
+	cfg.NetworkACLRules = cfg.NetworkACLRules[1:]
+	plan = &awsextra.Plan{}
+	before := len(svc.Calls())
+	if err := awsextra.PlanVPCNetworking(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	assertDescribeOnly(t, svc.Calls()[before:])
+	if got := actions(plan, "network acl entry"); count(got, "update") != 1 || count(got, "delete") != 1 || count(got, "create") != 0 {
+		t.Errorf("network acl entry actions = %v, want one update and one delete", got)
+	}
+}
@@ -0,0 +1,361 @@
+package awsextra
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// createPrivateSubnets creates the private subnets, the NAT gateways they
+// reach the internet through and, for each zone with private subnets, a
+// route table sending their traffic to that zone's NAT gateway, along with
+// the configured private routes.  In a dual-stack VPC their IPv6 traffic
+// goes out through an egress-only internet gateway instead.  Like the rest
+// of `up`, everything already there is re-used and recorded in state.
+func createPrivateSubnets(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, IGWID *string, publicIDs []*string, ipv6 *net.IPNet, state *State) error {
+	privateIDs, err := createSubnets(ctx, svc, cfg, vpcID, cfg.PrivateSubnetCIDRs, false, ipv6)
+	for _, subnetID := range privateIDs {
+		state.record("subnet", subnetID, vpcID)
+	}
+	if err != nil {
+		return err
+	}
+
+	public, err := describeSubnets(ctx, svc, publicIDs)
+	if err != nil {
+		return err
+	}
+	private, err := describeSubnets(ctx, svc, privateIDs)
+	if err != nil {
+		return err
+	}
+
+	// The zones with private subnets, and the public subnet each one's NAT
+	// gateway goes in.
+	var zones []string
+	privateByZone := map[string][]*string{}
+	natSubnets := map[string]*string{}
+	for _, subnet := range private {
+		zone := aws.StringValue(subnet.AvailabilityZone)
+		if privateByZone[zone] == nil {
+			zones = append(zones, zone)
+		}
+		privateByZone[zone] = append(privateByZone[zone], subnet.SubnetId)
+	}
+	for _, zone := range zones {
+		subnet := natSubnet(cfg, public, zone)
+		if subnet == nil {
+			return newError("create", "nat gateway", nil, fmt.Errorf("no public subnet in %s for the private subnets there", zone))
+		}
+		natSubnets[zone] = subnet.SubnetId
+	}
+
+	natIDs := map[string]*string{}
+	for _, zone := range zones {
+		subnetID := natSubnets[zone]
+		if natIDs[*subnetID] != nil {
+			continue
+		}
+		natID, err := ensureNATGateway(ctx, svc, cfg, subnetID, IGWID, state)
+		if err != nil {
+			return err
+		}
+		natIDs[*subnetID] = natID
+	}
+
+	var eigwID string
+	if cfg.IPv6 {
+		ID, err := ensureEgressOnlyIGW(ctx, svc, cfg, vpcID, state)
+		if err != nil {
+			return err
+		}
+		eigwID = *ID
+	}
+
+	for _, zone := range zones {
+		natID := natIDs[*natSubnets[zone]]
+		routes := cfg.privateRoutes(*natID, eigwID)
+		if _, err := ensureRouteTable(ctx, svc, cfg, vpcID, "private "+zone, routes, privateByZone[zone], state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func describeSubnets(ctx context.Context, svc EC2API, subnetIDs []*string) ([]*ec2.Subnet, error) {
+	if len(subnetIDs) == 0 {
+		return nil, nil
+	}
+	resp, err := svc.DescribeSubnetsWithContext(ctx, &ec2.DescribeSubnetsInput{SubnetIds: subnetIDs})
+	if err != nil {
+		return nil, newError("describe", "subnets", nil, err)
+	}
+	// EC2 doesn't return them in any particular order, and which public
+	// subnet a NAT gateway goes in has to be the same on every run.
+	byID := map[string]*ec2.Subnet{}
+	for _, subnet := range resp.Subnets {
+		byID[aws.StringValue(subnet.SubnetId)] = subnet
+	}
+	subnets := make([]*ec2.Subnet, 0, len(subnetIDs))
+	for _, ID := range subnetIDs {
+		if subnet := byID[aws.StringValue(ID)]; subnet != nil {
+			subnets = append(subnets, subnet)
+		}
+	}
+	return subnets, nil
+}
+
+// Find the stack's NAT gateway in the subnet or create it, with an elastic IP,
+// and wait for it to be available.
+func ensureNATGateway(ctx context.Context, svc EC2API, cfg *Config, subnetID *string, IGWID *string, state *State) (*string, error) {
+	nat, err := findNATGateway(ctx, svc, cfg, subnetID)
+	if err != nil {
+		return nil, err
+	}
+	if nat != nil {
+		fmt.Println("Found NAT gateway " + *nat.NatGatewayId)
+		dependsOn := []*string{subnetID, IGWID}
+		for _, address := range nat.NatGatewayAddresses {
+			state.record("elastic ip", address.AllocationId)
+			dependsOn = append(dependsOn, address.AllocationId)
+		}
+		state.record("nat gateway", nat.NatGatewayId, dependsOn...)
+		return nat.NatGatewayId, waitNATGatewayAvailable(ctx, svc, cfg, nat.NatGatewayId)
+	}
+
+	allocationID, err := ensureAddress(ctx, svc, cfg)
+	state.record("elastic ip", allocationID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := svc.CreateNatGatewayWithContext(ctx, &ec2.CreateNatGatewayInput{
+		SubnetId:     subnetID,     // Required
+		AllocationId: allocationID, // Required
+	})
+	if err != nil {
+		return nil, newError("create", "nat gateway", nil, err)
+	}
+	natID := resp.NatGateway.NatGatewayId
+	fmt.Println("Created NAT gateway " + *natID)
+	state.record("nat gateway", natID, subnetID, allocationID, IGWID)
+	if err := tagIt(ctx, svc, cfg, "nat gateway", natID, cfg.TagKey, cfg.TagValue); err != nil {
+		return natID, err
+	}
+	return natID, waitNATGatewayAvailable(ctx, svc, cfg, natID)
+}
+
+// Re-use a tagged elastic IP an earlier run allocated but didn't get to use,
+// or allocate a new one.
+func ensureAddress(ctx context.Context, svc EC2API, cfg *Config) (*string, error) {
+	resp, err := svc.DescribeAddressesWithContext(ctx, &ec2.DescribeAddressesInput{
+		Filters: []*ec2.Filter{cfg.tagFilter()},
+	})
+	if err != nil {
+		return nil, newError("describe", "elastic ips", nil, err)
+	}
+	for _, address := range resp.Addresses {
+		if address.AssociationId == nil && address.NetworkInterfaceId == nil {
+			fmt.Println("Found elastic IP " + *address.AllocationId)
+			return address.AllocationId, nil
+		}
+	}
+
+	allocated, err := svc.AllocateAddressWithContext(ctx, &ec2.AllocateAddressInput{Domain: aws.String("vpc")})
+	if err != nil {
+		return nil, newError("allocate", "elastic ip", nil, err)
+	}
+	fmt.Println("Allocated elastic IP " + *allocated.AllocationId + " " + aws.StringValue(allocated.PublicIp))
+	return allocated.AllocationId, tagIt(ctx, svc, cfg, "elastic ip", allocated.AllocationId, cfg.TagKey, cfg.TagValue)
+}
+
+func waitNATGatewayAvailable(ctx context.Context, svc EC2API, cfg *Config, natID *string) error {
+	return cfg.Retry.do(ctx, isPending, func() error {
+		resp, err := svc.DescribeNatGatewaysWithContext(ctx, &ec2.DescribeNatGatewaysInput{NatGatewayIds: []*string{natID}})
+		if err != nil {
+			return newError("describe", "nat gateway", natID, err)
+		}
+		nat := resp.NatGateways[0]
+		switch aws.StringValue(nat.State) {
+		case "available":
+			return nil
+		case "pending":
+			return newError("create", "nat gateway", natID, fmt.Errorf("%w: still pending", errPending))
+		}
+		return newError("create", "nat gateway", natID, fmt.Errorf("%s: %s", aws.StringValue(nat.State), aws.StringValue(nat.FailureMessage)))
+	})
+}
+
+func associated(rt *ec2.RouteTable, subnetID *string) bool {
+	for _, assoc := range rt.Associations {
+		if aws.StringValue(assoc.SubnetId) == aws.StringValue(subnetID) {
+			return true
+		}
+	}
+	return false
+}
+
+// The stack's NAT gateway in the subnet, unless it is being deleted.
+func findNATGateway(ctx context.Context, svc EC2API, cfg *Config, subnetID *string) (*ec2.NatGateway, error) {
+	resp, err := svc.DescribeNatGatewaysWithContext(ctx, &ec2.DescribeNatGatewaysInput{
+		Filter: []*ec2.Filter{
+			cfg.tagFilter(),
+			{Name: aws.String("subnet-id"), Values: []*string{subnetID}},
+			{Name: aws.String("state"), Values: aws.StringSlice([]string{"pending", "available"})},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "nat gateways", subnetID, err)
+	}
+	if len(resp.NatGateways) == 0 {
+		return nil, nil
+	}
+	return resp.NatGateways[0], nil
+}
+
+// The stack's route table in the VPC tagged for the given purpose, eg.
+// "private us-west-2a".
+func findRouteTable(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, purpose string) (*ec2.RouteTable, error) {
+	resp, err := svc.DescribeRouteTablesWithContext(ctx, &ec2.DescribeRouteTablesInput{
+		Filters: []*ec2.Filter{
+			cfg.tagFilter(),
+			{Name: aws.String("vpc-id"), Values: []*string{vpcID}},
+			{Name: aws.String("tag:for"), Values: []*string{aws.String(purpose)}},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "route tables", vpcID, err)
+	}
+	if len(resp.RouteTables) == 0 {
+		return nil, nil
+	}
+	return resp.RouteTables[0], nil
+}
+
+// planPrivateSubnets adds what createPrivateSubnets would do to plan.  vpcID
+// is nil when the VPC is still to be created.
+func planPrivateSubnets(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, plan *Plan) error {
+	if len(cfg.PrivateSubnetCIDRs) == 0 {
+		return nil
+	}
+
+	// Where each public subnet is or will be.
+	public := make([]*ec2.Subnet, len(cfg.SubnetCIDRs))
+	publicZones, err := subnetZones(ctx, svc, cfg, cfg.SubnetCIDRs)
+	if err != nil {
+		return err
+	}
+	for i, cidr := range cfg.SubnetCIDRs {
+		public[i] = &ec2.Subnet{CidrBlock: aws.String(cidr), AvailabilityZone: publicZones[i]}
+		if vpcID == nil {
+			continue
+		}
+		subnet, err := findSubnet(ctx, svc, vpcID, cidr)
+		if err != nil {
+			return err
+		}
+		if subnet != nil {
+			public[i] = subnet
+		}
+	}
+
+	privateZones, err := subnetZones(ctx, svc, cfg, cfg.PrivateSubnetCIDRs)
+	if err != nil {
+		return err
+	}
+	var zones []string
+	for i, cidr := range cfg.PrivateSubnetCIDRs {
+		zone := *privateZones[i]
+		var subnet *ec2.Subnet
+		if vpcID != nil {
+			if subnet, err = findSubnet(ctx, svc, vpcID, cidr); err != nil {
+				return err
+			}
+		}
+		if subnet != nil {
+			zone = aws.StringValue(subnet.AvailabilityZone)
+			plan.add("exists", "subnet", subnet.SubnetId, cfg.subnetDetail(cidr, zone, "private"))
+			planSubnetIPv6(cfg, subnet, plan)
+		} else {
+			plan.add("create", "subnet", nil, cfg.subnetDetail(cidr, zone, "private"))
+		}
+		if !contains(zones, zone) {
+			zones = append(zones, zone)
+		}
+	}
+
+	eigwTarget := ""
+	if cfg.IPv6 {
+		var eigw *ec2.EgressOnlyInternetGateway
+		if vpcID != nil {
+			if eigw, err = findEgressOnlyIGW(ctx, svc, cfg, vpcID); err != nil {
+				return err
+			}
+		}
+		if eigw != nil {
+			plan.add("exists", "egress-only internet gateway", eigw.EgressOnlyInternetGatewayId, "")
+			eigwTarget = *eigw.EgressOnlyInternetGatewayId
+		} else {
+			plan.add("create", "egress-only internet gateway", nil, "")
+			eigwTarget = "the egress-only internet gateway"
+		}
+	}
+
+	// Where each NAT gateway's zones send their traffic, by the public subnet
+	// it is in.
+	targets := map[*ec2.Subnet]string{}
+	for _, zone := range zones {
+		in := natSubnet(cfg, public, zone)
+		if in == nil {
+			return newError("plan", "nat gateway", nil, fmt.Errorf("no public subnet in %s for the private subnets there", zone))
+		}
+		detail := "in " + *in.CidrBlock
+
+		if targets[in] == "" {
+			var nat *ec2.NatGateway
+			if in.SubnetId != nil {
+				if nat, err = findNATGateway(ctx, svc, cfg, in.SubnetId); err != nil {
+					return err
+				}
+			}
+			if nat != nil {
+				for _, address := range nat.NatGatewayAddresses {
+					plan.add("exists", "elastic ip", address.AllocationId, aws.StringValue(address.PublicIp))
+				}
+				plan.add("exists", "nat gateway", nat.NatGatewayId, detail)
+				targets[in] = *nat.NatGatewayId
+			} else {
+				plan.add("create", "elastic ip", nil, "for the NAT gateway "+detail)
+				plan.add("create", "nat gateway", nil, detail)
+				targets[in] = "the NAT gateway " + detail
+			}
+		}
+
+		target := targets[in]
+		routes := cfg.privateRoutes(target, eigwTarget)
+		if err := planRouteTable(ctx, svc, cfg, vpcID, "private "+zone, routes, plan); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The public subnet the NAT gateway for zone's private subnets goes in: the
+// first one in public, which is in the order of cfg.SubnetCIDRs.
+func natSubnet(cfg *Config, public []*ec2.Subnet, zone string) *ec2.Subnet {
+	if len(public) == 0 {
+		return nil
+	}
+	if cfg.NATGateways != NATGatewaysPerZone {
+		return public[0]
+	}
+	for _, subnet := range public {
+		if aws.StringValue(subnet.AvailabilityZone) == zone {
+			return subnet
+		}
+	}
+	return nil
+}
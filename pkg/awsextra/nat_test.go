@@ -0,0 +1,193 @@
+package awsextra_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/jeremyd/structureag/pkg/awsextra"
+	"github.com/jeremyd/structureag/pkg/awsextra/awsextratest"
+)
+
+func privateConfig(numSubnets int, natGateways string) *awsextra.Config {
+	cfg := testConfig(numSubnets)
+	for i := 0; i < numSubnets; i++ {
+		cfg.PrivateSubnetCIDRs = append(cfg.PrivateSubnetCIDRs, fmt.Sprintf("172.25.%d.0/24", 100+i))
+	}
+	cfg.NATGateways = natGateways
+	return cfg
+}
+
+func TestCreateVPCNetworkingPrivateSubnets(t *testing.T) {
+	tests := []struct {
+		natGateways string
+		wantNATs    int
+	}{
+		{awsextra.NATGatewaysSingle, 1},
+		{awsextra.NATGatewaysPerZone, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.natGateways, func(t *testing.T) {
+			cfg := privateConfig(3, tt.natGateways)
+			svc := awsextratest.NewEC2("us-west-2")
+			state := awsextra.NewState(cfg)
+			vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, state)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			nats, _ := svc.DescribeNatGateways(&ec2.DescribeNatGatewaysInput{})
+			if len(nats.NatGateways) != tt.wantNATs {
+				t.Fatalf("%d NAT gateways, want %d", len(nats.NatGateways), tt.wantNATs)
+			}
+			natIDs := map[string]bool{}
+			for _, nat := range nats.NatGateways {
+				if aws.StringValue(nat.State) != "available" {
+					t.Errorf("NAT gateway %s is %s", *nat.NatGatewayId, *nat.State)
+				}
+				natIDs[*nat.NatGatewayId] = true
+			}
+			addresses, _ := svc.DescribeAddresses(&ec2.DescribeAddressesInput{})
+			if len(addresses.Addresses) != tt.wantNATs {
+				t.Errorf("%d elastic IPs, want %d", len(addresses.Addresses), tt.wantNATs)
+			}
+
+			// Each private subnet is associated with a route table sending
+			// 0.0.0.0/0 to a NAT gateway in its zone, or the single one.
+			private, _ := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{Filters: []*ec2.Filter{{
+				Name:   aws.String("cidr-block"),
+				Values: aws.StringSlice(cfg.PrivateSubnetCIDRs),
+			}}})
+			if len(private.Subnets) != 3 {
+				t.Fatalf("%d private subnets, want 3", len(private.Subnets))
+			}
+			for _, subnet := range private.Subnets {
+				if aws.BoolValue(subnet.MapPublicIpOnLaunch) {
+					t.Errorf("private subnet %s maps public IPs", *subnet.SubnetId)
+				}
+				rts, _ := svc.DescribeRouteTables(&ec2.DescribeRouteTablesInput{Filters: []*ec2.Filter{{
+					Name:   aws.String("association.subnet-id"),
+					Values: []*string{subnet.SubnetId},
+				}}})
+				if len(rts.RouteTables) != 1 {
+					t.Fatalf("subnet %s has %d route tables, want 1", *subnet.SubnetId, len(rts.RouteTables))
+				}
+				var natID string
+				for _, route := range rts.RouteTables[0].Routes {
+					if aws.StringValue(route.DestinationCidrBlock) == "0.0.0.0/0" {
+						natID = aws.StringValue(route.NatGatewayId)
+					}
+				}
+				if !natIDs[natID] {
+					t.Errorf("subnet %s default route goes to %q, want a NAT gateway", *subnet.SubnetId, natID)
+				}
+			}
+
+			// A second run finds everything and records the same state.
+			before := state.String()
+			if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, state); err != nil {
+				t.Fatal(err)
+			}
+			if after := state.String(); after != before {
+				t.Errorf("second run changed state from\n%s\nto\n%s", before, after)
+			}
+			if n := types(state)["nat gateway"]; n != tt.wantNATs {
+				t.Errorf("state has %d NAT gateways, want %d", n, tt.wantNATs)
+			}
+
+			if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, cfg); err != nil {
+				t.Fatalf("DeleteVPCNetworking: %v", err)
+			}
+			if n := svc.ResourceCount(); n != 0 {
+				t.Errorf("%d resources left after delete of %s", n, *vpcID)
+			}
+		})
+	}
+}
+
+func TestCreateVPCNetworkingPerZoneNeedsPublicSubnet(t *testing.T) {
+	cfg := privateConfig(1, awsextra.NATGatewaysPerZone)
+	cfg.PrivateSubnetCIDRs = append(cfg.PrivateSubnetCIDRs, "172.25.101.0/24")
+	svc := awsextratest.NewEC2("us-west-2")
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil); err == nil {
+		t.Fatal("CreateVPCNetworking succeeded with a private subnet in a zone without a public one")
+	}
+}
+
+func TestDeleteStateResourcesPrivateSubnets(t *testing.T) {
+	cfg := privateConfig(2, awsextra.NATGatewaysPerZone)
+	svc := awsextratest.NewEC2("us-west-2")
+	state := upWithState(t, svc, cfg)
+
+	if _, err := awsextra.DeleteStateResources(context.Background(), svc, cfg, state); err != nil {
+		t.Fatalf("DeleteStateResources: %v", err)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left after delete", n)
+	}
+	if len(state.Resources) != 0 {
+		t.Errorf("state still lists\n%s", state)
+	}
+}
+
+func TestPlanPrivateSubnets(t *testing.T) {
+	cfg := privateConfig(2, awsextra.NATGatewaysPerZone)
+	svc := awsextratest.NewEC2("us-west-2")
+
+	plan := &awsextra.Plan{}
+	if err := awsextra.PlanVPCNetworking(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	created := plan.Count("create")
+
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	plan = &awsextra.Plan{}
+	if err := awsextra.PlanVPCNetworking(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	if n := plan.Count("create"); n != 0 {
+		t.Errorf("plan after up creates %d things, want none\n%s", n, plan)
+	}
+	if n := plan.Count("exists"); n != created {
+		t.Errorf("plan after up finds %d things, want the %d it planned to create\n%s", n, created, plan)
+	}
+}
+
+// reverseSubnets returns DescribeSubnets results backwards, as EC2 is free to.
+type reverseSubnets struct {
+	*awsextratest.EC2
+}
+
+func (r *reverseSubnets) DescribeSubnetsWithContext(ctx aws.Context, in *ec2.DescribeSubnetsInput, opts ...request.Option) (*ec2.DescribeSubnetsOutput, error) {
+	out, err := r.EC2.DescribeSubnetsWithContext(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(out.Subnets)-1; i < j; i, j = i+1, j-1 {
+		out.Subnets[i], out.Subnets[j] = out.Subnets[j], out.Subnets[i]
+	}
+	return out, nil
+}
+
+func TestCreateVPCNetworkingNATSubnetOrder(t *testing.T) {
+	cfg := privateConfig(2, awsextra.NATGatewaysSingle)
+	svc := awsextratest.NewEC2("us-west-2")
+	state := upWithState(t, svc, cfg)
+
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), &reverseSubnets{svc}, cfg, state); err != nil {
+		t.Fatal(err)
+	}
+	nats, _ := svc.DescribeNatGateways(&ec2.DescribeNatGatewaysInput{})
+	if len(nats.NatGateways) != 1 {
+		t.Fatalf("%d NAT gateways, want 1", len(nats.NatGateways))
+	}
+	subnets, _ := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{SubnetIds: []*string{nats.NatGateways[0].SubnetId}})
+	if cidr := *subnets.Subnets[0].CidrBlock; cidr != cfg.SubnetCIDRs[0] {
+		t.Errorf("NAT gateway in %s, want the first public subnet %s", cidr, cfg.SubnetCIDRs[0])
+	}
+}
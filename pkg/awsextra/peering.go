@@ -0,0 +1,287 @@
+package awsextra
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The states of a peering connection that is, or is on its way to being,
+// usable.  Failed, rejected, expired and deleted ones are left for EC2 to
+// forget.
+var livePeeringStates = []string{"initiating-request", "pending-acceptance", "provisioning", "active"}
+
+// PeerVPCs ... peers the stack's VPC with the VPC of the stack peerCfg
+// describes, which may be in another region or account: peerSvc is an EC2
+// client for that region and account.  The two VPCs' CIDR blocks must not
+// overlap.  It requests the connection, accepts it on the peer's side and
+// routes each VPC's CIDR block over it in the other stack's route tables.
+// With allowDNS each side also lets the other resolve its public DNS
+// hostnames to private addresses.  Like `up` it converges on what is already
+// there, including a connection the peer stack requested.
+//
+// The connection belongs to the stack that requested it: only that stack
+// tags it, records it in state, which may be nil, and deletes it in its
+// teardown.  The other stack's teardown leaves it, and so its VPC, alone
+// until UnpeerVPCs is run.
+func PeerVPCs(ctx context.Context, svc EC2API, cfg *Config, peerSvc EC2API, peerCfg *Config, allowDNS bool, state *State) (*string, error) {
+	vpc, peerVPC, err := findPeerVPCs(ctx, svc, cfg, peerSvc, peerCfg)
+	if err != nil {
+		return nil, err
+	}
+	_, mine, _ := net.ParseCIDR(*vpc.CidrBlock)
+	_, theirs, _ := net.ParseCIDR(*peerVPC.CidrBlock)
+	if overlaps(mine, theirs) {
+		return nil, cidrConflict("peer", *vpc.CidrBlock, peerVPC.VpcId)
+	}
+
+	peering, err := findPeering(ctx, svc, vpc.VpcId, peerVPC.VpcId)
+	if err != nil {
+		return nil, err
+	}
+	if peering != nil {
+		fmt.Println("Found vpc peering connection " + *peering.VpcPeeringConnectionId + " to " + *peerVPC.VpcId)
+	} else {
+		in := &ec2.CreateVpcPeeringConnectionInput{VpcId: vpc.VpcId, PeerVpcId: peerVPC.VpcId}
+		if aws.StringValue(peerVPC.OwnerId) != aws.StringValue(vpc.OwnerId) {
+			in.PeerOwnerId = peerVPC.OwnerId
+		}
+		if peerCfg.Region != cfg.Region {
+			in.PeerRegion = aws.String(peerCfg.Region)
+		}
+		resp, err := svc.CreateVpcPeeringConnectionWithContext(ctx, in)
+		if err != nil {
+			return nil, newError("create", "vpc peering connection", nil, err)
+		}
+		peering = resp.VpcPeeringConnection
+		fmt.Println("Created vpc peering connection " + *peering.VpcPeeringConnectionId + " to " + *peerVPC.VpcId)
+	}
+	pcxID := peering.VpcPeeringConnectionId
+	requested := aws.StringValue(peering.RequesterVpcInfo.VpcId) == *vpc.VpcId
+	if requested {
+		state.record("vpc peering connection", pcxID, vpc.VpcId)
+		if err := tagIt(ctx, svc, cfg, "vpc peering connection", pcxID, cfg.TagKey, cfg.TagValue); err != nil {
+			return pcxID, err
+		}
+	}
+
+	// A request to another region takes a while to get there.
+	accepterSvc, accepterCfg := peerSvc, peerCfg
+	if !requested {
+		accepterSvc, accepterCfg = svc, cfg
+	}
+	if peering, err = waitPeering(ctx, accepterSvc, accepterCfg.Retry, pcxID, "pending-acceptance", "provisioning", "active"); err != nil {
+		return pcxID, err
+	}
+	if aws.StringValue(peering.Status.Code) == "pending-acceptance" {
+		params := &ec2.AcceptVpcPeeringConnectionInput{VpcPeeringConnectionId: pcxID}
+		if _, err := accepterSvc.AcceptVpcPeeringConnectionWithContext(ctx, params); err != nil {
+			return pcxID, newError("accept", "vpc peering connection", pcxID, err)
+		}
+		fmt.Println("Accepted vpc peering connection " + *pcxID)
+	}
+	if peering, err = waitPeering(ctx, svc, cfg.Retry, pcxID, "active"); err != nil {
+		return pcxID, err
+	}
+
+	if err := peerRoutes(ctx, svc, cfg, vpc.VpcId, *peerVPC.CidrBlock, pcxID); err != nil {
+		return pcxID, err
+	}
+	if err := peerRoutes(ctx, peerSvc, peerCfg, peerVPC.VpcId, *vpc.CidrBlock, pcxID); err != nil {
+		return pcxID, err
+	}
+
+	if allowDNS {
+		if err := allowPeerDNS(ctx, svc, peering, vpc.VpcId); err != nil {
+			return pcxID, err
+		}
+		if err := allowPeerDNS(ctx, peerSvc, peering, peerVPC.VpcId); err != nil {
+			return pcxID, err
+		}
+	}
+	return pcxID, nil
+}
+
+// UnpeerVPCs ... undoes PeerVPCs: it takes the routes over the peering
+// connection out of both stacks' route tables, deletes the connection and
+// drops it from state, which may be nil.  It does nothing if the VPCs aren't
+// peered.
+func UnpeerVPCs(ctx context.Context, svc EC2API, cfg *Config, peerSvc EC2API, peerCfg *Config, state *State) error {
+	vpc, peerVPC, err := findPeerVPCs(ctx, svc, cfg, peerSvc, peerCfg)
+	if err != nil {
+		return err
+	}
+	peering, err := findPeering(ctx, svc, vpc.VpcId, peerVPC.VpcId)
+	if err != nil {
+		return err
+	}
+	if peering == nil {
+		fmt.Println("No vpc peering connection to " + *peerVPC.VpcId)
+		return nil
+	}
+	pcxID := peering.VpcPeeringConnectionId
+
+	if err := removePeerRoutes(ctx, svc, cfg, vpc.VpcId, pcxID); err != nil {
+		return err
+	}
+	if err := removePeerRoutes(ctx, peerSvc, peerCfg, peerVPC.VpcId, pcxID); err != nil {
+		return err
+	}
+	if err := deleteResource(ctx, svc, cfg.Retry, Resource{Type: "vpc peering connection", ID: *pcxID}); err != nil {
+		return err
+	}
+	state.forget(*pcxID)
+	fmt.Println("Deleted vpc peering connection " + *pcxID)
+	return nil
+}
+
+// The VPCs of both stacks, which must both exist.
+func findPeerVPCs(ctx context.Context, svc EC2API, cfg *Config, peerSvc EC2API, peerCfg *Config) (vpc *ec2.Vpc, peerVPC *ec2.Vpc, err error) {
+	if vpc, err = findVPC(ctx, svc, cfg); err != nil {
+		return nil, nil, err
+	}
+	if vpc == nil {
+		return nil, nil, newError("peer", "vpc", nil, fmt.Errorf("no VPC tagged %s=%s in %s", cfg.TagKey, cfg.TagValue, cfg.Region))
+	}
+	if peerVPC, err = findVPC(ctx, peerSvc, peerCfg); err != nil {
+		return nil, nil, err
+	}
+	if peerVPC == nil {
+		return nil, nil, newError("peer", "vpc", nil, fmt.Errorf("no peer VPC tagged %s=%s in %s", peerCfg.TagKey, peerCfg.TagValue, peerCfg.Region))
+	}
+	return vpc, peerVPC, nil
+}
+
+// The live peering connection between the two VPCs, requested by either.
+func findPeering(ctx context.Context, svc EC2API, vpcID *string, peerVPCID *string) (*ec2.VpcPeeringConnection, error) {
+	both := []*string{vpcID, peerVPCID}
+	resp, err := svc.DescribeVpcPeeringConnectionsWithContext(ctx, &ec2.DescribeVpcPeeringConnectionsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("requester-vpc-info.vpc-id"), Values: both},
+			{Name: aws.String("accepter-vpc-info.vpc-id"), Values: both},
+			{Name: aws.String("status-code"), Values: aws.StringSlice(livePeeringStates)},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "vpc peering connections", nil, err)
+	}
+	if len(resp.VpcPeeringConnections) == 0 {
+		return nil, nil
+	}
+	return resp.VpcPeeringConnections[0], nil
+}
+
+// Wait for the peering connection to reach one of the states, as seen
+// through svc.  A connection that fails, or is rejected, expires or is
+// deleted, never will.
+func waitPeering(ctx context.Context, svc EC2API, retry RetryPolicy, pcxID *string, states ...string) (*ec2.VpcPeeringConnection, error) {
+	var peering *ec2.VpcPeeringConnection
+	notYet := func(err error) bool {
+		return isPending(err) || isNotFound(err)
+	}
+	err := retry.do(ctx, notYet, func() error {
+		resp, err := svc.DescribeVpcPeeringConnectionsWithContext(ctx, &ec2.DescribeVpcPeeringConnectionsInput{VpcPeeringConnectionIds: []*string{pcxID}})
+		if err != nil {
+			return newError("describe", "vpc peering connection", pcxID, err)
+		}
+		if len(resp.VpcPeeringConnections) == 0 {
+			return errNotFound("describe", "vpc peering connection", pcxID, "InvalidVpcPeeringConnectionID.NotFound")
+		}
+		peering = resp.VpcPeeringConnections[0]
+		status := aws.StringValue(peering.Status.Code)
+		switch {
+		case contains(states, status):
+			return nil
+		case contains(livePeeringStates, status):
+			return newError("peer", "vpc peering connection", pcxID, fmt.Errorf("%w: still %s", errPending, status))
+		}
+		return newError("peer", "vpc peering connection", pcxID, fmt.Errorf("%s: %s", status, aws.StringValue(peering.Status.Message)))
+	})
+	return peering, err
+}
+
+// The stack's own route tables, public and private.
+func stackRouteTables(ctx context.Context, svc EC2API, cfg *Config, vpcID *string) ([]*ec2.RouteTable, error) {
+	resp, err := svc.DescribeRouteTablesWithContext(ctx, &ec2.DescribeRouteTablesInput{
+		Filters: []*ec2.Filter{
+			cfg.tagFilter(),
+			{Name: aws.String("vpc-id"), Values: []*string{vpcID}},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "route tables", nil, err)
+	}
+	return resp.RouteTables, nil
+}
+
+// Route cidr over the peering connection in every one of the stack's route
+// tables.
+func peerRoutes(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, cidr string, pcxID *string) error {
+	routeTables, err := stackRouteTables(ctx, svc, cfg, vpcID)
+	if err != nil {
+		return err
+	}
+	for _, rt := range routeTables {
+		if err := ensureRoute(ctx, svc, rt, Route{Destination: cidr, Target: *pcxID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Take every route over the peering connection out of the stack's route
+// tables.
+func removePeerRoutes(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, pcxID *string) error {
+	routeTables, err := stackRouteTables(ctx, svc, cfg, vpcID)
+	if err != nil {
+		return err
+	}
+	for _, rt := range routeTables {
+		for _, r := range rt.Routes {
+			if aws.StringValue(r.VpcPeeringConnectionId) != *pcxID {
+				continue
+			}
+			_, err := svc.DeleteRouteWithContext(ctx, &ec2.DeleteRouteInput{
+				RouteTableId:             rt.RouteTableId,
+				DestinationCidrBlock:     r.DestinationCidrBlock,
+				DestinationIpv6CidrBlock: r.DestinationIpv6CidrBlock,
+				DestinationPrefixListId:  r.DestinationPrefixListId,
+			})
+			route := Route{Destination: routeDestination(r), Target: *pcxID}
+			if err != nil && !isNotFound(err) {
+				return newError("delete route "+route.String()+" in", "route table", rt.RouteTableId, err)
+			}
+			fmt.Println("Removed route " + route.String())
+		}
+	}
+	return nil
+}
+
+// Let the other side of the peering connection resolve the VPC's public DNS
+// hostnames to private addresses.  Each side's option can only be set
+// through its own region and account, so svc must be the VPC's.
+func allowPeerDNS(ctx context.Context, svc EC2API, peering *ec2.VpcPeeringConnection, vpcID *string) error {
+	side := peering.RequesterVpcInfo
+	if aws.StringValue(side.VpcId) != *vpcID {
+		side = peering.AccepterVpcInfo
+	}
+	if side.PeeringOptions != nil && aws.BoolValue(side.PeeringOptions.AllowDnsResolutionFromRemoteVpc) {
+		fmt.Println("Found DNS resolution from the peer of " + *vpcID)
+		return nil
+	}
+	in := &ec2.ModifyVpcPeeringConnectionOptionsInput{VpcPeeringConnectionId: peering.VpcPeeringConnectionId}
+	options := &ec2.PeeringConnectionOptionsRequest{AllowDnsResolutionFromRemoteVpc: aws.Bool(true)}
+	if side == peering.RequesterVpcInfo {
+		in.RequesterPeeringConnectionOptions = options
+	} else {
+		in.AccepterPeeringConnectionOptions = options
+	}
+	if _, err := svc.ModifyVpcPeeringConnectionOptionsWithContext(ctx, in); err != nil {
+		return newError("allow dns resolution over", "vpc peering connection", peering.VpcPeeringConnectionId, err)
+	}
+	fmt.Println("Allowed DNS resolution from the peer of " + *vpcID)
+	return nil
+}
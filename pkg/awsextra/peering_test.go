@@ -0,0 +1,263 @@
+package awsextra_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/jeremyd/structureag/pkg/awsextra"
+	"github.com/jeremyd/structureag/pkg/awsextra/awsextratest"
+)
+
+// peerConfig is a second stack, in region, that doesn't overlap testConfig.
+func peerConfig(region string) *awsextra.Config {
+	cfg := awsextra.NewConfig()
+	cfg.Region = region
+	cfg.VPCCIDRBlock = "10.1.0.0/16"
+	cfg.SubnetCIDRs = []string{"10.1.0.0/24"}
+	cfg.TagKey = "MYTAG"
+	cfg.TagValue = "peer"
+	return cfg
+}
+
+// peerRoutes returns where each of the stack's route tables sends dest.
+func peerRoutes(t *testing.T, svc *awsextratest.EC2, cfg *awsextra.Config, dest string) []string {
+	t.Helper()
+	rts, err := svc.DescribeRouteTables(&ec2.DescribeRouteTablesInput{Filters: []*ec2.Filter{
+		{Name: aws.String("tag:" + cfg.TagKey), Values: []*string{aws.String(cfg.TagValue)}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var targets []string
+	for _, rt := range rts.RouteTables {
+		target := ""
+		for _, r := range rt.Routes {
+			if aws.StringValue(r.DestinationCidrBlock) == dest {
+				target = aws.StringValue(r.VpcPeeringConnectionId)
+			}
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+func peering(t *testing.T, svc *awsextratest.EC2, pcxID *string) *ec2.VpcPeeringConnection {
+	t.Helper()
+	resp, err := svc.DescribeVpcPeeringConnections(&ec2.DescribeVpcPeeringConnectionsInput{VpcPeeringConnectionIds: []*string{pcxID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.VpcPeeringConnections[0]
+}
+
+func TestPeerVPCs(t *testing.T) {
+	cfg := testConfig(1)
+	cfg.PrivateSubnetCIDRs = []string{"172.25.100.0/24"}
+	peerCfg := peerConfig("us-west-2")
+	svc := awsextratest.NewEC2("us-west-2")
+	for _, c := range []*awsextra.Config{cfg, peerCfg} {
+		if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, c, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state := awsextra.NewState(cfg)
+
+	pcxID, err := awsextra.PeerVPCs(context.Background(), svc, cfg, svc, peerCfg, true, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcx := peering(t, svc, pcxID)
+	if got := *pcx.Status.Code; got != "active" {
+		t.Errorf("peering connection is %s, want active", got)
+	}
+	if !*pcx.RequesterVpcInfo.PeeringOptions.AllowDnsResolutionFromRemoteVpc || !*pcx.AccepterVpcInfo.PeeringOptions.AllowDnsResolutionFromRemoteVpc {
+		t.Errorf("peering options = %v and %v, want DNS resolution allowed both ways", pcx.RequesterVpcInfo.PeeringOptions, pcx.AccepterVpcInfo.PeeringOptions)
+	}
+	if got := svc.Tags(*pcxID)["MYTAG"]; got != "test" {
+		t.Errorf("peering connection tag = %q, want test", got)
+	}
+	if got := inState(state, "vpc peering connection"); len(got) != 1 || got[0] != *pcxID {
+		t.Errorf("state has peering connections %v, want %s", got, *pcxID)
+	}
+
+	// Both the public and private route tables route to the peer.
+	mine := peerRoutes(t, svc, cfg, peerCfg.VPCCIDRBlock)
+	theirs := peerRoutes(t, svc, peerCfg, cfg.VPCCIDRBlock)
+	if len(mine) != 2 || len(theirs) != 1 {
+		t.Fatalf("%d and %d route tables, want 2 and 1", len(mine), len(theirs))
+	}
+	for _, target := range append(mine, theirs...) {
+		if target != *pcxID {
+			t.Errorf("route to the other VPC goes to %q, want %s", target, *pcxID)
+		}
+	}
+
+	// Peering again, from either side, finds what is there.
+	before := len(svc.Calls())
+	if again, err := awsextra.PeerVPCs(context.Background(), svc, cfg, svc, peerCfg, true, state); err != nil || *again != *pcxID {
+		t.Fatalf("PeerVPCs again = %v, %v, want %s", aws.StringValue(again), err, *pcxID)
+	}
+	if again, err := awsextra.PeerVPCs(context.Background(), svc, peerCfg, svc, cfg, true, nil); err != nil || *again != *pcxID {
+		t.Fatalf("PeerVPCs from the peer = %v, %v, want %s", aws.StringValue(again), err, *pcxID)
+	}
+	for _, call := range svc.Calls()[before:] {
+		switch call {
+		case "CreateVpcPeeringConnection", "AcceptVpcPeeringConnection", "ModifyVpcPeeringConnectionOptions", "CreateRoute", "ReplaceRoute":
+			t.Errorf("peering again called %s", call)
+		}
+	}
+	if got := svc.Tags(*pcxID)["MYTAG"]; got != "test" {
+		t.Errorf("peering connection tag = %q after peering from the peer, want test", got)
+	}
+}
+
+func TestPeerVPCsOverlap(t *testing.T) {
+	cfg := testConfig(1)
+	peerCfg := peerConfig("us-west-2")
+	peerCfg.VPCCIDRBlock = "172.25.128.0/17"
+	peerCfg.SubnetCIDRs = []string{"172.25.128.0/24"}
+	svc := awsextratest.NewEC2("us-west-2")
+	for _, c := range []*awsextra.Config{cfg, peerCfg} {
+		if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, c, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := awsextra.PeerVPCs(context.Background(), svc, cfg, svc, peerCfg, false, nil)
+	if !errors.Is(err, awsextra.ErrCIDRConflict) {
+		t.Fatalf("err = %v, want ErrCIDRConflict", err)
+	}
+	if n := count(svc.Calls(), "CreateVpcPeeringConnection"); n != 0 {
+		t.Errorf("CreateVpcPeeringConnection called %d times, want 0", n)
+	}
+}
+
+func TestPeerVPCsCrossRegion(t *testing.T) {
+	cfg := testConfig(1)
+	peerCfg := peerConfig("us-east-1")
+	svc := awsextratest.NewEC2("us-west-2")
+	peerSvc := awsextratest.NewEC2("us-east-1")
+	peerSvc.SetAccount("210987654321")
+	awsextratest.Link(svc, peerSvc)
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), peerSvc, peerCfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	state := awsextra.NewState(cfg)
+
+	pcxID, err := awsextra.PeerVPCs(context.Background(), svc, cfg, peerSvc, peerCfg, true, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcx := peering(t, peerSvc, pcxID)
+	if *pcx.Status.Code != "active" || *pcx.AccepterVpcInfo.Region != "us-east-1" || *pcx.AccepterVpcInfo.OwnerId != "210987654321" {
+		t.Errorf("peering connection = %v, want an active one to 210987654321 in us-east-1", pcx)
+	}
+	if !*pcx.RequesterVpcInfo.PeeringOptions.AllowDnsResolutionFromRemoteVpc || !*pcx.AccepterVpcInfo.PeeringOptions.AllowDnsResolutionFromRemoteVpc {
+		t.Errorf("peering options = %v and %v, want DNS resolution allowed both ways", pcx.RequesterVpcInfo.PeeringOptions, pcx.AccepterVpcInfo.PeeringOptions)
+	}
+	if n := count(peerSvc.Calls(), "AcceptVpcPeeringConnection"); n != 1 {
+		t.Errorf("AcceptVpcPeeringConnection called %d times in the peer region, want 1", n)
+	}
+	if got := peerRoutes(t, peerSvc, peerCfg, cfg.VPCCIDRBlock); len(got) != 1 || got[0] != *pcxID {
+		t.Errorf("peer routes to %s = %v, want %s", cfg.VPCCIDRBlock, got, *pcxID)
+	}
+
+	if err := awsextra.UnpeerVPCs(context.Background(), svc, cfg, peerSvc, peerCfg, state); err != nil {
+		t.Fatal(err)
+	}
+	if got := *peering(t, svc, pcxID).Status.Code; got != "deleted" {
+		t.Errorf("peering connection is %s after unpeering, want deleted", got)
+	}
+	for _, target := range append(peerRoutes(t, svc, cfg, peerCfg.VPCCIDRBlock), peerRoutes(t, peerSvc, peerCfg, cfg.VPCCIDRBlock)...) {
+		if target != "" {
+			t.Errorf("route to %s left after unpeering", target)
+		}
+	}
+	if got := inState(state, "vpc peering connection"); len(got) != 0 {
+		t.Errorf("state still has peering connections %v", got)
+	}
+	if err := awsextra.UnpeerVPCs(context.Background(), svc, cfg, peerSvc, peerCfg, state); err != nil {
+		t.Errorf("unpeering again: %v", err)
+	}
+}
+
+// The peering connection belongs to the stack that asked for it, so the peer
+// stack can't be torn down until it is gone.
+func TestDeleteVPCNetworkingPeered(t *testing.T) {
+	cfg := testConfig(1)
+	peerCfg := peerConfig("us-west-2")
+	svc := awsextratest.NewEC2("us-west-2")
+	for _, c := range []*awsextra.Config{cfg, peerCfg} {
+		if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, c, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pcxID, err := awsextra.PeerVPCs(context.Background(), svc, cfg, svc, peerCfg, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, peerCfg); !errors.Is(err, awsextra.ErrNotInStack) {
+		t.Fatalf("deleting the peer stack: err = %v, want ErrNotInStack", err)
+	}
+	if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := *peering(t, svc, pcxID).Status.Code; got != "deleted" {
+		t.Errorf("peering connection is %s, want deleted", got)
+	}
+	if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, peerCfg); err != nil {
+		t.Fatal(err)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left", n)
+	}
+}
+
+// latePeering describes the first peering connection asked for by ID as not
+// there yet, as EC2 can just after it is created.
+type latePeering struct {
+	*awsextratest.EC2
+	seen bool
+}
+
+func (l *latePeering) DescribeVpcPeeringConnectionsWithContext(ctx aws.Context, in *ec2.DescribeVpcPeeringConnectionsInput, opts ...request.Option) (*ec2.DescribeVpcPeeringConnectionsOutput, error) {
+	if len(in.VpcPeeringConnectionIds) > 0 && !l.seen {
+		l.seen = true
+		return &ec2.DescribeVpcPeeringConnectionsOutput{}, nil
+	}
+	return l.EC2.DescribeVpcPeeringConnectionsWithContext(ctx, in, opts...)
+}
+
+func TestPeerVPCsNotDescribedYet(t *testing.T) {
+	cfg := testConfig(1)
+	peerCfg := peerConfig("us-west-2")
+	cfg.Retry.InitialInterval = time.Millisecond
+	peerCfg.Retry.InitialInterval = time.Millisecond
+	svc := awsextratest.NewEC2("us-west-2")
+	for _, c := range []*awsextra.Config{cfg, peerCfg} {
+		if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, c, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	late := &latePeering{EC2: svc}
+	pcxID, err := awsextra.PeerVPCs(context.Background(), late, cfg, late, peerCfg, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !late.seen {
+		t.Fatal("peering connection never described by ID")
+	}
+	if got := *peering(t, svc, pcxID).Status.Code; got != "active" {
+		t.Errorf("peering connection is %s, want active", got)
+	}
+}
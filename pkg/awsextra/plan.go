@@ -0,0 +1,484 @@
+package awsextra
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Plan lists what `up` or `down` would change, worked out with describe
+// calls only.
+type Plan struct {
+	Changes []Change
+}
+
+// Change is one step of a Plan.
+type Change struct {
+	Action   string // "create", "update", "exists", "delete" or "blocked"
+	Resource string // Kind of resource, eg. "subnet"
+	ID       string // Resource ID, if it already exists
+	Detail   string // What the step is about, eg. a CIDR block
+}
+
+// Symbols used when printing each action.
+var planSymbols = map[string]string{
+	"create":  "+",
+	"update":  "~",
+	"exists":  "=",
+	"delete":  "-",
+	"blocked": "!",
+}
+
+func (p *Plan) add(action string, resource string, ID *string, detail string) {
+	p.Changes = append(p.Changes, Change{
+		Action:   action,
+		Resource: resource,
+		ID:       aws.StringValue(ID),
+		Detail:   detail,
+	})
+}
+
+// Count returns how many changes have the given action.
+func (p *Plan) Count(action string) int {
+	n := 0
+	for _, c := range p.Changes {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+func (p *Plan) String() string {
+	var b strings.Builder
+	for _, c := range p.Changes {
+		line := planSymbols[c.Action] + " " + c.Action + " " + c.Resource
+		if c.ID != "" {
+			line += " " + c.ID
+		}
+		if c.Detail != "" {
+			line += " (" + c.Detail + ")"
+		}
+		fmt.Fprintln(&b, line)
+	}
+	fmt.Fprintf(&b, "%d to create, %d to update, %d to delete, %d existing",
+		p.Count("create"), p.Count("update"), p.Count("delete"), p.Count("exists"))
+	if n := p.Count("blocked"); n > 0 {
+		fmt.Fprintf(&b, ", %d blocked", n)
+	}
+	fmt.Fprintln(&b)
+	return b.String()
+}
+
+// PlanVPCNetworking ... adds what CreateVPCNetworking would do to plan,
+// without changing anything.  When the tagged VPC already exists, the
+// sub-resources it lacks are planned for creation, as CreateVPCNetworking
+// adds them on its next run.
+func PlanVPCNetworking(ctx context.Context, svc EC2API, cfg *Config, plan *Plan) error {
+	vpc, err := findVPC(ctx, svc, cfg)
+	if err != nil {
+		return err
+	}
+	if vpc == nil {
+		conflictID, err := vpcCheckConflict(ctx, svc, cfg)
+		if err != nil {
+			return err
+		}
+		if conflictID != nil {
+			return cidrConflict("plan", cfg.VPCCIDRBlock, conflictID)
+		}
+		zones, err := subnetZones(ctx, svc, cfg, cfg.SubnetCIDRs)
+		if err != nil {
+			return err
+		}
+		plan.add("create", "vpc", nil, cfg.VPCCIDRBlock)
+		if cfg.IPv6 {
+			plan.add("create", "ipv6 cidr block", nil, "Amazon provided /56")
+		}
+		plan.add("create", "dns attributes", nil, dnsDetail(cfg.EnableDNSSupport, cfg.EnableDNSHostnames))
+		plan.add("create", "dhcp options set", nil, dhcpDetail(cfg))
+		for i, cidr := range cfg.SubnetCIDRs {
+			plan.add("create", "subnet", nil, cfg.subnetDetail(cidr, *zones[i], ""))
+		}
+		plan.add("create", "internet gateway", nil, "")
+		routes := cfg.publicRoutes("the internet gateway")
+		if err := planRouteTable(ctx, svc, cfg, nil, RouteTablesPublic, routes, plan); err != nil {
+			return err
+		}
+		if err := planPrivateSubnets(ctx, svc, cfg, nil, plan); err != nil {
+			return err
+		}
+		if err := planNetworkACLs(ctx, svc, cfg, nil, plan); err != nil {
+			return err
+		}
+		if err := planEndpoints(ctx, svc, cfg, nil, plan); err != nil {
+			return err
+		}
+		if cfg.TransitGateway != nil {
+			if err := planTransitGateway(ctx, svc, cfg, nil, plan); err != nil {
+				return err
+			}
+		}
+		if cfg.VPN != nil {
+			return planVPN(ctx, svc, cfg, nil, plan)
+		}
+		return nil
+	}
+
+	// The VPC is there; report what it has and what `up` will add.
+	zones, err := subnetZones(ctx, svc, cfg, cfg.SubnetCIDRs)
+	if err != nil {
+		return err
+	}
+	plan.add("exists", "vpc", vpc.VpcId, aws.StringValue(vpc.CidrBlock))
+	if cfg.IPv6 {
+		if assoc := vpcIPv6Block(vpc); assoc != nil {
+			plan.add("exists", "ipv6 cidr block", vpc.VpcId, aws.StringValue(assoc.Ipv6CidrBlock))
+		} else {
+			plan.add("create", "ipv6 cidr block", vpc.VpcId, "Amazon provided /56")
+		}
+	}
+
+	support, err := vpcAttribute(ctx, svc, vpc.VpcId, ec2.VpcAttributeNameEnableDnsSupport)
+	if err != nil {
+		return err
+	}
+	hostnames, err := vpcAttribute(ctx, svc, vpc.VpcId, ec2.VpcAttributeNameEnableDnsHostnames)
+	if err != nil {
+		return err
+	}
+	if support == cfg.EnableDNSSupport && hostnames == cfg.EnableDNSHostnames {
+		plan.add("exists", "dns attributes", vpc.VpcId, dnsDetail(support, hostnames))
+	} else {
+		plan.add("update", "dns attributes", vpc.VpcId, dnsDetail(cfg.EnableDNSSupport, cfg.EnableDNSHostnames))
+	}
+
+	dhcp, err := findDhcpOptions(ctx, svc, cfg, vpc)
+	if err != nil {
+		return err
+	}
+	if dhcp != nil {
+		plan.add("exists", "dhcp options set", dhcp, dhcpDetail(cfg))
+	} else {
+		plan.add("create", "dhcp options set", nil, dhcpDetail(cfg))
+	}
+
+	for i, cidr := range cfg.SubnetCIDRs {
+		subnet, err := findSubnet(ctx, svc, vpc.VpcId, cidr)
+		if err != nil {
+			return err
+		}
+		if subnet != nil {
+			plan.add("exists", "subnet", subnet.SubnetId, cfg.subnetDetail(cidr, aws.StringValue(subnet.AvailabilityZone), ""))
+			planSubnetIPv6(cfg, subnet, plan)
+		} else {
+			plan.add("create", "subnet", nil, cfg.subnetDetail(cidr, *zones[i], ""))
+		}
+	}
+
+	igw, err := findIGW(ctx, svc, vpc.VpcId)
+	if err != nil {
+		return err
+	}
+	if igw != nil {
+		plan.add("exists", "internet gateway", igw.InternetGatewayId, "")
+	} else {
+		plan.add("create", "internet gateway", nil, "")
+	}
+
+	target := "the internet gateway"
+	if igw != nil {
+		target = *igw.InternetGatewayId
+	}
+	routes := cfg.publicRoutes(target)
+	if err := planRouteTable(ctx, svc, cfg, vpc.VpcId, RouteTablesPublic, routes, plan); err != nil {
+		return err
+	}
+
+	// Stacks from before the public route table have the internet route in
+	// the main one, which `up` takes out.
+	rt, err := mainRouteTable(ctx, svc, vpc.VpcId)
+	if err != nil {
+		return err
+	}
+	if route := findRoute(rt, "0.0.0.0/0"); igw != nil && route != nil && routeTarget(route) == target {
+		plan.add("delete", "route", rt.RouteTableId, "0.0.0.0/0 to "+target+" in the main route table")
+	}
+	if err := planPrivateSubnets(ctx, svc, cfg, vpc.VpcId, plan); err != nil {
+		return err
+	}
+	if err := planNetworkACLs(ctx, svc, cfg, vpc.VpcId, plan); err != nil {
+		return err
+	}
+	if err := planEndpoints(ctx, svc, cfg, vpc.VpcId, plan); err != nil {
+		return err
+	}
+	if cfg.TransitGateway != nil {
+		if err := planTransitGateway(ctx, svc, cfg, vpc.VpcId, plan); err != nil {
+			return err
+		}
+	}
+	if cfg.VPN != nil {
+		return planVPN(ctx, svc, cfg, vpc.VpcId, plan)
+	}
+	return nil
+}
+
+// PlanSecurityGroup ... adds what CreateSecurityGroup and
+// AuthorizeSecurityGroupsInternalSSH would do for the kindOf group to plan.
+func PlanSecurityGroup(ctx context.Context, svc EC2API, cfg *Config, kindOf string, plan *Plan) error {
+	return planSecurityGroup(ctx, svc, cfg, kindOf, func(groupID *string) []groupRule {
+		return internalSSHRules(groupID, cfg.IPv6)
+	}, false, plan)
+}
+
+// PlanSecurityGroups ... adds what CreateSecurityGroups would do to plan.
+// The rules of groups still to be created reference them by name.
+func PlanSecurityGroups(ctx context.Context, svc EC2API, cfg *Config, plan *Plan) error {
+	groups := cfg.securityGroups()
+	groupIDs := map[string]*string{}
+	for _, group := range groups {
+		groupID, err := GetSecurityGroup(ctx, svc, cfg, group.Kind)
+		if err != nil {
+			return err
+		}
+		if groupID == nil {
+			groupID = aws.String(group.Kind + "-" + cfg.TagKey)
+		}
+		groupIDs[group.Kind] = groupID
+	}
+	for _, group := range groups {
+		group := group
+		err := planSecurityGroup(ctx, svc, cfg, group.Kind, func(*string) []groupRule {
+			return group.rules(groupIDs)
+		}, true, plan)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planSecurityGroup adds the kindOf group and the rules it should have to
+// plan and, if reconcile, the rules reconcileRules would revoke or keep.
+func planSecurityGroup(ctx context.Context, svc EC2API, cfg *Config, kindOf string, rules func(groupID *string) []groupRule, reconcile bool, plan *Plan) error {
+	groupName := kindOf + "-" + cfg.TagKey
+	groupID, err := GetSecurityGroup(ctx, svc, cfg, kindOf)
+	if err != nil {
+		return err
+	}
+	if groupID == nil {
+		plan.add("create", "security group", nil, groupName)
+		for _, rule := range rules(aws.String(groupName)) {
+			plan.add("create", rule.resource(), nil, ruleDetail(rule))
+		}
+		return nil
+	}
+
+	plan.add("exists", "security group", groupID, groupName)
+	group, err := describeSecurityGroup(ctx, svc, groupID)
+	if err != nil {
+		return err
+	}
+	want := rules(groupID)
+	for _, rule := range want {
+		have := group.IpPermissions
+		if rule.egress {
+			have = group.IpPermissionsEgress
+		}
+		if hasPermission(have, rule.permission) {
+			plan.add("exists", rule.resource(), groupID, ruleDetail(rule))
+		} else {
+			plan.add("create", rule.resource(), groupID, ruleDetail(rule))
+		}
+	}
+	if !reconcile {
+		return nil
+	}
+	_, extra := ruleDiff(group, want)
+	for _, rule := range extra {
+		if cfg.NoPrune {
+			plan.add("exists", rule.resource(), groupID, ruleDetail(rule)+", not in the config")
+		} else {
+			plan.add("delete", rule.resource(), groupID, ruleDetail(rule))
+		}
+	}
+	return nil
+}
+
+// PlanDeleteVPCNetworking ... adds what DeleteVPCNetworking would delete to
+// plan, in the order it would go, and what it would have to leave.
+func PlanDeleteVPCNetworking(ctx context.Context, svc EC2API, cfg *Config, plan *Plan) error {
+	g, err := discover(ctx, svc, cfg, false)
+	if err != nil {
+		return err
+	}
+	return planTeardown(g, plan)
+}
+
+// PlanDeleteState ... adds the resources recorded in state to plan, in the
+// order DeleteStateResources deletes them.
+func PlanDeleteState(state *State, plan *Plan) error {
+	g := newGraph()
+	g.Resources = append([]Resource(nil), state.Resources...)
+	return planTeardown(g, plan)
+}
+
+func planTeardown(g *graph, plan *Plan) error {
+	blocked := g.blocked()
+	order, err := g.deleteOrder()
+	for _, r := range order {
+		if _, kept := g.keep[r.ID]; kept {
+			continue
+		}
+		if by, isBlocked := blocked[r.ID]; isBlocked {
+			plan.add("blocked", r.Type, aws.String(r.ID), "in use by "+strings.Join(by, ", "))
+		} else {
+			plan.add("delete", r.Type, aws.String(r.ID), "")
+		}
+	}
+	return err
+}
+
+func dnsDetail(support bool, hostnames bool) string {
+	return fmt.Sprintf("support=%t hostnames=%t", support, hostnames)
+}
+
+// Eg. "172.25.1.0/24 in us-west-2b, private, carved".
+func (cfg *Config) subnetDetail(cidr string, zone string, tier string) string {
+	detail := cidr + " in " + zone
+	if tier != "" {
+		detail += ", " + tier
+	}
+	if cfg.carved[cidr] {
+		detail += ", carved"
+	}
+	return detail
+}
+
+func dhcpDetail(cfg *Config) string {
+	return cfg.domainName() + " " + strings.Join(cfg.domainNameServers(), ",")
+}
+
+// Eg. "tcp 22-22 from 0.0.0.0/0" or "all to ::/0" for an egress rule.
+func ruleDetail(rule groupRule) string {
+	p := rule.permission
+	var sources []string
+	for _, r := range p.IpRanges {
+		sources = append(sources, aws.StringValue(r.CidrIp))
+	}
+	for _, r := range p.Ipv6Ranges {
+		sources = append(sources, aws.StringValue(r.CidrIpv6))
+	}
+	for _, pair := range p.UserIdGroupPairs {
+		sources = append(sources, aws.StringValue(pair.GroupId))
+	}
+	for _, pl := range p.PrefixListIds {
+		sources = append(sources, aws.StringValue(pl.PrefixListId))
+	}
+	direction := "from"
+	if rule.egress {
+		direction = "to"
+	}
+	protocol := strings.ToLower(aws.StringValue(p.IpProtocol))
+	if protocol == "-1" {
+		protocol = "all"
+	}
+	if p.FromPort != nil && *p.FromPort != -1 {
+		protocol += fmt.Sprintf(" %d-%d", aws.Int64Value(p.FromPort), aws.Int64Value(p.ToPort))
+	}
+	return protocol + " " + direction + " " + strings.Join(sources, ",")
+}
+
+//
+// Lookups shared by `up` and the plans.
+//
+
+// The value of a boolean VPC attribute such as enableDnsSupport.
+func vpcAttribute(ctx context.Context, svc EC2API, vpcID *string, attribute string) (bool, error) {
+	resp, err := svc.DescribeVpcAttributeWithContext(ctx, &ec2.DescribeVpcAttributeInput{
+		VpcId:     vpcID,
+		Attribute: aws.String(attribute),
+	})
+	if err != nil {
+		return false, newError("describe "+attribute+" of", "vpc", vpcID, err)
+	}
+	switch attribute {
+	case ec2.VpcAttributeNameEnableDnsSupport:
+		return resp.EnableDnsSupport != nil && aws.BoolValue(resp.EnableDnsSupport.Value), nil
+	case ec2.VpcAttributeNameEnableDnsHostnames:
+		return resp.EnableDnsHostnames != nil && aws.BoolValue(resp.EnableDnsHostnames.Value), nil
+	}
+	return false, nil
+}
+
+// The VPC's DHCP options set, if it is one of ours.
+func findDhcpOptions(ctx context.Context, svc EC2API, cfg *Config, vpc *ec2.Vpc) (*string, error) {
+	if aws.StringValue(vpc.DhcpOptionsId) == "default" {
+		return nil, nil
+	}
+	resp, err := svc.DescribeDhcpOptionsWithContext(ctx, &ec2.DescribeDhcpOptionsInput{
+		DhcpOptionsIds: []*string{vpc.DhcpOptionsId},
+		Filters:        []*ec2.Filter{cfg.tagFilter()},
+	})
+	if err != nil {
+		return nil, newError("describe", "dhcp options set", vpc.DhcpOptionsId, err)
+	}
+	if len(resp.DhcpOptions) == 0 {
+		return nil, nil
+	}
+	return resp.DhcpOptions[0].DhcpOptionsId, nil
+}
+
+// The VPC's subnet with exactly this CIDR block.
+func findSubnet(ctx context.Context, svc EC2API, vpcID *string, cidr string) (*ec2.Subnet, error) {
+	resp, err := svc.DescribeSubnetsWithContext(ctx, &ec2.DescribeSubnetsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("vpc-id"), Values: []*string{vpcID}},
+			{Name: aws.String("cidr-block"), Values: []*string{aws.String(cidr)}},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "subnet "+cidr, nil, err)
+	}
+	if len(resp.Subnets) == 0 {
+		return nil, nil
+	}
+	return resp.Subnets[0], nil
+}
+
+// The internet gateway attached to the VPC.
+func findIGW(ctx context.Context, svc EC2API, vpcID *string) (*ec2.InternetGateway, error) {
+	resp, err := svc.DescribeInternetGatewaysWithContext(ctx, &ec2.DescribeInternetGatewaysInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("attachment.vpc-id"), Values: []*string{vpcID}},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "internet gateways", vpcID, err)
+	}
+	if len(resp.InternetGateways) == 0 {
+		return nil, nil
+	}
+	return resp.InternetGateways[0], nil
+}
+
+// The route table EC2 created along with the VPC.
+func mainRouteTable(ctx context.Context, svc EC2API, vpcID *string) (*ec2.RouteTable, error) {
+	resp, err := svc.DescribeRouteTablesWithContext(ctx, &ec2.DescribeRouteTablesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("vpc-id"), Values: []*string{vpcID}},
+			{Name: aws.String("association.main"), Values: []*string{aws.String("true")}},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "route tables", vpcID, err)
+	}
+	if len(resp.RouteTables) == 0 {
+		return nil, newError("describe", "route tables", vpcID, fmt.Errorf("the VPC has no main route table"))
+	}
+	return resp.RouteTables[0], nil
+}
@@ -0,0 +1,209 @@
+package awsextra_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/jeremyd/structureag/pkg/awsextra"
+	"github.com/jeremyd/structureag/pkg/awsextra/awsextratest"
+)
+
+// planUp plans `up` the way structureag does and checks nothing but
+// describes were called.
+func planUp(t *testing.T, svc *awsextratest.EC2, cfg *awsextra.Config) *awsextra.Plan {
+	t.Helper()
+	before := len(svc.Calls())
+	plan := &awsextra.Plan{}
+	if err := awsextra.PlanVPCNetworking(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatalf("PlanVPCNetworking: %v", err)
+	}
+	if err := awsextra.PlanSecurityGroup(context.Background(), svc, cfg, "default", plan); err != nil {
+		t.Fatalf("PlanSecurityGroup: %v", err)
+	}
+	assertDescribeOnly(t, svc.Calls()[before:])
+	return plan
+}
+
+func assertDescribeOnly(t *testing.T, calls []string) {
+	t.Helper()
+	for _, call := range calls {
+		if !strings.HasPrefix(call, "Describe") {
+			t.Errorf("plan called %s", call)
+		}
+	}
+}
+
+func actions(plan *awsextra.Plan, resource string) []string {
+	var got []string
+	for _, c := range plan.Changes {
+		if c.Resource == resource {
+			got = append(got, c.Action)
+		}
+	}
+	return got
+}
+
+func TestPlanVPCNetworking(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, svc *awsextratest.EC2, cfg *awsextra.Config)
+		want   map[string][]string
+		counts map[string]int
+	}{
+		{
+			name:  "empty account",
+			setup: func(*testing.T, *awsextratest.EC2, *awsextra.Config) {},
+			want: map[string][]string{
+				"vpc":            {"create"},
+				"subnet":         {"create", "create"},
+				"security group": {"create"},
+				"ingress rule":   {"create", "create"},
+			},
+			counts: map[string]int{"create": 11},
+		},
+		{
+			name: "stack is up",
+			setup: func(t *testing.T, svc *awsextratest.EC2, cfg *awsextra.Config) {
+				up(t, svc, cfg)
+			},
+			want: map[string][]string{
+				"vpc":            {"exists"},
+				"subnet":         {"exists", "exists"},
+				"route":          {"exists"},
+				"security group": {"exists"},
+				"ingress rule":   {"exists", "exists"},
+			},
+			counts: map[string]int{"exists": 11},
+		},
+		{
+			name: "subnet deleted by hand",
+			setup: func(t *testing.T, svc *awsextratest.EC2, cfg *awsextra.Config) {
+				up(t, svc, cfg)
+				subnets, _ := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{})
+				if _, err := svc.DeleteSubnet(&ec2.DeleteSubnetInput{SubnetId: subnets.Subnets[1].SubnetId}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: map[string][]string{
+				"vpc":    {"exists"},
+				"subnet": {"exists", "create"},
+			},
+			counts: map[string]int{"exists": 10, "create": 1},
+		},
+		{
+			name: "dns hostnames turned off by hand",
+			setup: func(t *testing.T, svc *awsextratest.EC2, cfg *awsextra.Config) {
+				up(t, svc, cfg)
+				vpcs, _ := svc.DescribeVpcs(&ec2.DescribeVpcsInput{})
+				_, err := svc.ModifyVpcAttribute(&ec2.ModifyVpcAttributeInput{
+					VpcId:              vpcs.Vpcs[0].VpcId,
+					EnableDnsHostnames: &ec2.AttributeBooleanValue{Value: aws.Bool(false)},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: map[string][]string{
+				"dns attributes": {"update"},
+			},
+			counts: map[string]int{"exists": 10, "update": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(2)
+			svc := awsextratest.NewEC2("us-west-2")
+			tt.setup(t, svc, cfg)
+
+			plan := planUp(t, svc, cfg)
+			for resource, want := range tt.want {
+				if got := actions(plan, resource); strings.Join(got, ",") != strings.Join(want, ",") {
+					t.Errorf("%s actions = %v, want %v", resource, got, want)
+				}
+			}
+			for action, n := range tt.counts {
+				if got := plan.Count(action); got != n {
+					t.Errorf("%d to %s, want %d\n%s", got, action, n, plan)
+				}
+			}
+		})
+	}
+}
+
+func TestPlanVPCNetworkingConflict(t *testing.T) {
+	cfg := testConfig(1)
+	svc := awsextratest.NewEC2("us-west-2")
+	other := testConfig(0)
+	other.TagValue = "other"
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, other, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	err := awsextra.PlanVPCNetworking(context.Background(), svc, cfg, &awsextra.Plan{})
+	if !errors.Is(err, awsextra.ErrCIDRConflict) {
+		t.Fatalf("err = %v, want ErrCIDRConflict", err)
+	}
+}
+
+func TestPlanDeleteVPCNetworking(t *testing.T) {
+	cfg := testConfig(2)
+	svc := awsextratest.NewEC2("us-west-2")
+	up(t, svc, cfg)
+	count := svc.ResourceCount()
+
+	before := len(svc.Calls())
+	plan := &awsextra.Plan{}
+	if err := awsextra.PlanDeleteVPCNetworking(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	assertDescribeOnly(t, svc.Calls()[before:])
+
+	if got := plan.Count("delete"); got != count {
+		t.Errorf("plan deletes %d resources, %d exist\n%s", got, count, plan)
+	}
+	if n := svc.ResourceCount(); n != count {
+		t.Errorf("dry run changed the resource count from %d to %d", count, n)
+	}
+}
+
+// up brings the stack up the way structureag does.
+func up(t *testing.T, svc *awsextratest.EC2, cfg *awsextra.Config) {
+	t.Helper()
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sgID, err := awsextra.CreateSecurityGroup(context.Background(), svc, cfg, "default", vpcID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := awsextra.AuthorizeSecurityGroupsInternalSSH(context.Background(), svc, cfg, sgID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlanVPCNetworkingCarved(t *testing.T) {
+	cfg := testConfig(0)
+	cfg.SubnetCIDRs = []string{"", "172.25.9.0/24"}
+	cfg.SubnetPrefixLength = 20
+	if err := cfg.CarveSubnets(); err != nil {
+		t.Fatal(err)
+	}
+	svc := awsextratest.NewEC2("us-west-2")
+
+	plan := planUp(t, svc, cfg)
+	var details []string
+	for _, c := range plan.Changes {
+		if c.Resource == "subnet" {
+			details = append(details, c.Detail)
+		}
+	}
+	want := "172.25.16.0/20 in us-west-2a, carved,172.25.9.0/24 in us-west-2b"
+	if got := strings.Join(details, ","); got != want {
+		t.Errorf("subnets planned as %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,159 @@
+package awsextra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// RetryPolicy says how long to keep retrying a call that failed with an error
+// expected to go away by itself, eg. throttling or a resource EC2 hasn't
+// finished creating yet.  The pause between attempts starts at
+// InitialInterval and is multiplied by Multiplier after each attempt, up to
+// MaxInterval.  Zero fields take their value from DefaultRetryPolicy.
+type RetryPolicy struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+
+	// Each pause is randomised by up to this fraction either way, so calls
+	// failing together don't all retry together.
+	Jitter float64
+
+	// Give up once this much time has been spent waiting between attempts.
+	MaxElapsed time.Duration
+
+	// AWS error codes retried on every call.  A code starting with "." matches
+	// any code ending with it, eg. ".NotFound".
+	Codes []string
+}
+
+// DefaultRetryPolicy retries throttling and transient service errors for up
+// to five minutes, as long as the old fixed retries of sixty five second
+// pauses did.
+var DefaultRetryPolicy = RetryPolicy{
+	InitialInterval: time.Second,
+	MaxInterval:     30 * time.Second,
+	Multiplier:      2,
+	Jitter:          0.2,
+	MaxElapsed:      5 * time.Minute,
+	Codes: []string{
+		"RequestLimitExceeded",
+		"Throttling",
+		"ThrottlingException",
+		"RequestThrottled",
+		"InternalError",
+		"ServiceUnavailable",
+		"Unavailable",
+	},
+}
+
+// The policy with its unset fields filled in from DefaultRetryPolicy.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.InitialInterval <= 0 {
+		p.InitialInterval = DefaultRetryPolicy.InitialInterval
+	}
+	if p.MaxInterval <= 0 {
+		p.MaxInterval = DefaultRetryPolicy.MaxInterval
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = DefaultRetryPolicy.Multiplier
+	}
+	if p.MaxElapsed <= 0 {
+		p.MaxElapsed = DefaultRetryPolicy.MaxElapsed
+	}
+	if p.Codes == nil {
+		p.Codes = DefaultRetryPolicy.Codes
+	}
+	return p
+}
+
+// interval returns the pause before retry number n, counting from 0.
+func (p RetryPolicy) interval(n int) time.Duration {
+	d := float64(p.InitialInterval)
+	for i := 0; i < n && d < float64(p.MaxInterval); i++ {
+		d *= p.Multiplier
+	}
+	if d > float64(p.MaxInterval) {
+		d = float64(p.MaxInterval)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// do calls attempt until it succeeds, fails with an error neither the policy
+// nor retryable says to retry, the policy runs out of time or ctx is done.
+// It returns the last error from attempt, or the context's error.
+func (p RetryPolicy) do(ctx context.Context, retryable func(error) bool, attempt func() error) error {
+	p = p.withDefaults()
+	var waited time.Duration
+	for n := 0; ; n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+		if !p.retries(err) && (retryable == nil || !retryable(err)) {
+			return err
+		}
+		pause := p.interval(n)
+		if waited+pause > p.MaxElapsed {
+			return err
+		}
+		fmt.Print(".")
+		if err := sleep(ctx, pause); err != nil {
+			return err
+		}
+		waited += pause
+	}
+}
+
+// Is err one the policy always retries?
+func (p RetryPolicy) retries(err error) bool {
+	return onCodes(p.Codes...)(err)
+}
+
+// onCodes returns a retryable func for do matching errors with any of the
+// given AWS error codes.  A code starting with "." matches as a suffix.
+func onCodes(codes ...string) func(error) bool {
+	return func(err error) bool {
+		code := errorCode(err)
+		if code == nil {
+			return false
+		}
+		for _, c := range codes {
+			if *code == c || (strings.HasPrefix(c, ".") && strings.HasSuffix(*code, c)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// errPending is returned by an attempt that hasn't failed but isn't done
+// yet, eg. an instance still shutting down, so do tries it again.
+var errPending = errors.New("not done yet")
+
+func isPending(err error) bool {
+	return errors.Is(err, errPending)
+}
+
+// sleep pauses between retries, returning early with the context's error if
+// ctx is done first.  Tests replace it so retry loops run instantly.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
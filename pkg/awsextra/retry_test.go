@@ -0,0 +1,95 @@
+package awsextra
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// Record the pauses do asks for instead of sleeping.
+func fakeSleep(t *testing.T) *[]time.Duration {
+	var pauses []time.Duration
+	old := sleep
+	sleep = func(ctx context.Context, d time.Duration) error {
+		pauses = append(pauses, d)
+		return ctx.Err()
+	}
+	t.Cleanup(func() { sleep = old })
+	return &pauses
+}
+
+func TestRetryPolicyInterval(t *testing.T) {
+	p := RetryPolicy{InitialInterval: time.Second, MaxInterval: 5 * time.Second, Multiplier: 2}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for n, w := range want {
+		if got := p.interval(n); got != w {
+			t.Errorf("interval(%d) = %v, want %v", n, got, w)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.interval(1); got < time.Second || got > 3*time.Second {
+			t.Fatalf("interval(1) with jitter = %v, want within 1s-3s", got)
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	throttled := awserr.New("RequestLimitExceeded", "slow down", nil)
+	notFound := awserr.New("InvalidVpcID.NotFound", "no such vpc", nil)
+	denied := awserr.New("UnauthorizedOperation", "denied", nil)
+	p := RetryPolicy{InitialInterval: time.Second, MaxInterval: 10 * time.Second, Multiplier: 2, MaxElapsed: 30 * time.Second}
+
+	tests := []struct {
+		name      string
+		errs      []error // returned by each attempt in turn, then nil
+		retryable func(error) bool
+		wantErr   error
+		wantCalls int
+	}{
+		{"success", nil, nil, nil, 1},
+		{"throttling", []error{throttled, throttled}, nil, nil, 3},
+		{"caller's code", []error{notFound}, onCodes(".NotFound"), nil, 2},
+		{"not retryable", []error{notFound}, nil, notFound, 1},
+		{"other error", []error{denied}, onCodes(".NotFound"), denied, 1},
+		// 1+2+4+8+10 seconds, the next pause would pass MaxElapsed.
+		{"out of time", []error{throttled, throttled, throttled, throttled, throttled, throttled, throttled}, nil, throttled, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeSleep(t)
+			calls := 0
+			err := p.do(context.Background(), tt.retryable, func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if err != tt.wantErr {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("%d calls, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDoCancel(t *testing.T) {
+	fakeSleep(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := DefaultRetryPolicy.do(ctx, isPending, func() error {
+		calls++
+		cancel()
+		return errPending
+	})
+	if !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Errorf("err = %v after %d calls, want context.Canceled after 1", err, calls)
+	}
+}
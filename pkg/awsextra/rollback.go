@@ -0,0 +1,490 @@
+package awsextra
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// Transaction is an EC2API that remembers every resource created through it,
+// so a failed `up` can delete what it created and nothing else.  Resources an
+// earlier run created, and ones `up` found and adopted, are left alone.  The
+// flow log's IAM role and log group are remembered too when made through
+// the clients IAM and Logs return.  So are the changes `up` makes to the
+// routes and route table associations of tables it didn't create, eg. taking
+// the internet route out of the main route table, so they can be undone.
+type Transaction struct {
+	EC2API
+
+	mu      sync.Mutex
+	created []Resource
+	undo    []func(ctx context.Context, svc EC2API) error
+	iamSvc  IAMAPI
+	logsSvc LogsAPI
+}
+
+// NewTransaction returns a Transaction making its calls through svc.
+func NewTransaction(svc EC2API) *Transaction {
+	return &Transaction{EC2API: svc}
+}
+
+// Created returns the resources created so far, oldest first.
+func (t *Transaction) Created() []Resource {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Resource(nil), t.created...)
+}
+
+// IAM returns iamSvc, with the roles created through it remembered by the
+// transaction.
+func (t *Transaction) IAM(iamSvc IAMAPI) IAMAPI {
+	t.iamSvc = iamSvc
+	return &transactionIAM{IAMAPI: iamSvc, t: t}
+}
+
+// Logs returns logsSvc, with the log groups created through it remembered by
+// the transaction.
+func (t *Transaction) Logs(logsSvc LogsAPI) LogsAPI {
+	t.logsSvc = logsSvc
+	return &transactionLogs{LogsAPI: logsSvc, t: t}
+}
+
+type transactionIAM struct {
+	IAMAPI
+	t *Transaction
+}
+
+func (ti *transactionIAM) CreateRoleWithContext(ctx aws.Context, in *iam.CreateRoleInput, opts ...request.Option) (*iam.CreateRoleOutput, error) {
+	resp, err := ti.IAMAPI.CreateRoleWithContext(ctx, in, opts...)
+	if err == nil {
+		ti.t.add("iam role", resp.Role.RoleName)
+	}
+	return resp, err
+}
+
+type transactionLogs struct {
+	LogsAPI
+	t *Transaction
+}
+
+func (tl *transactionLogs) CreateLogGroupWithContext(ctx aws.Context, in *cloudwatchlogs.CreateLogGroupInput, opts ...request.Option) (*cloudwatchlogs.CreateLogGroupOutput, error) {
+	resp, err := tl.LogsAPI.CreateLogGroupWithContext(ctx, in, opts...)
+	if err == nil {
+		tl.t.add("log group", in.LogGroupName)
+	}
+	return resp, err
+}
+
+func (t *Transaction) add(resourceType string, ID *string, dependsOn ...*string) {
+	if ID == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := Resource{Type: resourceType, ID: *ID}
+	for _, dep := range dependsOn {
+		if dep != nil {
+			r.DependsOn = append(r.DependsOn, *dep)
+		}
+	}
+	t.created = append(t.created, r)
+}
+
+// depend records that the created resource ID depends on dep.
+func (t *Transaction) depend(ID *string, dep *string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.created {
+		if t.created[i].ID == aws.StringValue(ID) && !contains(t.created[i].DependsOn, aws.StringValue(dep)) {
+			t.created[i].DependsOn = append(t.created[i].DependsOn, aws.StringValue(dep))
+		}
+	}
+}
+
+// changed records how to undo a change made through the transaction.
+func (t *Transaction) changed(undo func(ctx context.Context, svc EC2API) error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.undo = append(t.undo, undo)
+}
+
+// routeBefore returns the table's route to dest as it is before it changes,
+// or nil if there is none or the transaction created the table, which goes
+// with its routes on a rollback anyway.
+func (t *Transaction) routeBefore(ctx aws.Context, rtID *string, dest string) (*ec2.Route, error) {
+	if createdID(t.Created(), aws.StringValue(rtID)) {
+		return nil, nil
+	}
+	resp, err := t.EC2API.DescribeRouteTablesWithContext(ctx, &ec2.DescribeRouteTablesInput{RouteTableIds: []*string{rtID}})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.RouteTables) == 0 {
+		return nil, nil
+	}
+	return findRoute(resp.RouteTables[0], dest), nil
+}
+
+func (t *Transaction) CreateRouteWithContext(ctx aws.Context, in *ec2.CreateRouteInput, opts ...request.Option) (*ec2.CreateRouteOutput, error) {
+	resp, err := t.EC2API.CreateRouteWithContext(ctx, in, opts...)
+	if err == nil && !createdID(t.Created(), aws.StringValue(in.RouteTableId)) {
+		dest := routeDestination(&ec2.Route{
+			DestinationCidrBlock:     in.DestinationCidrBlock,
+			DestinationIpv6CidrBlock: in.DestinationIpv6CidrBlock,
+			DestinationPrefixListId:  in.DestinationPrefixListId,
+		})
+		t.changed(func(ctx context.Context, svc EC2API) error {
+			_, err := svc.DeleteRouteWithContext(ctx, &ec2.DeleteRouteInput{
+				RouteTableId:             in.RouteTableId,
+				DestinationCidrBlock:     in.DestinationCidrBlock,
+				DestinationIpv6CidrBlock: in.DestinationIpv6CidrBlock,
+				DestinationPrefixListId:  in.DestinationPrefixListId,
+			})
+			if err != nil && !isNotFound(err) {
+				return newError("delete route "+dest+" in", "route table", in.RouteTableId, err)
+			}
+			fmt.Println("Deleted route " + dest + " from route table " + *in.RouteTableId)
+			return nil
+		})
+	}
+	return resp, err
+}
+
+func (t *Transaction) ReplaceRouteWithContext(ctx aws.Context, in *ec2.ReplaceRouteInput, opts ...request.Option) (*ec2.ReplaceRouteOutput, error) {
+	dest := routeDestination(&ec2.Route{
+		DestinationCidrBlock:     in.DestinationCidrBlock,
+		DestinationIpv6CidrBlock: in.DestinationIpv6CidrBlock,
+		DestinationPrefixListId:  in.DestinationPrefixListId,
+	})
+	old, err := t.routeBefore(ctx, in.RouteTableId, dest)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.EC2API.ReplaceRouteWithContext(ctx, in, opts...)
+	if err == nil && old != nil {
+		route := Route{Destination: dest, Target: routeTarget(old)}
+		t.changed(func(ctx context.Context, svc EC2API) error {
+			if _, err := svc.ReplaceRouteWithContext(ctx, replaceRouteInput(createRouteInput(in.RouteTableId, route))); err != nil {
+				return newError("restore route "+route.String()+" in", "route table", in.RouteTableId, err)
+			}
+			fmt.Println("Restored route " + route.String() + " in route table " + *in.RouteTableId)
+			return nil
+		})
+	}
+	return resp, err
+}
+
+func (t *Transaction) DeleteRouteWithContext(ctx aws.Context, in *ec2.DeleteRouteInput, opts ...request.Option) (*ec2.DeleteRouteOutput, error) {
+	dest := routeDestination(&ec2.Route{
+		DestinationCidrBlock:     in.DestinationCidrBlock,
+		DestinationIpv6CidrBlock: in.DestinationIpv6CidrBlock,
+		DestinationPrefixListId:  in.DestinationPrefixListId,
+	})
+	old, err := t.routeBefore(ctx, in.RouteTableId, dest)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.EC2API.DeleteRouteWithContext(ctx, in, opts...)
+	if err == nil && old != nil {
+		route := Route{Destination: dest, Target: routeTarget(old)}
+		t.changed(func(ctx context.Context, svc EC2API) error {
+			if _, err := svc.CreateRouteWithContext(ctx, createRouteInput(in.RouteTableId, route)); err != nil {
+				return newError("restore route "+route.String()+" in", "route table", in.RouteTableId, err)
+			}
+			fmt.Println("Restored route " + route.String() + " in route table " + *in.RouteTableId)
+			return nil
+		})
+	}
+	return resp, err
+}
+
+func (t *Transaction) AssociateRouteTableWithContext(ctx aws.Context, in *ec2.AssociateRouteTableInput, opts ...request.Option) (*ec2.AssociateRouteTableOutput, error) {
+	resp, err := t.EC2API.AssociateRouteTableWithContext(ctx, in, opts...)
+	created := t.Created()
+	if err == nil && !createdID(created, aws.StringValue(in.RouteTableId)) && !createdID(created, aws.StringValue(in.SubnetId)) {
+		t.changed(func(ctx context.Context, svc EC2API) error {
+			params := &ec2.DisassociateRouteTableInput{AssociationId: resp.AssociationId}
+			if _, err := svc.DisassociateRouteTableWithContext(ctx, params); err != nil && !isNotFound(err) {
+				return newError("disassociate", "route table", in.RouteTableId, fmt.Errorf("from %s: %w", *in.SubnetId, err))
+			}
+			fmt.Println("Disassociated route table " + *in.RouteTableId + " from subnet " + *in.SubnetId)
+			return nil
+		})
+	}
+	return resp, err
+}
+
+func (t *Transaction) CreateVpcWithContext(ctx aws.Context, in *ec2.CreateVpcInput, opts ...request.Option) (*ec2.CreateVpcOutput, error) {
+	resp, err := t.EC2API.CreateVpcWithContext(ctx, in, opts...)
+	if err == nil {
+		t.add("vpc", resp.Vpc.VpcId)
+	}
+	return resp, err
+}
+
+func (t *Transaction) CreateDhcpOptionsWithContext(ctx aws.Context, in *ec2.CreateDhcpOptionsInput, opts ...request.Option) (*ec2.CreateDhcpOptionsOutput, error) {
+	resp, err := t.EC2API.CreateDhcpOptionsWithContext(ctx, in, opts...)
+	if err == nil {
+		t.add("dhcp options set", resp.DhcpOptions.DhcpOptionsId)
+	}
+	return resp, err
+}
+
+func (t *Transaction) AssociateDhcpOptionsWithContext(ctx aws.Context, in *ec2.AssociateDhcpOptionsInput, opts ...request.Option) (*ec2.AssociateDhcpOptionsOutput, error) {
+	resp, err := t.EC2API.AssociateDhcpOptionsWithContext(ctx, in, opts...)
+	if err == nil {
+		t.depend(in.VpcId, in.DhcpOptionsId)
+	}
+	return resp, err
+}
+
+func (t *Transaction) CreateSubnetWithContext(ctx aws.Context, in *ec2.CreateSubnetInput, opts ...request.Option) (*ec2.CreateSubnetOutput, error) {
+	resp, err := t.EC2API.CreateSubnetWithContext(ctx, in, opts...)
+	if err == nil {
+		t.add("subnet", resp.Subnet.SubnetId, in.VpcId)
+	}
+	return resp, err
+}
+
+func (t *Transaction) CreateInternetGatewayWithContext(ctx aws.Context, in *ec2.CreateInternetGatewayInput, opts ...request.Option) (*ec2.CreateInternetGatewayOutput, error) {
+	resp, err := t.EC2API.CreateInternetGatewayWithContext(ctx, in, opts...)
+	if err == nil {
+		t.add("internet gateway", resp.InternetGateway.InternetGatewayId)
+	}
+	return resp, err
+}
+
+func (t *Transaction) AttachInternetGatewayWithContext(ctx aws.Context, in *ec2.AttachInternetGatewayInput, opts ...request.Option) (*ec2.AttachInternetGatewayOutput, error) {
+	resp, err := t.EC2API.AttachInternetGatewayWithContext(ctx, in, opts...)
+	if err == nil {
+		t.depend(in.InternetGatewayId, in.VpcId)
+	}
+	return resp, err
+}
+
+func (t *Transaction) CreateEgressOnlyInternetGatewayWithContext(ctx aws.Context, in *ec2.CreateEgressOnlyInternetGatewayInput, opts ...request.Option) (*ec2.CreateEgressOnlyInternetGatewayOutput, error) {
+	resp, err := t.EC2API.CreateEgressOnlyInternetGatewayWithContext(ctx, in, opts...)
+	if err == nil {
+		t.add("egress-only internet gateway", resp.EgressOnlyInternetGateway.EgressOnlyInternetGatewayId, in.VpcId)
+	}
+	return resp, err
+}
+
+func (t *Transaction) CreateSecurityGroupWithContext(ctx aws.Context, in *ec2.CreateSecurityGroupInput, opts ...request.Option) (*ec2.CreateSecurityGroupOutput, error) {
+	resp, err := t.EC2API.CreateSecurityGroupWithContext(ctx, in, opts...)
+	if err == nil {
+		t.add("security group", resp.GroupId, in.VpcId)
+	}
+	return resp, err
+}
+
+func (t *Transaction) CreateRouteTableWithContext(ctx aws.Context, in *ec2.CreateRouteTableInput, opts ...request.Option) (*ec2.CreateRouteTableOutput, error) {
+	resp, err := t.EC2API.CreateRouteTableWithContext(ctx, in, opts...)
+	if err == nil {
+		t.add("route table", resp.RouteTable.RouteTableId, in.VpcId)
+	}
+	return resp, err
+}
+
+func (t *Transaction) CreateNetworkAclWithContext(ctx aws.Context, in *ec2.CreateNetworkAclInput, opts ...request.Option) (*ec2.CreateNetworkAclOutput, error) {
+	resp, err := t.EC2API.CreateNetworkAclWithContext(ctx, in, opts...)
+	if err == nil {
+		t.add("network acl", resp.NetworkAcl.NetworkAclId, in.VpcId)
+	}
+	return resp, err
+}
+
+func (t *Transaction) AllocateAddressWithContext(ctx aws.Context, in *ec2.AllocateAddressInput, opts ...request.Option) (*ec2.AllocateAddressOutput, error) {
+	resp, err := t.EC2API.AllocateAddressWithContext(ctx, in, opts...)
+	if err == nil {
+		t.add("elastic ip", resp.AllocationId)
+	}
+	return resp, err
+}
+
+func (t *Transaction) CreateNatGatewayWithContext(ctx aws.Context, in *ec2.CreateNatGatewayInput, opts ...request.Option) (*ec2.CreateNatGatewayOutput, error) {
+	resp, err := t.EC2API.CreateNatGatewayWithContext(ctx, in, opts...)
+	if err == nil {
+		t.add("nat gateway", resp.NatGateway.NatGatewayId, in.SubnetId, in.AllocationId)
+	}
+	return resp, err
+}
+
+func (t *Transaction) CreateVpcEndpointWithContext(ctx aws.Context, in *ec2.CreateVpcEndpointInput, opts ...request.Option) (*ec2.CreateVpcEndpointOutput, error) {
+	resp, err := t.EC2API.CreateVpcEndpointWithContext(ctx, in, opts...)
+	if err == nil {
+		dependsOn := append(append(append([]*string{in.VpcId}, in.RouteTableIds...), in.SubnetIds...), in.SecurityGroupIds...)
+		t.add("vpc endpoint", resp.VpcEndpoint.VpcEndpointId, dependsOn...)
+	}
+	return resp, err
+}
+
+func (t *Transaction) CreateFlowLogsWithContext(ctx aws.Context, in *ec2.CreateFlowLogsInput, opts ...request.Option) (*ec2.CreateFlowLogsOutput, error) {
+	resp, err := t.EC2API.CreateFlowLogsWithContext(ctx, in, opts...)
+	if err == nil {
+		for _, ID := range resp.FlowLogIds {
+			t.add("flow log", ID, in.ResourceIds...)
+		}
+	}
+	return resp, err
+}
+
+func (t *Transaction) CreateTransitGatewayWithContext(ctx aws.Context, in *ec2.CreateTransitGatewayInput, opts ...request.Option) (*ec2.CreateTransitGatewayOutput, error) {
+	resp, err := t.EC2API.CreateTransitGatewayWithContext(ctx, in, opts...)
+	if err == nil {
+		t.add("transit gateway", resp.TransitGateway.TransitGatewayId)
+	}
+	return resp, err
+}
+
+func (t *Transaction) CreateTransitGatewayVpcAttachmentWithContext(ctx aws.Context, in *ec2.CreateTransitGatewayVpcAttachmentInput, opts ...request.Option) (*ec2.CreateTransitGatewayVpcAttachmentOutput, error) {
+	resp, err := t.EC2API.CreateTransitGatewayVpcAttachmentWithContext(ctx, in, opts...)
+	if err == nil {
+		dependsOn := append([]*string{in.VpcId, in.TransitGatewayId}, in.SubnetIds...)
+		t.add("transit gateway attachment", resp.TransitGatewayVpcAttachment.TransitGatewayAttachmentId, dependsOn...)
+	}
+	return resp, err
+}
+
+func (t *Transaction) CreateVpnGatewayWithContext(ctx aws.Context, in *ec2.CreateVpnGatewayInput, opts ...request.Option) (*ec2.CreateVpnGatewayOutput, error) {
+	resp, err := t.EC2API.CreateVpnGatewayWithContext(ctx, in, opts...)
+	if err == nil {
+		t.add("vpn gateway", resp.VpnGateway.VpnGatewayId)
+	}
+	return resp, err
+}
+
+func (t *Transaction) AttachVpnGatewayWithContext(ctx aws.Context, in *ec2.AttachVpnGatewayInput, opts ...request.Option) (*ec2.AttachVpnGatewayOutput, error) {
+	resp, err := t.EC2API.AttachVpnGatewayWithContext(ctx, in, opts...)
+	if err == nil {
+		t.depend(in.VpnGatewayId, in.VpcId)
+	}
+	return resp, err
+}
+
+func (t *Transaction) CreateCustomerGatewayWithContext(ctx aws.Context, in *ec2.CreateCustomerGatewayInput, opts ...request.Option) (*ec2.CreateCustomerGatewayOutput, error) {
+	resp, err := t.EC2API.CreateCustomerGatewayWithContext(ctx, in, opts...)
+	if err == nil {
+		t.add("customer gateway", resp.CustomerGateway.CustomerGatewayId)
+	}
+	return resp, err
+}
+
+func (t *Transaction) CreateVpnConnectionWithContext(ctx aws.Context, in *ec2.CreateVpnConnectionInput, opts ...request.Option) (*ec2.CreateVpnConnectionOutput, error) {
+	resp, err := t.EC2API.CreateVpnConnectionWithContext(ctx, in, opts...)
+	if err == nil {
+		t.add("vpn connection", resp.VpnConnection.VpnConnectionId, in.VpnGatewayId, in.CustomerGatewayId)
+	}
+	return resp, err
+}
+
+// Rollback ... undoes the route changes made through the transaction, newest
+// first, and then deletes the resources created through it, each one as soon
+// as nothing else it created depends on it, and drops them from state, which
+// may be nil.  A created resource that state shows an older one
+// depending on, eg. a new DHCP options set associated with an existing VPC,
+// is left and reported as blocked.  The IAM role and log group go last, once
+// the flow log using them is gone.  The Report lists what was removed.
+func (t *Transaction) Rollback(ctx context.Context, cfg *Config, state *State) (*Report, error) {
+	report := &Report{}
+	t.mu.Lock()
+	undo := t.undo
+	t.mu.Unlock()
+	for len(undo) > 0 {
+		if err := undo[len(undo)-1](ctx, t.EC2API); err != nil {
+			return report, err
+		}
+		undo = undo[:len(undo)-1]
+		t.mu.Lock()
+		t.undo = undo
+		t.mu.Unlock()
+	}
+
+	g := newGraph()
+	created := t.Created()
+	for _, r := range created {
+		if r.Type == "iam role" || r.Type == "log group" {
+			continue
+		}
+		if state != nil {
+			var by []string
+			for _, other := range state.Resources {
+				if contains(other.DependsOn, r.ID) && !createdID(created, other.ID) {
+					by = append(by, other.ID)
+				}
+			}
+			if len(by) > 0 {
+				report.block(r.Type, r.ID, by)
+				continue
+			}
+			if recorded := state.find(r.ID); recorded != nil {
+				for _, dep := range recorded.DependsOn {
+					if !contains(r.DependsOn, dep) {
+						r.DependsOn = append(r.DependsOn, dep)
+					}
+				}
+			}
+		}
+		g.Resources = append(g.Resources, r)
+	}
+	td := &teardown{
+		svc:    t.EC2API,
+		retry:  cfg.Retry,
+		report: report,
+		deleted: func(r Resource) {
+			t.forget(r.ID)
+			if state != nil {
+				state.forget(r.ID)
+			}
+		},
+	}
+	if err := td.run(ctx, g); err != nil {
+		return report, err
+	}
+	for _, r := range created {
+		ID := aws.String(r.ID)
+		switch r.Type {
+		case "iam role":
+			if err := deleteFlowLogRole(ctx, t.iamSvc, ID); err != nil {
+				return report, err
+			}
+		case "log group":
+			_, err := t.logsSvc.DeleteLogGroupWithContext(ctx, &cloudwatchlogs.DeleteLogGroupInput{LogGroupName: ID})
+			if code := errorCode(err); err != nil && (code == nil || *code != cloudwatchlogs.ErrCodeResourceNotFoundException) {
+				return report, newError("delete", "log group", ID, err)
+			}
+			fmt.Println("Deleted log group " + r.ID)
+		default:
+			continue
+		}
+		report.add(r.Type, ID)
+		t.forget(r.ID)
+	}
+	return report, nil
+}
+
+func createdID(created []Resource, ID string) bool {
+	for _, r := range created {
+		if r.ID == ID {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Transaction) forget(ID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.created {
+		if t.created[i].ID == ID {
+			t.created = append(t.created[:i], t.created[i+1:]...)
+			return
+		}
+	}
+}
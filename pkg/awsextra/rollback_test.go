@@ -0,0 +1,215 @@
+package awsextra_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/jeremyd/structureag/pkg/awsextra"
+	"github.com/jeremyd/structureag/pkg/awsextra/awsextratest"
+)
+
+func TestRollback(t *testing.T) {
+	cfg := testConfig(2)
+	svc := awsextratest.NewEC2("us-west-2")
+	svc.InjectError("CreateRoute", awserr.New("UnauthorizedOperation", "denied", nil))
+	state := awsextra.NewState(cfg)
+	tx := awsextra.NewTransaction(svc)
+
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), tx, cfg, state); err == nil {
+		t.Fatal("CreateVPCNetworking succeeded, want the injected CreateRoute failure")
+	}
+	if n := len(tx.Created()); n != 6 {
+		t.Errorf("transaction created %d resources, want 6: %v", n, tx.Created())
+	}
+
+	report, err := tx.Rollback(context.Background(), cfg, state)
+	if err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if len(report.Removed) != 6 {
+		t.Errorf("rollback removed %v, want all 6", report.Removed)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left after rollback", n)
+	}
+	if len(state.Resources) != 0 {
+		t.Errorf("state still lists\n%s", state)
+	}
+}
+
+func TestRollbackLeavesExisting(t *testing.T) {
+	cfg := testConfig(2)
+	svc := awsextratest.NewEC2("us-west-2")
+	state := upWithState(t, svc, cfg)
+
+	// Lose a subnet, then fail while re-creating it.
+	subnets, _ := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{})
+	lost := *subnets.Subnets[1].SubnetId
+	svc.DeleteSubnet(&ec2.DeleteSubnetInput{SubnetId: subnets.Subnets[1].SubnetId})
+	if _, err := awsextra.RefreshState(context.Background(), svc, state); err != nil {
+		t.Fatal(err)
+	}
+	before := svc.ResourceCount()
+	svc.InjectError("ModifySubnetAttribute", awserr.New("UnauthorizedOperation", "denied", nil))
+
+	tx := awsextra.NewTransaction(svc)
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), tx, cfg, state); err == nil {
+		t.Fatal("CreateVPCNetworking succeeded, want the injected ModifySubnetAttribute failure")
+	}
+	created := tx.Created()
+	if len(created) != 1 || created[0].Type != "subnet" || created[0].ID == lost {
+		t.Fatalf("transaction created %v, want only the new subnet", created)
+	}
+
+	if _, err := tx.Rollback(context.Background(), cfg, state); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if n := svc.ResourceCount(); n != before {
+		t.Errorf("%d resources left after rollback, want the %d that were there", n, before)
+	}
+	if got := types(state)["subnet"]; got != 1 {
+		t.Errorf("state has %d subnets, want 1\n%s", got, state)
+	}
+}
+
+func TestRollbackFlowLog(t *testing.T) {
+	cfg := flowLogConfig()
+	svc := awsextratest.NewEC2("us-west-2")
+	iamSvc := awsextratest.NewIAM()
+	logsSvc := awsextratest.NewLogs("us-west-2")
+	state := awsextra.NewState(cfg)
+	tx := awsextra.NewTransaction(svc)
+
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), tx, cfg, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The role and log group are made, then the flow log fails.
+	svc.InjectError("CreateFlowLogs", awserr.New("UnauthorizedOperation", "denied", nil))
+	if err := awsextra.CreateFlowLog(context.Background(), tx, tx.IAM(iamSvc), tx.Logs(logsSvc), cfg, vpcID, state); err == nil {
+		t.Fatal("CreateFlowLog succeeded, want the injected CreateFlowLogs failure")
+	}
+	if n := iamSvc.ResourceCount() + logsSvc.ResourceCount(); n != 2 {
+		t.Fatalf("%d IAM and Logs resources, want the role and log group", n)
+	}
+
+	report, err := tx.Rollback(context.Background(), cfg, state)
+	if err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	removed := map[string]bool{}
+	for _, r := range report.Removed {
+		removed[r.Resource] = true
+	}
+	if !removed["iam role"] || !removed["log group"] {
+		t.Errorf("rollback removed %v, want the role and log group too", report.Removed)
+	}
+	if n := svc.ResourceCount() + iamSvc.ResourceCount() + logsSvc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left after rollback", n)
+	}
+	if len(tx.Created()) != 0 {
+		t.Errorf("transaction still lists %v", tx.Created())
+	}
+}
+
+func TestRollbackAfterFlowLog(t *testing.T) {
+	cfg := flowLogConfig()
+	svc := awsextratest.NewEC2("us-west-2")
+	iamSvc := awsextratest.NewIAM()
+	logsSvc := awsextratest.NewLogs("us-west-2")
+	tx := awsextra.NewTransaction(svc)
+
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), tx, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := awsextra.CreateFlowLog(context.Background(), tx, tx.IAM(iamSvc), tx.Logs(logsSvc), cfg, vpcID, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A later step fails: the flow log goes before its role.
+	before := len(svc.Calls())
+	if _, err := tx.Rollback(context.Background(), cfg, nil); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if count(svc.Calls()[before:], "DeleteFlowLogs") != 1 {
+		t.Errorf("rollback didn't delete the flow log: %v", svc.Calls()[before:])
+	}
+	if n := svc.ResourceCount() + iamSvc.ResourceCount() + logsSvc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left after rollback", n)
+	}
+}
+
+func TestRollbackRestoresRoutes(t *testing.T) {
+	cfg := testConfig(2)
+	cfg.Routes = []awsextra.Route{{Destination: "10.1.0.0/16", Target: "pcx-0123456789abcdef0"}}
+	svc := awsextratest.NewEC2("us-west-2")
+	state := upWithState(t, svc, cfg)
+
+	// As an earlier version left it: the internet route in the main route
+	// table, and the second subnet only associated with that.
+	main, _ := svc.DescribeRouteTables(&ec2.DescribeRouteTablesInput{Filters: []*ec2.Filter{
+		{Name: aws.String("association.main"), Values: []*string{aws.String("true")}},
+	}})
+	mainID := main.RouteTables[0].RouteTableId
+	igws, _ := svc.DescribeInternetGateways(&ec2.DescribeInternetGatewaysInput{})
+	igwID := igws.InternetGateways[0].InternetGatewayId
+	if _, err := svc.CreateRoute(&ec2.CreateRouteInput{RouteTableId: mainID, DestinationCidrBlock: aws.String("0.0.0.0/0"), GatewayId: igwID}); err != nil {
+		t.Fatal(err)
+	}
+	subnets, _ := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{Filters: []*ec2.Filter{
+		{Name: aws.String("cidr-block"), Values: []*string{aws.String(cfg.SubnetCIDRs[1])}},
+	}})
+	subnetID := subnets.Subnets[0].SubnetId
+	associations := func() []*ec2.RouteTable {
+		rts, _ := svc.DescribeRouteTables(&ec2.DescribeRouteTablesInput{Filters: []*ec2.Filter{
+			{Name: aws.String("association.subnet-id"), Values: []*string{subnetID}},
+		}})
+		return rts.RouteTables
+	}
+	for _, rt := range associations() {
+		for _, assoc := range rt.Associations {
+			if aws.StringValue(assoc.SubnetId) == *subnetID {
+				svc.DisassociateRouteTable(&ec2.DisassociateRouteTableInput{AssociationId: assoc.RouteTableAssociationId})
+			}
+		}
+	}
+	before := svc.ResourceCount()
+
+	// The run changes all three, then fails.
+	cfg.Routes[0].Target = "tgw-0123456789abcdef0"
+	cfg.PrivateSubnetCIDRs = []string{"172.25.100.0/24"}
+	cfg.NATGateways = awsextra.NATGatewaysSingle
+	svc.InjectError("CreateNatGateway", awserr.New("UnauthorizedOperation", "denied", nil))
+	tx := awsextra.NewTransaction(svc)
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), tx, cfg, state); err == nil {
+		t.Fatal("CreateVPCNetworking succeeded, want the injected CreateNatGateway failure")
+	}
+	if got := routeTo(t, svc, "public", "10.1.0.0/16"); got != "tgw-0123456789abcdef0" {
+		t.Fatalf("before rollback, public 10.1.0.0/16 goes to %q", got)
+	}
+
+	if _, err := tx.Rollback(context.Background(), cfg, state); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if got := routeTo(t, svc, "public", "10.1.0.0/16"); got != "pcx-0123456789abcdef0" {
+		t.Errorf("public 10.1.0.0/16 goes to %q after rollback, want the peering connection back", got)
+	}
+	main, _ = svc.DescribeRouteTables(&ec2.DescribeRouteTablesInput{RouteTableIds: []*string{mainID}})
+	var restored bool
+	for _, r := range main.RouteTables[0].Routes {
+		restored = restored || aws.StringValue(r.DestinationCidrBlock) == "0.0.0.0/0" && aws.StringValue(r.GatewayId) == *igwID
+	}
+	if !restored {
+		t.Errorf("main route table routes = %v, want the internet route back", main.RouteTables[0].Routes)
+	}
+	if rts := associations(); len(rts) != 0 {
+		t.Errorf("subnet %s is associated with %v after rollback, want none", *subnetID, rts)
+	}
+	if n := svc.ResourceCount(); n != before {
+		t.Errorf("%d resources left after rollback, want the %d that were there", n, before)
+	}
+}
@@ -0,0 +1,299 @@
+package awsextra
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Route is a static route in the stack's route tables.
+type Route struct {
+	// An IPv4 or IPv6 CIDR block, or a prefix list ID, eg. "pl-68a54001".
+	Destination string
+
+	// Where the traffic goes: a VPC peering connection ("pcx-..."), a virtual
+	// private gateway ("vgw-..."), a transit gateway ("tgw-..."), a network
+	// interface ("eni-..."), an internet gateway ("igw-..."), an egress-only
+	// internet gateway ("eigw-...") or a NAT gateway ("nat-...").
+	Target string
+
+	// Which route tables get the route: RouteTablesPublic, RouteTablesPrivate
+	// or, if empty, all of them.
+	Tables string
+}
+
+// Values of Route.Tables.
+const (
+	RouteTablesPublic  = "public"
+	RouteTablesPrivate = "private"
+)
+
+// The ID prefix of each kind of route target.
+var routeTargets = []string{"pcx-", "vgw-", "tgw-", "eni-", "igw-", "eigw-", "nat-"}
+
+func (r Route) validate() error {
+	if strings.HasPrefix(r.Destination, "pl-") {
+		// A prefix list.
+	} else if _, _, err := net.ParseCIDR(r.Destination); err != nil {
+		return fmt.Errorf("destination: %v", err)
+	} else if r.Destination == "0.0.0.0/0" || r.Destination == "::/0" {
+		return fmt.Errorf("destination: %s is the internet route up manages itself", r.Destination)
+	}
+	known := false
+	for _, prefix := range routeTargets {
+		known = known || strings.HasPrefix(r.Target, prefix)
+	}
+	if !known {
+		return fmt.Errorf("target: %q is not a %s ID", r.Target, strings.Join(routeTargets, ", "))
+	}
+	switch r.Tables {
+	case "", RouteTablesPublic, RouteTablesPrivate:
+	default:
+		return fmt.Errorf("tables: %q is not %q or %q", r.Tables, RouteTablesPublic, RouteTablesPrivate)
+	}
+	return nil
+}
+
+func (r Route) String() string {
+	return r.Destination + " to " + r.Target
+}
+
+// The public route table's routes: the internet routes to the internet
+// gateway, then the configured ones.
+func (cfg *Config) publicRoutes(IGWID string) []Route {
+	routes := []Route{{Destination: "0.0.0.0/0", Target: IGWID}}
+	if cfg.IPv6 {
+		routes = append(routes, Route{Destination: "::/0", Target: IGWID})
+	}
+	return append(routes, cfg.routesFor(RouteTablesPublic)...)
+}
+
+// A private route table's routes: IPv4 internet traffic to the NAT gateway,
+// IPv6 internet traffic to the egress-only internet gateway, then the
+// configured routes.
+func (cfg *Config) privateRoutes(natID string, eigwID string) []Route {
+	routes := []Route{{Destination: "0.0.0.0/0", Target: natID}}
+	if cfg.IPv6 {
+		routes = append(routes, Route{Destination: "::/0", Target: eigwID})
+	}
+	return append(routes, cfg.routesFor(RouteTablesPrivate)...)
+}
+
+// The configured routes for the public or private route tables.
+func (cfg *Config) routesFor(tables string) []Route {
+	var routes []Route
+	for _, r := range cfg.Routes {
+		if r.Tables == "" || r.Tables == tables {
+			routes = append(routes, r)
+		}
+	}
+	return routes
+}
+
+// Find the stack's route table for the given purpose, eg. "public", or
+// create it, then make sure it has the routes and is explicitly associated
+// with the subnets.  It returns the route table's ID.
+func ensureRouteTable(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, purpose string, routes []Route, subnetIDs []*string, state *State) (*string, error) {
+	rt, err := findRouteTable(ctx, svc, cfg, vpcID, purpose)
+	if err != nil {
+		return nil, err
+	}
+	if rt != nil {
+		fmt.Println("Found route table " + *rt.RouteTableId + " for " + purpose)
+	} else {
+		resp, err := svc.CreateRouteTableWithContext(ctx, &ec2.CreateRouteTableInput{VpcId: vpcID})
+		if err != nil {
+			return nil, newError("create", "route table", nil, err)
+		}
+		rt = resp.RouteTable
+		fmt.Println("Created route table " + *rt.RouteTableId + " for " + purpose)
+	}
+	state.record("route table", rt.RouteTableId, vpcID)
+	if err := tagIt(ctx, svc, cfg, "route table", rt.RouteTableId, cfg.TagKey, cfg.TagValue); err != nil {
+		return rt.RouteTableId, err
+	}
+	if err := tagIt(ctx, svc, cfg, "route table", rt.RouteTableId, "for", purpose); err != nil {
+		return rt.RouteTableId, err
+	}
+
+	for _, route := range routes {
+		if err := ensureRoute(ctx, svc, rt, route); err != nil {
+			return rt.RouteTableId, err
+		}
+	}
+
+	for _, subnetID := range subnetIDs {
+		if associated(rt, subnetID) {
+			continue
+		}
+		_, err := svc.AssociateRouteTableWithContext(ctx, &ec2.AssociateRouteTableInput{
+			RouteTableId: rt.RouteTableId, // Required
+			SubnetId:     subnetID,        // Required
+		})
+		if err != nil {
+			return rt.RouteTableId, newError("associate", "route table", rt.RouteTableId, fmt.Errorf("with %s: %w", *subnetID, err))
+		}
+		fmt.Println("Associated route table " + *rt.RouteTableId + " with subnet " + *subnetID)
+	}
+	return rt.RouteTableId, nil
+}
+
+// Add the route to the table, or point it at the route's target if it goes
+// somewhere else.
+func ensureRoute(ctx context.Context, svc EC2API, rt *ec2.RouteTable, route Route) error {
+	existing := findRoute(rt, route.Destination)
+	if existing != nil && routeTarget(existing) == route.Target {
+		fmt.Println("Found route " + route.String())
+		return nil
+	}
+
+	in := createRouteInput(rt.RouteTableId, route)
+	if existing == nil {
+		if _, err := svc.CreateRouteWithContext(ctx, in); err != nil {
+			return newError("create route "+route.String()+" in", "route table", rt.RouteTableId, err)
+		}
+		fmt.Println("Created route " + route.String())
+		return nil
+	}
+	_, err := svc.ReplaceRouteWithContext(ctx, replaceRouteInput(in))
+	if err != nil {
+		return newError("replace route "+route.String()+" in", "route table", rt.RouteTableId, err)
+	}
+	fmt.Println("Replaced route " + route.String() + ", was to " + routeTarget(existing))
+	return nil
+}
+
+// The CreateRouteInput adding route to the table.
+func createRouteInput(rtID *string, route Route) *ec2.CreateRouteInput {
+	in := &ec2.CreateRouteInput{RouteTableId: rtID}
+	switch {
+	case strings.HasPrefix(route.Destination, "pl-"):
+		in.DestinationPrefixListId = aws.String(route.Destination)
+	case strings.Contains(route.Destination, ":"):
+		in.DestinationIpv6CidrBlock = aws.String(route.Destination)
+	default:
+		in.DestinationCidrBlock = aws.String(route.Destination)
+	}
+	switch target := route.Target; {
+	case strings.HasPrefix(target, "pcx-"):
+		in.VpcPeeringConnectionId = aws.String(target)
+	case strings.HasPrefix(target, "tgw-"):
+		in.TransitGatewayId = aws.String(target)
+	case strings.HasPrefix(target, "eni-"):
+		in.NetworkInterfaceId = aws.String(target)
+	case strings.HasPrefix(target, "nat-"):
+		in.NatGatewayId = aws.String(target)
+	case strings.HasPrefix(target, "eigw-"):
+		in.EgressOnlyInternetGatewayId = aws.String(target)
+	default:
+		in.GatewayId = aws.String(target)
+	}
+	return in
+}
+
+// The same route as in, as a ReplaceRouteInput pointing an existing route
+// at its target.
+func replaceRouteInput(in *ec2.CreateRouteInput) *ec2.ReplaceRouteInput {
+	return &ec2.ReplaceRouteInput{
+		RouteTableId:                in.RouteTableId,
+		DestinationCidrBlock:        in.DestinationCidrBlock,
+		DestinationIpv6CidrBlock:    in.DestinationIpv6CidrBlock,
+		DestinationPrefixListId:     in.DestinationPrefixListId,
+		EgressOnlyInternetGatewayId: in.EgressOnlyInternetGatewayId,
+		GatewayId:                   in.GatewayId,
+		NatGatewayId:                in.NatGatewayId,
+		NetworkInterfaceId:          in.NetworkInterfaceId,
+		TransitGatewayId:            in.TransitGatewayId,
+		VpcPeeringConnectionId:      in.VpcPeeringConnectionId,
+	}
+}
+
+// The table's route to dest, an IPv4 or IPv6 CIDR block or prefix list ID.
+func findRoute(rt *ec2.RouteTable, dest string) *ec2.Route {
+	for _, r := range rt.Routes {
+		if aws.StringValue(r.DestinationCidrBlock) == dest || aws.StringValue(r.DestinationIpv6CidrBlock) == dest ||
+			aws.StringValue(r.DestinationPrefixListId) == dest {
+			return r
+		}
+	}
+	return nil
+}
+
+// The ID of whatever the route sends its traffic to.
+func routeTarget(r *ec2.Route) string {
+	for _, ID := range []*string{r.GatewayId, r.NatGatewayId, r.EgressOnlyInternetGatewayId, r.VpcPeeringConnectionId, r.TransitGatewayId, r.NetworkInterfaceId, r.InstanceId} {
+		if ID != nil {
+			return *ID
+		}
+	}
+	return ""
+}
+
+// The IPv4 or IPv6 CIDR block, or prefix list ID, the route is for.
+func routeDestination(r *ec2.Route) string {
+	for _, dest := range []*string{r.DestinationCidrBlock, r.DestinationIpv6CidrBlock} {
+		if dest != nil {
+			return *dest
+		}
+	}
+	return aws.StringValue(r.DestinationPrefixListId)
+}
+
+// Earlier versions put the internet route in the VPC's main route table,
+// making every subnet without an explicit association public.  Once the
+// public subnets have their own table, take it out.
+func removeMainInternetRoute(ctx context.Context, svc EC2API, vpcID *string, IGWID *string) error {
+	rt, err := mainRouteTable(ctx, svc, vpcID)
+	if err != nil {
+		return err
+	}
+	route := findRoute(rt, "0.0.0.0/0")
+	if route == nil || routeTarget(route) != aws.StringValue(IGWID) {
+		return nil
+	}
+	_, err = svc.DeleteRouteWithContext(ctx, &ec2.DeleteRouteInput{
+		RouteTableId:         rt.RouteTableId,
+		DestinationCidrBlock: aws.String("0.0.0.0/0"),
+	})
+	if err != nil {
+		return newError("delete internet route in main", "route table", rt.RouteTableId, err)
+	}
+	fmt.Println("Removed the internet route from main route table " + *rt.RouteTableId)
+	return nil
+}
+
+// planRouteTable adds what ensureRouteTable would do to plan.  vpcID is nil
+// when the VPC is still to be created.
+func planRouteTable(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, purpose string, routes []Route, plan *Plan) error {
+	var rt *ec2.RouteTable
+	if vpcID != nil {
+		var err error
+		if rt, err = findRouteTable(ctx, svc, cfg, vpcID, purpose); err != nil {
+			return err
+		}
+	}
+	if rt == nil {
+		plan.add("create", "route table", nil, purpose)
+		for _, route := range routes {
+			plan.add("create", "route", nil, route.String())
+		}
+		return nil
+	}
+	plan.add("exists", "route table", rt.RouteTableId, purpose)
+	for _, route := range routes {
+		existing := findRoute(rt, route.Destination)
+		switch {
+		case existing == nil:
+			plan.add("create", "route", rt.RouteTableId, route.String())
+		case routeTarget(existing) != route.Target:
+			plan.add("update", "route", rt.RouteTableId, route.String()+", was to "+routeTarget(existing))
+		default:
+			plan.add("exists", "route", rt.RouteTableId, route.String())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package awsextra_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/jeremyd/structureag/pkg/awsextra"
+	"github.com/jeremyd/structureag/pkg/awsextra/awsextratest"
+)
+
+// routeTo returns where the route table named for purpose sends dest, or "".
+func routeTo(t *testing.T, svc *awsextratest.EC2, purpose string, dest string) string {
+	t.Helper()
+	rts, _ := svc.DescribeRouteTables(&ec2.DescribeRouteTablesInput{Filters: []*ec2.Filter{
+		{Name: aws.String("tag:for"), Values: []*string{aws.String(purpose)}},
+	}})
+	if len(rts.RouteTables) != 1 {
+		t.Fatalf("%d route tables for %s, want 1", len(rts.RouteTables), purpose)
+	}
+	for _, r := range rts.RouteTables[0].Routes {
+		if aws.StringValue(r.DestinationCidrBlock) == dest || aws.StringValue(r.DestinationIpv6CidrBlock) == dest ||
+			aws.StringValue(r.DestinationPrefixListId) == dest {
+			for _, ID := range []*string{r.GatewayId, r.NatGatewayId, r.EgressOnlyInternetGatewayId, r.VpcPeeringConnectionId, r.TransitGatewayId} {
+				if ID != nil {
+					return *ID
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func TestCreateVPCNetworkingStaticRoutes(t *testing.T) {
+	cfg := privateConfig(1, awsextra.NATGatewaysSingle)
+	cfg.Routes = []awsextra.Route{
+		{Destination: "10.1.0.0/16", Target: "pcx-0123456789abcdef0"},
+		{Destination: "pl-68a54001", Target: "vgw-0123456789abcdef0", Tables: awsextra.RouteTablesPrivate},
+	}
+	svc := awsextratest.NewEC2("us-west-2")
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	zone := "private us-west-2a"
+	if got := routeTo(t, svc, "public", "10.1.0.0/16"); got != "pcx-0123456789abcdef0" {
+		t.Errorf("public 10.1.0.0/16 goes to %q", got)
+	}
+	if got := routeTo(t, svc, zone, "10.1.0.0/16"); got != "pcx-0123456789abcdef0" {
+		t.Errorf("private 10.1.0.0/16 goes to %q", got)
+	}
+	if got := routeTo(t, svc, "public", "pl-68a54001"); got != "" {
+		t.Errorf("public route table has the private-only route to %q", got)
+	}
+	if got := routeTo(t, svc, zone, "pl-68a54001"); got != "vgw-0123456789abcdef0" {
+		t.Errorf("private pl-68a54001 goes to %q", got)
+	}
+
+	// Pointing a route somewhere else replaces it.
+	cfg.Routes[0].Target = "tgw-0123456789abcdef0"
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := routeTo(t, svc, "public", "10.1.0.0/16"); got != "tgw-0123456789abcdef0" {
+		t.Errorf("after the change, public 10.1.0.0/16 goes to %q", got)
+	}
+}
+
+// A stack brought up before the public route table has the internet route
+// in the main route table.
+func TestCreateVPCNetworkingMovesMainInternetRoute(t *testing.T) {
+	cfg := testConfig(2)
+	svc := awsextratest.NewEC2("us-west-2")
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	main, _ := svc.DescribeRouteTables(&ec2.DescribeRouteTablesInput{Filters: []*ec2.Filter{
+		{Name: aws.String("association.main"), Values: []*string{aws.String("true")}},
+	}})
+	igws, _ := svc.DescribeInternetGateways(&ec2.DescribeInternetGatewaysInput{})
+	_, err = svc.CreateRoute(&ec2.CreateRouteInput{
+		RouteTableId:         main.RouteTables[0].RouteTableId,
+		DestinationCidrBlock: aws.String("0.0.0.0/0"),
+		GatewayId:            igws.InternetGateways[0].InternetGatewayId,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan := &awsextra.Plan{}
+	if err := awsextra.PlanVPCNetworking(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	if n := plan.Count("delete"); n != 1 {
+		t.Errorf("plan deletes %d things, want the main internet route\n%s", n, plan)
+	}
+
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	subnets, _ := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{})
+	checkPublicRoutes(t, svc, vpcID, subnets.Subnets)
+}
@@ -1,46 +1,91 @@
 package awsextra
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"time"
+	"net"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/spf13/viper"
 )
 
-func CreateSecurityGroup(svc *ec2.EC2, kindOf string, vpcID *string) (securityGroupID *string) {
-	groupName := kindOf + "-" + viper.GetString("tagkey")
+// CreateSecurityGroup ... returns the stack's security group for kindOf,
+// creating it only if it doesn't exist.  A group left untagged by an earlier
+// run is found by name and tagged.  The group is recorded in state, which may
+// be nil.
+func CreateSecurityGroup(ctx context.Context, svc EC2API, cfg *Config, kindOf string, vpcID *string, state *State) (securityGroupID *string, err error) {
+	securityGroupID, err = GetSecurityGroup(ctx, svc, cfg, kindOf)
+	if err != nil {
+		return nil, err
+	}
+	if securityGroupID != nil {
+		fmt.Println("Found security group " + *securityGroupID)
+		state.record("security group", securityGroupID, vpcID)
+		return securityGroupID, nil
+	}
+
+	groupName := kindOf + "-" + cfg.TagKey
 	params := &ec2.CreateSecurityGroupInput{
 		Description: aws.String(groupName), // Required
 		GroupName:   aws.String(groupName),
 		VpcId:       vpcID,
 	}
-	resp, err := svc.CreateSecurityGroup(params)
-
-	haltOnError(err, "Error creating security group")
-	fmt.Println("Created security group " + *resp.GroupId)
-
-	securityGroupID = resp.GroupId
+	resp, err := svc.CreateSecurityGroupWithContext(ctx, params)
+	if code := errorCode(err); code != nil && *code == "InvalidGroup.Duplicate" {
+		securityGroupID, err = securityGroupByName(ctx, svc, groupName, vpcID)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println("Found security group " + *securityGroupID)
+	} else if err != nil {
+		return nil, newError("create", "security group", nil, err)
+	} else {
+		securityGroupID = resp.GroupId
+		fmt.Println("Created security group " + *securityGroupID)
+	}
+	state.record("security group", securityGroupID, vpcID)
 
 	// Tag with the necessary tags
-	tagIt(svc, securityGroupID, viper.GetString("tagkey"), viper.GetString("tagvalue"))
+	if err := tagIt(ctx, svc, cfg, "security group", securityGroupID, cfg.TagKey, cfg.TagValue); err != nil {
+		return securityGroupID, err
+	}
 	// Tag an extra tag so we know what this security group is for.
-	tagIt(svc, securityGroupID, "for", kindOf)
+	if err := tagIt(ctx, svc, cfg, "security group", securityGroupID, "for", kindOf); err != nil {
+		return securityGroupID, err
+	}
 
-	return securityGroupID
+	return securityGroupID, nil
 }
 
-func GetSecurityGroup(svc *ec2.EC2, kindOf string) *string {
+func securityGroupByName(ctx context.Context, svc EC2API, groupName string, vpcID *string) (*string, error) {
 	params := &ec2.DescribeSecurityGroupsInput{
 		Filters: []*ec2.Filter{
-			{ // Required
-				Name: aws.String("tag:" + viper.GetString("tagkey")),
-				Values: []*string{
-					aws.String(viper.GetString("tagvalue")),
-				},
+			{
+				Name:   aws.String("group-name"),
+				Values: []*string{aws.String(groupName)},
+			},
+			{
+				Name:   aws.String("vpc-id"),
+				Values: []*string{vpcID},
 			},
+		},
+	}
+	resp, err := svc.DescribeSecurityGroupsWithContext(ctx, params)
+	if err != nil {
+		return nil, newError("describe", "security groups", nil, err)
+	}
+	if len(resp.SecurityGroups) == 0 {
+		return nil, newError("describe", "security group "+groupName, nil, errors.New("not found"))
+	}
+	return resp.SecurityGroups[0].GroupId, nil
+}
+
+func GetSecurityGroup(ctx context.Context, svc EC2API, cfg *Config, kindOf string) (*string, error) {
+	params := &ec2.DescribeSecurityGroupsInput{
+		Filters: []*ec2.Filter{
+			cfg.tagFilter(),
 			{ // Required
 				Name: aws.String("tag:for"),
 				Values: []*string{
@@ -49,104 +94,534 @@ func GetSecurityGroup(svc *ec2.EC2, kindOf string) *string {
 			},
 		},
 	}
-	resp, err := svc.DescribeSecurityGroups(params)
-
-	haltOnError(err, "Error describing security groups")
+	resp, err := svc.DescribeSecurityGroupsWithContext(ctx, params)
+	if err != nil {
+		return nil, newError("describe", "security groups", nil, err)
+	}
 
 	if len(resp.SecurityGroups) == 0 {
-		return nil
+		return nil, nil
 	}
 
-	return resp.SecurityGroups[0].GroupId
+	return resp.SecurityGroups[0].GroupId, nil
 }
 
-func AuthorizeSecurityGroupsInternalSSH(svc *ec2.EC2, groupID *string) {
-	// Internal traffic from this group on all ports TCP
-	params := &ec2.AuthorizeSecurityGroupIngressInput{
-		GroupId: groupID,
-		IpPermissions: []*ec2.IpPermission{
-			{ // Required
-				FromPort:   aws.Int64(0),
-				IpProtocol: aws.String("TCP"),
-				ToPort:     aws.Int64(65535),
-				UserIdGroupPairs: []*ec2.UserIdGroupPair{
-					{ // Required
-						GroupId: groupID,
-					},
+// A rule, whether it is an egress rule, and the step that adds it, for error
+// messages.
+type groupRule struct {
+	step       string
+	permission *ec2.IpPermission
+	egress     bool
+}
+
+// Internal traffic from the group itself on all TCP ports, and SSH from
+// anywhere, over IPv6 too if ipv6 is set.  The IPv6 rule is separate so a
+// group from before the stack was dual-stack gets just that added.
+func internalSSHRules(groupID *string, ipv6 bool) []groupRule {
+	rules := []groupRule{
+		{step: "authorize internal TCP for", permission: &ec2.IpPermission{
+			FromPort:   aws.Int64(0),
+			IpProtocol: aws.String("tcp"),
+			ToPort:     aws.Int64(65535),
+			UserIdGroupPairs: []*ec2.UserIdGroupPair{
+				{ // Required
+					GroupId: groupID,
 				},
 			},
-		},
+		}},
+		{step: "authorize SSH for", permission: &ec2.IpPermission{
+			FromPort:   aws.Int64(22),
+			IpProtocol: aws.String("tcp"),
+			ToPort:     aws.Int64(22),
+			IpRanges: []*ec2.IpRange{
+				{CidrIp: aws.String("0.0.0.0/0")},
+			},
+		}},
+	}
+	if ipv6 {
+		rules = append(rules, groupRule{step: "authorize IPv6 SSH for", permission: &ec2.IpPermission{
+			FromPort:   aws.Int64(22),
+			IpProtocol: aws.String("tcp"),
+			ToPort:     aws.Int64(22),
+			Ipv6Ranges: []*ec2.Ipv6Range{
+				{CidrIpv6: aws.String("::/0")},
+			},
+		}})
 	}
-	_, errInt := svc.AuthorizeSecurityGroupIngress(params)
+	return rules
+}
+
+// AuthorizeSecurityGroupsInternalSSH ... adds the internal TCP and SSH rules
+// the group doesn't already have, allowing SSH over IPv6 as well when
+// cfg.IPv6 is set.
+func AuthorizeSecurityGroupsInternalSSH(ctx context.Context, svc EC2API, cfg *Config, groupID *string) error {
+	return authorizeRules(ctx, svc, groupID, internalSSHRules(groupID, cfg.IPv6))
+}
 
-	haltOnError(errInt, "Could not authorize security group for internal traffic on all TCP ports")
+// Add the rules the group doesn't already have.
+func authorizeRules(ctx context.Context, svc EC2API, groupID *string, rules []groupRule) error {
+	group, err := describeSecurityGroup(ctx, svc, groupID)
+	if err != nil {
+		return err
+	}
+	missing, _ := ruleDiff(group, rules)
+	return addRules(ctx, svc, groupID, missing)
+}
 
-	// SSH on 22
-	paramsSSH := &ec2.AuthorizeSecurityGroupIngressInput{
-		CidrIp:     aws.String("0.0.0.0/0"),
-		FromPort:   aws.Int64(22),
-		GroupId:    groupID,
-		IpProtocol: aws.String("TCP"),
-		ToPort:     aws.Int64(22),
+// reconcileRules makes the group's rules match rules: the missing ones are
+// added and the others revoked, or with cfg.NoPrune only reported.  Egress
+// rules are left alone unless rules has some, so a group without any keeps
+// the egress rules EC2 gave it.  What differs is printed.
+func reconcileRules(ctx context.Context, svc EC2API, cfg *Config, groupID *string, rules []groupRule) error {
+	group, err := describeSecurityGroup(ctx, svc, groupID)
+	if err != nil {
+		return err
+	}
+	missing, extra := ruleDiff(group, rules)
+	if len(missing) == 0 && len(extra) == 0 {
+		return nil
+	}
+	fmt.Println("Security group " + *groupID + " rules differ from the config:")
+	for _, rule := range missing {
+		fmt.Println(planSymbols["create"] + " " + rule.resource() + " " + ruleDetail(rule))
+	}
+	for _, rule := range extra {
+		if cfg.NoPrune {
+			fmt.Println(planSymbols["exists"] + " " + rule.resource() + " " + ruleDetail(rule) + " (not in the config, kept)")
+		} else {
+			fmt.Println(planSymbols["delete"] + " " + rule.resource() + " " + ruleDetail(rule))
+		}
 	}
-	_, errSSH := svc.AuthorizeSecurityGroupIngress(paramsSSH)
-	haltOnError(errSSH, "Could not authorize security group for SSH")
 
+	if err := addRules(ctx, svc, groupID, missing); err != nil {
+		return err
+	}
+	if cfg.NoPrune {
+		return nil
+	}
+	for _, rule := range extra {
+		var err error
+		if rule.egress {
+			params := &ec2.RevokeSecurityGroupEgressInput{GroupId: groupID, IpPermissions: []*ec2.IpPermission{rule.permission}}
+			_, err = svc.RevokeSecurityGroupEgressWithContext(ctx, params)
+		} else {
+			params := &ec2.RevokeSecurityGroupIngressInput{GroupId: groupID, IpPermissions: []*ec2.IpPermission{rule.permission}}
+			_, err = svc.RevokeSecurityGroupIngressWithContext(ctx, params)
+		}
+		if err != nil {
+			return newError(rule.step, "security group", groupID, err)
+		}
+	}
+	return nil
 }
 
-func DeleteSecurityGroup(svc *ec2.EC2, secGroupID *string) bool {
-	return handleDeleteSecGroup(svc, secGroupID, 0)
+func describeSecurityGroup(ctx context.Context, svc EC2API, groupID *string) (*ec2.SecurityGroup, error) {
+	resp, err := svc.DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{GroupIds: []*string{groupID}})
+	if err != nil {
+		return nil, newError("describe", "security group", groupID, err)
+	}
+	if len(resp.SecurityGroups) == 0 {
+		return nil, errNotFound("describe", "security group", groupID, "InvalidGroup.NotFound")
+	}
+	return resp.SecurityGroups[0], nil
 }
 
-func handleDeleteSecGroup(svc *ec2.EC2, secGroupID *string, retryCount int64) bool {
-	params := &ec2.DeleteSecurityGroupInput{
-		GroupId: secGroupID,
+// ruleDiff returns the rules the group is missing, and the ones it has
+// beyond rules, one source each.  Its egress rules are only compared when
+// rules has some.
+func ruleDiff(group *ec2.SecurityGroup, rules []groupRule) (missing []groupRule, extra []groupRule) {
+	var want, wantEgress []*ec2.IpPermission
+	for _, rule := range rules {
+		have := group.IpPermissions
+		if rule.egress {
+			have = group.IpPermissionsEgress
+			wantEgress = append(wantEgress, rule.permission)
+		} else {
+			want = append(want, rule.permission)
+		}
+		if !hasPermission(have, rule.permission) {
+			missing = append(missing, rule)
+		}
 	}
-	_, err := svc.DeleteSecurityGroup(params)
-	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			if awsErr.Code() == "DependencyViolation" {
-				fmt.Print(".")
-				retryCount++
-				if retryCount > 60 {
-					fmt.Println("retry limit reached for security group deletion.")
-					return false
-				}
-				time.Sleep(time.Second * 5)
-				handleDeleteSecGroup(svc, secGroupID, retryCount)
+	for _, p := range singleSources(group.IpPermissions) {
+		if !hasPermission(want, p) {
+			extra = append(extra, groupRule{step: "revoke ingress rule from", permission: p})
+		}
+	}
+	if len(wantEgress) > 0 {
+		for _, p := range singleSources(group.IpPermissionsEgress) {
+			if !hasPermission(wantEgress, p) {
+				extra = append(extra, groupRule{step: "revoke egress rule from", permission: p, egress: true})
 			}
+		}
+	}
+	return missing, extra
+}
+
+// singleSources splits permissions into one per source, which is how EC2
+// matches the rules it revokes.
+func singleSources(perms []*ec2.IpPermission) []*ec2.IpPermission {
+	var split []*ec2.IpPermission
+	one := func(p *ec2.IpPermission) *ec2.IpPermission {
+		return &ec2.IpPermission{IpProtocol: p.IpProtocol, FromPort: p.FromPort, ToPort: p.ToPort}
+	}
+	for _, p := range perms {
+		for _, r := range p.IpRanges {
+			s := one(p)
+			s.IpRanges = []*ec2.IpRange{{CidrIp: r.CidrIp}}
+			split = append(split, s)
+		}
+		for _, r := range p.Ipv6Ranges {
+			s := one(p)
+			s.Ipv6Ranges = []*ec2.Ipv6Range{{CidrIpv6: r.CidrIpv6}}
+			split = append(split, s)
+		}
+		for _, pair := range p.UserIdGroupPairs {
+			s := one(p)
+			s.UserIdGroupPairs = []*ec2.UserIdGroupPair{{GroupId: pair.GroupId, UserId: pair.UserId}}
+			split = append(split, s)
+		}
+		for _, pl := range p.PrefixListIds {
+			s := one(p)
+			s.PrefixListIds = []*ec2.PrefixListId{{PrefixListId: pl.PrefixListId}}
+			split = append(split, s)
+		}
+	}
+	return split
+}
+
+// Authorize rules, which the group doesn't have yet.
+func addRules(ctx context.Context, svc EC2API, groupID *string, rules []groupRule) error {
+	for _, rule := range rules {
+		var err error
+		if rule.egress {
+			params := &ec2.AuthorizeSecurityGroupEgressInput{GroupId: groupID, IpPermissions: []*ec2.IpPermission{rule.permission}}
+			_, err = svc.AuthorizeSecurityGroupEgressWithContext(ctx, params)
 		} else {
-			fmt.Println(err.Error())
+			params := &ec2.AuthorizeSecurityGroupIngressInput{GroupId: groupID, IpPermissions: []*ec2.IpPermission{rule.permission}}
+			_, err = svc.AuthorizeSecurityGroupIngressWithContext(ctx, params)
+		}
+		if err != nil {
+			return newError(rule.step, "security group", groupID, err)
+		}
+	}
+	return nil
+}
+
+// hasPermission reports whether every source of want is already allowed by
+// one of the group's permissions.
+func hasPermission(have []*ec2.IpPermission, want *ec2.IpPermission) bool {
+	matching := func(p *ec2.IpPermission) bool {
+		return strings.EqualFold(aws.StringValue(p.IpProtocol), aws.StringValue(want.IpProtocol)) &&
+			aws.Int64Value(p.FromPort) == aws.Int64Value(want.FromPort) &&
+			aws.Int64Value(p.ToPort) == aws.Int64Value(want.ToPort)
+	}
+	for _, r := range want.IpRanges {
+		found := false
+		for _, p := range have {
+			for _, hr := range p.IpRanges {
+				found = found || matching(p) && aws.StringValue(hr.CidrIp) == aws.StringValue(r.CidrIp)
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, r := range want.Ipv6Ranges {
+		found := false
+		for _, p := range have {
+			for _, hr := range p.Ipv6Ranges {
+				found = found || matching(p) && aws.StringValue(hr.CidrIpv6) == aws.StringValue(r.CidrIpv6)
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, pair := range want.UserIdGroupPairs {
+		found := false
+		for _, p := range have {
+			for _, hp := range p.UserIdGroupPairs {
+				found = found || matching(p) && aws.StringValue(hp.GroupId) == aws.StringValue(pair.GroupId)
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, pl := range want.PrefixListIds {
+		found := false
+		for _, p := range have {
+			for _, hp := range p.PrefixListIds {
+				found = found || matching(p) && aws.StringValue(hp.PrefixListId) == aws.StringValue(pl.PrefixListId)
+			}
+		}
+		if !found {
+			return false
 		}
-		return false
 	}
-	fmt.Println("deleted security group " + *secGroupID)
 	return true
 }
 
-func stripSecGroup(svc *ec2.EC2, secGroupID *string) {
+// SecurityGroup is one of the stack's security groups and its rules.  Kind
+// tells it from the stack's other groups, the way kindOf does for
+// CreateSecurityGroup.
+type SecurityGroup struct {
+	Kind string
+
+	// Rules for the traffic the group's members accept, and send.  A group
+	// without egress rules keeps the ones EC2 gave it, which send anything.
+	Ingress []SecurityGroupRule
+	Egress  []SecurityGroupRule
+}
+
+// SecurityGroupRule allows traffic from, or for egress rules to, exactly one
+// of CIDR, IPv6CIDR, PrefixList or Group.
+type SecurityGroupRule struct {
+	// "tcp", "udp", "icmp" or "all".  icmp rules for an IPv6CIDR are for
+	// ICMPv6.
+	Protocol string
+
+	// The ports a tcp or udp rule allows, all of them if both are 0.  ToPort
+	// defaults to FromPort.  icmp rules allow every type and code.
+	FromPort int64
+	ToPort   int64
+
+	CIDR       string
+	IPv6CIDR   string
+	PrefixList string
+
+	// The Kind of another of the stack's security groups, or of the rule's
+	// own group.
+	Group string
+}
+
+func (r SecurityGroupRule) ports() (int64, int64) {
+	if r.ToPort == 0 && r.FromPort == 0 {
+		return 0, 65535
+	}
+	if r.ToPort == 0 {
+		return r.FromPort, r.FromPort
+	}
+	return r.FromPort, r.ToPort
+}
+
+func (r SecurityGroupRule) validate(kinds map[string]bool) error {
+	switch r.Protocol {
+	case "tcp", "udp":
+		if from, to := r.ports(); from < 0 || to > 65535 || from > to {
+			return fmt.Errorf("from-port: %d-%d is not a port range", from, to)
+		}
+	case "icmp", "all":
+		if r.FromPort != 0 || r.ToPort != 0 {
+			return errors.New("from-port: only tcp and udp rules have ports")
+		}
+	default:
+		return fmt.Errorf("protocol: %q is not tcp, udp, icmp or all", r.Protocol)
+	}
+	sources := 0
+	for _, source := range []string{r.CIDR, r.IPv6CIDR, r.PrefixList, r.Group} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return errors.New("cidr: a rule needs exactly one of cidr, ipv6-cidr, prefix-list or group")
+	}
+	switch {
+	case r.CIDR != "":
+		ip, _, err := net.ParseCIDR(r.CIDR)
+		if err != nil {
+			return fmt.Errorf("cidr: %v", err)
+		}
+		if ip.To4() == nil {
+			return fmt.Errorf("cidr: %s is not IPv4, use ipv6-cidr", r.CIDR)
+		}
+	case r.IPv6CIDR != "":
+		ip, _, err := net.ParseCIDR(r.IPv6CIDR)
+		if err != nil {
+			return fmt.Errorf("ipv6-cidr: %v", err)
+		}
+		if ip.To4() != nil {
+			return fmt.Errorf("ipv6-cidr: %s is not IPv6, use cidr", r.IPv6CIDR)
+		}
+	case r.PrefixList != "":
+		if !strings.HasPrefix(r.PrefixList, "pl-") {
+			return fmt.Errorf("prefix-list: %q is not a pl- ID", r.PrefixList)
+		}
+	case !kinds[r.Group]:
+		return fmt.Errorf("group: there is no security group of kind %q", r.Group)
+	}
+	return nil
+}
+
+func (g SecurityGroup) validate(kinds map[string]bool) error {
+	for i, r := range g.Ingress {
+		if err := r.validate(kinds); err != nil {
+			return fmt.Errorf("ingress-%d-%v", i, err)
+		}
+	}
+	for i, r := range g.Egress {
+		if err := r.validate(kinds); err != nil {
+			return fmt.Errorf("egress-%d-%v", i, err)
+		}
+	}
+	return nil
+}
+
+// The rule's permission, with the groups it references looked up by kind in
+// groupIDs.
+func (r SecurityGroupRule) permission(groupIDs map[string]*string) *ec2.IpPermission {
+	p := &ec2.IpPermission{IpProtocol: aws.String(r.Protocol)}
+	switch r.Protocol {
+	case "tcp", "udp":
+		from, to := r.ports()
+		p.FromPort, p.ToPort = aws.Int64(from), aws.Int64(to)
+	case "icmp":
+		p.FromPort, p.ToPort = aws.Int64(-1), aws.Int64(-1)
+		if r.IPv6CIDR != "" {
+			p.IpProtocol = aws.String("icmpv6")
+		}
+	case "all":
+		p.IpProtocol = aws.String("-1")
+	}
+	switch {
+	case r.CIDR != "":
+		p.IpRanges = []*ec2.IpRange{{CidrIp: aws.String(r.CIDR)}}
+	case r.IPv6CIDR != "":
+		p.Ipv6Ranges = []*ec2.Ipv6Range{{CidrIpv6: aws.String(r.IPv6CIDR)}}
+	case r.PrefixList != "":
+		p.PrefixListIds = []*ec2.PrefixListId{{PrefixListId: aws.String(r.PrefixList)}}
+	default:
+		p.UserIdGroupPairs = []*ec2.UserIdGroupPair{{GroupId: groupIDs[r.Group]}}
+	}
+	return p
+}
+
+// The group's rules, ingress then egress.
+func (g SecurityGroup) rules(groupIDs map[string]*string) []groupRule {
+	var rules []groupRule
+	for i, r := range g.Ingress {
+		rules = append(rules, groupRule{step: fmt.Sprintf("authorize ingress rule %d for", i), permission: r.permission(groupIDs)})
+	}
+	for i, r := range g.Egress {
+		rules = append(rules, groupRule{step: fmt.Sprintf("authorize egress rule %d for", i), permission: r.permission(groupIDs), egress: true})
+	}
+	return rules
+}
+
+func (r groupRule) resource() string {
+	if r.egress {
+		return "egress rule"
+	}
+	return "ingress rule"
+}
+
+// The security groups up creates: cfg's or, if it has none, the default
+// group with the rules of internalSSHRules.
+func (cfg *Config) securityGroups() []SecurityGroup {
+	if len(cfg.SecurityGroups) > 0 {
+		return cfg.SecurityGroups
+	}
+	group := SecurityGroup{Kind: "default", Ingress: []SecurityGroupRule{
+		{Protocol: "tcp", Group: "default"},
+		{Protocol: "tcp", FromPort: 22, CIDR: "0.0.0.0/0"},
+	}}
+	if cfg.IPv6 {
+		group.Ingress = append(group.Ingress, SecurityGroupRule{Protocol: "tcp", FromPort: 22, IPv6CIDR: "::/0"})
+	}
+	return []SecurityGroup{group}
+}
+
+// CreateSecurityGroups ... creates the stack's security groups, cfg's or, if
+// it has none, the default one with the internal TCP and SSH rules.  Every
+// group is created first, so groups can reference each other, and then each
+// one's rules are reconciled: the missing ones are added and any others
+// revoked, or with cfg.NoPrune only reported.  The groups are recorded in
+// state, which may be nil.
+func CreateSecurityGroups(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, state *State) error {
+	groups := cfg.securityGroups()
+	groupIDs := map[string]*string{}
+	for _, group := range groups {
+		groupID, err := CreateSecurityGroup(ctx, svc, cfg, group.Kind, vpcID, state)
+		if err != nil {
+			return err
+		}
+		groupIDs[group.Kind] = groupID
+	}
+	for _, group := range groups {
+		if err := reconcileRules(ctx, svc, cfg, groupIDs[group.Kind], group.rules(groupIDs)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteSecurityGroup deletes the group, retrying under cfg's RetryPolicy
+// while something is still using it.
+func DeleteSecurityGroup(ctx context.Context, svc EC2API, cfg *Config, secGroupID *string) error {
+	err := cfg.Retry.do(ctx, onCodes("DependencyViolation"), func() error {
+		_, err := svc.DeleteSecurityGroupWithContext(ctx, &ec2.DeleteSecurityGroupInput{GroupId: secGroupID})
+		return err
+	})
+	if err != nil {
+		return newError("delete", "security group", secGroupID, err)
+	}
+	fmt.Println("deleted security group " + *secGroupID)
+	return nil
+}
+
+// Revoke every ingress rule of the group, and any egress rule that points at
+// another group, so neither side of a cross reference blocks deletion.
+func stripSecGroup(ctx context.Context, svc EC2API, secGroupID *string) error {
 	// First detangle the group from other groups.
 	paramsDesc := &ec2.DescribeSecurityGroupsInput{
 		GroupIds: []*string{secGroupID},
 	}
 
-	respDesc, errDesc := svc.DescribeSecurityGroups(paramsDesc)
+	respDesc, errDesc := svc.DescribeSecurityGroupsWithContext(ctx, paramsDesc)
 	if errDesc != nil {
-		fmt.Println("error describing security groups")
-		fmt.Println(errDesc)
+		return newError("describe", "security group", secGroupID, errDesc)
+	}
+	if len(respDesc.SecurityGroups) == 0 {
+		return errNotFound("describe", "security group", secGroupID, "InvalidGroup.NotFound")
 	}
+	group := respDesc.SecurityGroups[0]
 
-	paramsDeleteRules := &ec2.RevokeSecurityGroupIngressInput{
-		GroupId:       secGroupID,
-		IpPermissions: respDesc.SecurityGroups[0].IpPermissions,
+	if len(group.IpPermissions) > 0 {
+		paramsDeleteRules := &ec2.RevokeSecurityGroupIngressInput{
+			GroupId:       secGroupID,
+			IpPermissions: group.IpPermissions,
+		}
+
+		_, err := svc.RevokeSecurityGroupIngressWithContext(ctx, paramsDeleteRules)
+		if err != nil {
+			return newError("revoke rules from", "security group", secGroupID, err)
+		}
 	}
 
-	_, err := svc.RevokeSecurityGroupIngress(paramsDeleteRules)
-	if err != nil {
-		fmt.Println("error removing rules from security group")
-		fmt.Println(err)
-		return
+	var egress []*ec2.IpPermission
+	for _, p := range group.IpPermissionsEgress {
+		if len(p.UserIdGroupPairs) > 0 {
+			egress = append(egress, &ec2.IpPermission{
+				IpProtocol:       p.IpProtocol,
+				FromPort:         p.FromPort,
+				ToPort:           p.ToPort,
+				UserIdGroupPairs: p.UserIdGroupPairs,
+			})
+		}
 	}
-	fmt.Println("removed rules from: " + *secGroupID)
+	if len(egress) > 0 {
+		paramsDeleteEgress := &ec2.RevokeSecurityGroupEgressInput{
+			GroupId:       secGroupID,
+			IpPermissions: egress,
+		}
 
+		_, err := svc.RevokeSecurityGroupEgressWithContext(ctx, paramsDeleteEgress)
+		if err != nil {
+			return newError("revoke egress rules from", "security group", secGroupID, err)
+		}
+	}
+	fmt.Println("removed rules from: " + *secGroupID)
+	return nil
 }
@@ -0,0 +1,442 @@
+package awsextra_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/jeremyd/structureag/pkg/awsextra"
+	"github.com/jeremyd/structureag/pkg/awsextra/awsextratest"
+)
+
+func TestSecurityGroupLifecycle(t *testing.T) {
+	cfg := testConfig(1)
+	svc := awsextratest.NewEC2("us-west-2")
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missing, err := awsextra.GetSecurityGroup(context.Background(), svc, cfg, "default")
+	if err != nil || missing != nil {
+		t.Fatalf("GetSecurityGroup before create = %v, %v; want nil, nil", missing, err)
+	}
+
+	sgID, err := awsextra.CreateSecurityGroup(context.Background(), svc, cfg, "default", vpcID, nil)
+	if err != nil {
+		t.Fatalf("CreateSecurityGroup: %v", err)
+	}
+	tags := svc.Tags(*sgID)
+	if tags["MYTAG"] != "test" || tags["for"] != "default" {
+		t.Errorf("security group tags = %v", tags)
+	}
+
+	found, err := awsextra.GetSecurityGroup(context.Background(), svc, cfg, "default")
+	if err != nil || found == nil || *found != *sgID {
+		t.Fatalf("GetSecurityGroup = %v, %v; want %s", found, err, *sgID)
+	}
+
+	if err := awsextra.AuthorizeSecurityGroupsInternalSSH(context.Background(), svc, cfg, sgID); err != nil {
+		t.Fatalf("AuthorizeSecurityGroupsInternalSSH: %v", err)
+	}
+	resp, err := svc.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{GroupIds: []*string{sgID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var internal, ssh bool
+	for _, p := range resp.SecurityGroups[0].IpPermissions {
+		switch {
+		case aws.Int64Value(p.FromPort) == 0 && aws.Int64Value(p.ToPort) == 65535:
+			internal = len(p.UserIdGroupPairs) == 1 && *p.UserIdGroupPairs[0].GroupId == *sgID
+		case aws.Int64Value(p.FromPort) == 22:
+			ssh = len(p.IpRanges) == 1 && *p.IpRanges[0].CidrIp == "0.0.0.0/0"
+		}
+	}
+	if !internal || !ssh {
+		t.Errorf("rules = %v, want internal TCP and SSH", resp.SecurityGroups[0].IpPermissions)
+	}
+
+	if err := awsextra.DeleteSecurityGroup(context.Background(), svc, cfg, sgID); err != nil {
+		t.Fatalf("DeleteSecurityGroup: %v", err)
+	}
+	gone, err := awsextra.GetSecurityGroup(context.Background(), svc, cfg, "default")
+	if err != nil || gone != nil {
+		t.Errorf("GetSecurityGroup after delete = %v, %v; want nil, nil", gone, err)
+	}
+}
+
+func TestAuthorizeSecurityGroupsInternalSSHTwice(t *testing.T) {
+	cfg := testConfig(0)
+	svc := awsextratest.NewEC2("us-west-2")
+	vpcID, _ := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	sgID, _ := awsextra.CreateSecurityGroup(context.Background(), svc, cfg, "default", vpcID, nil)
+	if err := awsextra.AuthorizeSecurityGroupsInternalSSH(context.Background(), svc, cfg, sgID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := awsextra.AuthorizeSecurityGroupsInternalSSH(context.Background(), svc, cfg, sgID); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	groups, _ := svc.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{GroupIds: []*string{sgID}})
+	if n := len(groups.SecurityGroups[0].IpPermissions); n != 2 {
+		t.Errorf("group has %d permissions, want 2", n)
+	}
+}
+
+func TestCreateSecurityGroupTwice(t *testing.T) {
+	cfg := testConfig(0)
+	svc := awsextratest.NewEC2("us-west-2")
+	vpcID, _ := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	first, err := awsextra.CreateSecurityGroup(context.Background(), svc, cfg, "default", vpcID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := awsextra.CreateSecurityGroup(context.Background(), svc, cfg, "default", vpcID, nil)
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if *first != *second {
+		t.Errorf("second run returned %s, want existing %s", *second, *first)
+	}
+}
+
+func TestCreateSecurityGroupUntagged(t *testing.T) {
+	cfg := testConfig(0)
+	svc := awsextratest.NewEC2("us-west-2")
+	vpcID, _ := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	svc.InjectError("CreateTags", awserr.New("UnauthorizedOperation", "denied", nil))
+	if _, err := awsextra.CreateSecurityGroup(context.Background(), svc, cfg, "default", vpcID, nil); err == nil {
+		t.Fatal("first run succeeded, want the injected CreateTags failure")
+	}
+
+	sgID, err := awsextra.CreateSecurityGroup(context.Background(), svc, cfg, "default", vpcID, nil)
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if got := svc.Tags(*sgID); got["MYTAG"] != "test" || got["for"] != "default" {
+		t.Errorf("tags = %v, want MYTAG=test for=default", got)
+	}
+	found, _ := awsextra.GetSecurityGroup(context.Background(), svc, cfg, "default")
+	if found == nil || *found != *sgID {
+		t.Errorf("GetSecurityGroup = %v, want %s", found, *sgID)
+	}
+}
+
+func securityGroupsConfig() *awsextra.Config {
+	cfg := testConfig(1)
+	cfg.SecurityGroups = []awsextra.SecurityGroup{
+		{Kind: "db", Ingress: []awsextra.SecurityGroupRule{
+			{Protocol: "tcp", FromPort: 5432, Group: "web"},
+		}},
+		{Kind: "web", Ingress: []awsextra.SecurityGroupRule{
+			{Protocol: "tcp", FromPort: 443, CIDR: "0.0.0.0/0"},
+			{Protocol: "icmp", IPv6CIDR: "::/0"},
+			{Protocol: "all", Group: "web"},
+		}, Egress: []awsextra.SecurityGroupRule{
+			{Protocol: "udp", FromPort: 53, PrefixList: "pl-0123456789abcdef0"},
+		}},
+	}
+	return cfg
+}
+
+func securityGroup(t *testing.T, svc *awsextratest.EC2, groupID *string) *ec2.SecurityGroup {
+	t.Helper()
+	resp, err := svc.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{GroupIds: []*string{groupID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.SecurityGroups[0]
+}
+
+func TestCreateSecurityGroups(t *testing.T) {
+	cfg := securityGroupsConfig()
+	svc := awsextratest.NewEC2("us-west-2")
+	state := awsextra.NewState(cfg)
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := awsextra.CreateSecurityGroups(context.Background(), svc, cfg, vpcID, state); err != nil {
+		t.Fatalf("CreateSecurityGroups: %v", err)
+	}
+	if IDs := inState(state, "security group"); len(IDs) != 2 {
+		t.Errorf("state has security groups %v, want 2", IDs)
+	}
+
+	webID, _ := awsextra.GetSecurityGroup(context.Background(), svc, cfg, "web")
+	dbID, _ := awsextra.GetSecurityGroup(context.Background(), svc, cfg, "db")
+	if webID == nil || dbID == nil {
+		t.Fatalf("web group %v, db group %v, want both", webID, dbID)
+	}
+	db := securityGroup(t, svc, dbID)
+	if len(db.IpPermissions) != 1 || aws.Int64Value(db.IpPermissions[0].FromPort) != 5432 ||
+		len(db.IpPermissions[0].UserIdGroupPairs) != 1 || *db.IpPermissions[0].UserIdGroupPairs[0].GroupId != *webID {
+		t.Errorf("db ingress = %v, want tcp 5432 from %s", db.IpPermissions, *webID)
+	}
+	web := securityGroup(t, svc, webID)
+	var https, icmpv6, self bool
+	for _, p := range web.IpPermissions {
+		switch aws.StringValue(p.IpProtocol) {
+		case "tcp":
+			https = aws.Int64Value(p.FromPort) == 443 && aws.Int64Value(p.ToPort) == 443 && *p.IpRanges[0].CidrIp == "0.0.0.0/0"
+		case "icmpv6":
+			icmpv6 = *p.Ipv6Ranges[0].CidrIpv6 == "::/0"
+		case "-1":
+			self = *p.UserIdGroupPairs[0].GroupId == *webID
+		}
+	}
+	if !https || !icmpv6 || !self {
+		t.Errorf("web ingress = %v, want HTTPS, ICMPv6 and everything from itself", web.IpPermissions)
+	}
+	// web's egress rule replaces the one EC2 gave it, db keeps that.
+	if e := web.IpPermissionsEgress; len(e) != 1 || aws.StringValue(e[0].IpProtocol) != "udp" || aws.Int64Value(e[0].FromPort) != 53 ||
+		len(e[0].PrefixListIds) != 1 || *e[0].PrefixListIds[0].PrefixListId != "pl-0123456789abcdef0" {
+		t.Errorf("web egress = %v, want only DNS to the prefix list", e)
+	}
+	if e := db.IpPermissionsEgress; len(e) != 1 || aws.StringValue(e[0].IpProtocol) != "-1" {
+		t.Errorf("db egress = %v, want EC2's allow all", e)
+	}
+
+	// Running up again finds what is there.
+	before := len(svc.Calls())
+	if err := awsextra.CreateSecurityGroups(context.Background(), svc, cfg, vpcID, state); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	for _, call := range svc.Calls()[before:] {
+		switch call {
+		case "CreateSecurityGroup", "AuthorizeSecurityGroupIngress", "AuthorizeSecurityGroupEgress", "RevokeSecurityGroupIngress", "RevokeSecurityGroupEgress":
+			t.Errorf("second run called %s", call)
+		}
+	}
+
+	// The groups referencing each other don't hold up the teardown.
+	if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left", n)
+	}
+}
+
+func TestCreateSecurityGroupsReferencingEachOther(t *testing.T) {
+	cfg := testConfig(1)
+	cfg.SecurityGroups = []awsextra.SecurityGroup{
+		{Kind: "web", Ingress: []awsextra.SecurityGroupRule{{Protocol: "tcp", FromPort: 8080, Group: "app"}}},
+		{Kind: "app", Ingress: []awsextra.SecurityGroupRule{{Protocol: "tcp", FromPort: 8443, Group: "web"}}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	svc := awsextratest.NewEC2("us-west-2")
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan := &awsextra.Plan{}
+	if err := awsextra.PlanSecurityGroups(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	if got := actions(plan, "ingress rule"); len(got) != 2 || count(got, "create") != 2 {
+		t.Errorf("ingress rule actions = %v, want 2 creates", got)
+	}
+
+	if err := awsextra.CreateSecurityGroups(context.Background(), svc, cfg, vpcID, nil); err != nil {
+		t.Fatalf("CreateSecurityGroups: %v", err)
+	}
+	webID, _ := awsextra.GetSecurityGroup(context.Background(), svc, cfg, "web")
+	appID, _ := awsextra.GetSecurityGroup(context.Background(), svc, cfg, "app")
+	for _, pair := range [][2]*string{{webID, appID}, {appID, webID}} {
+		g := securityGroup(t, svc, pair[0])
+		if len(g.IpPermissions) != 1 || len(g.IpPermissions[0].UserIdGroupPairs) != 1 || *g.IpPermissions[0].UserIdGroupPairs[0].GroupId != *pair[1] {
+			t.Errorf("%s ingress = %v, want from %s", *pair[0], g.IpPermissions, *pair[1])
+		}
+	}
+
+	plan = &awsextra.Plan{}
+	if err := awsextra.PlanSecurityGroups(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	if n := plan.Count("create") + plan.Count("delete"); n != 0 {
+		t.Errorf("plan after up has %d changes:\n%s", n, plan)
+	}
+}
+
+func TestCreateSecurityGroupsDefault(t *testing.T) {
+	cfg := testConfig(1)
+	cfg.IPv6 = true
+	svc := awsextratest.NewEC2("us-west-2")
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sgID, _ := awsextra.CreateSecurityGroup(context.Background(), svc, cfg, "default", vpcID, nil)
+	if err := awsextra.AuthorizeSecurityGroupsInternalSSH(context.Background(), svc, cfg, sgID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without security groups configured, the default group made the old
+	// way is what up wants.
+	before := len(svc.Calls())
+	if err := awsextra.CreateSecurityGroups(context.Background(), svc, cfg, vpcID, nil); err != nil {
+		t.Fatalf("CreateSecurityGroups: %v", err)
+	}
+	for _, call := range svc.Calls()[before:] {
+		switch call {
+		case "CreateSecurityGroup", "AuthorizeSecurityGroupIngress", "AuthorizeSecurityGroupEgress":
+			t.Errorf("CreateSecurityGroups called %s", call)
+		}
+	}
+}
+
+func TestPlanSecurityGroups(t *testing.T) {
+	cfg := securityGroupsConfig()
+	svc := awsextratest.NewEC2("us-west-2")
+
+	plan := &awsextra.Plan{}
+	if err := awsextra.PlanSecurityGroups(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	if got := actions(plan, "security group"); len(got) != 2 || got[0] != "create" || got[1] != "create" {
+		t.Errorf("security group actions = %v, want [create create]", got)
+	}
+	if got := actions(plan, "ingress rule"); len(got) != 4 || count(got, "create") != 4 {
+		t.Errorf("ingress rule actions = %v, want 4 creates", got)
+	}
+	if got := actions(plan, "egress rule"); len(got) != 1 || got[0] != "create" {
+		t.Errorf("egress rule actions = %v, want [create]", got)
+	}
+
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := awsextra.CreateSecurityGroups(context.Background(), svc, cfg, vpcID, nil); err != nil {
+		t.Fatal(err)
+	}
+	plan = &awsextra.Plan{}
+	before := len(svc.Calls())
+	if err := awsextra.PlanSecurityGroups(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	assertDescribeOnly(t, svc.Calls()[before:])
+	if n := plan.Count("create"); n != 0 {
+		t.Errorf("plan after up has %d creates:\n%s", n, plan)
+	}
+}
+
+func TestCreateSecurityGroupsReconcile(t *testing.T) {
+	cfg := securityGroupsConfig()
+	svc := awsextratest.NewEC2("us-west-2")
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := awsextra.CreateSecurityGroups(context.Background(), svc, cfg, vpcID, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// By hand, RDP is opened and HTTPS closed.
+	webID, _ := awsextra.GetSecurityGroup(context.Background(), svc, cfg, "web")
+	rule := func(port int64) []*ec2.IpPermission {
+		return []*ec2.IpPermission{{
+			IpProtocol: aws.String("tcp"),
+			FromPort:   aws.Int64(port),
+			ToPort:     aws.Int64(port),
+			IpRanges:   []*ec2.IpRange{{CidrIp: aws.String("0.0.0.0/0")}},
+		}}
+	}
+	if _, err := svc.AuthorizeSecurityGroupIngress(&ec2.AuthorizeSecurityGroupIngressInput{GroupId: webID, IpPermissions: rule(3389)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.RevokeSecurityGroupIngress(&ec2.RevokeSecurityGroupIngressInput{GroupId: webID, IpPermissions: rule(443)}); err != nil {
+		t.Fatal(err)
+	}
+	ports := func() map[int64]bool {
+		open := map[int64]bool{}
+		for _, p := range securityGroup(t, svc, webID).IpPermissions {
+			if aws.StringValue(p.IpProtocol) == "tcp" {
+				open[aws.Int64Value(p.FromPort)] = true
+			}
+		}
+		return open
+	}
+
+	// The plan shows both.
+	plan := &awsextra.Plan{}
+	if err := awsextra.PlanSecurityGroups(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	if got := actions(plan, "ingress rule"); count(got, "create") != 1 || count(got, "delete") != 1 {
+		t.Errorf("ingress rule actions = %v, want one create and one delete", got)
+	}
+
+	// Without pruning, HTTPS comes back and RDP stays.
+	cfg.NoPrune = true
+	plan = &awsextra.Plan{}
+	if err := awsextra.PlanSecurityGroups(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	if got := actions(plan, "ingress rule"); count(got, "create") != 1 || count(got, "delete") != 0 {
+		t.Errorf("ingress rule actions with NoPrune = %v, want one create and no delete", got)
+	}
+	if err := awsextra.CreateSecurityGroups(context.Background(), svc, cfg, vpcID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if open := ports(); !open[443] || !open[3389] {
+		t.Errorf("open ports with NoPrune = %v, want 443 and 3389", open)
+	}
+
+	// Pruning revokes RDP.
+	cfg.NoPrune = false
+	before := len(svc.Calls())
+	if err := awsextra.CreateSecurityGroups(context.Background(), svc, cfg, vpcID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(svc.Calls()[before:], "RevokeSecurityGroupIngress"); n != 1 {
+		t.Errorf("%d RevokeSecurityGroupIngress calls, want 1", n)
+	}
+	if open := ports(); !open[443] || open[3389] {
+		t.Errorf("open ports = %v, want 443 and not 3389", open)
+	}
+	plan = &awsextra.Plan{}
+	if err := awsextra.PlanSecurityGroups(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	if n := plan.Count("create") + plan.Count("delete"); n != 0 {
+		t.Errorf("plan after reconciling has %d changes:\n%s", n, plan)
+	}
+}
+
+func TestCreateSecurityGroupsEmptyDescribe(t *testing.T) {
+	cfg := securityGroupsConfig()
+	svc := awsextratest.NewEC2("us-west-2")
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = awsextra.CreateSecurityGroups(context.Background(), &emptyDescribes{svc}, cfg, vpcID, nil)
+	var e *awsextra.Error
+	if !errors.As(err, &e) || e.Code != "InvalidGroup.NotFound" || e.Resource != "security group" {
+		t.Errorf("err = %v, want the security group not found", err)
+	}
+}
+
+func TestDeleteStateResourcesSecurityGroupEmptyDescribe(t *testing.T) {
+	cfg := testConfig(1)
+	svc := awsextratest.NewEC2("us-west-2")
+	state := awsextra.NewState(cfg)
+	state.Resources = []awsextra.Resource{{Type: "security group", ID: "sg-gone"}}
+
+	if _, err := awsextra.DeleteStateResources(context.Background(), &emptyDescribes{svc}, cfg, state); err != nil {
+		t.Fatalf("DeleteStateResources: %v", err)
+	}
+	if len(state.Resources) != 0 {
+		t.Errorf("state still lists\n%s", state)
+	}
+}
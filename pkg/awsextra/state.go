@@ -0,0 +1,556 @@
+package awsextra
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ErrStateMismatch is returned by LoadState when the state file belongs to a
+// different stack than the Config.
+var ErrStateMismatch = errors.New("state file belongs to another stack")
+
+// State lists every resource `up` manages for one stack, with what each one
+// depends on, so `down` can delete exactly those resources instead of
+// whatever carries the stack's tag.
+type State struct {
+	Region    string     `json:"region"`
+	Tag       string     `json:"tag"` // TagKey=TagValue of the stack
+	Resources []Resource `json:"resources"`
+}
+
+// Resource is one resource recorded in a State.
+type Resource struct {
+	Type      string   `json:"type"` // Kind of resource, eg. "subnet"
+	ID        string   `json:"id"`
+	DependsOn []string `json:"depends_on,omitempty"` // IDs that must outlive this resource
+}
+
+// NewState returns an empty State for the stack cfg describes.
+func NewState(cfg *Config) *State {
+	return &State{Region: cfg.Region, Tag: cfg.TagKey + "=" + cfg.TagValue}
+}
+
+// LoadState reads the state file at path.  It returns nil, nil when there is
+// no such file.
+func LoadState(path string, cfg *Config) (*State, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &State{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	want := NewState(cfg)
+	if state.Region != want.Region || state.Tag != want.Tag {
+		return nil, fmt.Errorf("%w: %s is for %s in %s, not %s in %s", ErrStateMismatch, path, state.Tag, state.Region, want.Tag, want.Region)
+	}
+	return state, nil
+}
+
+// Save writes the state to path.  The file is replaced in one step so an
+// interrupted write can't leave half a state behind.
+func (s *State) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// record adds a resource, or more dependencies to one already recorded.  It
+// does nothing on a nil State or ID so `up` can be run without one.
+func (s *State) record(resourceType string, ID *string, dependsOn ...*string) {
+	if s == nil || ID == nil {
+		return
+	}
+	r := s.find(*ID)
+	if r == nil {
+		s.Resources = append(s.Resources, Resource{Type: resourceType, ID: *ID})
+		r = &s.Resources[len(s.Resources)-1]
+	}
+	for _, dep := range dependsOn {
+		if dep != nil && *dep != *ID && !contains(r.DependsOn, *dep) {
+			r.DependsOn = append(r.DependsOn, *dep)
+		}
+	}
+}
+
+func (s *State) find(ID string) *Resource {
+	for i := range s.Resources {
+		if s.Resources[i].ID == ID {
+			return &s.Resources[i]
+		}
+	}
+	return nil
+}
+
+func (s *State) forget(ID string) {
+	if s == nil {
+		return
+	}
+	for i := range s.Resources {
+		if s.Resources[i].ID == ID {
+			s.Resources = append(s.Resources[:i], s.Resources[i+1:]...)
+			return
+		}
+	}
+}
+
+// Is any other recorded resource still depending on ID?
+func (s *State) needed(ID string) bool {
+	for _, r := range s.Resources {
+		if contains(r.DependsOn, ID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *State) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "stack %s in %s\n", s.Tag, s.Region)
+	for _, r := range s.Resources {
+		line := r.Type + " " + r.ID
+		if len(r.DependsOn) > 0 {
+			line += " (depends on " + strings.Join(r.DependsOn, ", ") + ")"
+		}
+		fmt.Fprintln(&b, line)
+	}
+	return b.String()
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteOrder lists the resources so each comes before everything it depends
+// on, newest first otherwise.
+func (s *State) deleteOrder() ([]Resource, error) {
+	left := &State{Resources: append([]Resource(nil), s.Resources...)}
+	var order []Resource
+	for len(left.Resources) > 0 {
+		next := -1
+		for i := len(left.Resources) - 1; i >= 0; i-- {
+			if !left.needed(left.Resources[i].ID) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return order, newError("delete", "state", nil, fmt.Errorf("dependency cycle between %d resources", len(left.Resources)))
+		}
+		order = append(order, left.Resources[next])
+		left.forget(left.Resources[next].ID)
+	}
+	return order, nil
+}
+
+// DeleteStateResources ... deletes the resources recorded in state, each one
+// as soon as nothing left in the state depends on it, several at a time,
+// retrying under cfg's RetryPolicy.  Deleted resources, and ones that turn
+// out to be gone already, are removed from the state as it goes, so after an
+// error the state still lists what is left to delete.
+func DeleteStateResources(ctx context.Context, svc EC2API, cfg *Config, state *State) (*Report, error) {
+	report := &Report{}
+	g := newGraph()
+	g.Resources = append([]Resource(nil), state.Resources...)
+	t := &teardown{
+		svc:    svc,
+		retry:  cfg.Retry,
+		report: report,
+		deleted: func(r Resource) {
+			state.forget(r.ID)
+		},
+	}
+	return report, t.run(ctx, g)
+}
+
+// RefreshState ... drops the resources that no longer exist from state and
+// returns them.
+func RefreshState(ctx context.Context, svc EC2API, state *State) ([]Resource, error) {
+	var gone []Resource
+	for _, r := range append([]Resource(nil), state.Resources...) {
+		exists, err := resourceExists(ctx, svc, r)
+		if err != nil {
+			return gone, err
+		}
+		if !exists {
+			gone = append(gone, r)
+			state.forget(r.ID)
+		}
+	}
+	return gone, nil
+}
+
+func resourceExists(ctx context.Context, svc EC2API, r Resource) (bool, error) {
+	IDs := []*string{aws.String(r.ID)}
+	var err error
+	switch r.Type {
+	case "vpc":
+		_, err = svc.DescribeVpcsWithContext(ctx, &ec2.DescribeVpcsInput{VpcIds: IDs})
+	case "subnet":
+		_, err = svc.DescribeSubnetsWithContext(ctx, &ec2.DescribeSubnetsInput{SubnetIds: IDs})
+	case "internet gateway":
+		_, err = svc.DescribeInternetGatewaysWithContext(ctx, &ec2.DescribeInternetGatewaysInput{InternetGatewayIds: IDs})
+	case "egress-only internet gateway":
+		_, err = svc.DescribeEgressOnlyInternetGatewaysWithContext(ctx, &ec2.DescribeEgressOnlyInternetGatewaysInput{EgressOnlyInternetGatewayIds: IDs})
+	case "dhcp options set":
+		_, err = svc.DescribeDhcpOptionsWithContext(ctx, &ec2.DescribeDhcpOptionsInput{DhcpOptionsIds: IDs})
+	case "security group":
+		_, err = svc.DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{GroupIds: IDs})
+	case "route table":
+		_, err = svc.DescribeRouteTablesWithContext(ctx, &ec2.DescribeRouteTablesInput{RouteTableIds: IDs})
+	case "network acl":
+		_, err = svc.DescribeNetworkAclsWithContext(ctx, &ec2.DescribeNetworkAclsInput{NetworkAclIds: IDs})
+	case "elastic ip":
+		_, err = svc.DescribeAddressesWithContext(ctx, &ec2.DescribeAddressesInput{AllocationIds: IDs})
+	case "nat gateway":
+		// EC2 goes on describing a deleted NAT gateway for a while.
+		var resp *ec2.DescribeNatGatewaysOutput
+		resp, err = svc.DescribeNatGatewaysWithContext(ctx, &ec2.DescribeNatGatewaysInput{NatGatewayIds: IDs})
+		if err == nil && (len(resp.NatGateways) == 0 || aws.StringValue(resp.NatGateways[0].State) == "deleted") {
+			return false, nil
+		}
+	case "flow log":
+		var resp *ec2.DescribeFlowLogsOutput
+		resp, err = svc.DescribeFlowLogsWithContext(ctx, &ec2.DescribeFlowLogsInput{FlowLogIds: IDs})
+		if err == nil && len(resp.FlowLogs) == 0 {
+			return false, nil
+		}
+	case "vpc peering connection":
+		// And a deleted, or otherwise dead, peering connection.
+		var resp *ec2.DescribeVpcPeeringConnectionsOutput
+		resp, err = svc.DescribeVpcPeeringConnectionsWithContext(ctx, &ec2.DescribeVpcPeeringConnectionsInput{VpcPeeringConnectionIds: IDs})
+		if err == nil && (len(resp.VpcPeeringConnections) == 0 || !contains(livePeeringStates, aws.StringValue(resp.VpcPeeringConnections[0].Status.Code))) {
+			return false, nil
+		}
+	case "transit gateway":
+		// And a deleted transit gateway or attachment.
+		var resp *ec2.DescribeTransitGatewaysOutput
+		resp, err = svc.DescribeTransitGatewaysWithContext(ctx, &ec2.DescribeTransitGatewaysInput{TransitGatewayIds: IDs})
+		if err == nil && (len(resp.TransitGateways) == 0 || aws.StringValue(resp.TransitGateways[0].State) == "deleted") {
+			return false, nil
+		}
+	case "transit gateway attachment":
+		var resp *ec2.DescribeTransitGatewayVpcAttachmentsOutput
+		resp, err = svc.DescribeTransitGatewayVpcAttachmentsWithContext(ctx, &ec2.DescribeTransitGatewayVpcAttachmentsInput{TransitGatewayAttachmentIds: IDs})
+		if err == nil && (len(resp.TransitGatewayVpcAttachments) == 0 || aws.StringValue(resp.TransitGatewayVpcAttachments[0].State) == "deleted") {
+			return false, nil
+		}
+	case "vpn gateway":
+		// And a deleted virtual private gateway, customer gateway or VPN
+		// connection.
+		var resp *ec2.DescribeVpnGatewaysOutput
+		resp, err = svc.DescribeVpnGatewaysWithContext(ctx, &ec2.DescribeVpnGatewaysInput{VpnGatewayIds: IDs})
+		if err == nil && (len(resp.VpnGateways) == 0 || aws.StringValue(resp.VpnGateways[0].State) == "deleted") {
+			return false, nil
+		}
+	case "customer gateway":
+		var resp *ec2.DescribeCustomerGatewaysOutput
+		resp, err = svc.DescribeCustomerGatewaysWithContext(ctx, &ec2.DescribeCustomerGatewaysInput{CustomerGatewayIds: IDs})
+		if err == nil && (len(resp.CustomerGateways) == 0 || aws.StringValue(resp.CustomerGateways[0].State) == "deleted") {
+			return false, nil
+		}
+	case "vpn connection":
+		var resp *ec2.DescribeVpnConnectionsOutput
+		resp, err = svc.DescribeVpnConnectionsWithContext(ctx, &ec2.DescribeVpnConnectionsInput{VpnConnectionIds: IDs})
+		if err == nil && (len(resp.VpnConnections) == 0 || aws.StringValue(resp.VpnConnections[0].State) == "deleted") {
+			return false, nil
+		}
+	case "vpc endpoint":
+		// And a deleted VPC endpoint.
+		var resp *ec2.DescribeVpcEndpointsOutput
+		resp, err = svc.DescribeVpcEndpointsWithContext(ctx, &ec2.DescribeVpcEndpointsInput{VpcEndpointIds: IDs})
+		if err == nil && (len(resp.VpcEndpoints) == 0 || aws.StringValue(resp.VpcEndpoints[0].State) == "deleted") {
+			return false, nil
+		}
+	default:
+		return false, newError("describe", r.Type, IDs[0], errors.New("unknown resource type"))
+	}
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, newError("describe", r.Type, IDs[0], err)
+	}
+	return true, nil
+}
+
+// ReconcileState ... adds the resources carrying the stack's tag that state
+// doesn't list yet, with their dependencies, and returns them.
+func ReconcileState(ctx context.Context, svc EC2API, cfg *Config, state *State) ([]Resource, error) {
+	tagged, err := taggedResources(ctx, svc, cfg)
+	if err != nil {
+		return nil, err
+	}
+	var added []Resource
+	for _, r := range tagged {
+		if state.find(r.ID) == nil {
+			added = append(added, r)
+		}
+		state.record(r.Type, aws.String(r.ID), aws.StringSlice(r.DependsOn)...)
+	}
+	return added, nil
+}
+
+// Everything carrying the stack's tag, dependencies first.
+func taggedResources(ctx context.Context, svc EC2API, cfg *Config) ([]Resource, error) {
+	filters := []*ec2.Filter{cfg.tagFilter()}
+	var found []Resource
+
+	dhcp, err := svc.DescribeDhcpOptionsWithContext(ctx, &ec2.DescribeDhcpOptionsInput{Filters: filters})
+	if err != nil {
+		return nil, newError("describe", "dhcp options sets", nil, err)
+	}
+	for _, opts := range dhcp.DhcpOptions {
+		found = append(found, Resource{Type: "dhcp options set", ID: *opts.DhcpOptionsId})
+	}
+
+	vpcs, err := svc.DescribeVpcsWithContext(ctx, &ec2.DescribeVpcsInput{Filters: filters})
+	if err != nil {
+		return nil, newError("describe", "vpcs", nil, err)
+	}
+	for _, vpc := range vpcs.Vpcs {
+		r := Resource{Type: "vpc", ID: *vpc.VpcId}
+		if ID := aws.StringValue(vpc.DhcpOptionsId); ID != "" && ID != "default" {
+			r.DependsOn = []string{ID}
+		}
+		found = append(found, r)
+	}
+
+	subnets, err := svc.DescribeSubnetsWithContext(ctx, &ec2.DescribeSubnetsInput{Filters: filters})
+	if err != nil {
+		return nil, newError("describe", "subnets", nil, err)
+	}
+	for _, subnet := range subnets.Subnets {
+		found = append(found, Resource{Type: "subnet", ID: *subnet.SubnetId, DependsOn: []string{*subnet.VpcId}})
+	}
+
+	igws, err := svc.DescribeInternetGatewaysWithContext(ctx, &ec2.DescribeInternetGatewaysInput{Filters: filters})
+	if err != nil {
+		return nil, newError("describe", "internet gateways", nil, err)
+	}
+	for _, igw := range igws.InternetGateways {
+		r := Resource{Type: "internet gateway", ID: *igw.InternetGatewayId}
+		for _, attachment := range igw.Attachments {
+			r.DependsOn = append(r.DependsOn, *attachment.VpcId)
+		}
+		found = append(found, r)
+	}
+
+	eigws, err := svc.DescribeEgressOnlyInternetGatewaysWithContext(ctx, &ec2.DescribeEgressOnlyInternetGatewaysInput{Filters: filters})
+	if err != nil {
+		return nil, newError("describe", "egress-only internet gateways", nil, err)
+	}
+	for _, eigw := range eigws.EgressOnlyInternetGateways {
+		r := Resource{Type: "egress-only internet gateway", ID: *eigw.EgressOnlyInternetGatewayId}
+		for _, attachment := range eigw.Attachments {
+			r.DependsOn = append(r.DependsOn, *attachment.VpcId)
+		}
+		found = append(found, r)
+	}
+
+	groups, err := svc.DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{Filters: filters})
+	if err != nil {
+		return nil, newError("describe", "security groups", nil, err)
+	}
+	for _, group := range groups.SecurityGroups {
+		found = append(found, Resource{Type: "security group", ID: *group.GroupId, DependsOn: []string{*group.VpcId}})
+	}
+
+	routeTables, err := svc.DescribeRouteTablesWithContext(ctx, &ec2.DescribeRouteTablesInput{Filters: filters})
+	if err != nil {
+		return nil, newError("describe", "route tables", nil, err)
+	}
+	for _, rt := range routeTables.RouteTables {
+		if !isMainRouteTable(rt) {
+			found = append(found, Resource{Type: "route table", ID: *rt.RouteTableId, DependsOn: []string{*rt.VpcId}})
+		}
+	}
+
+	acls, err := svc.DescribeNetworkAclsWithContext(ctx, &ec2.DescribeNetworkAclsInput{Filters: filters})
+	if err != nil {
+		return nil, newError("describe", "network acls", nil, err)
+	}
+	for _, acl := range acls.NetworkAcls {
+		r := Resource{Type: "network acl", ID: *acl.NetworkAclId, DependsOn: []string{*acl.VpcId}}
+		for _, assoc := range acl.Associations {
+			r.DependsOn = append(r.DependsOn, *assoc.SubnetId)
+		}
+		found = append(found, r)
+	}
+
+	addresses, err := svc.DescribeAddressesWithContext(ctx, &ec2.DescribeAddressesInput{Filters: filters})
+	if err != nil {
+		return nil, newError("describe", "elastic ips", nil, err)
+	}
+	for _, address := range addresses.Addresses {
+		found = append(found, Resource{Type: "elastic ip", ID: *address.AllocationId})
+	}
+
+	nats, err := svc.DescribeNatGatewaysWithContext(ctx, &ec2.DescribeNatGatewaysInput{
+		Filter: append(filters, &ec2.Filter{
+			Name:   aws.String("state"),
+			Values: aws.StringSlice([]string{"pending", "available"}),
+		}),
+	})
+	if err != nil {
+		return nil, newError("describe", "nat gateways", nil, err)
+	}
+	for _, nat := range nats.NatGateways {
+		r := Resource{Type: "nat gateway", ID: *nat.NatGatewayId, DependsOn: []string{*nat.SubnetId}}
+		for _, address := range nat.NatGatewayAddresses {
+			r.DependsOn = append(r.DependsOn, *address.AllocationId)
+		}
+		for _, igw := range igws.InternetGateways {
+			for _, attachment := range igw.Attachments {
+				if *attachment.VpcId == *nat.VpcId {
+					r.DependsOn = append(r.DependsOn, *igw.InternetGatewayId)
+				}
+			}
+		}
+		found = append(found, r)
+	}
+
+	endpoints, err := svc.DescribeVpcEndpointsWithContext(ctx, &ec2.DescribeVpcEndpointsInput{
+		Filters: append(filters, &ec2.Filter{
+			Name:   aws.String("vpc-endpoint-state"),
+			Values: aws.StringSlice([]string{"pendingAcceptance", "pending", "available"}),
+		}),
+	})
+	if err != nil {
+		return nil, newError("describe", "vpc endpoints", nil, err)
+	}
+	for _, endpoint := range endpoints.VpcEndpoints {
+		r := Resource{Type: "vpc endpoint", ID: *endpoint.VpcEndpointId, DependsOn: []string{*endpoint.VpcId}}
+		r.DependsOn = append(r.DependsOn, aws.StringValueSlice(endpoint.RouteTableIds)...)
+		r.DependsOn = append(r.DependsOn, aws.StringValueSlice(endpoint.SubnetIds)...)
+		for _, group := range endpoint.Groups {
+			r.DependsOn = append(r.DependsOn, *group.GroupId)
+		}
+		found = append(found, r)
+	}
+
+	flowLogs, err := svc.DescribeFlowLogsWithContext(ctx, &ec2.DescribeFlowLogsInput{Filter: filters})
+	if err != nil {
+		return nil, newError("describe", "flow logs", nil, err)
+	}
+	for _, flowLog := range flowLogs.FlowLogs {
+		found = append(found, Resource{Type: "flow log", ID: *flowLog.FlowLogId, DependsOn: []string{*flowLog.ResourceId}})
+	}
+
+	peerings, err := svc.DescribeVpcPeeringConnectionsWithContext(ctx, &ec2.DescribeVpcPeeringConnectionsInput{
+		Filters: append(filters, &ec2.Filter{
+			Name:   aws.String("status-code"),
+			Values: aws.StringSlice(livePeeringStates),
+		}),
+	})
+	if err != nil {
+		return nil, newError("describe", "vpc peering connections", nil, err)
+	}
+	for _, peering := range peerings.VpcPeeringConnections {
+		found = append(found, Resource{Type: "vpc peering connection", ID: *peering.VpcPeeringConnectionId, DependsOn: []string{*peering.RequesterVpcInfo.VpcId}})
+	}
+
+	tgws, err := svc.DescribeTransitGatewaysWithContext(ctx, &ec2.DescribeTransitGatewaysInput{
+		Filters: append(filters, &ec2.Filter{
+			Name:   aws.String("state"),
+			Values: aws.StringSlice(liveTGWStates),
+		}),
+	})
+	if err != nil {
+		return nil, newError("describe", "transit gateways", nil, err)
+	}
+	var tgwIDs []string
+	for _, tgw := range tgws.TransitGateways {
+		tgwIDs = append(tgwIDs, *tgw.TransitGatewayId)
+		found = append(found, Resource{Type: "transit gateway", ID: *tgw.TransitGatewayId})
+	}
+
+	attachments, err := svc.DescribeTransitGatewayVpcAttachmentsWithContext(ctx, &ec2.DescribeTransitGatewayVpcAttachmentsInput{
+		Filters: append(filters, &ec2.Filter{
+			Name:   aws.String("state"),
+			Values: aws.StringSlice(liveTGWAttachmentStates),
+		}),
+	})
+	if err != nil {
+		return nil, newError("describe", "transit gateway attachments", nil, err)
+	}
+	for _, attachment := range attachments.TransitGatewayVpcAttachments {
+		r := Resource{Type: "transit gateway attachment", ID: *attachment.TransitGatewayAttachmentId, DependsOn: []string{*attachment.VpcId}}
+		r.DependsOn = append(r.DependsOn, aws.StringValueSlice(attachment.SubnetIds)...)
+		if contains(tgwIDs, *attachment.TransitGatewayId) {
+			r.DependsOn = append(r.DependsOn, *attachment.TransitGatewayId)
+		}
+		found = append(found, r)
+	}
+
+	live := &ec2.Filter{Name: aws.String("state"), Values: aws.StringSlice(liveVPNStates)}
+	vgws, err := svc.DescribeVpnGatewaysWithContext(ctx, &ec2.DescribeVpnGatewaysInput{Filters: append(filters, live)})
+	if err != nil {
+		return nil, newError("describe", "vpn gateways", nil, err)
+	}
+	var gatewayIDs []string
+	for _, vgw := range vgws.VpnGateways {
+		gatewayIDs = append(gatewayIDs, *vgw.VpnGatewayId)
+		r := Resource{Type: "vpn gateway", ID: *vgw.VpnGatewayId}
+		if vpcID := vgwAttachment(vgw); vpcID != "" {
+			r.DependsOn = []string{vpcID}
+		}
+		found = append(found, r)
+	}
+
+	cgws, err := svc.DescribeCustomerGatewaysWithContext(ctx, &ec2.DescribeCustomerGatewaysInput{Filters: append(filters, live)})
+	if err != nil {
+		return nil, newError("describe", "customer gateways", nil, err)
+	}
+	for _, cgw := range cgws.CustomerGateways {
+		gatewayIDs = append(gatewayIDs, *cgw.CustomerGatewayId)
+		found = append(found, Resource{Type: "customer gateway", ID: *cgw.CustomerGatewayId})
+	}
+
+	conns, err := svc.DescribeVpnConnectionsWithContext(ctx, &ec2.DescribeVpnConnectionsInput{Filters: append(filters, live)})
+	if err != nil {
+		return nil, newError("describe", "vpn connections", nil, err)
+	}
+	for _, conn := range conns.VpnConnections {
+		r := Resource{Type: "vpn connection", ID: *conn.VpnConnectionId}
+		for _, ID := range []string{aws.StringValue(conn.VpnGatewayId), aws.StringValue(conn.CustomerGatewayId)} {
+			if contains(gatewayIDs, ID) {
+				r.DependsOn = append(r.DependsOn, ID)
+			}
+		}
+		found = append(found, r)
+	}
+	return found, nil
+}
@@ -0,0 +1,271 @@
+package awsextra_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/jeremyd/structureag/pkg/awsextra"
+	"github.com/jeremyd/structureag/pkg/awsextra/awsextratest"
+)
+
+// upWithState brings a stack up the way structureag does, recording it in a
+// new State.
+func upWithState(t *testing.T, svc *awsextratest.EC2, cfg *awsextra.Config) *awsextra.State {
+	t.Helper()
+	state := awsextra.NewState(cfg)
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := awsextra.CreateSecurityGroup(context.Background(), svc, cfg, "default", vpcID, state); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func types(state *awsextra.State) map[string]int {
+	counts := map[string]int{}
+	for _, r := range state.Resources {
+		counts[r.Type]++
+	}
+	return counts
+}
+
+func TestStateUpDown(t *testing.T) {
+	cfg := testConfig(2)
+	svc := awsextratest.NewEC2("us-west-2")
+	state := upWithState(t, svc, cfg)
+
+	want := map[string]int{"vpc": 1, "dhcp options set": 1, "subnet": 2, "internet gateway": 1, "security group": 1}
+	for resource, n := range want {
+		if got := types(state)[resource]; got != n {
+			t.Errorf("state has %d %s, want %d\n%s", got, resource, n, state)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := state.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := awsextra.LoadState(path, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.String() != state.String() {
+		t.Errorf("loaded state\n%s\nwant\n%s", loaded, state)
+	}
+
+	if _, err := awsextra.DeleteStateResources(context.Background(), svc, cfg, loaded); err != nil {
+		t.Fatalf("DeleteStateResources: %v", err)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left after delete", n)
+	}
+	if len(loaded.Resources) != 0 {
+		t.Errorf("state still lists\n%s", loaded)
+	}
+}
+
+func TestStateRerunRecordsOnce(t *testing.T) {
+	cfg := testConfig(2)
+	svc := awsextratest.NewEC2("us-west-2")
+	state := upWithState(t, svc, cfg)
+	before := state.String()
+
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := awsextra.CreateSecurityGroup(context.Background(), svc, cfg, "default", vpcID, state); err != nil {
+		t.Fatal(err)
+	}
+	if state.String() != before {
+		t.Errorf("state after second run\n%s\nwant\n%s", state, before)
+	}
+}
+
+func TestStateDeleteLeavesSameTagAlone(t *testing.T) {
+	cfg := testConfig(1)
+	svc := awsextratest.NewEC2("us-west-2")
+	state := upWithState(t, svc, cfg)
+
+	// A second VPC carrying the same tag, eg. from a copied config.
+	other, _ := svc.CreateVpc(&ec2.CreateVpcInput{CidrBlock: aws.String("172.26.0.0/16")})
+	svc.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{other.Vpc.VpcId},
+		Tags:      []*ec2.Tag{{Key: aws.String("MYTAG"), Value: aws.String("test")}},
+	})
+
+	if _, err := awsextra.DeleteStateResources(context.Background(), svc, cfg, state); err != nil {
+		t.Fatalf("DeleteStateResources: %v", err)
+	}
+	vpcs, _ := svc.DescribeVpcs(&ec2.DescribeVpcsInput{})
+	if len(vpcs.Vpcs) != 1 || *vpcs.Vpcs[0].VpcId != *other.Vpc.VpcId {
+		t.Errorf("vpcs left = %v, want only %s", vpcs.Vpcs, *other.Vpc.VpcId)
+	}
+}
+
+func TestStateDeleteResume(t *testing.T) {
+	cfg := testConfig(2)
+	svc := awsextratest.NewEC2("us-west-2")
+	state := upWithState(t, svc, cfg)
+	svc.InjectError("DeleteVpc", awserr.New("UnauthorizedOperation", "denied", nil))
+
+	_, err := awsextra.DeleteStateResources(context.Background(), svc, cfg, state)
+	var e *awsextra.Error
+	if !errors.As(err, &e) || e.Resource != "vpc" {
+		t.Fatalf("err = %v, want the vpc delete to fail", err)
+	}
+	if got := types(state); len(got) != 2 || got["vpc"] != 1 || got["dhcp options set"] != 1 {
+		t.Errorf("state after failure lists %v, want the vpc and dhcp options set", got)
+	}
+
+	if _, err := awsextra.DeleteStateResources(context.Background(), svc, cfg, state); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left after delete", n)
+	}
+}
+
+// cancelOnDeleteSubnet cancels the teardown's context from inside its first
+// DeleteSubnet, as a Ctrl-C would.
+type cancelOnDeleteSubnet struct {
+	*awsextratest.EC2
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnDeleteSubnet) DeleteSubnetWithContext(ctx aws.Context, in *ec2.DeleteSubnetInput, opts ...request.Option) (*ec2.DeleteSubnetOutput, error) {
+	c.cancel()
+	return c.EC2.DeleteSubnetWithContext(context.Background(), in, opts...)
+}
+
+func TestStateDeleteInterrupted(t *testing.T) {
+	cfg := testConfig(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	svc := &cancelOnDeleteSubnet{EC2: awsextratest.NewEC2("us-west-2"), cancel: cancel}
+	state := upWithState(t, svc.EC2, cfg)
+
+	_, err := awsextra.DeleteStateResources(ctx, svc, cfg, state)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if got := types(state); got["subnet"] != 0 || got["vpc"] != 1 {
+		t.Errorf("state after interrupt lists %v, want the vpc but not the subnet", got)
+	}
+
+	if _, err := awsextra.DeleteStateResources(context.Background(), svc.EC2, cfg, state); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left after delete", n)
+	}
+}
+
+func TestStateDeleteAlreadyGone(t *testing.T) {
+	cfg := testConfig(1)
+	svc := awsextratest.NewEC2("us-west-2")
+	state := upWithState(t, svc, cfg)
+	groupID, _ := awsextra.GetSecurityGroup(context.Background(), svc, cfg, "default")
+	if err := awsextra.DeleteSecurityGroup(context.Background(), svc, cfg, groupID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := awsextra.DeleteStateResources(context.Background(), svc, cfg, state); err != nil {
+		t.Fatalf("DeleteStateResources: %v", err)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left after delete", n)
+	}
+}
+
+func TestRefreshState(t *testing.T) {
+	cfg := testConfig(2)
+	svc := awsextratest.NewEC2("us-west-2")
+	state := upWithState(t, svc, cfg)
+	subnets, _ := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{})
+	svc.DeleteSubnet(&ec2.DeleteSubnetInput{SubnetId: subnets.Subnets[0].SubnetId})
+
+	gone, err := awsextra.RefreshState(context.Background(), svc, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gone) != 1 || gone[0].ID != *subnets.Subnets[0].SubnetId {
+		t.Errorf("gone = %v, want %s", gone, *subnets.Subnets[0].SubnetId)
+	}
+	if got := types(state)["subnet"]; got != 1 {
+		t.Errorf("state has %d subnets, want 1", got)
+	}
+}
+
+func TestRefreshStateEmptyDescribe(t *testing.T) {
+	cfg := privateConfig(1, awsextra.NATGatewaysSingle)
+	svc := awsextratest.NewEC2("us-west-2")
+	state := upWithState(t, svc, cfg)
+	natID := inState(state, "nat gateway")[0]
+
+	gone, err := awsextra.RefreshState(context.Background(), &emptyDescribes{svc}, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gone) != 1 || gone[0].ID != natID {
+		t.Errorf("gone = %v, want %s", gone, natID)
+	}
+}
+
+func TestReconcileState(t *testing.T) {
+	cfg := testConfig(2)
+	svc := awsextratest.NewEC2("us-west-2")
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := awsextra.CreateSecurityGroup(context.Background(), svc, cfg, "default", vpcID, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	state := awsextra.NewState(cfg)
+	added, err := awsextra.ReconcileState(context.Background(), svc, cfg, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 7 {
+		t.Errorf("added %d resources, want 7\n%s", len(added), state)
+	}
+	if added, _ := awsextra.ReconcileState(context.Background(), svc, cfg, state); len(added) != 0 {
+		t.Errorf("second reconcile added %v", added)
+	}
+
+	if _, err := awsextra.DeleteStateResources(context.Background(), svc, cfg, state); err != nil {
+		t.Fatalf("DeleteStateResources: %v", err)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left after delete", n)
+	}
+}
+
+func TestLoadState(t *testing.T) {
+	cfg := testConfig(0)
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	state, err := awsextra.LoadState(path, cfg)
+	if state != nil || err != nil {
+		t.Fatalf("LoadState of a missing file = %v, %v; want nil, nil", state, err)
+	}
+
+	if err := awsextra.NewState(cfg).Save(path); err != nil {
+		t.Fatal(err)
+	}
+	staging := testConfig(0)
+	staging.TagValue = "staging"
+	if _, err := awsextra.LoadState(path, staging); !errors.Is(err, awsextra.ErrStateMismatch) {
+		t.Errorf("err = %v, want ErrStateMismatch", err)
+	}
+}
@@ -1,43 +1,28 @@
 package awsextra
 
 import (
-	"fmt"
-	"time"
+	"context"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
-func tagIt(svc *ec2.EC2, ID *string, tagKey string, tagValue string) bool {
-	tagItRetry(svc, ID, tagKey, tagValue, 0)
-	return true
-}
-
-// Re-try incase of aws failure to recognize new resource ID.
-func tagItRetry(svc *ec2.EC2, ID *string, tagKey string, tagValue string, retryCount int64) bool {
-	retryCount++
-
-	_, errtag := svc.CreateTags(&ec2.CreateTagsInput{
-		Resources: []*string{ID},
-		Tags: []*ec2.Tag{
-			{
-				Key:   aws.String(tagKey),
-				Value: aws.String(tagValue),
+// tagIt tags the new resource ID, a resource of the given kind (eg. "subnet").
+// EC2 is eventually consistent, so a resource that was just created can be
+// reported as not found for a little while; that is retried under cfg's
+// RetryPolicy and any other error is returned straight away.
+func tagIt(ctx context.Context, svc EC2API, cfg *Config, resource string, ID *string, tagKey string, tagValue string) error {
+	err := cfg.Retry.do(ctx, onCodes(".NotFound"), func() error {
+		_, err := svc.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{
+			Resources: []*string{ID},
+			Tags: []*ec2.Tag{
+				{
+					Key:   aws.String(tagKey),
+					Value: aws.String(tagValue),
+				},
 			},
-		},
+		})
+		return err
 	})
-	if errtag != nil {
-		if awsErr, ok := errtag.(awserr.Error); ok {
-			fmt.Println("Retrying aws Code: " + awsErr.Code())
-		} else {
-			fmt.Println(errtag)
-		}
-		if retryCount > 20 {
-			haltOnError(errtag, "Aborted: Maximum retries reached for tagging "+*ID)
-		}
-		time.Sleep(time.Second * 5)
-		tagItRetry(svc, ID, tagKey, tagValue, retryCount)
-	}
-	return true
+	return newError("tag", resource, ID, err)
 }
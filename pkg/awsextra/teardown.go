@@ -0,0 +1,846 @@
+package awsextra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ErrNotInStack is wrapped by the errors DeleteVPCNetworking returns for
+// resources in the VPC that don't carry the stack's tag.  They are left alone
+// and so is everything they depend on.
+var ErrNotInStack = errors.New("not tagged as part of the stack")
+
+// ErrBlocked is wrapped by the error returned when a delete fails because
+// resources the teardown didn't know about still use the resource.
+var ErrBlocked = errors.New("still in use")
+
+// graph is a set of resources and what each one depends on, found by
+// describing the stack or read from a State.
+type graph struct {
+	State
+	keep      map[string]error // Resources that can't be deleted, and why
+	goingAway map[string]bool  // Resources AWS is already removing
+}
+
+func newGraph() *graph {
+	return &graph{keep: map[string]error{}, goingAway: map[string]bool{}}
+}
+
+// blocked returns the resources that can't go because a kept resource
+// depends on them, directly or not, each with the remaining resources
+// that depend on it.
+func (g *graph) blocked() map[string][]string {
+	blocked := map[string][]string{}
+	var walk func(ID string)
+	walk = func(ID string) {
+		r := g.find(ID)
+		if r == nil {
+			return
+		}
+		for _, dep := range r.DependsOn {
+			if _, seen := blocked[dep]; seen || g.find(dep) == nil {
+				continue
+			}
+			blocked[dep] = g.dependents(dep)
+			walk(dep)
+		}
+	}
+	for ID := range g.keep {
+		walk(ID)
+	}
+	return blocked
+}
+
+func (g *graph) dependents(ID string) []string {
+	var IDs []string
+	for _, r := range g.Resources {
+		if contains(r.DependsOn, ID) {
+			IDs = append(IDs, r.ID)
+		}
+	}
+	return IDs
+}
+
+// discover describes the VPC carrying the stack's tag and everything in it.
+// Unless force is set, resources without the tag are kept.  Network
+// interfaces owned by other AWS services are always kept.
+func discover(ctx context.Context, svc EC2API, cfg *Config, force bool) (*graph, error) {
+	g := newGraph()
+	owned := func(tags []*ec2.Tag) bool {
+		return force || hasTag(tags, cfg.TagKey, cfg.TagValue)
+	}
+	add := func(resourceType string, ID *string, tags []*ec2.Tag, dependsOn ...*string) {
+		g.record(resourceType, ID, dependsOn...)
+		if !owned(tags) {
+			g.keep[*ID] = ErrNotInStack
+		}
+	}
+
+	dhcp, err := svc.DescribeDhcpOptionsWithContext(ctx, &ec2.DescribeDhcpOptionsInput{Filters: []*ec2.Filter{cfg.tagFilter()}})
+	if err != nil {
+		return nil, newError("describe", "dhcp options sets", nil, err)
+	}
+	for _, opts := range dhcp.DhcpOptions {
+		g.record("dhcp options set", opts.DhcpOptionsId)
+	}
+
+	vpcs, err := svc.DescribeVpcsWithContext(ctx, &ec2.DescribeVpcsInput{Filters: []*ec2.Filter{cfg.tagFilter()}})
+	if err != nil {
+		return nil, newError("describe", "vpcs", nil, err)
+	}
+	var vpcIDs []*string
+	for _, vpc := range vpcs.Vpcs {
+		vpcIDs = append(vpcIDs, vpc.VpcId)
+		if g.find(aws.StringValue(vpc.DhcpOptionsId)) != nil {
+			g.record("vpc", vpc.VpcId, vpc.DhcpOptionsId)
+		} else {
+			g.record("vpc", vpc.VpcId)
+		}
+	}
+
+	// Internet gateways, attached or left over from a failed `up`.
+	igwsByVPC := map[string][]*string{}
+	tagged, err := svc.DescribeInternetGatewaysWithContext(ctx, &ec2.DescribeInternetGatewaysInput{Filters: []*ec2.Filter{cfg.tagFilter()}})
+	if err != nil {
+		return nil, newError("describe", "internet gateways", nil, err)
+	}
+	for _, igw := range tagged.InternetGateways {
+		if len(igw.Attachments) == 0 {
+			g.record("internet gateway", igw.InternetGatewayId)
+		}
+	}
+
+	// Elastic IPs, in use by a NAT gateway or left over from a failed `up`.
+	addresses, err := svc.DescribeAddressesWithContext(ctx, &ec2.DescribeAddressesInput{Filters: []*ec2.Filter{cfg.tagFilter()}})
+	if err != nil {
+		return nil, newError("describe", "elastic ips", nil, err)
+	}
+	for _, address := range addresses.Addresses {
+		g.record("elastic ip", address.AllocationId)
+	}
+
+	// The stack's own transit gateways go after its attachments to them.
+	// Other VPCs' attachments keep them.
+	tgws, err := svc.DescribeTransitGatewaysWithContext(ctx, &ec2.DescribeTransitGatewaysInput{
+		Filters: []*ec2.Filter{
+			cfg.tagFilter(),
+			{Name: aws.String("state"), Values: aws.StringSlice(append(liveTGWStates, "deleting"))},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "transit gateways", nil, err)
+	}
+	var tgwIDs []*string
+	for _, tgw := range tgws.TransitGateways {
+		tgwIDs = append(tgwIDs, tgw.TransitGatewayId)
+		g.record("transit gateway", tgw.TransitGatewayId)
+		if aws.StringValue(tgw.State) == "deleting" {
+			g.goingAway[*tgw.TransitGatewayId] = true
+		}
+	}
+	if len(tgwIDs) > 0 {
+		others, err := svc.DescribeTransitGatewayVpcAttachmentsWithContext(ctx, &ec2.DescribeTransitGatewayVpcAttachmentsInput{
+			Filters: []*ec2.Filter{
+				{Name: aws.String("transit-gateway-id"), Values: tgwIDs},
+				{Name: aws.String("state"), Values: aws.StringSlice(liveTGWAttachmentStates)},
+			},
+		})
+		if err != nil {
+			return nil, newError("describe", "transit gateway attachments", nil, err)
+		}
+		for _, attachment := range others.TransitGatewayVpcAttachments {
+			if !contains(aws.StringValueSlice(vpcIDs), aws.StringValue(attachment.VpcId)) {
+				g.record("transit gateway attachment", attachment.TransitGatewayAttachmentId, attachment.TransitGatewayId)
+				g.keep[*attachment.TransitGatewayAttachmentId] = fmt.Errorf("%w: attaches %s", ErrNotInStack, aws.StringValue(attachment.VpcId))
+			}
+		}
+	}
+
+	// The stack's virtual private gateways, detached before their VPC goes,
+	// and customer gateways go after the VPN connections between them.
+	vgws, err := svc.DescribeVpnGatewaysWithContext(ctx, &ec2.DescribeVpnGatewaysInput{
+		Filters: []*ec2.Filter{
+			cfg.tagFilter(),
+			{Name: aws.String("state"), Values: aws.StringSlice(append(liveVPNStates, "deleting"))},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "vpn gateways", nil, err)
+	}
+	for _, vgw := range vgws.VpnGateways {
+		if vpcID := vgwAttachment(vgw); g.find(vpcID) != nil {
+			g.record("vpn gateway", vgw.VpnGatewayId, aws.String(vpcID))
+		} else {
+			g.record("vpn gateway", vgw.VpnGatewayId)
+		}
+		if aws.StringValue(vgw.State) == "deleting" {
+			g.goingAway[*vgw.VpnGatewayId] = true
+		}
+	}
+	cgws, err := svc.DescribeCustomerGatewaysWithContext(ctx, &ec2.DescribeCustomerGatewaysInput{
+		Filters: []*ec2.Filter{
+			cfg.tagFilter(),
+			{Name: aws.String("state"), Values: aws.StringSlice(append(liveVPNStates, "deleting"))},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "customer gateways", nil, err)
+	}
+	for _, cgw := range cgws.CustomerGateways {
+		g.record("customer gateway", cgw.CustomerGatewayId)
+		if aws.StringValue(cgw.State) == "deleting" {
+			g.goingAway[*cgw.CustomerGatewayId] = true
+		}
+	}
+	// Connections to either gateway, whoever made them.
+	conns, err := svc.DescribeVpnConnectionsWithContext(ctx, &ec2.DescribeVpnConnectionsInput{
+		Filters: []*ec2.Filter{{Name: aws.String("state"), Values: aws.StringSlice(append(liveVPNStates, "deleting"))}},
+	})
+	if err != nil {
+		return nil, newError("describe", "vpn connections", nil, err)
+	}
+	for _, conn := range conns.VpnConnections {
+		var dependsOn []*string
+		for _, ID := range []*string{conn.VpnGatewayId, conn.CustomerGatewayId} {
+			if g.find(aws.StringValue(ID)) != nil {
+				dependsOn = append(dependsOn, ID)
+			}
+		}
+		if len(dependsOn) == 0 {
+			continue
+		}
+		add("vpn connection", conn.VpnConnectionId, conn.Tags, dependsOn...)
+		if aws.StringValue(conn.State) == "deleting" {
+			g.goingAway[*conn.VpnConnectionId] = true
+		}
+	}
+	if len(vpcIDs) == 0 {
+		return g, nil
+	}
+
+	inVPC := []*ec2.Filter{{Name: aws.String("vpc-id"), Values: vpcIDs}}
+	attached, err := svc.DescribeInternetGatewaysWithContext(ctx, &ec2.DescribeInternetGatewaysInput{
+		Filters: []*ec2.Filter{{Name: aws.String("attachment.vpc-id"), Values: vpcIDs}},
+	})
+	if err != nil {
+		return nil, newError("describe", "internet gateways", nil, err)
+	}
+	for _, igw := range attached.InternetGateways {
+		var attachedTo []*string
+		for _, attachment := range igw.Attachments {
+			attachedTo = append(attachedTo, attachment.VpcId)
+			igwsByVPC[*attachment.VpcId] = append(igwsByVPC[*attachment.VpcId], igw.InternetGatewayId)
+		}
+		add("internet gateway", igw.InternetGatewayId, igw.Tags, attachedTo...)
+	}
+	attachedVGWs, err := svc.DescribeVpnGatewaysWithContext(ctx, &ec2.DescribeVpnGatewaysInput{
+		Filters: []*ec2.Filter{{Name: aws.String("attachment.vpc-id"), Values: vpcIDs}},
+	})
+	if err != nil {
+		return nil, newError("describe", "vpn gateways", nil, err)
+	}
+	for _, vgw := range attachedVGWs.VpnGateways {
+		if vpcID := vgwAttachment(vgw); g.find(vpcID) != nil {
+			add("vpn gateway", vgw.VpnGatewayId, vgw.Tags, aws.String(vpcID))
+		}
+	}
+
+	// EC2 only filters egress-only internet gateways on tags.
+	eigws, err := svc.DescribeEgressOnlyInternetGatewaysWithContext(ctx, &ec2.DescribeEgressOnlyInternetGatewaysInput{})
+	if err != nil {
+		return nil, newError("describe", "egress-only internet gateways", nil, err)
+	}
+	for _, eigw := range eigws.EgressOnlyInternetGateways {
+		for _, attachment := range eigw.Attachments {
+			if g.find(aws.StringValue(attachment.VpcId)) != nil {
+				add("egress-only internet gateway", eigw.EgressOnlyInternetGatewayId, eigw.Tags, attachment.VpcId)
+			}
+		}
+	}
+
+	subnets, err := svc.DescribeSubnetsWithContext(ctx, &ec2.DescribeSubnetsInput{Filters: inVPC})
+	if err != nil {
+		return nil, newError("describe", "subnets", nil, err)
+	}
+	for _, subnet := range subnets.Subnets {
+		add("subnet", subnet.SubnetId, subnet.Tags, subnet.VpcId)
+	}
+
+	// Network ACLs hand their subnets back to the default one before they
+	// go, and so go before the subnets.
+	acls, err := svc.DescribeNetworkAclsWithContext(ctx, &ec2.DescribeNetworkAclsInput{Filters: inVPC})
+	if err != nil {
+		return nil, newError("describe", "network acls", nil, err)
+	}
+	for _, acl := range acls.NetworkAcls {
+		if aws.BoolValue(acl.IsDefault) {
+			continue
+		}
+		dependsOn := []*string{acl.VpcId}
+		for _, assoc := range acl.Associations {
+			dependsOn = append(dependsOn, assoc.SubnetId)
+		}
+		add("network acl", acl.NetworkAclId, acl.Tags, dependsOn...)
+	}
+
+	// NAT gateways go before their subnet, elastic IP and, since they map a
+	// public address, the internet gateway.
+	nats, err := svc.DescribeNatGatewaysWithContext(ctx, &ec2.DescribeNatGatewaysInput{
+		Filter: append(inVPC, &ec2.Filter{
+			Name:   aws.String("state"),
+			Values: aws.StringSlice([]string{"pending", "available", "deleting"}),
+		}),
+	})
+	if err != nil {
+		return nil, newError("describe", "nat gateways", nil, err)
+	}
+	for _, nat := range nats.NatGateways {
+		dependsOn := append([]*string{nat.SubnetId}, igwsByVPC[aws.StringValue(nat.VpcId)]...)
+		for _, address := range nat.NatGatewayAddresses {
+			if force && g.find(aws.StringValue(address.AllocationId)) == nil {
+				g.record("elastic ip", address.AllocationId)
+			}
+			if g.find(aws.StringValue(address.AllocationId)) != nil {
+				dependsOn = append(dependsOn, address.AllocationId)
+			}
+		}
+		add("nat gateway", nat.NatGatewayId, nat.Tags, dependsOn...)
+		if aws.StringValue(nat.State) == "deleting" {
+			g.goingAway[*nat.NatGatewayId] = true
+		}
+	}
+
+	routeTables, err := svc.DescribeRouteTablesWithContext(ctx, &ec2.DescribeRouteTablesInput{Filters: inVPC})
+	if err != nil {
+		return nil, newError("describe", "route tables", nil, err)
+	}
+	for _, rt := range routeTables.RouteTables {
+		if !isMainRouteTable(rt) {
+			add("route table", rt.RouteTableId, rt.Tags, rt.VpcId)
+		}
+	}
+
+	groups, err := svc.DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{Filters: inVPC})
+	if err != nil {
+		return nil, newError("describe", "security groups", nil, err)
+	}
+	for _, group := range groups.SecurityGroups {
+		if aws.StringValue(group.GroupName) != "default" {
+			add("security group", group.GroupId, group.Tags, group.VpcId)
+		}
+	}
+	// Our groups lose their rules before they are deleted, but a kept group
+	// referencing one of them keeps it in use.
+	for _, group := range groups.SecurityGroups {
+		if _, kept := g.keep[*group.GroupId]; !kept {
+			continue
+		}
+		for _, p := range append(group.IpPermissions, group.IpPermissionsEgress...) {
+			for _, pair := range p.UserIdGroupPairs {
+				if g.find(aws.StringValue(pair.GroupId)) != nil {
+					g.record("security group", group.GroupId, pair.GroupId)
+				}
+			}
+		}
+	}
+
+	flowLogs, err := svc.DescribeFlowLogsWithContext(ctx, &ec2.DescribeFlowLogsInput{
+		Filter: []*ec2.Filter{{Name: aws.String("resource-id"), Values: vpcIDs}},
+	})
+	if err != nil {
+		return nil, newError("describe", "flow logs", nil, err)
+	}
+	for _, flowLog := range flowLogs.FlowLogs {
+		add("flow log", flowLog.FlowLogId, flowLog.Tags, flowLog.ResourceId)
+	}
+
+	// Peering connections, whichever side requested them, go before the VPC.
+	for _, side := range []string{"requester-vpc-info.vpc-id", "accepter-vpc-info.vpc-id"} {
+		peerings, err := svc.DescribeVpcPeeringConnectionsWithContext(ctx, &ec2.DescribeVpcPeeringConnectionsInput{
+			Filters: []*ec2.Filter{
+				{Name: aws.String(side), Values: vpcIDs},
+				{Name: aws.String("status-code"), Values: aws.StringSlice(livePeeringStates)},
+			},
+		})
+		if err != nil {
+			return nil, newError("describe", "vpc peering connections", nil, err)
+		}
+		for _, peering := range peerings.VpcPeeringConnections {
+			vpcID := peering.AccepterVpcInfo.VpcId
+			if side == "requester-vpc-info.vpc-id" {
+				vpcID = peering.RequesterVpcInfo.VpcId
+			}
+			add("vpc peering connection", peering.VpcPeeringConnectionId, peering.Tags, vpcID)
+		}
+	}
+
+	// Endpoints go before the route tables, subnets and security group they
+	// use.
+	endpoints, err := svc.DescribeVpcEndpointsWithContext(ctx, &ec2.DescribeVpcEndpointsInput{
+		Filters: append(inVPC, &ec2.Filter{
+			Name:   aws.String("vpc-endpoint-state"),
+			Values: aws.StringSlice([]string{"pendingAcceptance", "pending", "available", "rejected", "failed", "deleting"}),
+		}),
+	})
+	if err != nil {
+		return nil, newError("describe", "vpc endpoints", nil, err)
+	}
+	for _, endpoint := range endpoints.VpcEndpoints {
+		dependsOn := []*string{endpoint.VpcId}
+		for _, ID := range append(append([]*string{}, endpoint.RouteTableIds...), endpoint.SubnetIds...) {
+			if g.find(aws.StringValue(ID)) != nil {
+				dependsOn = append(dependsOn, ID)
+			}
+		}
+		for _, group := range endpoint.Groups {
+			if g.find(aws.StringValue(group.GroupId)) != nil {
+				dependsOn = append(dependsOn, group.GroupId)
+			}
+		}
+		add("vpc endpoint", endpoint.VpcEndpointId, endpoint.Tags, dependsOn...)
+		if aws.StringValue(endpoint.State) == "deleting" {
+			g.goingAway[*endpoint.VpcEndpointId] = true
+		}
+	}
+
+	// Transit gateway attachments go before the subnets they use, which EC2
+	// doesn't let go until the attachment is deleted.
+	attachments, err := svc.DescribeTransitGatewayVpcAttachmentsWithContext(ctx, &ec2.DescribeTransitGatewayVpcAttachmentsInput{
+		Filters: append(inVPC, &ec2.Filter{
+			Name:   aws.String("state"),
+			Values: aws.StringSlice(append(liveTGWAttachmentStates, "failed", "deleting")),
+		}),
+	})
+	if err != nil {
+		return nil, newError("describe", "transit gateway attachments", nil, err)
+	}
+	for _, attachment := range attachments.TransitGatewayVpcAttachments {
+		dependsOn := append([]*string{attachment.VpcId}, attachment.SubnetIds...)
+		if g.find(aws.StringValue(attachment.TransitGatewayId)) != nil {
+			dependsOn = append(dependsOn, attachment.TransitGatewayId)
+		}
+		add("transit gateway attachment", attachment.TransitGatewayAttachmentId, attachment.Tags, dependsOn...)
+		if aws.StringValue(attachment.State) == "deleting" {
+			g.goingAway[*attachment.TransitGatewayAttachmentId] = true
+		}
+	}
+
+	instances, err := svc.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{
+		Filters: append(inVPC, &ec2.Filter{
+			Name:   aws.String("instance-state-name"),
+			Values: aws.StringSlice([]string{"pending", "running", "stopping", "stopped", "shutting-down"}),
+		}),
+	})
+	if err != nil {
+		return nil, newError("describe", "instances", nil, err)
+	}
+	for _, reservation := range instances.Reservations {
+		for _, instance := range reservation.Instances {
+			// Public addresses keep the internet gateway from detaching.
+			dependsOn := append([]*string{instance.SubnetId}, igwsByVPC[aws.StringValue(instance.VpcId)]...)
+			for _, group := range instance.SecurityGroups {
+				dependsOn = append(dependsOn, group.GroupId)
+			}
+			add("instance", instance.InstanceId, instance.Tags, dependsOn...)
+			if aws.StringValue(instance.State.Name) == "shutting-down" {
+				g.goingAway[*instance.InstanceId] = true
+			}
+		}
+	}
+
+	enis, err := svc.DescribeNetworkInterfacesWithContext(ctx, &ec2.DescribeNetworkInterfacesInput{Filters: inVPC})
+	if err != nil {
+		return nil, newError("describe", "network interfaces", nil, err)
+	}
+	for _, eni := range enis.NetworkInterfaces {
+		// A NAT gateway's or VPC endpoint's interfaces go with it.
+		switch aws.StringValue(eni.InterfaceType) {
+		case "nat_gateway", "vpc_endpoint":
+			continue
+		}
+		attachment := eni.Attachment
+		instanceID := ""
+		if attachment != nil {
+			instanceID = aws.StringValue(attachment.InstanceId)
+		}
+		// Interfaces deleted on termination go with their instance.
+		if g.find(instanceID) != nil && aws.BoolValue(attachment.DeleteOnTermination) {
+			continue
+		}
+		dependsOn := []*string{eni.SubnetId}
+		for _, group := range eni.Groups {
+			dependsOn = append(dependsOn, group.GroupId)
+		}
+		add("network interface", eni.NetworkInterfaceId, eni.TagSet, dependsOn...)
+		if aws.BoolValue(eni.RequesterManaged) {
+			g.keep[*eni.NetworkInterfaceId] = fmt.Errorf("%w: requester %s, %q", ErrManagedInterface, aws.StringValue(eni.RequesterId), aws.StringValue(eni.Description))
+		}
+		if attachment != nil && aws.StringValue(attachment.Status) == "detaching" {
+			g.goingAway[*eni.NetworkInterfaceId] = true
+		}
+		// The instance is terminated first, leaving the interface to delete.
+		if g.find(instanceID) != nil {
+			g.record("instance", attachment.InstanceId, eni.NetworkInterfaceId)
+		}
+	}
+	return g, nil
+}
+
+func hasTag(tags []*ec2.Tag, key string, value string) bool {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key && aws.StringValue(tag.Value) == value {
+			return true
+		}
+	}
+	return false
+}
+
+func isMainRouteTable(rt *ec2.RouteTable) bool {
+	for _, assoc := range rt.Associations {
+		if aws.BoolValue(assoc.Main) {
+			return true
+		}
+	}
+	return false
+}
+
+// teardown deletes the resources of a graph, leaves first and as many at a
+// time as the graph allows.
+type teardown struct {
+	svc    EC2API
+	retry  RetryPolicy
+	report *Report
+
+	// rediscover describes the stack again after a DependencyViolation so
+	// the teardown can tell a real dependency from EC2 catching up with
+	// deletes.  Without it such errors are simply retried under the policy.
+	rediscover func(context.Context) (*graph, error)
+
+	// deleted is called with every resource as soon as it is gone.
+	deleted func(Resource)
+}
+
+// errGone is returned by delete for a resource that was already gone.
+var errGone = errors.New("already gone")
+
+// run deletes every node of g that isn't kept, each once nothing left
+// depends on it.  Once ctx is done no more deletes are started; the ones
+// already running are waited for.
+func (t *teardown) run(ctx context.Context, g *graph) error {
+	// Strip the rules from the groups that will be deleted, so groups that
+	// reference each other don't block one another.
+	blocked := g.blocked()
+	for _, r := range g.Resources {
+		_, kept := g.keep[r.ID]
+		_, isBlocked := blocked[r.ID]
+		if r.Type == "security group" && !kept && !isBlocked {
+			err := stripSecGroup(ctx, t.svc, aws.String(r.ID))
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+		}
+	}
+
+	type result struct {
+		r   Resource
+		err error
+	}
+	results := make(chan result)
+	started := map[string]bool{}
+	running := 0
+	var failed []error
+	for {
+		for _, r := range g.Resources {
+			if ctx.Err() != nil {
+				break
+			}
+			if _, kept := g.keep[r.ID]; kept || started[r.ID] || g.needed(r.ID) {
+				continue
+			}
+			started[r.ID] = true
+			running++
+			fmt.Println("delete " + r.Type + ": " + r.ID)
+			go func(r Resource) {
+				results <- result{r, t.delete(ctx, r)}
+			}(r)
+		}
+		if running == 0 {
+			break
+		}
+		res := <-results
+		running--
+		if res.err != nil && res.err != errGone {
+			fmt.Println("failed to delete " + res.r.Type + ": " + res.r.ID)
+			failed = append(failed, res.err)
+			continue
+		}
+		if res.err == nil {
+			t.report.add(res.r.Type, aws.String(res.r.ID))
+		}
+		g.forget(res.r.ID)
+		if t.deleted != nil {
+			t.deleted(res.r)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return errors.Join(append(failed, err)...)
+	}
+
+	// Whatever is left waits on a kept resource or a failed delete.
+	var errs []error
+	for _, r := range g.Resources {
+		if reason, kept := g.keep[r.ID]; kept {
+			for _, dep := range r.DependsOn {
+				if _, depKept := g.keep[dep]; g.find(dep) != nil && !depKept {
+					errs = append(errs, newError("delete", r.Type, aws.String(r.ID), reason))
+					break
+				}
+			}
+		} else if started[r.ID] {
+			continue
+		} else {
+			t.report.block(r.Type, r.ID, g.dependents(r.ID))
+		}
+	}
+	return errors.Join(append(errs, failed...)...)
+}
+
+// delete removes one resource.  A DependencyViolation is retried under the
+// teardown's RetryPolicy for as long as nothing the teardown can't delete is
+// found depending on the resource.
+func (t *teardown) delete(ctx context.Context, r Resource) error {
+	inUse := onCodes("DependencyViolation", "InvalidNetworkInterface.InUse", "InvalidIPAddress.InUse")
+	err := t.retry.do(ctx, inUse, func() error {
+		err := deleteResource(ctx, t.svc, t.retry, r)
+		if !inUse(err) || t.rediscover == nil {
+			return err
+		}
+		g, rerr := t.rediscover(ctx)
+		if rerr != nil {
+			return rerr
+		}
+		var blockers []string
+		for _, ID := range g.dependents(r.ID) {
+			if !g.goingAway[ID] {
+				blockers = append(blockers, g.find(ID).Type+" "+ID)
+			}
+		}
+		if len(blockers) > 0 {
+			return newError("delete", r.Type, aws.String(r.ID), fmt.Errorf("%w by %s", ErrBlocked, strings.Join(blockers, ", ")))
+		}
+		return err
+	})
+	if isNotFound(err) {
+		return errGone
+	}
+	return err
+}
+
+// deleteResource makes one attempt at deleting r.
+func deleteResource(ctx context.Context, svc EC2API, retry RetryPolicy, r Resource) error {
+	ID := aws.String(r.ID)
+	switch r.Type {
+	case "vpc":
+		_, err := svc.DeleteVpcWithContext(ctx, &ec2.DeleteVpcInput{VpcId: ID})
+		return newError("delete", r.Type, ID, err)
+	case "subnet":
+		_, err := svc.DeleteSubnetWithContext(ctx, &ec2.DeleteSubnetInput{SubnetId: ID})
+		return newError("delete", r.Type, ID, err)
+	case "internet gateway":
+		resp, err := svc.DescribeInternetGatewaysWithContext(ctx, &ec2.DescribeInternetGatewaysInput{InternetGatewayIds: []*string{ID}})
+		if err != nil {
+			return newError("describe", r.Type, ID, err)
+		}
+		if len(resp.InternetGateways) == 0 {
+			return errGone
+		}
+		for _, attachment := range resp.InternetGateways[0].Attachments {
+			params := &ec2.DetachInternetGatewayInput{InternetGatewayId: ID, VpcId: attachment.VpcId}
+			if _, err := svc.DetachInternetGatewayWithContext(ctx, params); err != nil {
+				return newError("detach", r.Type, ID, err)
+			}
+		}
+		_, err = svc.DeleteInternetGatewayWithContext(ctx, &ec2.DeleteInternetGatewayInput{InternetGatewayId: ID})
+		return newError("delete", r.Type, ID, err)
+	case "egress-only internet gateway":
+		params := &ec2.DeleteEgressOnlyInternetGatewayInput{EgressOnlyInternetGatewayId: ID}
+		_, err := svc.DeleteEgressOnlyInternetGatewayWithContext(ctx, params)
+		return newError("delete", r.Type, ID, err)
+	case "dhcp options set":
+		_, err := svc.DeleteDhcpOptionsWithContext(ctx, &ec2.DeleteDhcpOptionsInput{DhcpOptionsId: ID})
+		return newError("delete", r.Type, ID, err)
+	case "security group":
+		_, err := svc.DeleteSecurityGroupWithContext(ctx, &ec2.DeleteSecurityGroupInput{GroupId: ID})
+		return newError("delete", r.Type, ID, err)
+	case "route table":
+		resp, err := svc.DescribeRouteTablesWithContext(ctx, &ec2.DescribeRouteTablesInput{RouteTableIds: []*string{ID}})
+		if err != nil {
+			return newError("describe", r.Type, ID, err)
+		}
+		if len(resp.RouteTables) == 0 {
+			return errGone
+		}
+		for _, assoc := range resp.RouteTables[0].Associations {
+			params := &ec2.DisassociateRouteTableInput{AssociationId: assoc.RouteTableAssociationId}
+			if _, err := svc.DisassociateRouteTableWithContext(ctx, params); err != nil {
+				return newError("disassociate", r.Type, ID, err)
+			}
+		}
+		_, err = svc.DeleteRouteTableWithContext(ctx, &ec2.DeleteRouteTableInput{RouteTableId: ID})
+		return newError("delete", r.Type, ID, err)
+	case "network acl":
+		return deleteNetworkACL(ctx, svc, ID)
+	case "network interface":
+		resp, err := svc.DescribeNetworkInterfacesWithContext(ctx, &ec2.DescribeNetworkInterfacesInput{NetworkInterfaceIds: []*string{ID}})
+		if err != nil {
+			return newError("describe", r.Type, ID, err)
+		}
+		if len(resp.NetworkInterfaces) == 0 {
+			return errGone
+		}
+		if attachment := resp.NetworkInterfaces[0].Attachment; attachment != nil && aws.StringValue(attachment.Status) != "detached" {
+			params := &ec2.DetachNetworkInterfaceInput{AttachmentId: attachment.AttachmentId, Force: aws.Bool(true)}
+			if _, err := svc.DetachNetworkInterfaceWithContext(ctx, params); err != nil {
+				return newError("detach", r.Type, ID, err)
+			}
+		}
+		_, err = svc.DeleteNetworkInterfaceWithContext(ctx, &ec2.DeleteNetworkInterfaceInput{NetworkInterfaceId: ID})
+		return newError("delete", r.Type, ID, err)
+	case "nat gateway":
+		if _, err := svc.DeleteNatGatewayWithContext(ctx, &ec2.DeleteNatGatewayInput{NatGatewayId: ID}); err != nil {
+			return newError("delete", r.Type, ID, err)
+		}
+		return waitNATGatewayDeleted(ctx, svc, retry, ID)
+	case "vpc endpoint":
+		resp, err := svc.DeleteVpcEndpointsWithContext(ctx, &ec2.DeleteVpcEndpointsInput{VpcEndpointIds: []*string{ID}})
+		if err != nil {
+			return newError("delete", r.Type, ID, err)
+		}
+		for _, item := range resp.Unsuccessful {
+			err := awserr.New(aws.StringValue(item.Error.Code), aws.StringValue(item.Error.Message), nil)
+			return newError("delete", r.Type, ID, err)
+		}
+		return waitEndpointDeleted(ctx, svc, retry, ID)
+	case "flow log":
+		return deleteFlowLog(ctx, svc, ID)
+	case "vpc peering connection":
+		params := &ec2.DeleteVpcPeeringConnectionInput{VpcPeeringConnectionId: ID}
+		if _, err := svc.DeleteVpcPeeringConnectionWithContext(ctx, params); err != nil {
+			return newError("delete", r.Type, ID, err)
+		}
+		return waitPeeringDeleted(ctx, svc, retry, ID)
+	case "transit gateway attachment":
+		params := &ec2.DeleteTransitGatewayVpcAttachmentInput{TransitGatewayAttachmentId: ID}
+		if _, err := svc.DeleteTransitGatewayVpcAttachmentWithContext(ctx, params); err != nil {
+			return newError("delete", r.Type, ID, err)
+		}
+		return waitTGWAttachmentDeleted(ctx, svc, retry, ID)
+	case "transit gateway":
+		if _, err := svc.DeleteTransitGatewayWithContext(ctx, &ec2.DeleteTransitGatewayInput{TransitGatewayId: ID}); err != nil {
+			return newError("delete", r.Type, ID, err)
+		}
+		return waitTransitGatewayDeleted(ctx, svc, retry, ID)
+	case "vpn connection":
+		if _, err := svc.DeleteVpnConnectionWithContext(ctx, &ec2.DeleteVpnConnectionInput{VpnConnectionId: ID}); err != nil {
+			return newError("delete", r.Type, ID, err)
+		}
+		return waitVPNConnectionDeleted(ctx, svc, retry, ID)
+	case "vpn gateway":
+		return deleteVPNGateway(ctx, svc, retry, ID)
+	case "customer gateway":
+		if _, err := svc.DeleteCustomerGatewayWithContext(ctx, &ec2.DeleteCustomerGatewayInput{CustomerGatewayId: ID}); err != nil {
+			return newError("delete", r.Type, ID, err)
+		}
+		return waitCustomerGatewayDeleted(ctx, svc, retry, ID)
+	case "elastic ip":
+		_, err := svc.ReleaseAddressWithContext(ctx, &ec2.ReleaseAddressInput{AllocationId: ID})
+		return newError("release", r.Type, ID, err)
+	case "instance":
+		if _, err := svc.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{InstanceIds: []*string{ID}}); err != nil {
+			return newError("terminate", r.Type, ID, err)
+		}
+		return waitInstanceTerminated(ctx, svc, retry, ID)
+	}
+	return newError("delete", r.Type, ID, errors.New("unknown resource type"))
+}
+
+func waitInstanceTerminated(ctx context.Context, svc EC2API, retry RetryPolicy, instanceID *string) error {
+	return retry.do(ctx, isPending, func() error {
+		resp, err := svc.DescribeInstancesWithContext(ctx, &ec2.DescribeInstancesInput{InstanceIds: []*string{instanceID}})
+		if err != nil {
+			return newError("describe", "instance", instanceID, err)
+		}
+		if len(resp.Reservations) == 0 || len(resp.Reservations[0].Instances) == 0 {
+			return nil
+		}
+		state := aws.StringValue(resp.Reservations[0].Instances[0].State.Name)
+		if state != "terminated" {
+			return newError("terminate", "instance", instanceID, fmt.Errorf("%w: still %s", errPending, state))
+		}
+		return nil
+	})
+}
+
+func waitNATGatewayDeleted(ctx context.Context, svc EC2API, retry RetryPolicy, natID *string) error {
+	return retry.do(ctx, isPending, func() error {
+		resp, err := svc.DescribeNatGatewaysWithContext(ctx, &ec2.DescribeNatGatewaysInput{NatGatewayIds: []*string{natID}})
+		if err != nil {
+			return newError("describe", "nat gateway", natID, err)
+		}
+		if len(resp.NatGateways) == 0 {
+			return nil
+		}
+		state := aws.StringValue(resp.NatGateways[0].State)
+		if state != "deleted" {
+			return newError("delete", "nat gateway", natID, fmt.Errorf("%w: still %s", errPending, state))
+		}
+		return nil
+	})
+}
+
+func waitEndpointDeleted(ctx context.Context, svc EC2API, retry RetryPolicy, endpointID *string) error {
+	return retry.do(ctx, isPending, func() error {
+		resp, err := svc.DescribeVpcEndpointsWithContext(ctx, &ec2.DescribeVpcEndpointsInput{VpcEndpointIds: []*string{endpointID}})
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return newError("describe", "vpc endpoint", endpointID, err)
+		}
+		if len(resp.VpcEndpoints) == 0 {
+			return nil
+		}
+		state := aws.StringValue(resp.VpcEndpoints[0].State)
+		if state != "deleted" {
+			return newError("delete", "vpc endpoint", endpointID, fmt.Errorf("%w: still %s", errPending, state))
+		}
+		return nil
+	})
+}
+
+func waitPeeringDeleted(ctx context.Context, svc EC2API, retry RetryPolicy, pcxID *string) error {
+	return retry.do(ctx, isPending, func() error {
+		resp, err := svc.DescribeVpcPeeringConnectionsWithContext(ctx, &ec2.DescribeVpcPeeringConnectionsInput{VpcPeeringConnectionIds: []*string{pcxID}})
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return newError("describe", "vpc peering connection", pcxID, err)
+		}
+		if len(resp.VpcPeeringConnections) == 0 {
+			return nil
+		}
+		state := aws.StringValue(resp.VpcPeeringConnections[0].Status.Code)
+		if state != "deleted" {
+			return newError("delete", "vpc peering connection", pcxID, fmt.Errorf("%w: still %s", errPending, state))
+		}
+		return nil
+	})
+}
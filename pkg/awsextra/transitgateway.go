@@ -0,0 +1,565 @@
+package awsextra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// TransitGateway attaches the stack's VPC to a transit gateway, the hub of a
+// hub-and-spoke network joining VPCs, VPNs and Direct Connect.
+type TransitGateway struct {
+	// An existing transit gateway, eg. "tgw-0123456789abcdef0", which may be
+	// shared from another account.
+	ID string
+
+	// Or create a transit gateway tagged as part of the stack, which is
+	// deleted with it.
+	Create bool
+
+	// Which subnets the attachment uses, the tier's first in each zone:
+	// RouteTablesPublic, RouteTablesPrivate or, if empty, the private subnets
+	// if there are any, else the public ones.
+	Tier string
+
+	// The transit gateway route table the attachment is associated with, eg.
+	// "tgw-rtb-0123456789abcdef0".  If empty the attachment keeps the transit
+	// gateway's default association, if it has one.
+	AssociateRouteTable string
+
+	// The transit gateway route tables the VPC's routes are propagated to.
+	PropagateRouteTables []string
+
+	// IPv4 or IPv6 CIDR blocks, or prefix list IDs, routed to the transit
+	// gateway from every one of the stack's route tables.
+	Destinations []string
+}
+
+// The states of a transit gateway, or attachment, that is or will be usable.
+var (
+	liveTGWStates           = []string{"pending", "available", "modifying"}
+	liveTGWAttachmentStates = []string{"initiating", "initiatingRequest", "pendingAcceptance", "pending", "available", "modifying"}
+)
+
+// The tier whose subnets the attachment uses.
+func (tg *TransitGateway) tier(cfg *Config) string {
+	if tg.Tier != "" {
+		return tg.Tier
+	}
+	if len(cfg.PrivateSubnetCIDRs) > 0 {
+		return RouteTablesPrivate
+	}
+	return RouteTablesPublic
+}
+
+func (tg *TransitGateway) validate(cfg *Config) error {
+	if (tg.ID == "") == !tg.Create {
+		return errors.New("id: one of id and create is required")
+	}
+	if tg.ID != "" && !strings.HasPrefix(tg.ID, "tgw-") {
+		return fmt.Errorf("id: %q is not a transit gateway ID", tg.ID)
+	}
+	switch tg.Tier {
+	case "", RouteTablesPublic, RouteTablesPrivate:
+	default:
+		return fmt.Errorf("tier: %q is not %q or %q", tg.Tier, RouteTablesPublic, RouteTablesPrivate)
+	}
+	tier := tg.tier(cfg)
+	if (tier == RouteTablesPublic && len(cfg.SubnetCIDRs) == 0) || (tier == RouteTablesPrivate && len(cfg.PrivateSubnetCIDRs) == 0) {
+		return fmt.Errorf("tier: there are no %s subnets", tier)
+	}
+	// A new transit gateway's route tables don't exist yet.
+	if tg.Create && (tg.AssociateRouteTable != "" || len(tg.PropagateRouteTables) > 0) {
+		return errors.New("create: a new transit gateway's route tables can't be named, it uses its default one")
+	}
+	if tg.AssociateRouteTable != "" && !strings.HasPrefix(tg.AssociateRouteTable, "tgw-rtb-") {
+		return fmt.Errorf("associate-route-table: %q is not a transit gateway route table ID", tg.AssociateRouteTable)
+	}
+	for _, rtb := range tg.PropagateRouteTables {
+		if !strings.HasPrefix(rtb, "tgw-rtb-") {
+			return fmt.Errorf("propagate-route-tables: %q is not a transit gateway route table ID", rtb)
+		}
+	}
+	for _, dest := range tg.Destinations {
+		if strings.HasPrefix(dest, "pl-") {
+			// A prefix list.
+		} else if _, _, err := net.ParseCIDR(dest); err != nil {
+			return fmt.Errorf("destinations: %v", err)
+		} else if dest == "0.0.0.0/0" || dest == "::/0" {
+			return fmt.Errorf("destinations: %s is the internet route up manages itself", dest)
+		}
+		for i, r := range cfg.Routes {
+			if r.Destination == dest {
+				return fmt.Errorf("destinations: %s is route-%d's destination too", dest, i)
+			}
+		}
+	}
+	return nil
+}
+
+// createTransitGatewayAttachment attaches the VPC to the configured transit
+// gateway, creating that first if asked to, associates and propagates the
+// attachment to the configured transit gateway route tables and routes the
+// destinations to the transit gateway.
+func createTransitGatewayAttachment(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, state *State) error {
+	tg := cfg.TransitGateway
+	tgwID, err := ensureTransitGateway(ctx, svc, cfg, state)
+	if err != nil {
+		return err
+	}
+	subnetIDs, _, err := zoneSubnets(ctx, svc, cfg, vpcID, tg.tier(cfg))
+	if err != nil {
+		return err
+	}
+	dependsOn := append([]*string{vpcID}, subnetIDs...)
+	if tg.Create {
+		dependsOn = append(dependsOn, tgwID)
+	}
+
+	attachment, err := findTGWAttachment(ctx, svc, vpcID, tgwID)
+	if err != nil {
+		return err
+	}
+	if attachment != nil {
+		fmt.Println("Found transit gateway attachment " + *attachment.TransitGatewayAttachmentId + " to " + *tgwID)
+	} else {
+		resp, err := svc.CreateTransitGatewayVpcAttachmentWithContext(ctx, &ec2.CreateTransitGatewayVpcAttachmentInput{
+			TransitGatewayId: tgwID,     // Required
+			VpcId:            vpcID,     // Required
+			SubnetIds:        subnetIDs, // Required
+		})
+		if err != nil {
+			return newError("create", "transit gateway attachment", nil, err)
+		}
+		attachment = resp.TransitGatewayVpcAttachment
+		fmt.Println("Created transit gateway attachment " + *attachment.TransitGatewayAttachmentId + " to " + *tgwID)
+	}
+	attachmentID := attachment.TransitGatewayAttachmentId
+	state.record("transit gateway attachment", attachmentID, dependsOn...)
+	if err := tagIt(ctx, svc, cfg, "transit gateway attachment", attachmentID, cfg.TagKey, cfg.TagValue); err != nil {
+		return err
+	}
+	if attachment, err = waitTGWAttachment(ctx, svc, cfg.Retry, attachmentID); err != nil {
+		return err
+	}
+
+	// Subnets in zones added since the attachment was made.
+	if missing := missingIDs(attachment.SubnetIds, subnetIDs); len(missing) > 0 {
+		params := &ec2.ModifyTransitGatewayVpcAttachmentInput{TransitGatewayAttachmentId: attachmentID, AddSubnetIds: missing}
+		if _, err := svc.ModifyTransitGatewayVpcAttachmentWithContext(ctx, params); err != nil {
+			return newError("add subnets to", "transit gateway attachment", attachmentID, err)
+		}
+		fmt.Println("Added " + strings.Join(aws.StringValueSlice(missing), ", ") + " to transit gateway attachment " + *attachmentID)
+		if _, err := waitTGWAttachment(ctx, svc, cfg.Retry, attachmentID); err != nil {
+			return err
+		}
+	}
+
+	if tg.AssociateRouteTable != "" {
+		if err := associateTGWRouteTable(ctx, svc, cfg.Retry, attachmentID, aws.String(tg.AssociateRouteTable)); err != nil {
+			return err
+		}
+	}
+	if err := propagateTGWRouteTables(ctx, svc, attachmentID, tg.PropagateRouteTables); err != nil {
+		return err
+	}
+
+	routeTables, err := stackRouteTables(ctx, svc, cfg, vpcID)
+	if err != nil {
+		return err
+	}
+	for _, rt := range routeTables {
+		for _, dest := range tg.Destinations {
+			if err := ensureRoute(ctx, svc, rt, Route{Destination: dest, Target: *tgwID}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// The configured transit gateway, or the stack's own, created if need be,
+// once it is available.
+func ensureTransitGateway(ctx context.Context, svc EC2API, cfg *Config, state *State) (*string, error) {
+	tg := cfg.TransitGateway
+	if !tg.Create {
+		tgwID := aws.String(tg.ID)
+		if err := waitTransitGateway(ctx, svc, cfg.Retry, tgwID); err != nil {
+			return nil, err
+		}
+		fmt.Println("Found transit gateway " + tg.ID)
+		return tgwID, nil
+	}
+
+	tgw, err := findTransitGateway(ctx, svc, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if tgw != nil {
+		fmt.Println("Found transit gateway " + *tgw.TransitGatewayId)
+	} else {
+		resp, err := svc.CreateTransitGatewayWithContext(ctx, &ec2.CreateTransitGatewayInput{
+			Description: aws.String("structureag " + cfg.TagValue),
+		})
+		if err != nil {
+			return nil, newError("create", "transit gateway", nil, err)
+		}
+		tgw = resp.TransitGateway
+		fmt.Println("Created transit gateway " + *tgw.TransitGatewayId)
+	}
+	tgwID := tgw.TransitGatewayId
+	state.record("transit gateway", tgwID)
+	if err := tagIt(ctx, svc, cfg, "transit gateway", tgwID, cfg.TagKey, cfg.TagValue); err != nil {
+		return tgwID, err
+	}
+	return tgwID, waitTransitGateway(ctx, svc, cfg.Retry, tgwID)
+}
+
+// The stack's own transit gateway, unless it is going away.
+func findTransitGateway(ctx context.Context, svc EC2API, cfg *Config) (*ec2.TransitGateway, error) {
+	resp, err := svc.DescribeTransitGatewaysWithContext(ctx, &ec2.DescribeTransitGatewaysInput{
+		Filters: []*ec2.Filter{
+			cfg.tagFilter(),
+			{Name: aws.String("state"), Values: aws.StringSlice(liveTGWStates)},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "transit gateways", nil, err)
+	}
+	if len(resp.TransitGateways) == 0 {
+		return nil, nil
+	}
+	return resp.TransitGateways[0], nil
+}
+
+// The VPC's live attachment to the transit gateway, whoever made it.
+func findTGWAttachment(ctx context.Context, svc EC2API, vpcID *string, tgwID *string) (*ec2.TransitGatewayVpcAttachment, error) {
+	resp, err := svc.DescribeTransitGatewayVpcAttachmentsWithContext(ctx, &ec2.DescribeTransitGatewayVpcAttachmentsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("vpc-id"), Values: []*string{vpcID}},
+			{Name: aws.String("transit-gateway-id"), Values: []*string{tgwID}},
+			{Name: aws.String("state"), Values: aws.StringSlice(liveTGWAttachmentStates)},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "transit gateway attachments", vpcID, err)
+	}
+	if len(resp.TransitGatewayVpcAttachments) == 0 {
+		return nil, nil
+	}
+	return resp.TransitGatewayVpcAttachments[0], nil
+}
+
+func waitTransitGateway(ctx context.Context, svc EC2API, retry RetryPolicy, tgwID *string) error {
+	return retry.do(ctx, isPending, func() error {
+		resp, err := svc.DescribeTransitGatewaysWithContext(ctx, &ec2.DescribeTransitGatewaysInput{TransitGatewayIds: []*string{tgwID}})
+		if err != nil {
+			return newError("describe", "transit gateway", tgwID, err)
+		}
+		if len(resp.TransitGateways) == 0 {
+			return errNotFound("wait for", "transit gateway", tgwID, "InvalidTransitGatewayID.NotFound")
+		}
+		switch state := aws.StringValue(resp.TransitGateways[0].State); state {
+		case "available":
+			return nil
+		case "pending", "modifying":
+			return newError("wait for", "transit gateway", tgwID, fmt.Errorf("%w: still %s", errPending, state))
+		default:
+			return newError("attach to", "transit gateway", tgwID, fmt.Errorf("it is %s", state))
+		}
+	})
+}
+
+// Wait for the attachment to be available.  One to another account's transit
+// gateway that doesn't accept attachments automatically waits on its owner.
+func waitTGWAttachment(ctx context.Context, svc EC2API, retry RetryPolicy, attachmentID *string) (*ec2.TransitGatewayVpcAttachment, error) {
+	var attachment *ec2.TransitGatewayVpcAttachment
+	err := retry.do(ctx, isPending, func() error {
+		resp, err := svc.DescribeTransitGatewayVpcAttachmentsWithContext(ctx, &ec2.DescribeTransitGatewayVpcAttachmentsInput{
+			TransitGatewayAttachmentIds: []*string{attachmentID},
+		})
+		if err != nil {
+			return newError("describe", "transit gateway attachment", attachmentID, err)
+		}
+		if len(resp.TransitGatewayVpcAttachments) == 0 {
+			return errNotFound("wait for", "transit gateway attachment", attachmentID, "InvalidTransitGatewayAttachmentID.NotFound")
+		}
+		attachment = resp.TransitGatewayVpcAttachments[0]
+		switch state := aws.StringValue(attachment.State); state {
+		case "available":
+			return nil
+		case "pendingAcceptance":
+			return newError("wait for", "transit gateway attachment", attachmentID, errors.New("the transit gateway's owner has to accept it"))
+		default:
+			if contains(liveTGWAttachmentStates, state) {
+				return newError("wait for", "transit gateway attachment", attachmentID, fmt.Errorf("%w: still %s", errPending, state))
+			}
+			return newError("wait for", "transit gateway attachment", attachmentID, fmt.Errorf("it is %s", state))
+		}
+	})
+	return attachment, err
+}
+
+func waitTGWAttachmentDeleted(ctx context.Context, svc EC2API, retry RetryPolicy, attachmentID *string) error {
+	return retry.do(ctx, isPending, func() error {
+		resp, err := svc.DescribeTransitGatewayVpcAttachmentsWithContext(ctx, &ec2.DescribeTransitGatewayVpcAttachmentsInput{
+			TransitGatewayAttachmentIds: []*string{attachmentID},
+		})
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return newError("describe", "transit gateway attachment", attachmentID, err)
+		}
+		if len(resp.TransitGatewayVpcAttachments) == 0 {
+			return nil
+		}
+		state := aws.StringValue(resp.TransitGatewayVpcAttachments[0].State)
+		if state != "deleted" {
+			return newError("delete", "transit gateway attachment", attachmentID, fmt.Errorf("%w: still %s", errPending, state))
+		}
+		return nil
+	})
+}
+
+func waitTransitGatewayDeleted(ctx context.Context, svc EC2API, retry RetryPolicy, tgwID *string) error {
+	return retry.do(ctx, isPending, func() error {
+		resp, err := svc.DescribeTransitGatewaysWithContext(ctx, &ec2.DescribeTransitGatewaysInput{TransitGatewayIds: []*string{tgwID}})
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return newError("describe", "transit gateway", tgwID, err)
+		}
+		if len(resp.TransitGateways) == 0 {
+			return nil
+		}
+		state := aws.StringValue(resp.TransitGateways[0].State)
+		if state != "deleted" {
+			return newError("delete", "transit gateway", tgwID, fmt.Errorf("%w: still %s", errPending, state))
+		}
+		return nil
+	})
+}
+
+// The attachment's route table association, or nil if it has none.
+func tgwAssociation(ctx context.Context, svc EC2API, attachmentID *string) (*ec2.TransitGatewayAttachmentAssociation, error) {
+	resp, err := svc.DescribeTransitGatewayAttachmentsWithContext(ctx, &ec2.DescribeTransitGatewayAttachmentsInput{
+		TransitGatewayAttachmentIds: []*string{attachmentID},
+	})
+	if err != nil {
+		return nil, newError("describe", "transit gateway attachment", attachmentID, err)
+	}
+	if len(resp.TransitGatewayAttachments) == 0 {
+		return nil, errNotFound("describe", "transit gateway attachment", attachmentID, "InvalidTransitGatewayAttachmentID.NotFound")
+	}
+	return resp.TransitGatewayAttachments[0].Association, nil
+}
+
+// Associate the attachment with the route table, taking it out of the one it
+// is associated with first.  An attachment has only one association.
+func associateTGWRouteTable(ctx context.Context, svc EC2API, retry RetryPolicy, attachmentID *string, rtbID *string) error {
+	assoc, err := tgwAssociation(ctx, svc, attachmentID)
+	if err != nil {
+		return err
+	}
+	if assoc != nil && aws.StringValue(assoc.TransitGatewayRouteTableId) == *rtbID {
+		fmt.Println("Found transit gateway attachment " + *attachmentID + " associated with " + *rtbID)
+		return nil
+	}
+	if assoc != nil {
+		oldID := assoc.TransitGatewayRouteTableId
+		if aws.StringValue(assoc.State) != "disassociating" {
+			params := &ec2.DisassociateTransitGatewayRouteTableInput{TransitGatewayAttachmentId: attachmentID, TransitGatewayRouteTableId: oldID}
+			if _, err := svc.DisassociateTransitGatewayRouteTableWithContext(ctx, params); err != nil {
+				return newError("disassociate "+*oldID+" from", "transit gateway attachment", attachmentID, err)
+			}
+			fmt.Println("Disassociated transit gateway attachment " + *attachmentID + " from " + *oldID)
+		}
+		err := retry.do(ctx, isPending, func() error {
+			assoc, err := tgwAssociation(ctx, svc, attachmentID)
+			if err != nil {
+				return err
+			}
+			if assoc != nil {
+				return newError("disassociate "+*oldID+" from", "transit gateway attachment", attachmentID, fmt.Errorf("%w: still %s", errPending, aws.StringValue(assoc.State)))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	params := &ec2.AssociateTransitGatewayRouteTableInput{TransitGatewayAttachmentId: attachmentID, TransitGatewayRouteTableId: rtbID}
+	if _, err := svc.AssociateTransitGatewayRouteTableWithContext(ctx, params); err != nil {
+		return newError("associate "+*rtbID+" with", "transit gateway attachment", attachmentID, err)
+	}
+	fmt.Println("Associated transit gateway attachment " + *attachmentID + " with " + *rtbID)
+	return nil
+}
+
+// The transit gateway route tables the attachment propagates to.
+func tgwPropagations(ctx context.Context, svc EC2API, attachmentID *string) ([]string, error) {
+	resp, err := svc.GetTransitGatewayAttachmentPropagationsWithContext(ctx, &ec2.GetTransitGatewayAttachmentPropagationsInput{
+		TransitGatewayAttachmentId: attachmentID,
+	})
+	if err != nil {
+		return nil, newError("get propagations of", "transit gateway attachment", attachmentID, err)
+	}
+	var rtbIDs []string
+	for _, p := range resp.TransitGatewayAttachmentPropagations {
+		if aws.StringValue(p.State) != "disabled" {
+			rtbIDs = append(rtbIDs, aws.StringValue(p.TransitGatewayRouteTableId))
+		}
+	}
+	return rtbIDs, nil
+}
+
+// Propagate the attachment's routes to each route table it doesn't yet.
+func propagateTGWRouteTables(ctx context.Context, svc EC2API, attachmentID *string, rtbIDs []string) error {
+	if len(rtbIDs) == 0 {
+		return nil
+	}
+	propagated, err := tgwPropagations(ctx, svc, attachmentID)
+	if err != nil {
+		return err
+	}
+	for _, rtbID := range rtbIDs {
+		if contains(propagated, rtbID) {
+			fmt.Println("Found transit gateway attachment " + *attachmentID + " propagating to " + rtbID)
+			continue
+		}
+		params := &ec2.EnableTransitGatewayRouteTablePropagationInput{
+			TransitGatewayAttachmentId: attachmentID,
+			TransitGatewayRouteTableId: aws.String(rtbID),
+		}
+		if _, err := svc.EnableTransitGatewayRouteTablePropagationWithContext(ctx, params); err != nil {
+			return newError("propagate "+rtbID+" from", "transit gateway attachment", attachmentID, err)
+		}
+		fmt.Println("Propagated transit gateway attachment " + *attachmentID + " to " + rtbID)
+	}
+	return nil
+}
+
+// planTransitGateway adds what createTransitGatewayAttachment would do to
+// plan.  vpcID is nil when the VPC is still to be created.
+func planTransitGateway(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, plan *Plan) error {
+	tg := cfg.TransitGateway
+	var tgwID *string
+	if tg.Create {
+		tgw, err := findTransitGateway(ctx, svc, cfg)
+		if err != nil {
+			return err
+		}
+		if tgw == nil {
+			plan.add("create", "transit gateway", nil, "")
+		} else {
+			tgwID = tgw.TransitGatewayId
+			plan.add("exists", "transit gateway", tgwID, "")
+		}
+	} else {
+		tgwID = aws.String(tg.ID)
+		resp, err := svc.DescribeTransitGatewaysWithContext(ctx, &ec2.DescribeTransitGatewaysInput{TransitGatewayIds: []*string{tgwID}})
+		if err != nil {
+			return newError("describe", "transit gateway", tgwID, err)
+		}
+		if len(resp.TransitGateways) == 0 {
+			return errNotFound("describe", "transit gateway", tgwID, "InvalidTransitGatewayID.NotFound")
+		}
+		plan.add("exists", "transit gateway", tgwID, aws.StringValue(resp.TransitGateways[0].State))
+	}
+	target := "the new transit gateway"
+	if tgwID != nil {
+		target = *tgwID
+	}
+
+	var attachment *ec2.TransitGatewayVpcAttachment
+	if vpcID != nil && tgwID != nil {
+		var err error
+		if attachment, err = findTGWAttachment(ctx, svc, vpcID, tgwID); err != nil {
+			return err
+		}
+	}
+	detail := "to " + target + " in the " + tg.tier(cfg) + " subnets"
+	if attachment == nil {
+		plan.add("create", "transit gateway attachment", nil, detail)
+		if tg.AssociateRouteTable != "" {
+			plan.add("create", "transit gateway association", nil, tg.AssociateRouteTable)
+		}
+		for _, rtbID := range tg.PropagateRouteTables {
+			plan.add("create", "transit gateway propagation", nil, rtbID)
+		}
+		for _, dest := range tg.Destinations {
+			plan.add("create", "route", nil, Route{Destination: dest, Target: target}.String())
+		}
+		return nil
+	}
+
+	attachmentID := attachment.TransitGatewayAttachmentId
+	subnetIDs, pending, err := zoneSubnets(ctx, svc, cfg, vpcID, tg.tier(cfg))
+	if err != nil {
+		return err
+	}
+	missing := aws.StringValueSlice(missingIDs(attachment.SubnetIds, subnetIDs))
+	if pending {
+		missing = append(missing, "the new subnets")
+	}
+	if len(missing) > 0 {
+		plan.add("update", "transit gateway attachment", attachmentID, detail+", add "+strings.Join(missing, ", "))
+	} else {
+		plan.add("exists", "transit gateway attachment", attachmentID, detail)
+	}
+
+	if tg.AssociateRouteTable != "" {
+		assoc, err := tgwAssociation(ctx, svc, attachmentID)
+		if err != nil {
+			return err
+		}
+		switch {
+		case assoc == nil:
+			plan.add("create", "transit gateway association", attachmentID, tg.AssociateRouteTable)
+		case aws.StringValue(assoc.TransitGatewayRouteTableId) != tg.AssociateRouteTable:
+			plan.add("update", "transit gateway association", attachmentID, tg.AssociateRouteTable+", was "+aws.StringValue(assoc.TransitGatewayRouteTableId))
+		default:
+			plan.add("exists", "transit gateway association", attachmentID, tg.AssociateRouteTable)
+		}
+	}
+	if len(tg.PropagateRouteTables) > 0 {
+		propagated, err := tgwPropagations(ctx, svc, attachmentID)
+		if err != nil {
+			return err
+		}
+		for _, rtbID := range tg.PropagateRouteTables {
+			if contains(propagated, rtbID) {
+				plan.add("exists", "transit gateway propagation", attachmentID, rtbID)
+			} else {
+				plan.add("create", "transit gateway propagation", attachmentID, rtbID)
+			}
+		}
+	}
+
+	routeTables, err := stackRouteTables(ctx, svc, cfg, vpcID)
+	if err != nil {
+		return err
+	}
+	for _, rt := range routeTables {
+		for _, dest := range tg.Destinations {
+			route := Route{Destination: dest, Target: target}
+			existing := findRoute(rt, dest)
+			switch {
+			case existing == nil:
+				plan.add("create", "route", rt.RouteTableId, route.String())
+			case routeTarget(existing) != target:
+				plan.add("update", "route", rt.RouteTableId, route.String()+", was to "+routeTarget(existing))
+			default:
+				plan.add("exists", "route", rt.RouteTableId, route.String())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+package awsextra_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/jeremyd/structureag/pkg/awsextra"
+	"github.com/jeremyd/structureag/pkg/awsextra/awsextratest"
+)
+
+// An available transit gateway that isn't part of any stack, with a route
+// table of its own besides the default one.
+func existingTransitGateway(t *testing.T, svc *awsextratest.EC2) (tgwID *string, rtbID *string) {
+	t.Helper()
+	tgw, err := svc.CreateTransitGateway(&ec2.CreateTransitGatewayInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgwID = tgw.TransitGateway.TransitGatewayId
+	if _, err := svc.DescribeTransitGateways(&ec2.DescribeTransitGatewaysInput{TransitGatewayIds: []*string{tgwID}}); err != nil {
+		t.Fatal(err)
+	}
+	rtb, err := svc.CreateTransitGatewayRouteTable(&ec2.CreateTransitGatewayRouteTableInput{TransitGatewayId: tgwID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tgwID, rtb.TransitGatewayRouteTable.TransitGatewayRouteTableId
+}
+
+// The VPC's transit gateway attachments, whatever their state.
+func tgwAttachments(t *testing.T, svc *awsextratest.EC2, vpcID *string) []*ec2.TransitGatewayVpcAttachment {
+	t.Helper()
+	resp, err := svc.DescribeTransitGatewayVpcAttachments(&ec2.DescribeTransitGatewayVpcAttachmentsInput{Filters: []*ec2.Filter{
+		{Name: aws.String("vpc-id"), Values: []*string{vpcID}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.TransitGatewayVpcAttachments
+}
+
+// tgwRoutes returns where each of the stack's route tables sends dest.
+func tgwRoutes(t *testing.T, svc *awsextratest.EC2, dest string) []string {
+	t.Helper()
+	rts, err := svc.DescribeRouteTables(&ec2.DescribeRouteTablesInput{Filters: []*ec2.Filter{
+		{Name: aws.String("tag:MYTAG"), Values: []*string{aws.String("test")}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var targets []string
+	for _, rt := range rts.RouteTables {
+		target := ""
+		for _, r := range rt.Routes {
+			if aws.StringValue(r.DestinationCidrBlock) == dest {
+				target = aws.StringValue(r.TransitGatewayId)
+			}
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+func TestCreateVPCNetworkingTransitGateway(t *testing.T) {
+	cfg := privateConfig(2, awsextra.NATGatewaysSingle)
+	cfg.TransitGateway = &awsextra.TransitGateway{Create: true, Destinations: []string{"10.0.0.0/8"}}
+	svc := awsextratest.NewEC2("us-west-2")
+	state := awsextra.NewState(cfg)
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tgwIDs := inState(state, "transit gateway")
+	if len(tgwIDs) != 1 || svc.Tags(tgwIDs[0])["MYTAG"] != "test" {
+		t.Fatalf("state has transit gateways %v, want one tagged to the stack", tgwIDs)
+	}
+	attachments := tgwAttachments(t, svc, vpcID)
+	if len(attachments) != 1 || *attachments[0].TransitGatewayId != tgwIDs[0] || *attachments[0].State != "available" {
+		t.Fatalf("attachments = %v, want one available to %s", attachments, tgwIDs[0])
+	}
+	if got := subnetCIDRs(t, svc, attachments[0].SubnetIds); len(got) != 2 || got[0] != cfg.PrivateSubnetCIDRs[0] || got[1] != cfg.PrivateSubnetCIDRs[1] {
+		t.Errorf("attachment is in %v, want the private subnets", got)
+	}
+	if got := inState(state, "transit gateway attachment"); len(got) != 1 || got[0] != *attachments[0].TransitGatewayAttachmentId {
+		t.Errorf("state has attachments %v, want %s", got, *attachments[0].TransitGatewayAttachmentId)
+	}
+	// The public and both private route tables.
+	routes := tgwRoutes(t, svc, "10.0.0.0/8")
+	if len(routes) != 3 {
+		t.Fatalf("%d route tables, want 3", len(routes))
+	}
+	for _, target := range routes {
+		if target != tgwIDs[0] {
+			t.Errorf("route to 10.0.0.0/8 goes to %q, want %s", target, tgwIDs[0])
+		}
+	}
+
+	// Running up again finds what is there.
+	before := len(svc.Calls())
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, state); err != nil {
+		t.Fatal(err)
+	}
+	for _, call := range svc.Calls()[before:] {
+		switch call {
+		case "CreateTransitGateway", "CreateTransitGatewayVpcAttachment", "ModifyTransitGatewayVpcAttachment", "CreateRoute", "ReplaceRoute":
+			t.Errorf("second run called %s", call)
+		}
+	}
+
+	// The attachment is deleted, and gone, before its subnets are, so none
+	// of the four subnets is deleted twice.
+	before = len(svc.Calls())
+	if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, cfg); err != nil {
+		t.Fatal(err)
+	}
+	calls := svc.Calls()[before:]
+	if n := count(calls, "DeleteSubnet"); n != 4 {
+		t.Errorf("DeleteSubnet called %d times, want 4", n)
+	}
+	if n := count(calls, "DeleteTransitGateway"); n != 1 {
+		t.Errorf("DeleteTransitGateway called %d times, want 1", n)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left", n)
+	}
+}
+
+func TestCreateVPCNetworkingExistingTransitGateway(t *testing.T) {
+	svc := awsextratest.NewEC2("us-west-2")
+	tgwID, rtbID := existingTransitGateway(t, svc)
+	cfg := testConfig(1)
+	cfg.TransitGateway = &awsextra.TransitGateway{
+		ID:                   *tgwID,
+		AssociateRouteTable:  *rtbID,
+		PropagateRouteTables: []string{*rtbID},
+		Destinations:         []string{"10.0.0.0/8"},
+	}
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachmentID := tgwAttachments(t, svc, vpcID)[0].TransitGatewayAttachmentId
+
+	// The default association gave way to the configured one.
+	resp, err := svc.DescribeTransitGatewayAttachments(&ec2.DescribeTransitGatewayAttachmentsInput{TransitGatewayAttachmentIds: []*string{attachmentID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assoc := resp.TransitGatewayAttachments[0].Association; assoc == nil || *assoc.TransitGatewayRouteTableId != *rtbID || *assoc.State != "associated" {
+		t.Errorf("association = %v, want %s", assoc, *rtbID)
+	}
+	props, err := svc.GetTransitGatewayAttachmentPropagations(&ec2.GetTransitGatewayAttachmentPropagationsInput{TransitGatewayAttachmentId: attachmentID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	propagated := false
+	for _, p := range props.TransitGatewayAttachmentPropagations {
+		propagated = propagated || *p.TransitGatewayRouteTableId == *rtbID
+	}
+	if !propagated {
+		t.Errorf("propagations = %v, want one to %s", props.TransitGatewayAttachmentPropagations, *rtbID)
+	}
+
+	// A subnet in a new zone joins the attachment, and nothing else changes.
+	cfg.SubnetCIDRs = append(cfg.SubnetCIDRs, "172.25.1.0/24")
+	before := len(svc.Calls())
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, call := range svc.Calls()[before:] {
+		switch call {
+		case "CreateTransitGatewayVpcAttachment", "AssociateTransitGatewayRouteTable", "DisassociateTransitGatewayRouteTable", "EnableTransitGatewayRouteTablePropagation":
+			t.Errorf("second run called %s", call)
+		}
+	}
+	if n := count(svc.Calls()[before:], "ModifyTransitGatewayVpcAttachment"); n != 1 {
+		t.Errorf("ModifyTransitGatewayVpcAttachment called %d times, want 1", n)
+	}
+	if got := tgwAttachments(t, svc, vpcID)[0].SubnetIds; len(got) != 2 {
+		t.Errorf("attachment is in %v, want both subnets", aws.StringValueSlice(got))
+	}
+
+	// down leaves the transit gateway alone.
+	if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := *tgwAttachments(t, svc, vpcID)[0].State; got != "deleted" {
+		t.Errorf("attachment is %s, want deleted", got)
+	}
+	if n := svc.ResourceCount(); n != 1 {
+		t.Errorf("%d resources left, want the transit gateway", n)
+	}
+}
+
+// Another stack's attachment keeps the stack's transit gateway.
+func TestDeleteVPCNetworkingSharedTransitGateway(t *testing.T) {
+	cfg := testConfig(1)
+	cfg.TransitGateway = &awsextra.TransitGateway{Create: true}
+	svc := awsextratest.NewEC2("us-west-2")
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	tgws, err := svc.DescribeTransitGateways(&ec2.DescribeTransitGatewaysInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spoke := peerConfig("us-west-2")
+	spoke.TransitGateway = &awsextra.TransitGateway{ID: *tgws.TransitGateways[0].TransitGatewayId}
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, spoke, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, cfg); !errors.Is(err, awsextra.ErrNotInStack) {
+		t.Fatalf("err = %v, want ErrNotInStack", err)
+	}
+	if n := count(svc.Calls(), "DeleteTransitGateway"); n != 0 {
+		t.Errorf("DeleteTransitGateway called %d times, want 0", n)
+	}
+	if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, spoke); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left", n)
+	}
+}
+
+func TestPlanVPCNetworkingTransitGateway(t *testing.T) {
+	cfg := privateConfig(1, awsextra.NATGatewaysSingle)
+	cfg.TransitGateway = &awsextra.TransitGateway{Create: true, Destinations: []string{"10.0.0.0/8"}}
+	svc := awsextratest.NewEC2("us-west-2")
+
+	plan := &awsextra.Plan{}
+	if err := awsextra.PlanVPCNetworking(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	for _, resource := range []string{"transit gateway", "transit gateway attachment"} {
+		if got := actions(plan, resource); len(got) != 1 || got[0] != "create" {
+			t.Errorf("%s actions = %v, want [create]", resource, got)
+		}
+	}
+
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	plan = &awsextra.Plan{}
+	if err := awsextra.PlanVPCNetworking(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	if n := plan.Count("create") + plan.Count("update"); n != 0 {
+		t.Errorf("plan after up has %d changes:\n%s", n, plan)
+	}
+	if got := actions(plan, "transit gateway attachment"); len(got) != 1 || got[0] != "exists" {
+		t.Errorf("transit gateway attachment actions = %v, want [exists]", got)
+	}
+}
+
+func TestDeleteStateResourcesTransitGatewayEmptyDescribe(t *testing.T) {
+	cfg := privateConfig(1, awsextra.NATGatewaysSingle)
+	cfg.TransitGateway = &awsextra.TransitGateway{Create: true, Destinations: []string{"10.0.0.0/8"}}
+	svc := awsextratest.NewEC2("us-west-2")
+	up := upWithState(t, svc, cfg)
+
+	// Both are deleted, then waited on until they describe as gone.
+	state := awsextra.NewState(cfg)
+	for _, r := range up.Resources {
+		if r.Type == "transit gateway" || r.Type == "transit gateway attachment" {
+			state.Resources = append(state.Resources, r)
+		}
+	}
+	if _, err := awsextra.DeleteStateResources(context.Background(), &emptyDescribes{svc}, cfg, state); err != nil {
+		t.Fatalf("DeleteStateResources: %v", err)
+	}
+	if len(state.Resources) != 0 {
+		t.Errorf("state still lists\n%s", state)
+	}
+}
+
+func TestPlanVPCNetworkingTransitGatewayEmptyDescribe(t *testing.T) {
+	cfg := privateConfig(1, awsextra.NATGatewaysSingle)
+	cfg.TransitGateway = &awsextra.TransitGateway{ID: "tgw-gone", Destinations: []string{"10.0.0.0/8"}}
+	svc := awsextratest.NewEC2("us-west-2")
+
+	err := awsextra.PlanVPCNetworking(context.Background(), &emptyDescribes{svc}, cfg, &awsextra.Plan{})
+	var e *awsextra.Error
+	if !errors.As(err, &e) || e.Code != "InvalidTransitGatewayID.NotFound" || e.ID != "tgw-gone" {
+		t.Errorf("err = %v, want the transit gateway not found", err)
+	}
+}
@@ -1,518 +1,531 @@
 package awsextra
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"strconv"
-	"time"
+	"net"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/spf13/viper"
 )
 
 // Up
 
 // Lookup vpc (just to ensure it exists)
-func detectVPC(svc *ec2.EC2) (vpcID *string) {
-	var ourTag = viper.GetString("tagvalue")
+func detectVPC(ctx context.Context, svc EC2API, cfg *Config) (vpcID *string, err error) {
+	vpc, err := findVPC(ctx, svc, cfg)
+	if vpc == nil {
+		return nil, err
+	}
+	return vpc.VpcId, nil
+}
+
+func findVPC(ctx context.Context, svc EC2API, cfg *Config) (*ec2.Vpc, error) {
 	params := &ec2.DescribeVpcsInput{
 		Filters: []*ec2.Filter{
-			{
-				Name: aws.String("tag:" + viper.GetString("tagkey")),
-				Values: []*string{
-					aws.String(ourTag),
-				},
-			},
+			cfg.tagFilter(),
 		},
 	}
-	resp, err := svc.DescribeVpcs(params)
-
-	haltOnError(err, "Error describing VPCs")
+	resp, err := svc.DescribeVpcsWithContext(ctx, params)
+	if err != nil {
+		return nil, newError("describe", "vpcs", nil, err)
+	}
 
 	if len(resp.Vpcs) == 0 {
-		return nil
+		return nil, nil
 	}
 
-	return resp.Vpcs[0].VpcId
+	return resp.Vpcs[0], nil
 }
 
-func vpcCheckConflict(svc *ec2.EC2) bool {
+// Returns the ID of any VPC already using our CIDR block.
+func vpcCheckConflict(ctx context.Context, svc EC2API, cfg *Config) (conflictID *string, err error) {
 	params := &ec2.DescribeVpcsInput{}
-	resp, err := svc.DescribeVpcs(params)
-	haltOnError(err, "Error describing VPCs")
+	resp, err := svc.DescribeVpcsWithContext(ctx, params)
+	if err != nil {
+		return nil, newError("describe", "vpcs", nil, err)
+	}
 	for i := range resp.Vpcs {
-		if *resp.Vpcs[i].CidrBlock == viper.GetString("vpc-cidr-block") {
-			fmt.Println("Error.  Conflicting VPC CIDR block detected.  In use by " + *resp.Vpcs[i].VpcId)
-			return true
+		if *resp.Vpcs[i].CidrBlock == cfg.VPCCIDRBlock {
+			return resp.Vpcs[i].VpcId, nil
 		}
 	}
-	return false
+	return nil, nil
 }
 
-// CreateVPCNetworking ... creates a VPC and all required sub-resources. Or returns existing.
-func CreateVPCNetworking(svc *ec2.EC2) *string {
+// cidrConflict is the error for cidr clashing with the CIDR block of vpcID.
+func cidrConflict(op string, cidr string, vpcID *string) error {
+	return newError(op, "vpc", nil, fmt.Errorf("%w %s in use by %s", ErrCIDRConflict, cidr, *vpcID))
+}
 
-	// If the VPC exists return the existing VPC ID
-	foundVpcID := detectVPC(svc)
-	if foundVpcID != nil {
-		fmt.Println("Found VPC: " + *foundVpcID)
-		return foundVpcID
+// CreateVPCNetworking ... creates a VPC and all required sub-resources.  Every
+// step first looks for what is already there and only creates what is
+// missing, so re-running it repairs a stack a previous run left half built.
+// The resources are recorded in state, which may be nil, as soon as they are
+// known, including when a later step fails.
+func CreateVPCNetworking(ctx context.Context, svc EC2API, cfg *Config, state *State) (*string, error) {
+	vpcID, err := ensureVPC(ctx, svc, cfg)
+	state.record("vpc", vpcID)
+	if err != nil {
+		return vpcID, err
 	}
 
-	// If a VPC already exists with this same CIDR then halt.
-	if vpcCheckConflict(svc) {
-		usedConf := viper.ConfigFileUsed()
-		haltError("Please modify " + usedConf + " config to select a different vpc-cidr-block block and re-run.")
+	if err := ensureDNSAttributes(ctx, svc, cfg, vpcID); err != nil {
+		return vpcID, err
 	}
 
-	// Create the VPC
-	params := &ec2.CreateVpcInput{
-		CidrBlock: aws.String(viper.GetString("vpc-cidr-block")), // Required
+	// Modify VPC for our dhcp options set
+	dhcpOptionsSetID, err := ensureDhcpOptionsSet(ctx, svc, cfg, vpcID)
+	state.record("dhcp options set", dhcpOptionsSetID)
+	state.record("vpc", vpcID, dhcpOptionsSetID)
+	if err != nil {
+		return vpcID, err
 	}
 
-	resp, err := svc.CreateVpc(params)
-
-	haltOnError(err, "Failed to create VPC.")
-	vpcID := resp.Vpc.VpcId
-	fmt.Println("Created VPC: " + *vpcID)
-
-	// Modify VPC for DnsSupport = true
-	paramsModVPC := &ec2.ModifyVpcAttributeInput{
-		VpcId: vpcID, // Required
-		EnableDnsSupport: &ec2.AttributeBooleanValue{
-			Value: aws.Bool(true),
-		},
+	// Dual-stack VPCs get an Amazon provided /56, carved into a /64 per subnet
+	var ipv6 *net.IPNet
+	if cfg.IPv6 {
+		if ipv6, err = ensureVPCIPv6(ctx, svc, cfg, vpcID); err != nil {
+			return vpcID, err
+		}
 	}
 
-	_, pModErr := svc.ModifyVpcAttribute(paramsModVPC)
-
-	haltOnError(pModErr, "Error modifying VPC attributes for DnsSupport")
-
-	// Modify VPC for DnsHostnames = true
-	paramsModVPC2 := &ec2.ModifyVpcAttributeInput{
-		VpcId: vpcID, // Required
-		EnableDnsHostnames: &ec2.AttributeBooleanValue{
-			Value: aws.Bool(true),
-		},
+	// Create subnets
+	subnetIDs, err := createSubnets(ctx, svc, cfg, vpcID, cfg.SubnetCIDRs, true, ipv6)
+	for _, subnetID := range subnetIDs {
+		state.record("subnet", subnetID, vpcID)
 	}
-
-	_, pModErr2 := svc.ModifyVpcAttribute(paramsModVPC2)
-
-	haltOnError(pModErr2, "Error Modifying VPC attribute for DnsHostnames")
-
-	// Modify VPC for new dhcp options set
-	dhcpOptionsSetID := createDhcpOptionsSet(svc)
-	paramsModVPC3 := &ec2.AssociateDhcpOptionsInput{
-		VpcId:         vpcID,            // Required
-		DhcpOptionsId: dhcpOptionsSetID, // Required
+	if err != nil {
+		return vpcID, err
 	}
 
-	_, pModErr3 := svc.AssociateDhcpOptions(paramsModVPC3)
-
-	haltOnError(pModErr3, "error associating dhcp options set with VPC")
+	// Create IGW and attach to VPC
+	IGWID, err := addInternetGatewayToVPC(ctx, svc, cfg, vpcID)
+	state.record("internet gateway", IGWID, vpcID)
+	if err != nil {
+		return vpcID, err
+	}
 
-	// Create Route Table
-	rtParams := &ec2.DescribeRouteTablesInput{
-		Filters: []*ec2.Filter{
-			{
-				Name: aws.String("vpc-id"),
-				Values: []*string{
-					vpcID,
-				},
-			},
-		},
+	// The public subnets get their own route table with the internet route,
+	// so subnets added by hand stay private unless they are associated too.
+	routes := cfg.publicRoutes(*IGWID)
+	if _, err := ensureRouteTable(ctx, svc, cfg, vpcID, RouteTablesPublic, routes, subnetIDs, state); err != nil {
+		return vpcID, err
+	}
+	if err := removeMainInternetRoute(ctx, svc, vpcID, IGWID); err != nil {
+		return vpcID, err
 	}
-	rtResp, rtErr := svc.DescribeRouteTables(rtParams)
 
-	haltOnError(rtErr, "Error creating Route Table")
-	fmt.Println("Created route table: " + *rtResp.RouteTables[0].RouteTableId)
+	// Private subnets reach the internet through NAT gateways
+	if len(cfg.PrivateSubnetCIDRs) > 0 {
+		if err := createPrivateSubnets(ctx, svc, cfg, vpcID, IGWID, subnetIDs, ipv6, state); err != nil {
+			return vpcID, err
+		}
+	}
 
-	// Tag the VPC and route tables
-	tagIt(svc, vpcID, viper.GetString("tagkey"), viper.GetString("tagvalue"))
-	tagIt(svc, rtResp.RouteTables[0].RouteTableId, viper.GetString("tagkey"), viper.GetString("tagvalue"))
+	// Each tier's subnets get its network ACL, or the default one back
+	if err := createNetworkACLs(ctx, svc, cfg, vpcID, state); err != nil {
+		return vpcID, err
+	}
 
-	// Create subnets
-	createSubnets(svc, vpcID)
+	// Endpoints go in the route tables and subnets made above
+	if len(cfg.Endpoints) > 0 {
+		if err := createEndpoints(ctx, svc, cfg, vpcID, state); err != nil {
+			return vpcID, err
+		}
+	}
 
-	// Create IGW and attach to VPC
-	IGWID := addInternetGatewayToVPC(svc, vpcID)
+	// The transit gateway attachment uses the subnets, and its routes go in
+	// the route tables, made above
+	if cfg.TransitGateway != nil {
+		if err := createTransitGatewayAttachment(ctx, svc, cfg, vpcID, state); err != nil {
+			return vpcID, err
+		}
+	}
 
-	// Add route entry to route table for IGW
-	createRouteForIGW(svc, IGWID, rtResp.RouteTables[0].RouteTableId)
+	// So do the VPN's route propagations
+	if cfg.VPN != nil {
+		if err := createVPN(ctx, svc, cfg, vpcID, state); err != nil {
+			return vpcID, err
+		}
+	}
 
-	return vpcID
+	return vpcID, nil
 }
 
-func createDhcpOptionsSet(svc *ec2.EC2) *string {
-	useHostNameSuffix := ""
-	if viper.GetString("region") == "us-east-1" {
-		useHostNameSuffix = "ec2.internal"
-	} else {
-		useHostNameSuffix = ".compute.internal"
+// Find the tagged VPC or create it.  A new VPC is tagged straight away so a
+// later run can find it whatever happens next.
+func ensureVPC(ctx context.Context, svc EC2API, cfg *Config) (*string, error) {
+	foundVpcID, err := detectVPC(ctx, svc, cfg)
+	if err != nil {
+		return nil, err
 	}
-	params := &ec2.CreateDhcpOptionsInput{
-		DhcpConfigurations: []*ec2.NewDhcpConfiguration{
-			{ // Required
-				Key: aws.String("domain-name-servers"),
-				Values: []*string{
-					aws.String("AmazonProvidedDNS"), // Required
-				},
-			},
-			{ // Required
-				Key: aws.String("domain-name"),
-				Values: []*string{
-					aws.String(viper.GetString("region") + useHostNameSuffix), // Required
-				},
-			},
-		},
+	if foundVpcID != nil {
+		fmt.Println("Found VPC: " + *foundVpcID)
+		return foundVpcID, nil
 	}
 
-	resp, err := svc.CreateDhcpOptions(params)
-
-	haltOnError(err, "error creating DHCP Options Set")
-
-	fmt.Println("Created dhcpOptionsSet" + *resp.DhcpOptions.DhcpOptionsId)
-
-	tagIt(svc, resp.DhcpOptions.DhcpOptionsId, viper.GetString("tagkey"), viper.GetString("tagvalue"))
-
-	return resp.DhcpOptions.DhcpOptionsId
-}
-
-func addInternetGatewayToVPC(svc *ec2.EC2, vpcID *string) *string {
-	params := &ec2.CreateInternetGatewayInput{}
-	resp, err := svc.CreateInternetGateway(params)
-
-	haltOnError(err, "Error creating IGW")
-	fmt.Println("Created IGW " + *resp.InternetGateway.InternetGatewayId)
-
-	params2 := &ec2.AttachInternetGatewayInput{
-		InternetGatewayId: resp.InternetGateway.InternetGatewayId, // Required
-		VpcId:             vpcID,                                  // Required
+	// If a VPC already exists with this same CIDR then stop.
+	conflictID, err := vpcCheckConflict(ctx, svc, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if conflictID != nil {
+		return nil, cidrConflict("create", cfg.VPCCIDRBlock, conflictID)
 	}
-	_, err2 := svc.AttachInternetGateway(params2)
-
-	haltOnError(err2, "Error attaching IGW to VPC")
 
-	tagIt(svc, resp.InternetGateway.InternetGatewayId, viper.GetString("tagkey"), viper.GetString("tagvalue"))
-	return resp.InternetGateway.InternetGatewayId
-}
+	// Create the VPC
+	params := &ec2.CreateVpcInput{
+		CidrBlock:                   aws.String(cfg.VPCCIDRBlock), // Required
+		AmazonProvidedIpv6CidrBlock: aws.Bool(cfg.IPv6),
+	}
 
-func createRouteForIGW(svc *ec2.EC2, IGWID *string, routeTableID *string) {
-	params := &ec2.CreateRouteInput{
-		DestinationCidrBlock: aws.String("0.0.0.0/0"), // Required
-		RouteTableId:         routeTableID,            // Required
-		GatewayId:            IGWID,
+	resp, err := svc.CreateVpcWithContext(ctx, params)
+	if err != nil {
+		return nil, newError("create", "vpc", nil, err)
 	}
-	_, err := svc.CreateRoute(params)
+	vpcID := resp.Vpc.VpcId
+	fmt.Println("Created VPC: " + *vpcID)
 
-	haltOnError(err, "Error creating route for IGW.")
-	fmt.Println("Created route table entry for IGW")
+	return vpcID, tagIt(ctx, svc, cfg, "vpc", vpcID, cfg.TagKey, cfg.TagValue)
 }
 
-func createSubnets(svc *ec2.EC2, vpcID *string) {
-	// Get the availability zones list
-	descAZParams := &ec2.DescribeAvailabilityZonesInput{}
-	descAZResp, descAZErr := svc.DescribeAvailabilityZones(descAZParams)
-
-	haltOnError(descAZErr, "Error describing AZs")
-
-	// Create the subnets
-	times, _ := strconv.ParseInt(viper.GetString("num-subnets"), 10, 0)
-	var loop int64
-	for loop = 0; loop < times; loop++ {
-		//useAZIndex := loop % numAZs
-		myCidrBlock := viper.GetString("subnet-" + fmt.Sprintf("%d", loop) + "-cidr")
-		params := &ec2.CreateSubnetInput{
-			CidrBlock:        aws.String(myCidrBlock),
-			VpcId:            vpcID,
-			AvailabilityZone: descAZResp.AvailabilityZones[loop].ZoneName,
+// Set the VPC's DNS attributes that don't match the config.  Hostnames depend
+// on DNS support, so support is turned on first but off last.
+func ensureDNSAttributes(ctx context.Context, svc EC2API, cfg *Config, vpcID *string) error {
+	if cfg.EnableDNSSupport {
+		if err := ensureDNSSupport(ctx, svc, cfg, vpcID); err != nil {
+			return err
 		}
-		resp, err := svc.CreateSubnet(params)
-
-		haltOnError(err, "Error creating subnet.")
-		fmt.Println("Created subnet " + *resp.Subnet.SubnetId)
+	}
 
-		// Set auto-assign public IP on subnet
-		params2 := &ec2.ModifySubnetAttributeInput{
-			SubnetId: resp.Subnet.SubnetId,
-			MapPublicIpOnLaunch: &ec2.AttributeBooleanValue{
-				Value: aws.Bool(true),
+	hostnames, err := vpcAttribute(ctx, svc, vpcID, ec2.VpcAttributeNameEnableDnsHostnames)
+	if err != nil {
+		return err
+	}
+	if hostnames != cfg.EnableDNSHostnames {
+		// Modify VPC for DnsHostnames
+		paramsModVPC2 := &ec2.ModifyVpcAttributeInput{
+			VpcId: vpcID, // Required
+			EnableDnsHostnames: &ec2.AttributeBooleanValue{
+				Value: aws.Bool(cfg.EnableDNSHostnames),
 			},
 		}
-		_, err2 := svc.ModifySubnetAttribute(params2)
 
-		haltOnError(err2, "Error setting auto assign public IP failed for subnet.")
+		_, pModErr2 := svc.ModifyVpcAttributeWithContext(ctx, paramsModVPC2)
+		if pModErr2 != nil {
+			return newError("set DnsHostnames on", "vpc", vpcID, pModErr2)
+		}
+	}
 
-		tagIt(svc, resp.Subnet.SubnetId, viper.GetString("tagkey"), viper.GetString("tagvalue"))
+	if !cfg.EnableDNSSupport {
+		return ensureDNSSupport(ctx, svc, cfg, vpcID)
 	}
+	return nil
 }
 
-//
-// Down
-//
-
-func deleteVPC(svc *ec2.EC2) {
-	// Find the VPC associated with this kube cluster
-	vpcID := detectVPC(svc)
+func ensureDNSSupport(ctx context.Context, svc EC2API, cfg *Config, vpcID *string) error {
+	support, err := vpcAttribute(ctx, svc, vpcID, ec2.VpcAttributeNameEnableDnsSupport)
+	if err != nil {
+		return err
+	}
+	if support != cfg.EnableDNSSupport {
+		// Modify VPC for DnsSupport
+		paramsModVPC := &ec2.ModifyVpcAttributeInput{
+			VpcId: vpcID, // Required
+			EnableDnsSupport: &ec2.AttributeBooleanValue{
+				Value: aws.Bool(cfg.EnableDNSSupport),
+			},
+		}
 
-	if vpcID == nil {
-		fmt.Printf("VPC: not found")
-		return
+		_, pModErr := svc.ModifyVpcAttributeWithContext(ctx, paramsModVPC)
+		if pModErr != nil {
+			return newError("set DnsSupport on", "vpc", vpcID, pModErr)
+		}
 	}
-	fmt.Print("delete VPC: " + *vpcID)
-	deleteVPCRetry(svc, vpcID, 0)
-	fmt.Println()
+	return nil
 }
 
-func deleteVPCRetry(svc *ec2.EC2, vpcID *string, retryCount int64) {
-	params := &ec2.DeleteVpcInput{
-		VpcId: vpcID,
+// Associate our dhcp options set with the VPC, re-using a tagged set left by
+// an earlier run before creating a new one.
+func ensureDhcpOptionsSet(ctx context.Context, svc EC2API, cfg *Config, vpcID *string) (*string, error) {
+	vpc, err := findVPC(ctx, svc, cfg)
+	if err != nil {
+		return nil, err
+	}
+	associated, err := findDhcpOptions(ctx, svc, cfg, vpc)
+	if err != nil {
+		return nil, err
+	}
+	if associated != nil {
+		fmt.Println("Found dhcpOptionsSet " + *associated)
+		return associated, nil
 	}
-	_, err := svc.DeleteVpc(params)
 
-	if awsErr, ok := err.(awserr.Error); ok {
-		if awsErr.Code() == "DependencyViolation" {
-			fmt.Print(".")
-			retryCount++
-			if retryCount > 60 {
-				fmt.Println("retry limit reached for vpc deletion.")
-				return
-			}
-			time.Sleep(time.Second * 5)
-			deleteVPCRetry(svc, vpcID, retryCount)
+	resp, err := svc.DescribeDhcpOptionsWithContext(ctx, &ec2.DescribeDhcpOptionsInput{
+		Filters: []*ec2.Filter{cfg.tagFilter()},
+	})
+	if err != nil {
+		return nil, newError("describe", "dhcp options sets", nil, err)
+	}
+	var dhcpOptionsSetID *string
+	if len(resp.DhcpOptions) > 0 {
+		dhcpOptionsSetID = resp.DhcpOptions[0].DhcpOptionsId
+		fmt.Println("Found dhcpOptionsSet " + *dhcpOptionsSetID)
+	} else {
+		dhcpOptionsSetID, err = createDhcpOptionsSet(ctx, svc, cfg)
+		if err != nil {
+			return nil, err
 		}
 	}
+
+	paramsModVPC3 := &ec2.AssociateDhcpOptionsInput{
+		VpcId:         vpcID,            // Required
+		DhcpOptionsId: dhcpOptionsSetID, // Required
+	}
+
+	_, pModErr3 := svc.AssociateDhcpOptionsWithContext(ctx, paramsModVPC3)
+	if pModErr3 != nil {
+		return dhcpOptionsSetID, newError("associate", "dhcp options set", dhcpOptionsSetID, pModErr3)
+	}
+	return dhcpOptionsSetID, nil
 }
 
-func deleteIGW(svc *ec2.EC2) bool {
-	params := &ec2.DescribeInternetGatewaysInput{
-		Filters: []*ec2.Filter{
-			{
-				Name: aws.String("tag:" + viper.GetString("tagkey")),
+func createDhcpOptionsSet(ctx context.Context, svc EC2API, cfg *Config) (*string, error) {
+	params := &ec2.CreateDhcpOptionsInput{
+		DhcpConfigurations: []*ec2.NewDhcpConfiguration{
+			{ // Required
+				Key:    aws.String("domain-name-servers"),
+				Values: aws.StringSlice(cfg.domainNameServers()), // Required
+			},
+			{ // Required
+				Key: aws.String("domain-name"),
 				Values: []*string{
-					aws.String(viper.GetString("tagvalue")),
+					aws.String(cfg.domainName()), // Required
 				},
 			},
 		},
 	}
 
-	resp, err := svc.DescribeInternetGateways(params)
-	if err != nil || len(resp.InternetGateways) == 0 {
-		fmt.Println("IGW: not found")
-		if err != nil {
-			fmt.Println(err)
-		}
-		return false
-	}
-
-	fmt.Print("delete IGW: " + *resp.InternetGateways[0].InternetGatewayId)
-
-	paramsDetach := &ec2.DetachInternetGatewayInput{
-		InternetGatewayId: resp.InternetGateways[0].InternetGatewayId,
-		VpcId:             resp.InternetGateways[0].Attachments[0].VpcId,
+	resp, err := svc.CreateDhcpOptionsWithContext(ctx, params)
+	if err != nil {
+		return nil, newError("create", "dhcp options set", nil, err)
 	}
 
-	_, errDetach := svc.DetachInternetGateway(paramsDetach)
+	fmt.Println("Created dhcpOptionsSet " + *resp.DhcpOptions.DhcpOptionsId)
 
-	if errDetach != nil {
-		fmt.Println("error detaching IGW from vpc")
-		fmt.Println(errDetach)
-		return false
-	}
+	err = tagIt(ctx, svc, cfg, "dhcp options set", resp.DhcpOptions.DhcpOptionsId, cfg.TagKey, cfg.TagValue)
 
-	deleteIGWRetry(svc, resp.InternetGateways[0].InternetGatewayId, 0)
-	fmt.Println()
-	return true
+	return resp.DhcpOptions.DhcpOptionsId, err
 }
 
-func deleteIGWRetry(svc *ec2.EC2, IGWID *string, retryCount int64) bool {
-	paramsDelete := &ec2.DeleteInternetGatewayInput{
-		InternetGatewayId: IGWID,
+// Return the IGW attached to the VPC.  Otherwise attach a tagged IGW an
+// earlier run created but didn't attach, or a new one.
+func addInternetGatewayToVPC(ctx context.Context, svc EC2API, cfg *Config, vpcID *string) (*string, error) {
+	attached, err := findIGW(ctx, svc, vpcID)
+	if err != nil {
+		return nil, err
 	}
-
-	_, errDelete := svc.DeleteInternetGateway(paramsDelete)
-
-	if errDelete != nil {
-		if awsErr, ok := errDelete.(awserr.Error); ok {
-			if awsErr.Code() == "DependencyViolation" {
-				fmt.Print(".")
-				retryCount++
-				if retryCount > 60 {
-					fmt.Println("retry limit reached for IGW deletion.")
-					return false
-				}
-				time.Sleep(time.Second * 5)
-				deleteIGWRetry(svc, IGWID, retryCount)
-			}
-		} else {
-			fmt.Println(errDelete.Error())
-		}
+	if attached != nil {
+		fmt.Println("Found IGW " + *attached.InternetGatewayId)
+		return attached.InternetGatewayId, tagIt(ctx, svc, cfg, "internet gateway", attached.InternetGatewayId, cfg.TagKey, cfg.TagValue)
 	}
-	return true
-}
 
-func deleteRouteTable(svc *ec2.EC2) bool {
-	params := &ec2.DescribeRouteTablesInput{
-		Filters: []*ec2.Filter{
-			{
-				Name: aws.String("tag:" + viper.GetString("tagkey")),
-				Values: []*string{
-					aws.String(viper.GetString("tagvalue")),
-				},
-			},
-		},
+	var IGWID *string
+	tagged, err := svc.DescribeInternetGatewaysWithContext(ctx, &ec2.DescribeInternetGatewaysInput{
+		Filters: []*ec2.Filter{cfg.tagFilter()},
+	})
+	if err != nil {
+		return nil, newError("describe", "internet gateways", nil, err)
 	}
-
-	resp, err := svc.DescribeRouteTables(params)
-
-	if err != nil || len(resp.RouteTables) == 0 {
-		fmt.Println("Route Table: not found")
+	for _, igw := range tagged.InternetGateways {
+		if len(igw.Attachments) == 0 {
+			IGWID = igw.InternetGatewayId
+			fmt.Println("Found IGW " + *IGWID)
+			break
+		}
+	}
+	if IGWID == nil {
+		params := &ec2.CreateInternetGatewayInput{}
+		resp, err := svc.CreateInternetGatewayWithContext(ctx, params)
 		if err != nil {
-			fmt.Println(err)
+			return nil, newError("create", "internet gateway", nil, err)
+		}
+		IGWID = resp.InternetGateway.InternetGatewayId
+		fmt.Println("Created IGW " + *IGWID)
+		if err := tagIt(ctx, svc, cfg, "internet gateway", IGWID, cfg.TagKey, cfg.TagValue); err != nil {
+			return IGWID, err
 		}
-		return false
 	}
-	fmt.Print("delete Route Table: " + *resp.RouteTables[0].RouteTableId)
-	deleteRouteTableRetry(svc, resp.RouteTables[0].RouteTableId, 0)
-	fmt.Println()
-	return true
-}
 
-func deleteRouteTableRetry(svc *ec2.EC2, routeTableID *string, retryCount int64) {
-	paramsDelete := &ec2.DeleteRouteTableInput{
-		RouteTableId: routeTableID,
+	params2 := &ec2.AttachInternetGatewayInput{
+		InternetGatewayId: IGWID, // Required
+		VpcId:             vpcID, // Required
 	}
-
-	_, errDelete := svc.DeleteRouteTable(paramsDelete)
-
-	if errDelete != nil {
-		if awsErr, ok := errDelete.(awserr.Error); ok {
-			if awsErr.Code() == "DependencyViolation" {
-				fmt.Print(".")
-				retryCount++
-				if retryCount > 60 {
-					fmt.Println("retry limit reached for dhcpOptions deletion.")
-					return
-				}
-				time.Sleep(time.Second * 5)
-				deleteRouteTableRetry(svc, routeTableID, retryCount)
-			}
-		} else {
-			fmt.Println(errDelete)
-		}
+	_, err2 := svc.AttachInternetGatewayWithContext(ctx, params2)
+	if err2 != nil {
+		return IGWID, newError("attach", "internet gateway", IGWID, err2)
 	}
+	return IGWID, nil
 }
 
-func deleteDhcpOptionSet(svc *ec2.EC2) bool {
-	params := &ec2.DescribeDhcpOptionsInput{
-		Filters: []*ec2.Filter{
-			{
-				Name: aws.String("tag:" + viper.GetString("tagkey")),
-				Values: []*string{
-					aws.String(viper.GetString("tagvalue")),
-				},
-			},
-		},
+// The availability zone for each of the subnets, round robin over the zones
+// subnets may go in.
+func subnetZones(ctx context.Context, svc EC2API, cfg *Config, cidrs []string) ([]*string, error) {
+	if len(cidrs) == 0 {
+		return nil, nil
 	}
-
-	resp, err := svc.DescribeDhcpOptions(params)
-
-	if err != nil || len(resp.DhcpOptions) == 0 {
-		fmt.Println("error describing dhcp option sets")
-		fmt.Println(err)
-		return false
+	available, err := usableZones(ctx, svc, cfg)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Print("delete DHCP options set: " + *resp.DhcpOptions[0].DhcpOptionsId)
-	deleteDhcpOptionsRetry(svc, resp.DhcpOptions[0].DhcpOptionsId, 0)
-	fmt.Println()
-	return true
+	zones := make([]*string, len(cidrs))
+	for i := range cidrs {
+		zones[i] = available[i%len(available)].ZoneName
+	}
+	return zones, nil
 }
 
-func deleteDhcpOptionsRetry(svc *ec2.EC2, dhcpOptionsID *string, retryCount int64) {
-	paramsDelete := &ec2.DeleteDhcpOptionsInput{
-		DhcpOptionsId: dhcpOptionsID,
+// The zones subnets may go in: the ones cfg.Zones names, or the first
+// cfg.NumZones, or all, of the region's available zones.  Local and
+// wavelength zones, and zones that are impaired or unavailable, are left
+// out.
+func usableZones(ctx context.Context, svc EC2API, cfg *Config) ([]*ec2.AvailabilityZone, error) {
+	resp, err := svc.DescribeAvailabilityZonesWithContext(ctx, &ec2.DescribeAvailabilityZonesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("state"), Values: []*string{aws.String("available")}},
+			{Name: aws.String("zone-type"), Values: []*string{aws.String("availability-zone")}},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "availability zones", nil, err)
+	}
+	available := resp.AvailabilityZones
+	if len(available) == 0 {
+		return nil, newError("describe", "availability zones", nil, errors.New("no available zones in "+cfg.Region))
 	}
 
-	_, respErr := svc.DeleteDhcpOptions(paramsDelete)
-
-	if respErr != nil {
-		if awsErr, ok := respErr.(awserr.Error); ok {
-			if awsErr.Code() == "DependencyViolation" {
-				fmt.Print(".")
-				retryCount++
-				if retryCount > 60 {
-					fmt.Println("retry limit reached for dhcpOptions deletion.")
-					return
+	if len(cfg.Zones) > 0 {
+		// Zone IDs name the same zone in every account; names don't.
+		var zones []*ec2.AvailabilityZone
+		for _, want := range cfg.Zones {
+			var found *ec2.AvailabilityZone
+			for _, zone := range available {
+				if aws.StringValue(zone.ZoneName) == want || aws.StringValue(zone.ZoneId) == want {
+					found = zone
 				}
-				time.Sleep(time.Second * 5)
-				deleteDhcpOptionsRetry(svc, dhcpOptionsID, retryCount)
 			}
-		} else {
-			fmt.Println(respErr.Error())
+			if found == nil {
+				return nil, newError("describe", "availability zones", nil, fmt.Errorf("%s is not an available zone in %s", want, cfg.Region))
+			}
+			zones = append(zones, found)
+		}
+		return zones, nil
+	}
+	if cfg.NumZones > 0 {
+		if cfg.NumZones > len(available) {
+			return nil, newError("describe", "availability zones", nil, fmt.Errorf("%s has %d available zones, not %d", cfg.Region, len(available), cfg.NumZones))
 		}
+		available = available[:cfg.NumZones]
 	}
+	return available, nil
 }
 
-func deleteSubnet(svc *ec2.EC2, subnetID *string, retryCount int64) bool {
-	params := &ec2.DeleteSubnetInput{
-		SubnetId: subnetID,
+// Create the subnets of a tier the VPC doesn't have yet, and make sure each
+// is tagged, has its /64 of ipv6 unless that is nil and, if public, maps
+// public IPs.  It returns the IDs of the subnets it got to.
+func createSubnets(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, cidrs []string, public bool, ipv6 *net.IPNet) (subnetIDs []*string, err error) {
+	zones, err := subnetZones(ctx, svc, cfg, cidrs)
+	if err != nil {
+		return nil, err
+	}
+	offset := ipv6PrivateOffset
+	if public {
+		offset = ipv6PublicOffset
 	}
 
-	_, err := svc.DeleteSubnet(params)
-
-	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			if awsErr.Code() == "DependencyViolation" {
-				fmt.Print(".")
-				retryCount++
-				if retryCount > 60 {
-					fmt.Println("retry limit reached for subnet deletion.")
-					return false
-				}
-				time.Sleep(time.Second * 5)
-				deleteSubnet(svc, subnetID, retryCount)
-			}
+	for loop, myCidrBlock := range cidrs {
+		subnet, err := findSubnet(ctx, svc, vpcID, myCidrBlock)
+		if err != nil {
+			return subnetIDs, err
+		}
+		if subnet != nil {
+			fmt.Println("Found subnet " + *subnet.SubnetId)
 		} else {
-			fmt.Println(err.Error())
+			params := &ec2.CreateSubnetInput{
+				CidrBlock:        aws.String(myCidrBlock),
+				VpcId:            vpcID,
+				AvailabilityZone: zones[loop],
+			}
+			if ipv6 != nil {
+				params.Ipv6CidrBlock = aws.String(subnetIPv6CIDR(ipv6, offset+loop))
+			}
+			resp, err := svc.CreateSubnetWithContext(ctx, params)
+			if err != nil {
+				return subnetIDs, newError("create", "subnet "+myCidrBlock, nil, err)
+			}
+			subnet = resp.Subnet
+			fmt.Println("Created subnet " + *subnet.SubnetId)
 		}
-	}
+		subnetIDs = append(subnetIDs, subnet.SubnetId)
 
-	return true
-}
+		if err := tagIt(ctx, svc, cfg, "subnet", subnet.SubnetId, cfg.TagKey, cfg.TagValue); err != nil {
+			return subnetIDs, err
+		}
 
-func deleteSubnets(svc *ec2.EC2) bool {
-	params := &ec2.DescribeSubnetsInput{
-		Filters: []*ec2.Filter{
-			{
-				Name: aws.String("tag:" + viper.GetString("tagkey")),
-				Values: []*string{
-					aws.String(viper.GetString("tagvalue")),
-				},
+		if ipv6 != nil {
+			if err := ensureSubnetIPv6(ctx, svc, subnet, subnetIPv6CIDR(ipv6, offset+loop)); err != nil {
+				return subnetIDs, err
+			}
+		}
+
+		// Set auto-assign public IP on subnet
+		if !public || aws.BoolValue(subnet.MapPublicIpOnLaunch) {
+			continue
+		}
+		params2 := &ec2.ModifySubnetAttributeInput{
+			SubnetId: subnet.SubnetId,
+			MapPublicIpOnLaunch: &ec2.AttributeBooleanValue{
+				Value: aws.Bool(true),
 			},
-		},
+		}
+		_, err2 := svc.ModifySubnetAttributeWithContext(ctx, params2)
+		if err2 != nil {
+			return subnetIDs, newError("enable auto assign public IP on", "subnet", subnet.SubnetId, err2)
+		}
 	}
+	return subnetIDs, nil
+}
 
-	resp, err := svc.DescribeSubnets(params)
-
-	haltOnError(err, "Error describing subnets")
+//
+// Down
+//
 
-	allSuccess := true
-	for i := 0; i < len(resp.Subnets); i++ {
-		fmt.Println("delete subnet: " + *resp.Subnets[i].SubnetId)
-		if deleteSubnet(svc, resp.Subnets[i].SubnetId, 0) == false {
-			allSuccess = false
-		}
-	}
-	fmt.Println()
-	return allSuccess
+// DeleteVPCNetworking ... deletes the stack: the tagged VPC with its subnets,
+// route tables, internet gateway, security groups and network interfaces,
+// and the tagged DHCP options set.  Resources are deleted as soon as nothing
+// left depends on them, several at a time.  Anything in the VPC without the
+// stack's tag is left alone, as is everything it depends on; the error then
+// wraps ErrNotInStack for each such resource and the Report lists what was
+// removed and what was blocked.
+func DeleteVPCNetworking(ctx context.Context, svc EC2API, cfg *Config) (*Report, error) {
+	return teardownStack(ctx, svc, cfg, false)
 }
 
-// DeleteVPCNetworking ... Deletes all VPC components.
-func DeleteVPCNetworking(svc *ec2.EC2) bool {
-	deleteIGW(svc)
-	deleteSubnets(svc)
-	deleteVPC(svc)
-	deleteDhcpOptionSet(svc)
-	return true
+func teardownStack(ctx context.Context, svc EC2API, cfg *Config, force bool) (*Report, error) {
+	report := &Report{}
+	g, err := discover(ctx, svc, cfg, force)
+	if err != nil {
+		return report, err
+	}
+	t := &teardown{
+		svc:    svc,
+		retry:  cfg.Retry,
+		report: report,
+		rediscover: func(ctx context.Context) (*graph, error) {
+			return discover(ctx, svc, cfg, force)
+		},
+	}
+	return report, t.run(ctx, g)
 }
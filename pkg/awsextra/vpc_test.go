@@ -0,0 +1,542 @@
+package awsextra_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/jeremyd/structureag/pkg/awsextra"
+	"github.com/jeremyd/structureag/pkg/awsextra/awsextratest"
+)
+
+func testConfig(numSubnets int) *awsextra.Config {
+	cfg := awsextra.NewConfig()
+	cfg.Region = "us-west-2"
+	cfg.VPCCIDRBlock = "172.25.0.0/16"
+	for i := 0; i < numSubnets; i++ {
+		cfg.SubnetCIDRs = append(cfg.SubnetCIDRs, fmt.Sprintf("172.25.%d.0/24", i))
+	}
+	cfg.TagKey = "MYTAG"
+	cfg.TagValue = "test"
+	return cfg
+}
+
+func TestCreateVPCNetworking(t *testing.T) {
+	tests := []struct {
+		name       string
+		numSubnets int
+	}{
+		{"no subnets", 0},
+		{"one subnet", 1},
+		{"subnet per zone", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(tt.numSubnets)
+			svc := awsextratest.NewEC2("us-west-2")
+
+			vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+			if err != nil {
+				t.Fatalf("CreateVPCNetworking: %v", err)
+			}
+			if got := svc.Tags(*vpcID)["MYTAG"]; got != "test" {
+				t.Errorf("vpc tag = %q, want %q", got, "test")
+			}
+			for _, attr := range []string{"enableDnsSupport", "enableDnsHostnames"} {
+				if !svc.VpcAttribute(*vpcID, attr) {
+					t.Errorf("%s not enabled", attr)
+				}
+			}
+
+			subnets, err := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{Filters: []*ec2.Filter{
+				{Name: aws.String("tag:MYTAG"), Values: []*string{aws.String("test")}},
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(subnets.Subnets) != tt.numSubnets {
+				t.Fatalf("got %d subnets, want %d", len(subnets.Subnets), tt.numSubnets)
+			}
+			zones := map[string]bool{}
+			for _, s := range subnets.Subnets {
+				if !aws.BoolValue(s.MapPublicIpOnLaunch) {
+					t.Errorf("subnet %s does not map public IPs", *s.SubnetId)
+				}
+				zones[*s.AvailabilityZone] = true
+			}
+			if len(zones) != tt.numSubnets {
+				t.Errorf("subnets spread over %d zones, want %d", len(zones), tt.numSubnets)
+			}
+
+			vpcs, _ := svc.DescribeVpcs(&ec2.DescribeVpcsInput{VpcIds: []*string{vpcID}})
+			if got := svc.Tags(*vpcs.Vpcs[0].DhcpOptionsId)["MYTAG"]; got != "test" {
+				t.Errorf("dhcp options set tag = %q, want %q", got, "test")
+			}
+
+			checkPublicRoutes(t, svc, vpcID, subnets.Subnets)
+		})
+	}
+}
+
+func TestCreateVPCNetworkingMoreSubnetsThanZones(t *testing.T) {
+	cfg := testConfig(4)
+	svc := awsextratest.NewEC2("us-west-2")
+
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil); err != nil {
+		t.Fatalf("CreateVPCNetworking: %v", err)
+	}
+	subnets, _ := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{})
+	perZone := map[string]int{}
+	for _, s := range subnets.Subnets {
+		perZone[*s.AvailabilityZone]++
+	}
+	if len(subnets.Subnets) != 4 || perZone["us-west-2a"] != 2 || perZone["us-west-2b"] != 1 || perZone["us-west-2c"] != 1 {
+		t.Errorf("subnets per zone = %v, want two in us-west-2a and one in each other zone", perZone)
+	}
+}
+
+func TestCreateVPCNetworkingZones(t *testing.T) {
+	zone := func(name, ID, zoneType, state string) *ec2.AvailabilityZone {
+		return &ec2.AvailabilityZone{
+			RegionName: aws.String("us-west-2"),
+			ZoneName:   aws.String(name),
+			ZoneId:     aws.String(ID),
+			ZoneType:   aws.String(zoneType),
+			State:      aws.String(state),
+		}
+	}
+	// Zone names map to different zone IDs in every account.
+	shuffled := []*ec2.AvailabilityZone{
+		zone("us-west-2a", "usw2-az3", "availability-zone", "available"),
+		zone("us-west-2b", "usw2-az1", "availability-zone", "available"),
+		zone("us-west-2c", "usw2-az2", "availability-zone", "available"),
+		zone("us-west-2-lax-1a", "usw2-lax1-az1", "local-zone", "available"),
+		zone("us-west-2d", "usw2-az4", "availability-zone", "impaired"),
+	}
+	tests := []struct {
+		name    string
+		modify  func(*awsextra.Config)
+		want    []string
+		wantErr bool
+	}{
+		{"all available", func(*awsextra.Config) {}, []string{"us-west-2a", "us-west-2b", "us-west-2c", "us-west-2a"}, false},
+		{"num zones", func(c *awsextra.Config) { c.NumZones = 2 }, []string{"us-west-2a", "us-west-2b", "us-west-2a", "us-west-2b"}, false},
+		{"pinned by id", func(c *awsextra.Config) { c.Zones = []string{"usw2-az1", "usw2-az2"} }, []string{"us-west-2b", "us-west-2c", "us-west-2b", "us-west-2c"}, false},
+		{"pinned by name", func(c *awsextra.Config) { c.Zones = []string{"us-west-2c"} }, []string{"us-west-2c", "us-west-2c", "us-west-2c", "us-west-2c"}, false},
+		{"impaired zone", func(c *awsextra.Config) { c.Zones = []string{"usw2-az4"} }, nil, true},
+		{"local zone", func(c *awsextra.Config) { c.Zones = []string{"us-west-2-lax-1a"} }, nil, true},
+		{"too many zones", func(c *awsextra.Config) { c.NumZones = 4 }, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(4)
+			tt.modify(cfg)
+			svc := awsextratest.NewEC2("us-west-2")
+			svc.SetAvailabilityZones(shuffled...)
+
+			_, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateVPCNetworking() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			subnets, _ := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{})
+			var got []string
+			for _, s := range subnets.Subnets {
+				got = append(got, *s.AvailabilityZone)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("subnets in %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateVPCNetworkingExisting(t *testing.T) {
+	cfg := testConfig(1)
+	svc := awsextratest.NewEC2("us-west-2")
+
+	first, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *first != *second {
+		t.Errorf("second run created %s, want existing %s", *second, *first)
+	}
+}
+
+func TestCreateVPCNetworkingRepair(t *testing.T) {
+	for _, operation := range []string{"ModifyVpcAttribute", "CreateDhcpOptions", "AssociateDhcpOptions", "CreateSubnet", "ModifySubnetAttribute", "AttachInternetGateway", "CreateRoute"} {
+		t.Run(operation, func(t *testing.T) {
+			cfg := testConfig(2)
+			svc := awsextratest.NewEC2("us-west-2")
+			svc.InjectError(operation, awserr.New("RequestLimitExceeded", "slow down", nil))
+			if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil); err == nil {
+				t.Fatalf("first run succeeded, want the injected %s failure", operation)
+			}
+
+			vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+			if err != nil {
+				t.Fatalf("second run: %v", err)
+			}
+			tagged := []*ec2.Filter{{Name: aws.String("tag:MYTAG"), Values: []*string{aws.String("test")}}}
+			vpcs, _ := svc.DescribeVpcs(&ec2.DescribeVpcsInput{})
+			dhcp, _ := svc.DescribeDhcpOptions(&ec2.DescribeDhcpOptionsInput{})
+			subnets, _ := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{Filters: tagged})
+			igws, _ := svc.DescribeInternetGateways(&ec2.DescribeInternetGatewaysInput{Filters: tagged})
+			if len(vpcs.Vpcs) != 1 || len(dhcp.DhcpOptions) != 1 || len(subnets.Subnets) != 2 || len(igws.InternetGateways) != 1 {
+				t.Fatalf("got %d vpcs, %d dhcp options sets, %d subnets, %d IGWs; want 1, 1, 2, 1",
+					len(vpcs.Vpcs), len(dhcp.DhcpOptions), len(subnets.Subnets), len(igws.InternetGateways))
+			}
+			if *vpcs.Vpcs[0].DhcpOptionsId != *dhcp.DhcpOptions[0].DhcpOptionsId {
+				t.Errorf("vpc uses %s, want %s", *vpcs.Vpcs[0].DhcpOptionsId, *dhcp.DhcpOptions[0].DhcpOptionsId)
+			}
+			if len(igws.InternetGateways[0].Attachments) != 1 {
+				t.Errorf("IGW is not attached")
+			}
+			for _, s := range subnets.Subnets {
+				if !aws.BoolValue(s.MapPublicIpOnLaunch) {
+					t.Errorf("subnet %s does not map public IPs", *s.SubnetId)
+				}
+			}
+			for _, attr := range []string{"enableDnsSupport", "enableDnsHostnames"} {
+				if !svc.VpcAttribute(*vpcID, attr) {
+					t.Errorf("%s not enabled", attr)
+				}
+			}
+			checkPublicRoutes(t, svc, vpcID, subnets.Subnets)
+		})
+	}
+}
+
+func TestCreateVPCNetworkingConverged(t *testing.T) {
+	cfg := testConfig(2)
+	svc := awsextratest.NewEC2("us-west-2")
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	before := len(svc.Calls())
+
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, call := range svc.Calls()[before:] {
+		if call != "CreateTags" && !strings.HasPrefix(call, "Describe") {
+			t.Errorf("second run called %s, want only describes and tags", call)
+		}
+	}
+}
+
+func TestCreateVPCNetworkingConflict(t *testing.T) {
+	cfg := testConfig(1)
+	svc := awsextratest.NewEC2("us-west-2")
+	svc.CreateVpc(&ec2.CreateVpcInput{CidrBlock: aws.String("172.25.0.0/16")})
+
+	_, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if !errors.Is(err, awsextra.ErrCIDRConflict) {
+		t.Fatalf("err = %v, want ErrCIDRConflict", err)
+	}
+}
+
+func TestCreateVPCNetworkingErrors(t *testing.T) {
+	tests := []struct {
+		operation string
+		resource  string
+	}{
+		{"CreateVpc", "vpc"},
+		{"CreateDhcpOptions", "dhcp options set"},
+		{"CreateTags", "vpc"},
+		{"CreateSubnet", "subnet 172.25.0.0/24"},
+		{"AttachInternetGateway", "internet gateway"},
+		{"CreateRoute", "route table"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.operation, func(t *testing.T) {
+			cfg := testConfig(1)
+			svc := awsextratest.NewEC2("us-west-2")
+			svc.InjectError(tt.operation, awserr.New("UnauthorizedOperation", "denied", nil))
+
+			_, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+			var e *awsextra.Error
+			if !errors.As(err, &e) {
+				t.Fatalf("err = %v, want *awsextra.Error", err)
+			}
+			if e.Resource != tt.resource || e.Code != "UnauthorizedOperation" {
+				t.Errorf("got resource %q code %q, want %q UnauthorizedOperation", e.Resource, e.Code, tt.resource)
+			}
+		})
+	}
+}
+
+func TestDeleteVPCNetworking(t *testing.T) {
+	tests := []struct {
+		name       string
+		numSubnets int
+		withSG     bool
+	}{
+		{"bare vpc", 0, false},
+		{"subnets", 3, false},
+		{"subnets and security group", 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(tt.numSubnets)
+			svc := awsextratest.NewEC2("us-west-2")
+			vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.withSG {
+				sgID, err := awsextra.CreateSecurityGroup(context.Background(), svc, cfg, "default", vpcID, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := awsextra.AuthorizeSecurityGroupsInternalSSH(context.Background(), svc, cfg, sgID); err != nil {
+					t.Fatal(err)
+				}
+				if err := awsextra.DeleteSecurityGroup(context.Background(), svc, cfg, sgID); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, cfg); err != nil {
+				t.Fatalf("DeleteVPCNetworking: %v", err)
+			}
+			if n := svc.ResourceCount(); n != 0 {
+				t.Errorf("%d resources left after delete", n)
+			}
+		})
+	}
+}
+
+func TestDeleteVPCNetworkingNotInStack(t *testing.T) {
+	cfg := testConfig(2)
+	svc := awsextratest.NewEC2("us-west-2")
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A group and an instance created outside structureag keep the VPC and
+	// the instance's subnet in use.
+	blocker, _ := svc.CreateSecurityGroup(&ec2.CreateSecurityGroupInput{
+		GroupName: aws.String("manual"), Description: aws.String("manual"), VpcId: vpcID,
+	})
+	subnets, _ := svc.DescribeSubnets(&ec2.DescribeSubnetsInput{})
+	instance, _ := svc.RunInstances(&ec2.RunInstancesInput{SubnetId: subnets.Subnets[0].SubnetId})
+	sleeps := 0
+	awsextra.SetSleep(t, func(time.Duration) { sleeps++ })
+
+	report, err := awsextra.DeleteVPCNetworking(context.Background(), svc, cfg)
+	if !errors.Is(err, awsextra.ErrNotInStack) {
+		t.Fatalf("err = %v, want ErrNotInStack", err)
+	}
+	for _, ID := range []string{*blocker.GroupId, *instance.Instances[0].InstanceId} {
+		if !strings.Contains(err.Error(), ID) {
+			t.Errorf("err = %v, want it to name %s", err, ID)
+		}
+	}
+	if sleeps != 0 {
+		t.Errorf("slept %d times, want no retries on a known blocker", sleeps)
+	}
+
+	removed := map[string]bool{}
+	for _, r := range report.Removed {
+		removed[r.ID] = true
+	}
+	if !removed[*subnets.Subnets[1].SubnetId] {
+		t.Errorf("unused subnet %s not removed\n%s", *subnets.Subnets[1].SubnetId, report)
+	}
+	blocked := map[string]bool{}
+	for _, b := range report.Blocked {
+		blocked[b.ID] = true
+	}
+	if !blocked[*vpcID] || !blocked[*subnets.Subnets[0].SubnetId] {
+		t.Errorf("report does not list the vpc and the instance's subnet as blocked\n%s", report)
+	}
+}
+
+func TestDeleteVPCNetworkingDependencyViolation(t *testing.T) {
+	cfg := testConfig(1)
+	svc := awsextratest.NewEC2("us-west-2")
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	// EC2 still counts the deleted subnet for a few retries.
+	violation := awserr.New("DependencyViolation", "has dependencies", nil)
+	svc.InjectError("DeleteVpc", violation)
+	sleeps := 0
+	awsextra.SetSleep(t, func(time.Duration) {
+		sleeps++
+		if sleeps < 3 {
+			svc.InjectError("DeleteVpc", violation)
+		}
+	})
+
+	if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, cfg); err != nil {
+		t.Fatalf("DeleteVPCNetworking: %v", err)
+	}
+	if sleeps != 3 {
+		t.Errorf("retried %d times, want 3", sleeps)
+	}
+}
+
+// lateBlocker creates a security group in the VPC just before the first
+// DeleteVpc, after the teardown has looked at the stack.
+type lateBlocker struct {
+	*awsextratest.EC2
+	group *string
+}
+
+func (l *lateBlocker) DeleteVpcWithContext(ctx aws.Context, in *ec2.DeleteVpcInput, opts ...request.Option) (*ec2.DeleteVpcOutput, error) {
+	if l.group == nil {
+		resp, err := l.EC2.CreateSecurityGroup(&ec2.CreateSecurityGroupInput{
+			GroupName: aws.String("late"), Description: aws.String("late"), VpcId: in.VpcId,
+		})
+		if err != nil {
+			return nil, err
+		}
+		l.group = resp.GroupId
+	}
+	return l.EC2.DeleteVpcWithContext(ctx, in, opts...)
+}
+
+func TestDeleteVPCNetworkingLateBlocker(t *testing.T) {
+	cfg := testConfig(1)
+	svc := &lateBlocker{EC2: awsextratest.NewEC2("us-west-2")}
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sleeps := 0
+	awsextra.SetSleep(t, func(time.Duration) { sleeps++ })
+
+	_, err = awsextra.DeleteVPCNetworking(context.Background(), svc, cfg)
+	var e *awsextra.Error
+	if !errors.Is(err, awsextra.ErrBlocked) || !errors.As(err, &e) || e.ID != *vpcID {
+		t.Fatalf("err = %v, want vpc %s blocked", err, *vpcID)
+	}
+	if !strings.Contains(err.Error(), *svc.group) {
+		t.Errorf("err = %v, want it to name %s", err, *svc.group)
+	}
+	if sleeps != 0 {
+		t.Errorf("retried %d times, want none while a blocker remains", sleeps)
+	}
+}
+
+func TestDeleteVPCNetworkingNothingToDelete(t *testing.T) {
+	cfg := testConfig(0)
+	svc := awsextratest.NewEC2("us-west-2")
+	if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, cfg); err != nil {
+		t.Fatalf("DeleteVPCNetworking: %v", err)
+	}
+}
+
+func TestTwoStacksInOneProcess(t *testing.T) {
+	svc := awsextratest.NewEC2("us-west-2")
+	demo := testConfig(1)
+	staging := testConfig(1)
+	staging.TagValue = "staging"
+	staging.VPCCIDRBlock = "172.26.0.0/16"
+	staging.SubnetCIDRs = []string{"172.26.0.0/24"}
+
+	demoID, err := awsextra.CreateVPCNetworking(context.Background(), svc, demo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stagingID, err := awsextra.CreateVPCNetworking(context.Background(), svc, staging, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *demoID == *stagingID {
+		t.Fatalf("both stacks share VPC %s", *demoID)
+	}
+
+	if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, demo); err != nil {
+		t.Fatal(err)
+	}
+	vpcs, _ := svc.DescribeVpcs(&ec2.DescribeVpcsInput{})
+	if len(vpcs.Vpcs) != 1 || *vpcs.Vpcs[0].VpcId != *stagingID {
+		t.Errorf("deleting demo left %v, want only staging %s", vpcs.Vpcs, *stagingID)
+	}
+}
+
+func TestConfigDNS(t *testing.T) {
+	cfg := testConfig(0)
+	cfg.EnableDNSHostnames = false
+	svc := awsextratest.NewEC2("us-west-2")
+
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !svc.VpcAttribute(*vpcID, "enableDnsSupport") || svc.VpcAttribute(*vpcID, "enableDnsHostnames") {
+		t.Errorf("DNS attributes do not match config")
+	}
+
+	// Both go on, and back off, in whichever order EC2 allows.
+	for _, on := range []bool{true, false} {
+		cfg.EnableDNSSupport, cfg.EnableDNSHostnames = on, on
+		if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil); err != nil {
+			t.Fatal(err)
+		}
+		if svc.VpcAttribute(*vpcID, "enableDnsSupport") != on || svc.VpcAttribute(*vpcID, "enableDnsHostnames") != on {
+			t.Errorf("DNS attributes do not match config with both %v", on)
+		}
+	}
+}
+
+// checkPublicRoutes checks the public route table sends 0.0.0.0/0 to the
+// internet gateway and is associated with the subnets, and the main route
+// table has no internet route.
+func checkPublicRoutes(t *testing.T, svc *awsextratest.EC2, vpcID *string, subnets []*ec2.Subnet) {
+	t.Helper()
+	rts, _ := svc.DescribeRouteTables(&ec2.DescribeRouteTablesInput{Filters: []*ec2.Filter{
+		{Name: aws.String("vpc-id"), Values: []*string{vpcID}},
+		{Name: aws.String("tag:for"), Values: []*string{aws.String("public")}},
+	}})
+	if len(rts.RouteTables) != 1 {
+		t.Fatalf("%d public route tables, want 1", len(rts.RouteTables))
+	}
+	public := rts.RouteTables[0]
+	if !hasRoute(public, "0.0.0.0/0") {
+		t.Errorf("public route table has no default route to the IGW")
+	}
+	for _, subnet := range subnets {
+		associated := false
+		for _, assoc := range public.Associations {
+			associated = associated || aws.StringValue(assoc.SubnetId) == *subnet.SubnetId
+		}
+		if !associated {
+			t.Errorf("subnet %s is not associated with the public route table", *subnet.SubnetId)
+		}
+	}
+
+	rts, _ = svc.DescribeRouteTables(&ec2.DescribeRouteTablesInput{Filters: []*ec2.Filter{
+		{Name: aws.String("vpc-id"), Values: []*string{vpcID}},
+		{Name: aws.String("association.main"), Values: []*string{aws.String("true")}},
+	}})
+	if hasRoute(rts.RouteTables[0], "0.0.0.0/0") {
+		t.Errorf("main route table has an internet route")
+	}
+}
+
+func hasRoute(rt *ec2.RouteTable, dest string) bool {
+	for _, r := range rt.Routes {
+		if aws.StringValue(r.DestinationCidrBlock) == dest {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,576 @@
+package awsextra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// VPN connects the stack's VPC to a remote network, eg. an office, over a
+// site-to-site VPN: a virtual private gateway attached to the VPC, a
+// customer gateway standing for the remote router and the VPN connection
+// between them, all tagged as part of the stack.
+type VPN struct {
+	// The public IPv4 address of the remote router, eg. "203.0.113.12".
+	CustomerGatewayIP string
+
+	// The remote router's BGP ASN.  If 0, 65000 is used, which is also what
+	// a router using static routes is given.
+	BGPASN int64
+
+	// The remote network's IPv4 CIDR blocks.  If any are given the connection
+	// uses static routes to them, else the routes are learnt over BGP.
+	StaticRoutes []string
+
+	// The ASN of the virtual private gateway's side of the BGP sessions.  If
+	// 0, Amazon's default is used.
+	AmazonSideASN int64
+
+	// The file the tunnel configuration for the remote router is written to,
+	// if any.  It holds the tunnels' pre-shared keys.
+	ConfigFile string
+}
+
+// The BGP ASN a customer gateway gets when none is configured.
+const defaultCustomerGatewayASN = 65000
+
+// The states of a virtual private gateway, customer gateway or VPN
+// connection that is or will be usable.
+var liveVPNStates = []string{"pending", "available"}
+
+func (v *VPN) bgpASN() int64 {
+	if v.BGPASN == 0 {
+		return defaultCustomerGatewayASN
+	}
+	return v.BGPASN
+}
+
+func (v *VPN) staticRoutesOnly() bool {
+	return len(v.StaticRoutes) > 0
+}
+
+func (v *VPN) validate() error {
+	if v.CustomerGatewayIP == "" {
+		return errors.New("customer-gateway-ip: is required")
+	}
+	if ip := net.ParseIP(v.CustomerGatewayIP); ip == nil || ip.To4() == nil {
+		return fmt.Errorf("customer-gateway-ip: %q is not an IPv4 address", v.CustomerGatewayIP)
+	}
+	if v.BGPASN < 0 || v.BGPASN > 4294967294 {
+		return fmt.Errorf("bgp-asn: %d is not between 1 and 4294967294", v.BGPASN)
+	}
+	// Amazon's side takes a private ASN.
+	if asn := v.AmazonSideASN; asn != 0 && !(asn >= 64512 && asn <= 65534) && !(asn >= 4200000000 && asn <= 4294967294) {
+		return fmt.Errorf("amazon-side-asn: %d is not a private ASN, 64512-65534 or 4200000000-4294967294", asn)
+	}
+	for _, cidr := range v.StaticRoutes {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("static-routes: %v", err)
+		}
+		if ip.To4() == nil {
+			return fmt.Errorf("static-routes: %s is not an IPv4 CIDR block", cidr)
+		}
+	}
+	return nil
+}
+
+// createVPN attaches a virtual private gateway to the VPC and connects it to
+// the customer gateway, creating whichever of them isn't there yet, adds the
+// static routes to the connection, propagates the remote network's routes to
+// the stack's route tables and writes the tunnel configuration out.
+func createVPN(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, state *State) error {
+	vpn := cfg.VPN
+	vgwID, err := ensureVPNGateway(ctx, svc, cfg, vpcID, state)
+	if err != nil {
+		return err
+	}
+	cgwID, err := ensureCustomerGateway(ctx, svc, cfg, state)
+	if err != nil {
+		return err
+	}
+
+	conn, err := findVPNConnection(ctx, svc, cfg, vgwID, cgwID)
+	if err != nil {
+		return err
+	}
+	if conn != nil {
+		fmt.Println("Found VPN connection " + *conn.VpnConnectionId)
+	} else {
+		resp, err := svc.CreateVpnConnectionWithContext(ctx, &ec2.CreateVpnConnectionInput{
+			Type:              aws.String("ipsec.1"), // Required
+			CustomerGatewayId: cgwID,                 // Required
+			VpnGatewayId:      vgwID,
+			Options:           &ec2.VpnConnectionOptionsSpecification{StaticRoutesOnly: aws.Bool(vpn.staticRoutesOnly())},
+		})
+		if err != nil {
+			return newError("create", "vpn connection", nil, err)
+		}
+		conn = resp.VpnConnection
+		// The tunnels take a few minutes to come up, which needn't hold up
+		// the rest of the stack.
+		fmt.Println("Created VPN connection " + *conn.VpnConnectionId + ", its tunnels come up in a few minutes")
+	}
+	connID := conn.VpnConnectionId
+	state.record("vpn connection", connID, vgwID, cgwID)
+	if err := tagIt(ctx, svc, cfg, "vpn connection", connID, cfg.TagKey, cfg.TagValue); err != nil {
+		return err
+	}
+
+	for _, cidr := range vpn.StaticRoutes {
+		if hasVPNRoute(conn, cidr) {
+			continue
+		}
+		params := &ec2.CreateVpnConnectionRouteInput{VpnConnectionId: connID, DestinationCidrBlock: aws.String(cidr)}
+		if _, err := svc.CreateVpnConnectionRouteWithContext(ctx, params); err != nil {
+			return newError("add route "+cidr+" to", "vpn connection", connID, err)
+		}
+		fmt.Println("Added route " + cidr + " to VPN connection " + *connID)
+	}
+
+	// Route propagation brings the static routes, or the ones learnt over
+	// BGP, into the route tables.
+	routeTables, err := stackRouteTables(ctx, svc, cfg, vpcID)
+	if err != nil {
+		return err
+	}
+	for _, rt := range routeTables {
+		if propagates(rt, *vgwID) {
+			continue
+		}
+		params := &ec2.EnableVgwRoutePropagationInput{GatewayId: vgwID, RouteTableId: rt.RouteTableId}
+		if _, err := svc.EnableVgwRoutePropagationWithContext(ctx, params); err != nil {
+			return newError("propagate "+*vgwID+" routes to", "route table", rt.RouteTableId, err)
+		}
+		fmt.Println("Propagated routes from " + *vgwID + " to route table " + *rt.RouteTableId)
+	}
+
+	if vpn.ConfigFile == "" {
+		return nil
+	}
+	if err := os.WriteFile(vpn.ConfigFile, []byte(aws.StringValue(conn.CustomerGatewayConfiguration)), 0600); err != nil {
+		return newError("write the tunnel configuration of", "vpn connection", connID, err)
+	}
+	fmt.Println("Wrote the tunnel configuration of " + *connID + " to " + vpn.ConfigFile)
+	return nil
+}
+
+// The stack's virtual private gateway, created if need be, once it is
+// attached to the VPC.
+func ensureVPNGateway(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, state *State) (*string, error) {
+	vgw, err := findVPNGateway(ctx, svc, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if vgw != nil {
+		fmt.Println("Found virtual private gateway " + *vgw.VpnGatewayId)
+	} else {
+		params := &ec2.CreateVpnGatewayInput{Type: aws.String("ipsec.1")} // Required
+		if cfg.VPN.AmazonSideASN != 0 {
+			params.AmazonSideAsn = aws.Int64(cfg.VPN.AmazonSideASN)
+		}
+		resp, err := svc.CreateVpnGatewayWithContext(ctx, params)
+		if err != nil {
+			return nil, newError("create", "vpn gateway", nil, err)
+		}
+		vgw = resp.VpnGateway
+		fmt.Println("Created virtual private gateway " + *vgw.VpnGatewayId)
+	}
+	vgwID := vgw.VpnGatewayId
+	state.record("vpn gateway", vgwID, vpcID)
+	if err := tagIt(ctx, svc, cfg, "vpn gateway", vgwID, cfg.TagKey, cfg.TagValue); err != nil {
+		return vgwID, err
+	}
+	if vgw, err = waitVPNGateway(ctx, svc, cfg.Retry, vgwID); err != nil {
+		return vgwID, err
+	}
+
+	switch attachedTo := vgwAttachment(vgw); attachedTo {
+	case *vpcID:
+		fmt.Println("Found virtual private gateway " + *vgwID + " attached to " + *vpcID)
+		return vgwID, waitVPNGatewayAttached(ctx, svc, cfg.Retry, vgwID, vpcID)
+	case "":
+	default:
+		return vgwID, newError("attach", "vpn gateway", vgwID, fmt.Errorf("it is attached to %s", attachedTo))
+	}
+	params := &ec2.AttachVpnGatewayInput{VpnGatewayId: vgwID, VpcId: vpcID}
+	if _, err := svc.AttachVpnGatewayWithContext(ctx, params); err != nil {
+		return vgwID, newError("attach", "vpn gateway", vgwID, err)
+	}
+	fmt.Println("Attached virtual private gateway " + *vgwID + " to " + *vpcID)
+	return vgwID, waitVPNGatewayAttached(ctx, svc, cfg.Retry, vgwID, vpcID)
+}
+
+// The stack's customer gateway for the configured address and ASN, created
+// if need be, once it is available.
+func ensureCustomerGateway(ctx context.Context, svc EC2API, cfg *Config, state *State) (*string, error) {
+	cgw, err := findCustomerGateway(ctx, svc, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cgw != nil {
+		fmt.Println("Found customer gateway " + *cgw.CustomerGatewayId + " for " + cfg.VPN.CustomerGatewayIP)
+	} else {
+		resp, err := svc.CreateCustomerGatewayWithContext(ctx, &ec2.CreateCustomerGatewayInput{
+			Type:     aws.String("ipsec.1"),       // Required
+			BgpAsn:   aws.Int64(cfg.VPN.bgpASN()), // Required
+			PublicIp: aws.String(cfg.VPN.CustomerGatewayIP),
+		})
+		if err != nil {
+			return nil, newError("create", "customer gateway", nil, err)
+		}
+		cgw = resp.CustomerGateway
+		fmt.Println("Created customer gateway " + *cgw.CustomerGatewayId + " for " + cfg.VPN.CustomerGatewayIP)
+	}
+	cgwID := cgw.CustomerGatewayId
+	state.record("customer gateway", cgwID)
+	if err := tagIt(ctx, svc, cfg, "customer gateway", cgwID, cfg.TagKey, cfg.TagValue); err != nil {
+		return cgwID, err
+	}
+	return cgwID, waitCustomerGateway(ctx, svc, cfg.Retry, cgwID)
+}
+
+// The stack's virtual private gateway, unless it is going away.
+func findVPNGateway(ctx context.Context, svc EC2API, cfg *Config) (*ec2.VpnGateway, error) {
+	resp, err := svc.DescribeVpnGatewaysWithContext(ctx, &ec2.DescribeVpnGatewaysInput{
+		Filters: []*ec2.Filter{
+			cfg.tagFilter(),
+			{Name: aws.String("state"), Values: aws.StringSlice(liveVPNStates)},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "vpn gateways", nil, err)
+	}
+	if len(resp.VpnGateways) == 0 {
+		return nil, nil
+	}
+	return resp.VpnGateways[0], nil
+}
+
+// The stack's customer gateway with the configured address and ASN.  One
+// for an old address is left for `down`.
+func findCustomerGateway(ctx context.Context, svc EC2API, cfg *Config) (*ec2.CustomerGateway, error) {
+	resp, err := svc.DescribeCustomerGatewaysWithContext(ctx, &ec2.DescribeCustomerGatewaysInput{
+		Filters: []*ec2.Filter{
+			cfg.tagFilter(),
+			{Name: aws.String("ip-address"), Values: []*string{aws.String(cfg.VPN.CustomerGatewayIP)}},
+			{Name: aws.String("bgp-asn"), Values: []*string{aws.String(strconv.FormatInt(cfg.VPN.bgpASN(), 10))}},
+			{Name: aws.String("state"), Values: aws.StringSlice(liveVPNStates)},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "customer gateways", nil, err)
+	}
+	if len(resp.CustomerGateways) == 0 {
+		return nil, nil
+	}
+	return resp.CustomerGateways[0], nil
+}
+
+// The stack's live VPN connection between the two gateways.
+func findVPNConnection(ctx context.Context, svc EC2API, cfg *Config, vgwID *string, cgwID *string) (*ec2.VpnConnection, error) {
+	resp, err := svc.DescribeVpnConnectionsWithContext(ctx, &ec2.DescribeVpnConnectionsInput{
+		Filters: []*ec2.Filter{
+			cfg.tagFilter(),
+			{Name: aws.String("vpn-gateway-id"), Values: []*string{vgwID}},
+			{Name: aws.String("customer-gateway-id"), Values: []*string{cgwID}},
+			{Name: aws.String("state"), Values: aws.StringSlice(liveVPNStates)},
+		},
+	})
+	if err != nil {
+		return nil, newError("describe", "vpn connections", nil, err)
+	}
+	if len(resp.VpnConnections) == 0 {
+		return nil, nil
+	}
+	return resp.VpnConnections[0], nil
+}
+
+// The VPC the virtual private gateway is attached, or being attached, to.
+func vgwAttachment(vgw *ec2.VpnGateway) string {
+	for _, attachment := range vgw.VpcAttachments {
+		switch aws.StringValue(attachment.State) {
+		case "attaching", "attached":
+			return aws.StringValue(attachment.VpcId)
+		}
+	}
+	return ""
+}
+
+func hasVPNRoute(conn *ec2.VpnConnection, cidr string) bool {
+	for _, r := range conn.Routes {
+		if aws.StringValue(r.DestinationCidrBlock) == cidr && contains(liveVPNStates, aws.StringValue(r.State)) {
+			return true
+		}
+	}
+	return false
+}
+
+func propagates(rt *ec2.RouteTable, vgwID string) bool {
+	for _, p := range rt.PropagatingVgws {
+		if aws.StringValue(p.GatewayId) == vgwID {
+			return true
+		}
+	}
+	return false
+}
+
+func waitVPNGateway(ctx context.Context, svc EC2API, retry RetryPolicy, vgwID *string) (*ec2.VpnGateway, error) {
+	var vgw *ec2.VpnGateway
+	err := retry.do(ctx, isPending, func() error {
+		resp, err := svc.DescribeVpnGatewaysWithContext(ctx, &ec2.DescribeVpnGatewaysInput{VpnGatewayIds: []*string{vgwID}})
+		if err != nil {
+			return newError("describe", "vpn gateway", vgwID, err)
+		}
+		if len(resp.VpnGateways) == 0 {
+			return errNotFound("wait for", "vpn gateway", vgwID, "InvalidVpnGatewayID.NotFound")
+		}
+		vgw = resp.VpnGateways[0]
+		switch state := aws.StringValue(vgw.State); state {
+		case "available":
+			return nil
+		case "pending":
+			return newError("wait for", "vpn gateway", vgwID, fmt.Errorf("%w: still %s", errPending, state))
+		default:
+			return newError("wait for", "vpn gateway", vgwID, fmt.Errorf("it is %s", state))
+		}
+	})
+	return vgw, err
+}
+
+func waitVPNGatewayAttached(ctx context.Context, svc EC2API, retry RetryPolicy, vgwID *string, vpcID *string) error {
+	return retry.do(ctx, isPending, func() error {
+		resp, err := svc.DescribeVpnGatewaysWithContext(ctx, &ec2.DescribeVpnGatewaysInput{VpnGatewayIds: []*string{vgwID}})
+		if err != nil {
+			return newError("describe", "vpn gateway", vgwID, err)
+		}
+		if len(resp.VpnGateways) == 0 {
+			return errNotFound("attach", "vpn gateway", vgwID, "InvalidVpnGatewayID.NotFound")
+		}
+		for _, attachment := range resp.VpnGateways[0].VpcAttachments {
+			if aws.StringValue(attachment.VpcId) != *vpcID {
+				continue
+			}
+			switch state := aws.StringValue(attachment.State); state {
+			case "attached":
+				return nil
+			case "attaching":
+				return newError("attach", "vpn gateway", vgwID, fmt.Errorf("%w: still %s", errPending, state))
+			}
+		}
+		return newError("attach", "vpn gateway", vgwID, fmt.Errorf("it is not attached to %s", *vpcID))
+	})
+}
+
+func waitVPNGatewayDetached(ctx context.Context, svc EC2API, retry RetryPolicy, vgwID *string) error {
+	return retry.do(ctx, isPending, func() error {
+		resp, err := svc.DescribeVpnGatewaysWithContext(ctx, &ec2.DescribeVpnGatewaysInput{VpnGatewayIds: []*string{vgwID}})
+		if err != nil {
+			return newError("describe", "vpn gateway", vgwID, err)
+		}
+		if len(resp.VpnGateways) == 0 {
+			return nil
+		}
+		for _, attachment := range resp.VpnGateways[0].VpcAttachments {
+			if state := aws.StringValue(attachment.State); state != "detached" {
+				return newError("detach", "vpn gateway", vgwID, fmt.Errorf("%w: still %s", errPending, state))
+			}
+		}
+		return nil
+	})
+}
+
+func waitCustomerGateway(ctx context.Context, svc EC2API, retry RetryPolicy, cgwID *string) error {
+	return retry.do(ctx, isPending, func() error {
+		resp, err := svc.DescribeCustomerGatewaysWithContext(ctx, &ec2.DescribeCustomerGatewaysInput{CustomerGatewayIds: []*string{cgwID}})
+		if err != nil {
+			return newError("describe", "customer gateway", cgwID, err)
+		}
+		if len(resp.CustomerGateways) == 0 {
+			return errNotFound("wait for", "customer gateway", cgwID, "InvalidCustomerGatewayID.NotFound")
+		}
+		switch state := aws.StringValue(resp.CustomerGateways[0].State); state {
+		case "available":
+			return nil
+		case "pending":
+			return newError("wait for", "customer gateway", cgwID, fmt.Errorf("%w: still %s", errPending, state))
+		default:
+			return newError("wait for", "customer gateway", cgwID, fmt.Errorf("it is %s", state))
+		}
+	})
+}
+
+func waitVPNGatewayDeleted(ctx context.Context, svc EC2API, retry RetryPolicy, vgwID *string) error {
+	return retry.do(ctx, isPending, func() error {
+		resp, err := svc.DescribeVpnGatewaysWithContext(ctx, &ec2.DescribeVpnGatewaysInput{VpnGatewayIds: []*string{vgwID}})
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return newError("describe", "vpn gateway", vgwID, err)
+		}
+		if len(resp.VpnGateways) == 0 {
+			return nil
+		}
+		state := aws.StringValue(resp.VpnGateways[0].State)
+		if state != "deleted" {
+			return newError("delete", "vpn gateway", vgwID, fmt.Errorf("%w: still %s", errPending, state))
+		}
+		return nil
+	})
+}
+
+func waitCustomerGatewayDeleted(ctx context.Context, svc EC2API, retry RetryPolicy, cgwID *string) error {
+	return retry.do(ctx, isPending, func() error {
+		resp, err := svc.DescribeCustomerGatewaysWithContext(ctx, &ec2.DescribeCustomerGatewaysInput{CustomerGatewayIds: []*string{cgwID}})
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return newError("describe", "customer gateway", cgwID, err)
+		}
+		if len(resp.CustomerGateways) == 0 {
+			return nil
+		}
+		state := aws.StringValue(resp.CustomerGateways[0].State)
+		if state != "deleted" {
+			return newError("delete", "customer gateway", cgwID, fmt.Errorf("%w: still %s", errPending, state))
+		}
+		return nil
+	})
+}
+
+// The gateways can't go until the connection is deleted, which takes a few
+// minutes.
+func waitVPNConnectionDeleted(ctx context.Context, svc EC2API, retry RetryPolicy, connID *string) error {
+	return retry.do(ctx, isPending, func() error {
+		resp, err := svc.DescribeVpnConnectionsWithContext(ctx, &ec2.DescribeVpnConnectionsInput{VpnConnectionIds: []*string{connID}})
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return newError("describe", "vpn connection", connID, err)
+		}
+		if len(resp.VpnConnections) == 0 {
+			return nil
+		}
+		state := aws.StringValue(resp.VpnConnections[0].State)
+		if state != "deleted" {
+			return newError("delete", "vpn connection", connID, fmt.Errorf("%w: still %s", errPending, state))
+		}
+		return nil
+	})
+}
+
+// deleteVPNGateway detaches the virtual private gateway from its VPC, which
+// takes its propagated routes out of the VPC's route tables, and deletes it.
+func deleteVPNGateway(ctx context.Context, svc EC2API, retry RetryPolicy, vgwID *string) error {
+	resp, err := svc.DescribeVpnGatewaysWithContext(ctx, &ec2.DescribeVpnGatewaysInput{VpnGatewayIds: []*string{vgwID}})
+	if err != nil {
+		return newError("describe", "vpn gateway", vgwID, err)
+	}
+	if len(resp.VpnGateways) == 0 {
+		return errGone
+	}
+	if vpcID := vgwAttachment(resp.VpnGateways[0]); vpcID != "" {
+		params := &ec2.DetachVpnGatewayInput{VpnGatewayId: vgwID, VpcId: aws.String(vpcID)}
+		if _, err := svc.DetachVpnGatewayWithContext(ctx, params); err != nil {
+			return newError("detach", "vpn gateway", vgwID, err)
+		}
+	}
+	if err := waitVPNGatewayDetached(ctx, svc, retry, vgwID); err != nil {
+		return err
+	}
+	if _, err := svc.DeleteVpnGatewayWithContext(ctx, &ec2.DeleteVpnGatewayInput{VpnGatewayId: vgwID}); err != nil {
+		return newError("delete", "vpn gateway", vgwID, err)
+	}
+	return waitVPNGatewayDeleted(ctx, svc, retry, vgwID)
+}
+
+// planVPN adds what createVPN would do to plan.  vpcID is nil when the VPC is
+// still to be created.
+func planVPN(ctx context.Context, svc EC2API, cfg *Config, vpcID *string, plan *Plan) error {
+	vpn := cfg.VPN
+	vgw, err := findVPNGateway(ctx, svc, cfg)
+	if err != nil {
+		return err
+	}
+	vpc := "the VPC"
+	if vpcID != nil {
+		vpc = *vpcID
+	}
+	var vgwID *string
+	switch {
+	case vgw == nil:
+		plan.add("create", "vpn gateway", nil, "attached to "+vpc)
+	case vpcID == nil || vgwAttachment(vgw) != *vpcID:
+		vgwID = vgw.VpnGatewayId
+		plan.add("update", "vpn gateway", vgwID, "attach to "+vpc)
+	default:
+		vgwID = vgw.VpnGatewayId
+		plan.add("exists", "vpn gateway", vgwID, "attached to "+vpc)
+	}
+
+	cgw, err := findCustomerGateway(ctx, svc, cfg)
+	if err != nil {
+		return err
+	}
+	detail := fmt.Sprintf("%s, BGP ASN %d", vpn.CustomerGatewayIP, vpn.bgpASN())
+	var cgwID *string
+	if cgw == nil {
+		plan.add("create", "customer gateway", nil, detail)
+	} else {
+		cgwID = cgw.CustomerGatewayId
+		plan.add("exists", "customer gateway", cgwID, detail)
+	}
+
+	var conn *ec2.VpnConnection
+	if vgwID != nil && cgwID != nil {
+		if conn, err = findVPNConnection(ctx, svc, cfg, vgwID, cgwID); err != nil {
+			return err
+		}
+	}
+	detail = "with BGP routing"
+	if vpn.staticRoutesOnly() {
+		detail = "with static routes to " + strings.Join(vpn.StaticRoutes, ", ")
+	}
+	if conn == nil {
+		plan.add("create", "vpn connection", nil, detail)
+	} else {
+		var missing []string
+		for _, cidr := range vpn.StaticRoutes {
+			if !hasVPNRoute(conn, cidr) {
+				missing = append(missing, cidr)
+			}
+		}
+		if len(missing) > 0 {
+			plan.add("update", "vpn connection", conn.VpnConnectionId, detail+", add "+strings.Join(missing, ", "))
+		} else {
+			plan.add("exists", "vpn connection", conn.VpnConnectionId, detail)
+		}
+	}
+
+	if vpcID == nil || vgwID == nil {
+		plan.add("create", "route propagation", nil, "from the virtual private gateway to the stack's route tables")
+		return nil
+	}
+	routeTables, err := stackRouteTables(ctx, svc, cfg, vpcID)
+	if err != nil {
+		return err
+	}
+	for _, rt := range routeTables {
+		if propagates(rt, *vgwID) {
+			plan.add("exists", "route propagation", rt.RouteTableId, "from "+*vgwID)
+		} else {
+			plan.add("create", "route propagation", rt.RouteTableId, "from "+*vgwID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package awsextra_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/jeremyd/structureag/pkg/awsextra"
+	"github.com/jeremyd/structureag/pkg/awsextra/awsextratest"
+)
+
+func vpnConfig(t *testing.T) *awsextra.Config {
+	cfg := privateConfig(2, awsextra.NATGatewaysSingle)
+	cfg.VPN = &awsextra.VPN{
+		CustomerGatewayIP: "203.0.113.12",
+		StaticRoutes:      []string{"192.168.0.0/16"},
+		ConfigFile:        filepath.Join(t.TempDir(), "vpn.xml"),
+	}
+	return cfg
+}
+
+func vpnConnection(t *testing.T, svc *awsextratest.EC2, connID string) *ec2.VpnConnection {
+	t.Helper()
+	resp, err := svc.DescribeVpnConnections(&ec2.DescribeVpnConnectionsInput{VpnConnectionIds: []*string{aws.String(connID)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.VpnConnections[0]
+}
+
+func TestCreateVPCNetworkingVPN(t *testing.T) {
+	cfg := vpnConfig(t)
+	svc := awsextratest.NewEC2("us-west-2")
+	state := awsextra.NewState(cfg)
+	vpcID, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, resource := range []string{"vpn gateway", "customer gateway", "vpn connection"} {
+		IDs := inState(state, resource)
+		if len(IDs) != 1 || svc.Tags(IDs[0])["MYTAG"] != "test" {
+			t.Fatalf("state has %s %v, want one tagged to the stack", resource, IDs)
+		}
+	}
+	vgwID := inState(state, "vpn gateway")[0]
+	vgws, err := svc.DescribeVpnGateways(&ec2.DescribeVpnGatewaysInput{VpnGatewayIds: []*string{aws.String(vgwID)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attached := false
+	for _, a := range vgws.VpnGateways[0].VpcAttachments {
+		attached = attached || *a.VpcId == *vpcID && *a.State == "attached"
+	}
+	if !attached {
+		t.Errorf("attachments = %v, want attached to %s", vgws.VpnGateways[0].VpcAttachments, *vpcID)
+	}
+
+	conn := vpnConnection(t, svc, inState(state, "vpn connection")[0])
+	if *conn.VpnGatewayId != vgwID || !aws.BoolValue(conn.Options.StaticRoutesOnly) {
+		t.Errorf("connection = %v, want static routes to %s", conn, vgwID)
+	}
+	if len(conn.Routes) != 1 || *conn.Routes[0].DestinationCidrBlock != "192.168.0.0/16" {
+		t.Errorf("connection routes = %v, want 192.168.0.0/16", conn.Routes)
+	}
+
+	// The public and both private route tables learn the VPN's routes.
+	rts, err := svc.DescribeRouteTables(&ec2.DescribeRouteTablesInput{Filters: []*ec2.Filter{
+		{Name: aws.String("tag:MYTAG"), Values: []*string{aws.String("test")}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rts.RouteTables) != 3 {
+		t.Fatalf("%d route tables, want 3", len(rts.RouteTables))
+	}
+	for _, rt := range rts.RouteTables {
+		if len(rt.PropagatingVgws) != 1 || *rt.PropagatingVgws[0].GatewayId != vgwID {
+			t.Errorf("%s propagates %v, want %s", *rt.RouteTableId, rt.PropagatingVgws, vgwID)
+		}
+	}
+
+	info, err := os.Stat(cfg.VPN.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("config file mode = %v, want 0600", info.Mode().Perm())
+	}
+	if b, _ := os.ReadFile(cfg.VPN.ConfigFile); !strings.Contains(string(b), "pre_shared_key") {
+		t.Errorf("config file lacks the pre-shared keys:\n%s", b)
+	}
+
+	// Running up again finds what is there.
+	before := len(svc.Calls())
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, state); err != nil {
+		t.Fatal(err)
+	}
+	for _, call := range svc.Calls()[before:] {
+		switch call {
+		case "CreateVpnGateway", "AttachVpnGateway", "CreateCustomerGateway", "CreateVpnConnection", "CreateVpnConnectionRoute", "EnableVgwRoutePropagation":
+			t.Errorf("second run called %s", call)
+		}
+	}
+
+	// The connection goes first, and the gateway is detached before the VPC goes.
+	before = len(svc.Calls())
+	if _, err := awsextra.DeleteVPCNetworking(context.Background(), svc, cfg); err != nil {
+		t.Fatal(err)
+	}
+	calls := svc.Calls()[before:]
+	index := func(op string) int {
+		for i, call := range calls {
+			if call == op {
+				return i
+			}
+		}
+		t.Fatalf("%s not called", op)
+		return -1
+	}
+	if i := index("DeleteVpnConnection"); i > index("DeleteVpnGateway") || i > index("DeleteCustomerGateway") {
+		t.Errorf("DeleteVpnConnection called after the gateways were deleted: %v", calls)
+	}
+	if index("DetachVpnGateway") > index("DeleteVpc") {
+		t.Errorf("DetachVpnGateway called after DeleteVpc: %v", calls)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left", n)
+	}
+}
+
+func TestDeleteStateResourcesVPNOverBGP(t *testing.T) {
+	cfg := testConfig(1)
+	cfg.VPN = &awsextra.VPN{CustomerGatewayIP: "203.0.113.12", BGPASN: 65010, AmazonSideASN: 64600}
+	svc := awsextratest.NewEC2("us-west-2")
+	state := upWithState(t, svc, cfg)
+
+	conn := vpnConnection(t, svc, inState(state, "vpn connection")[0])
+	if aws.BoolValue(conn.Options.StaticRoutesOnly) {
+		t.Errorf("connection uses static routes, want BGP")
+	}
+	cgws, err := svc.DescribeCustomerGateways(&ec2.DescribeCustomerGatewaysInput{CustomerGatewayIds: []*string{conn.CustomerGatewayId}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := *cgws.CustomerGateways[0].BgpAsn; got != "65010" {
+		t.Errorf("customer gateway ASN = %s, want 65010", got)
+	}
+
+	if _, err := awsextra.DeleteStateResources(context.Background(), svc, cfg, state); err != nil {
+		t.Fatalf("DeleteStateResources: %v", err)
+	}
+	if n := svc.ResourceCount(); n != 0 {
+		t.Errorf("%d resources left after delete", n)
+	}
+	if len(state.Resources) != 0 {
+		t.Errorf("state still lists\n%s", state)
+	}
+}
+
+func TestDeleteStateResourcesVPNEmptyDescribe(t *testing.T) {
+	cfg := vpnConfig(t)
+	svc := awsextratest.NewEC2("us-west-2")
+	up := upWithState(t, svc, cfg)
+
+	// The connection and customer gateway are deleted and then waited on;
+	// the virtual private gateway is described first and so found gone.
+	state := awsextra.NewState(cfg)
+	for _, r := range up.Resources {
+		switch r.Type {
+		case "vpn connection", "vpn gateway", "customer gateway":
+			state.Resources = append(state.Resources, r)
+		}
+	}
+	if _, err := awsextra.DeleteStateResources(context.Background(), &emptyDescribes{svc}, cfg, state); err != nil {
+		t.Fatalf("DeleteStateResources: %v", err)
+	}
+	if len(state.Resources) != 0 {
+		t.Errorf("state still lists\n%s", state)
+	}
+}
+
+func TestPlanVPCNetworkingVPN(t *testing.T) {
+	cfg := vpnConfig(t)
+	svc := awsextratest.NewEC2("us-west-2")
+
+	plan := &awsextra.Plan{}
+	if err := awsextra.PlanVPCNetworking(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	for _, resource := range []string{"vpn gateway", "customer gateway", "vpn connection"} {
+		if got := actions(plan, resource); len(got) != 1 || got[0] != "create" {
+			t.Errorf("%s actions = %v, want [create]", resource, got)
+		}
+	}
+
+	if _, err := awsextra.CreateVPCNetworking(context.Background(), svc, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	plan = &awsextra.Plan{}
+	before := len(svc.Calls())
+	if err := awsextra.PlanVPCNetworking(context.Background(), svc, cfg, plan); err != nil {
+		t.Fatal(err)
+	}
+	assertDescribeOnly(t, svc.Calls()[before:])
+	if n := plan.Count("create") + plan.Count("update"); n != 0 {
+		t.Errorf("plan after up has %d changes:\n%s", n, plan)
+	}
+}
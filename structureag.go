@@ -1,28 +1,53 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/jeremyd/structureag/pkg/awsextra"
 	"github.com/spf13/viper"
 )
 
 func main() {
 	// Command line flags (non-VIPER)
-	var action = flag.String("action", "", "Action can be: init, launch-minion")
+	var action = flag.String("action", "", "Action can be: plan, up, down, delete, state, peer, unpeer")
+	var dryRun = flag.Bool("dry-run", false, "With -action=down, only list what would be deleted")
+	var stateFile = flag.String("state-file", "./structureag.state.json", "File recording the resources up created")
+	var noRollback = flag.Bool("no-rollback", false, "With -action=up, leave what a failed run created, and the routes it changed, for debugging instead of undoing them")
+	var noPrune = flag.Bool("no-prune", false, "With -action=up or plan, only report security group rules that aren't in the config instead of revoking them")
+	var peerConfig = flag.String("peer-config", "", "With -action=peer or unpeer, the config file of the stack to peer with")
+	var peerDNS = flag.Bool("peer-dns", false, "With -action=peer, let each VPC resolve the other's public DNS hostnames to private addresses")
 	flag.Parse()
 	switch *action {
+	case "plan":
 	case "up":
 	case "down":
 	case "delete":
+	case "state":
+		switch flag.Arg(0) {
+		case "", "show", "refresh", "reconcile":
+		default:
+			fmt.Println("Usage:  structureag -action=state [show|refresh|reconcile]")
+			os.Exit(1)
+		}
+	case "peer", "unpeer":
+		if *peerConfig == "" {
+			fmt.Println("Usage:  structureag -action=" + *action + " -peer-config=<PEER CONFIG FILE> [-peer-dns]")
+			os.Exit(1)
+		}
 	default:
-		fmt.Println("Usage:  structureag -action=<ACTION>  Please specify an action: up, down, delete.")
+		fmt.Println("Usage:  structureag -action=<ACTION>  Please specify an action: plan, up, down, delete, state, peer, unpeer.")
 		os.Exit(1)
 	}
 
@@ -33,32 +58,348 @@ func main() {
 	viper.AutomaticEnv()
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	err := viper.ReadInConfig() // Find and read the config file
-	if err != nil {             // Handle errors reading the config file
-		panic(fmt.Errorf("Fatal error config file: %s \n", err))
-	}
+	halt(err, "Fatal error reading the config file.")
+
+	cfg, err := loadConfig(viper.GetViper())
+	halt(err, "Please fix "+viper.ConfigFileUsed()+" and re-run.")
+	cfg.NoPrune = *noPrune
 
-	svc := ec2.New(session.New(), &aws.Config{Region: aws.String(viper.GetString("region"))})
+	sess := newSession(cfg.Region, viper.GetString("profile"))
+	svc := ec2.New(sess)
+	iamSvc := iam.New(sess)
+	logsSvc := cloudwatchlogs.New(sess)
 	//elbSvc := elb.New(session.New(), &aws.Config{Region: aws.String(viper.GetString("region"))})
 
+	// Ctrl-C or SIGTERM cancels ctx so no new calls are made, and what was
+	// done so far is still recorded.  A second one quits at once.
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		signal.Stop(interrupt)
+		fmt.Fprintln(os.Stderr, "\nInterrupted, stopping once the calls in flight return.  Interrupt again to quit at once.")
+		cancel()
+	}()
+
+	// Resources recorded by earlier runs of up, nil if there are none.
+	state, err := awsextra.LoadState(*stateFile, cfg)
+	halt(err, "Failed to read the state file.")
+
+	if *action == "plan" {
+		plan := &awsextra.Plan{}
+		halt(awsextra.PlanVPCNetworking(ctx, svc, cfg, plan), "Failed to plan VPC networking.")
+		halt(awsextra.PlanSecurityGroups(ctx, svc, cfg, plan), "Failed to plan security groups.")
+		halt(awsextra.PlanFlowLog(ctx, svc, iamSvc, logsSvc, cfg, plan), "Failed to plan flow log.")
+		fmt.Print(plan)
+	}
+
+	if *action == "down" && *dryRun {
+		plan := &awsextra.Plan{}
+		if state != nil {
+			halt(awsextra.PlanDeleteState(state, plan), "Failed to plan deletion from the state file.")
+		} else {
+			halt(awsextra.PlanDeleteVPCNetworking(ctx, svc, cfg, plan), "Failed to plan VPC networking deletion.")
+		}
+		halt(awsextra.PlanDeleteFlowLogResources(ctx, iamSvc, logsSvc, cfg, plan), "Failed to plan flow log role and log group deletion.")
+		fmt.Print(plan)
+		return
+	}
+
 	if *action == "up" {
+		if state == nil {
+			state = awsextra.NewState(cfg)
+		}
+
+		// Everything up creates or changes goes through tx, so a failed run
+		// can undo it unless -no-rollback.
+		tx := awsextra.NewTransaction(svc)
+		fail := func(err error, message string) {
+			if err == nil {
+				return
+			}
+			haltInterrupted(ctx, err, *stateFile)
+			if !*noRollback {
+				fmt.Println("Rolling back the resources this run created.")
+				report, rollbackErr := tx.Rollback(ctx, cfg, state)
+				fmt.Print(report)
+				saveState(state, *stateFile)
+				halt(rollbackErr, "Failed to roll back, run -action=down to delete what is left.")
+			}
+			halt(err, message)
+		}
 
 		// Create VPC
-		vpcID := awsextra.CreateVPCNetworking(svc)
+		vpcID, err := awsextra.CreateVPCNetworking(ctx, tx, cfg, state)
+		saveState(state, *stateFile)
+		if errors.Is(err, awsextra.ErrCIDRConflict) {
+			halt(err, "Please modify "+viper.ConfigFileUsed()+" config to select a different vpc-cidr-block block and re-run.")
+		}
+		fail(err, "Failed to create VPC networking.")
 
 		// Create SSH key
 		//awsextra.createSSHKey(svc)
 
 		// Create Security Groups
-		securityGroupID := awsextra.CreateSecurityGroup(svc, "default", vpcID)
-		awsextra.AuthorizeSecurityGroupsInternalSSH(svc, securityGroupID)
+		err = awsextra.CreateSecurityGroups(ctx, tx, cfg, vpcID, state)
+		saveState(state, *stateFile)
+		fail(err, "Failed to create security groups.")
+
+		// Flow logs, with their log group and IAM role if asked for
+		err = awsextra.CreateFlowLog(ctx, tx, tx.IAM(iamSvc), tx.Logs(logsSvc), cfg, vpcID, state)
+		saveState(state, *stateFile)
+		fail(err, "Failed to create flow log.")
 
 	}
 
-	if *action == "down" {
-		securityGroupID := awsextra.GetSecurityGroup(svc, "default")
-		awsextra.DeleteSecurityGroup(svc, securityGroupID)
+	if *action == "down" && state != nil {
+		// Delete exactly what up recorded
+		report, err := awsextra.DeleteStateResources(ctx, svc, cfg, state)
+		fmt.Print(report)
+		saveState(state, *stateFile)
+		halt(err, "Failed to delete the resources in "+*stateFile+", re-run to continue.")
+	} else if *action == "down" {
+		// Delete the VPC and all sub resources
+		report, err := awsextra.DeleteVPCNetworking(ctx, svc, cfg)
+		fmt.Print(report)
+		halt(err, "Failed to delete VPC networking.")
+	}
+	if *action == "delete" {
+		// Forced teardown: instances, network interfaces, security groups and then the VPC
+		report, err := awsextra.ForceDeleteVPCNetworking(ctx, svc, cfg)
+		fmt.Print(report)
+		if state != nil {
+			_, refreshErr := awsextra.RefreshState(ctx, svc, state)
+			halt(refreshErr, "Failed to refresh the state file.")
+			saveState(state, *stateFile)
+		}
+		halt(err, "Failed to delete the stack, re-run to continue.")
+	}
 
-		// Delete VPC and all sub resources
-		awsextra.DeleteVPCNetworking(svc)
+	// The flow log's role and log group go once the VPC and its flow log are
+	// gone; a failed teardown has halted above.
+	if *action == "down" || *action == "delete" {
+		halt(awsextra.DeleteFlowLogResources(ctx, iamSvc, logsSvc, cfg), "Failed to delete the flow log role and log group.")
+	}
+
+	if *action == "peer" || *action == "unpeer" {
+		// The peer stack's config comes from its own file only; the
+		// STRUCTURE_ environment is for this stack.
+		peerViper := viper.New()
+		peerViper.SetConfigFile(*peerConfig)
+		halt(peerViper.ReadInConfig(), "Fatal error reading the peer config file.")
+		peerCfg, err := loadConfig(peerViper)
+		halt(err, "Please fix "+*peerConfig+" and re-run.")
+		peerSvc := ec2.New(newSession(peerCfg.Region, peerViper.GetString("profile")))
+
+		if state == nil {
+			state = awsextra.NewState(cfg)
+		}
+		if *action == "peer" {
+			pcxID, err := awsextra.PeerVPCs(ctx, svc, cfg, peerSvc, peerCfg, *peerDNS, state)
+			saveState(state, *stateFile)
+			if errors.Is(err, awsextra.ErrCIDRConflict) {
+				halt(err, "The vpc-cidr-blocks of the two stacks overlap, so they can't be peered.")
+			}
+			halt(err, "Failed to peer with the stack in "+*peerConfig+", re-run to continue.")
+			fmt.Println("Peered with " + peerCfg.TagKey + "=" + peerCfg.TagValue + " over " + *pcxID)
+		} else {
+			err := awsextra.UnpeerVPCs(ctx, svc, cfg, peerSvc, peerCfg, state)
+			saveState(state, *stateFile)
+			halt(err, "Failed to unpeer from the stack in "+*peerConfig+", re-run to continue.")
+		}
+	}
+
+	if *action == "state" {
+		if state == nil {
+			state = awsextra.NewState(cfg)
+		}
+		switch flag.Arg(0) {
+		case "refresh", "reconcile":
+			gone, err := awsextra.RefreshState(ctx, svc, state)
+			halt(err, "Failed to refresh the state file.")
+			for _, r := range gone {
+				fmt.Println("gone: " + r.Type + " " + r.ID)
+			}
+			if flag.Arg(0) == "reconcile" {
+				added, err := awsextra.ReconcileState(ctx, svc, cfg, state)
+				halt(err, "Failed to reconcile the state file with tagged resources.")
+				for _, r := range added {
+					fmt.Println("added: " + r.Type + " " + r.ID)
+				}
+			}
+			saveState(state, *stateFile)
+		}
+		fmt.Print(state)
+	}
+}
+
+// Write the state file, or remove it once nothing is left in it.
+func saveState(state *awsextra.State, path string) {
+	if len(state.Resources) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			halt(err, "Failed to remove the state file.")
+		}
+		return
+	}
+	halt(state.Save(path), "Failed to write the state file.")
+}
+
+// Build a stack's Config from v: the config file and, for the stack being
+// worked on, the STRUCTURE_ environment.
+func loadConfig(v *viper.Viper) (*awsextra.Config, error) {
+	v.SetDefault("enable-dns-support", true)
+	v.SetDefault("enable-dns-hostnames", true)
+	v.SetDefault("vpn-config-file", "./structureag.vpn.xml")
+
+	cfg := awsextra.NewConfig()
+	cfg.Region = v.GetString("region")
+	cfg.VPCCIDRBlock = v.GetString("vpc-cidr-block")
+	for i := 0; i < v.GetInt("num-subnets"); i++ {
+		cfg.SubnetCIDRs = append(cfg.SubnetCIDRs, v.GetString(fmt.Sprintf("subnet-%d-cidr", i)))
+	}
+	for i := 0; i < v.GetInt("num-private-subnets"); i++ {
+		cfg.PrivateSubnetCIDRs = append(cfg.PrivateSubnetCIDRs, v.GetString(fmt.Sprintf("private-subnet-%d-cidr", i)))
+	}
+	cfg.NATGateways = v.GetString("nat-gateways")
+	cfg.Zones = v.GetStringSlice("zones")
+	cfg.NumZones = v.GetInt("num-zones")
+	cfg.IPv6 = v.GetBool("ipv6")
+	cfg.SubnetPrefixLength = v.GetInt("subnet-prefix-length")
+	cfg.SubnetHosts = v.GetInt("subnet-hosts")
+	for i := 0; i < v.GetInt("num-routes"); i++ {
+		cfg.Routes = append(cfg.Routes, awsextra.Route{
+			Destination: v.GetString(fmt.Sprintf("route-%d-destination", i)),
+			Target:      v.GetString(fmt.Sprintf("route-%d-target", i)),
+			Tables:      v.GetString(fmt.Sprintf("route-%d-tables", i)),
+		})
+	}
+	// Network ACL rules are a [[nacls]] table each, in order.
+	var nacls []struct {
+		Tier     string
+		Egress   bool
+		Action   string
+		Protocol string
+		FromPort int64 `mapstructure:"from-port"`
+		ToPort   int64 `mapstructure:"to-port"`
+		CIDR     string
+	}
+	if err := v.UnmarshalKey("nacls", &nacls); err != nil {
+		return cfg, fmt.Errorf("nacls: %v", err)
+	}
+	for _, rule := range nacls {
+		cfg.NetworkACLRules = append(cfg.NetworkACLRules, awsextra.NetworkACLRule(rule))
+	}
+	// Security groups are a [[security-groups]] table each, with their rules
+	// in [[security-groups.ingress]] and [[security-groups.egress]] tables.
+	type securityGroupRule struct {
+		Protocol   string
+		FromPort   int64 `mapstructure:"from-port"`
+		ToPort     int64 `mapstructure:"to-port"`
+		CIDR       string
+		IPv6CIDR   string `mapstructure:"ipv6-cidr"`
+		PrefixList string `mapstructure:"prefix-list"`
+		Group      string
+	}
+	var groups []struct {
+		Kind    string
+		Ingress []securityGroupRule
+		Egress  []securityGroupRule
+	}
+	if err := v.UnmarshalKey("security-groups", &groups); err != nil {
+		return cfg, fmt.Errorf("security-groups: %v", err)
+	}
+	for _, g := range groups {
+		group := awsextra.SecurityGroup{Kind: g.Kind}
+		for _, rule := range g.Ingress {
+			group.Ingress = append(group.Ingress, awsextra.SecurityGroupRule(rule))
+		}
+		for _, rule := range g.Egress {
+			group.Egress = append(group.Egress, awsextra.SecurityGroupRule(rule))
+		}
+		cfg.SecurityGroups = append(cfg.SecurityGroups, group)
+	}
+	if v.GetBool("flow-logs") {
+		cfg.FlowLog = &awsextra.FlowLog{
+			TrafficType:            v.GetString("flow-log-traffic-type"),
+			DestinationType:        v.GetString("flow-log-destination-type"),
+			Destination:            v.GetString("flow-log-destination"),
+			LogFormat:              v.GetString("flow-log-format"),
+			MaxAggregationInterval: v.GetInt64("flow-log-max-aggregation-interval"),
+			RoleARN:                v.GetString("flow-log-role-arn"),
+			CreateRole:             v.GetBool("flow-log-create-role"),
+			CreateLogGroup:         v.GetBool("flow-log-create-log-group"),
+			DeleteLogGroup:         v.GetBool("flow-log-delete-log-group"),
+		}
+	}
+	for i := 0; i < v.GetInt("num-endpoints"); i++ {
+		cfg.Endpoints = append(cfg.Endpoints, awsextra.Endpoint{
+			Service: v.GetString(fmt.Sprintf("endpoint-%d-service", i)),
+			Type:    v.GetString(fmt.Sprintf("endpoint-%d-type", i)),
+			Tier:    v.GetString(fmt.Sprintf("endpoint-%d-tier", i)),
+		})
+	}
+	if v.GetString("transit-gateway-id") != "" || v.GetBool("transit-gateway-create") {
+		cfg.TransitGateway = &awsextra.TransitGateway{
+			ID:                   v.GetString("transit-gateway-id"),
+			Create:               v.GetBool("transit-gateway-create"),
+			Tier:                 v.GetString("transit-gateway-tier"),
+			AssociateRouteTable:  v.GetString("transit-gateway-associate-route-table"),
+			PropagateRouteTables: v.GetStringSlice("transit-gateway-propagate-route-tables"),
+			Destinations:         v.GetStringSlice("transit-gateway-destinations"),
+		}
+	}
+	if v.GetString("vpn-customer-gateway-ip") != "" {
+		cfg.VPN = &awsextra.VPN{
+			CustomerGatewayIP: v.GetString("vpn-customer-gateway-ip"),
+			BGPASN:            v.GetInt64("vpn-bgp-asn"),
+			StaticRoutes:      v.GetStringSlice("vpn-static-routes"),
+			AmazonSideASN:     v.GetInt64("vpn-amazon-side-asn"),
+			ConfigFile:        v.GetString("vpn-config-file"),
+		}
+	}
+	cfg.TagKey = v.GetString("tagkey")
+	cfg.TagValue = v.GetString("tagvalue")
+	cfg.EnableDNSSupport = v.GetBool("enable-dns-support")
+	cfg.EnableDNSHostnames = v.GetBool("enable-dns-hostnames")
+	cfg.DomainName = v.GetString("domain-name")
+	if v.IsSet("domain-name-servers") {
+		cfg.DomainNameServers = v.GetStringSlice("domain-name-servers")
+	}
+	cfg.Retry.InitialInterval = v.GetDuration("retry-initial-interval")
+	cfg.Retry.MaxInterval = v.GetDuration("retry-max-interval")
+	cfg.Retry.MaxElapsed = v.GetDuration("retry-max-elapsed")
+	if err := cfg.CarveSubnets(); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// A session for region, with the credentials of the named profile in the
+// shared AWS config files if one is given, eg. for a stack in another account.
+func newSession(region string, profile string) *session.Session {
+	if profile == "" {
+		return session.New(&aws.Config{Region: aws.String(region)})
+	}
+	return session.Must(session.NewSessionWithOptions(session.Options{
+		Config:            aws.Config{Region: aws.String(region)},
+		Profile:           profile,
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+}
+
+// If up was interrupted, say where it got to and how to carry on.
+func haltInterrupted(ctx context.Context, err error, stateFile string) {
+	if err != nil && ctx.Err() != nil {
+		halt(err, "Interrupted.  What was created so far is recorded in "+stateFile+".  Re-run -action=up to resume, or -action=down to roll it back.")
+	}
+}
+
+// If an error happened, print it with this message to stderr and exit.
+func halt(err error, message string) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, message)
+		os.Exit(1)
 	}
 }